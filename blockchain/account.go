@@ -32,8 +32,8 @@ func GetAccountState(address []byte, stateRoot *trie.MerkleTrieNode, retrieve tr
 	return accountData, nil
 }
 
-// Save writes updated account state to trie passed. Returns updated trie leaf
-func (accountState AccountState) Save(address []byte, stateRoot *trie.MerkleTrieNode, retrieve trie.LookupFn) (*trie.MerkleTrieNode, error) {
+// Save writes updated account state to trie passed. Returns updated trie nodes
+func (accountState AccountState) Save(address []byte, stateRoot *trie.MerkleTrieNode, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, error) {
 	accountStateBytes, err := proto.Marshal(&accountState)
 	if err != nil {
 		return nil, errors.Wrap(err, "Save: failed marshal account record")
@@ -21,9 +21,11 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type Blockchain struct {
-	LastBlockHash        []byte   `protobuf:"bytes,1,opt,name=lastBlockHash,proto3" json:"lastBlockHash,omitempty"`
-	StateMerkleRoot      []byte   `protobuf:"bytes,2,opt,name=stateMerkleRoot,proto3" json:"stateMerkleRoot,omitempty"`
-	LastBlockIndex       uint64   `protobuf:"varint,3,opt,name=lastBlockIndex,proto3" json:"lastBlockIndex,omitempty"`
+	LastBlockHash   []byte `protobuf:"bytes,1,opt,name=lastBlockHash,proto3" json:"lastBlockHash,omitempty"`
+	StateMerkleRoot []byte `protobuf:"bytes,2,opt,name=stateMerkleRoot,proto3" json:"stateMerkleRoot,omitempty"`
+	LastBlockIndex  uint64 `protobuf:"varint,3,opt,name=lastBlockIndex,proto3" json:"lastBlockIndex,omitempty"`
+	// TotalWeight is sum of blocks' weights calculated by fork-choice rule
+	TotalWeight          uint64   `protobuf:"varint,4,opt,name=totalWeight,proto3" json:"totalWeight,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -75,6 +77,13 @@ func (m *Blockchain) GetLastBlockIndex() uint64 {
 	return 0
 }
 
+func (m *Blockchain) GetTotalWeight() uint64 {
+	if m != nil {
+		return m.TotalWeight
+	}
+	return 0
+}
+
 type AccountState struct {
 	Balance uint64 `protobuf:"varint,1,opt,name=balance,proto3" json:"balance,omitempty"`
 	// OutTxCounter is used for checking tx nonce, for valid outgoing transaction
@@ -402,45 +411,109 @@ func (m *Block) GetTransactions() [][]byte {
 	return nil
 }
 
+// FinalityCertificate proves that block was finalized by authority set.
+// It is stored alongside block in local storage
+type FinalityCertificate struct {
+	BlockIndex uint64 `protobuf:"varint,1,opt,name=blockIndex,proto3" json:"blockIndex,omitempty"`
+	BlockHash  []byte `protobuf:"bytes,2,opt,name=blockHash,proto3" json:"blockHash,omitempty"`
+	// Precommits are authorities' signatures of precommit vote for blockHash
+	// in format: signature||publicKey
+	Precommits           [][]byte `protobuf:"bytes,3,rep,name=precommits,proto3" json:"precommits,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FinalityCertificate) Reset()         { *m = FinalityCertificate{} }
+func (m *FinalityCertificate) String() string { return proto.CompactTextString(m) }
+func (*FinalityCertificate) ProtoMessage()    {}
+func (*FinalityCertificate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e9ac6287ce250c9a, []int{4}
+}
+
+func (m *FinalityCertificate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FinalityCertificate.Unmarshal(m, b)
+}
+func (m *FinalityCertificate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FinalityCertificate.Marshal(b, m, deterministic)
+}
+func (m *FinalityCertificate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FinalityCertificate.Merge(m, src)
+}
+func (m *FinalityCertificate) XXX_Size() int {
+	return xxx_messageInfo_FinalityCertificate.Size(m)
+}
+func (m *FinalityCertificate) XXX_DiscardUnknown() {
+	xxx_messageInfo_FinalityCertificate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FinalityCertificate proto.InternalMessageInfo
+
+func (m *FinalityCertificate) GetBlockIndex() uint64 {
+	if m != nil {
+		return m.BlockIndex
+	}
+	return 0
+}
+
+func (m *FinalityCertificate) GetBlockHash() []byte {
+	if m != nil {
+		return m.BlockHash
+	}
+	return nil
+}
+
+func (m *FinalityCertificate) GetPrecommits() [][]byte {
+	if m != nil {
+		return m.Precommits
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Blockchain)(nil), "blockchain.Blockchain")
 	proto.RegisterType((*AccountState)(nil), "blockchain.AccountState")
 	proto.RegisterType((*TX)(nil), "blockchain.TX")
 	proto.RegisterType((*Block)(nil), "blockchain.Block")
+	proto.RegisterType((*FinalityCertificate)(nil), "blockchain.FinalityCertificate")
 }
 
 func init() { proto.RegisterFile("blockchain.proto", fileDescriptor_e9ac6287ce250c9a) }
 
 var fileDescriptor_e9ac6287ce250c9a = []byte{
-	// 476 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x93, 0xcd, 0x8e, 0xd3, 0x30,
-	0x10, 0xc7, 0xd5, 0x7c, 0x74, 0xb7, 0xb3, 0xe9, 0xb2, 0xb2, 0x56, 0xc8, 0x42, 0x1c, 0xaa, 0x08,
-	0xa1, 0x9e, 0x38, 0xc0, 0x13, 0xf0, 0x71, 0x00, 0x09, 0x24, 0x14, 0xf6, 0xc0, 0xd5, 0x4d, 0xa7,
-	0x5b, 0x6b, 0x13, 0x3b, 0xb2, 0xdd, 0xaa, 0xdc, 0x11, 0x67, 0xde, 0x83, 0x97, 0x44, 0x33, 0x69,
-	0xf3, 0x51, 0xad, 0xf6, 0xe6, 0xff, 0xcf, 0x13, 0xcf, 0xcc, 0x7f, 0x26, 0x70, 0xb3, 0xaa, 0x6c,
-	0xf9, 0x50, 0x6e, 0x95, 0x36, 0x6f, 0x1a, 0x67, 0x83, 0x15, 0xd0, 0x93, 0xfc, 0xf7, 0x04, 0xe0,
-	0x43, 0x27, 0xc5, 0x2b, 0x98, 0x57, 0xca, 0x07, 0x26, 0x9f, 0x95, 0xdf, 0xca, 0xc9, 0x62, 0xb2,
-	0xcc, 0x8a, 0x31, 0x14, 0x4b, 0x78, 0xe6, 0x83, 0x0a, 0xf8, 0x0d, 0xdd, 0x43, 0x85, 0x85, 0xb5,
-	0x41, 0x46, 0x1c, 0x77, 0x8e, 0xc5, 0x6b, 0xb8, 0xee, 0x3e, 0xfd, 0x62, 0xd6, 0x78, 0x90, 0xf1,
-	0x62, 0xb2, 0x4c, 0x8a, 0x33, 0x9a, 0xff, 0x99, 0x40, 0xf6, 0xbe, 0x2c, 0xed, 0xce, 0x84, 0x1f,
-	0xf4, 0x84, 0x90, 0x70, 0xb1, 0x52, 0x95, 0x32, 0x25, 0x72, 0x09, 0x49, 0x71, 0x92, 0x22, 0x87,
-	0xcc, 0xee, 0xc2, 0xdd, 0xe1, 0x23, 0x05, 0xa3, 0xe3, 0xcc, 0x49, 0x31, 0x62, 0xe2, 0x05, 0x5c,
-	0x3a, 0xf4, 0xe8, 0xf6, 0xb8, 0xe6, 0x84, 0x59, 0xd1, 0x69, 0xf1, 0x12, 0x66, 0xa7, 0xf3, 0x5b,
-	0x99, 0xf0, 0x65, 0x0f, 0xf2, 0xbf, 0x11, 0x44, 0x77, 0x3f, 0x29, 0xfd, 0x1e, 0x9d, 0xd7, 0xd6,
-	0x70, 0xfa, 0x79, 0x71, 0x92, 0x42, 0x40, 0xb2, 0x71, 0xb6, 0x3e, 0x36, 0xcc, 0x67, 0x71, 0x0b,
-	0xa9, 0xb1, 0x54, 0x6a, 0xdb, 0x5c, 0x2b, 0xc4, 0x35, 0x44, 0xc1, 0x1e, 0x33, 0x44, 0xc1, 0x8a,
-	0xe7, 0x30, 0x55, 0x35, 0x15, 0x28, 0x53, 0x0e, 0x3b, 0x2a, 0x71, 0x03, 0xf1, 0x06, 0x51, 0x4e,
-	0x19, 0xd2, 0x91, 0x5b, 0x6c, 0x82, 0xb6, 0x46, 0x55, 0x9f, 0x54, 0x50, 0xf2, 0x82, 0xdf, 0x18,
-	0x31, 0x6a, 0xf1, 0x5e, 0xf9, 0xaf, 0xba, 0xd6, 0x41, 0x5e, 0xf2, 0xa7, 0x9d, 0x3e, 0xde, 0x7d,
-	0x77, 0xba, 0x44, 0x39, 0xeb, 0xee, 0x58, 0x53, 0xfd, 0x5b, 0x1a, 0x2c, 0xb4, 0xf5, 0xd3, 0x99,
-	0x2c, 0xf1, 0xfa, 0xde, 0xa8, 0xb0, 0x73, 0x28, 0xaf, 0x5a, 0x4b, 0x3a, 0x90, 0xff, 0x8b, 0x21,
-	0xe5, 0x51, 0x3d, 0xe1, 0xca, 0x2d, 0xa4, 0x9a, 0xc7, 0xdb, 0x4e, 0xa3, 0x15, 0xf4, 0x6e, 0xd0,
-	0x35, 0xfa, 0xa0, 0xea, 0x86, 0xbd, 0x89, 0x8b, 0x1e, 0xd0, 0xae, 0x35, 0x0e, 0xf7, 0xfd, 0xae,
-	0xb5, 0x56, 0x8d, 0x21, 0x79, 0x11, 0x0e, 0x83, 0x45, 0x4b, 0x5b, 0x2f, 0x86, 0xec, 0xb1, 0x7d,
-	0x9c, 0x3e, 0xbe, 0x8f, 0xc3, 0xc5, 0xb8, 0x38, 0x5b, 0x8c, 0x05, 0x5c, 0xad, 0xd0, 0xe0, 0x46,
-	0x97, 0x5a, 0xb9, 0x5f, 0x6c, 0x6a, 0x56, 0x0c, 0x11, 0x6d, 0xb3, 0x5a, 0xaf, 0xf5, 0x60, 0x32,
-	0x33, 0x0e, 0x3a, 0xa3, 0xd4, 0x77, 0xe3, 0xac, 0xdd, 0x70, 0x48, 0x6b, 0x74, 0x0f, 0x9e, 0x76,
-	0x9b, 0x2a, 0x0c, 0x07, 0xea, 0x1c, 0xbd, 0xcc, 0x16, 0x31, 0x55, 0x78, 0xd2, 0xec, 0x85, 0x53,
-	0xc6, 0xab, 0x92, 0x92, 0x79, 0x39, 0xe7, 0xfb, 0x11, 0x5b, 0x4d, 0xf9, 0x1f, 0x7f, 0xf7, 0x3f,
-	0x00, 0x00, 0xff, 0xff, 0x82, 0x99, 0x49, 0x9a, 0xf7, 0x03, 0x00, 0x00,
+	// 539 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x94, 0xcf, 0x8e, 0xd3, 0x30,
+	0x10, 0xc6, 0xd5, 0xa6, 0x7f, 0xb6, 0xd3, 0x74, 0x59, 0x99, 0x15, 0xb2, 0x10, 0x42, 0x55, 0x84,
+	0x50, 0x4f, 0x1c, 0xe0, 0x09, 0x60, 0x11, 0x02, 0x09, 0x24, 0x14, 0x56, 0x82, 0xab, 0x93, 0xba,
+	0xad, 0xb5, 0x89, 0x1d, 0xd9, 0xd3, 0xaa, 0xfb, 0x02, 0x9c, 0x79, 0x0a, 0x2e, 0xbc, 0x24, 0x9a,
+	0x49, 0x9b, 0xa4, 0xd5, 0x6a, 0x6f, 0x9e, 0x9f, 0xc7, 0x99, 0xcf, 0xdf, 0x8c, 0x03, 0x57, 0x59,
+	0xe1, 0xf2, 0xbb, 0x7c, 0xa3, 0x8c, 0x7d, 0x53, 0x79, 0x87, 0x4e, 0x40, 0x4b, 0x92, 0xbf, 0x3d,
+	0x80, 0x0f, 0x4d, 0x28, 0x5e, 0xc1, 0xac, 0x50, 0x01, 0x99, 0x7c, 0x56, 0x61, 0x23, 0x7b, 0xf3,
+	0xde, 0x22, 0x4e, 0x4f, 0xa1, 0x58, 0xc0, 0x93, 0x80, 0x0a, 0xf5, 0x37, 0xed, 0xef, 0x0a, 0x9d,
+	0x3a, 0x87, 0xb2, 0xcf, 0x79, 0xe7, 0x58, 0xbc, 0x86, 0xcb, 0xe6, 0xe8, 0x17, 0xbb, 0xd4, 0x7b,
+	0x19, 0xcd, 0x7b, 0x8b, 0x41, 0x7a, 0x46, 0xc5, 0x1c, 0xa6, 0xe8, 0x50, 0x15, 0x3f, 0xb5, 0x59,
+	0x6f, 0x50, 0x0e, 0x38, 0xa9, 0x8b, 0x92, 0xdf, 0x3d, 0x88, 0xdf, 0xe7, 0xb9, 0xdb, 0x5a, 0xfc,
+	0x41, 0x45, 0x84, 0x84, 0x71, 0xa6, 0x0a, 0x65, 0x73, 0xcd, 0x22, 0x07, 0xe9, 0x31, 0x14, 0x09,
+	0xc4, 0x6e, 0x8b, 0xb7, 0xfb, 0x1b, 0x4a, 0xd6, 0x9e, 0xb5, 0x0d, 0xd2, 0x13, 0x26, 0x9e, 0xc3,
+	0x85, 0xd7, 0x41, 0xfb, 0x9d, 0x5e, 0xb2, 0xa4, 0x38, 0x6d, 0x62, 0xf1, 0x02, 0x26, 0xc7, 0xf5,
+	0x5b, 0x96, 0x12, 0xa7, 0x2d, 0x48, 0xfe, 0xf4, 0xa1, 0x7f, 0xfb, 0x8b, 0xca, 0xef, 0xb4, 0x0f,
+	0xc6, 0x59, 0x2e, 0x3f, 0x4b, 0x8f, 0xa1, 0x10, 0x30, 0x58, 0x79, 0x57, 0x1e, 0x2c, 0xe1, 0xb5,
+	0xb8, 0x86, 0xa1, 0x75, 0x24, 0xb5, 0xbe, 0x7e, 0x1d, 0x88, 0x4b, 0xe8, 0xa3, 0x3b, 0x54, 0xe8,
+	0xa3, 0x13, 0xcf, 0x60, 0xa4, 0x4a, 0x12, 0x28, 0x87, 0x9c, 0x76, 0x88, 0xc4, 0x15, 0x44, 0x2b,
+	0xad, 0xe5, 0x88, 0x21, 0x2d, 0xf9, 0x8a, 0x15, 0x1a, 0x67, 0x55, 0xf1, 0x51, 0xa1, 0x92, 0x63,
+	0xfe, 0xc6, 0x09, 0xa3, 0x2b, 0xae, 0x55, 0xf8, 0x6a, 0x4a, 0x83, 0xf2, 0x82, 0x8f, 0x36, 0xf1,
+	0x61, 0xef, 0xbb, 0x37, 0xb9, 0x96, 0x93, 0x66, 0x8f, 0x63, 0xd2, 0xbf, 0xa1, 0xd6, 0x43, 0xad,
+	0x9f, 0xd6, 0x64, 0x49, 0x30, 0x6b, 0xab, 0x70, 0xeb, 0xb5, 0x9c, 0xd6, 0x96, 0x34, 0x20, 0xf9,
+	0x17, 0xc1, 0x90, 0x9b, 0xf9, 0x88, 0x2b, 0xd7, 0x30, 0x34, 0x3c, 0x00, 0x75, 0x37, 0xea, 0x80,
+	0xbe, 0x8b, 0xa6, 0xd4, 0x01, 0x55, 0x59, 0xb1, 0x37, 0x51, 0xda, 0x02, 0x9a, 0xc6, 0xca, 0xeb,
+	0x5d, 0x3b, 0x8d, 0xb5, 0x55, 0xa7, 0x90, 0xbc, 0xc0, 0x7d, 0x67, 0x14, 0x87, 0xb5, 0x17, 0x5d,
+	0xf6, 0xd0, 0xc4, 0x8e, 0x1e, 0x9e, 0xd8, 0xee, 0x60, 0x8c, 0xcf, 0x06, 0x63, 0x0e, 0xd3, 0x4c,
+	0x5b, 0xbd, 0x32, 0xb9, 0x51, 0xfe, 0x9e, 0x4d, 0x8d, 0xd3, 0x2e, 0xa2, 0x79, 0x57, 0xcb, 0xa5,
+	0xe9, 0x74, 0x66, 0xc2, 0x49, 0x67, 0x94, 0xee, 0x5d, 0x79, 0xe7, 0x56, 0x9c, 0x52, 0x1b, 0xdd,
+	0x82, 0xc7, 0xdd, 0x26, 0x85, 0xb8, 0xa7, 0x9b, 0xeb, 0x20, 0xe3, 0x79, 0x44, 0x0a, 0x8f, 0x31,
+	0x7b, 0xe1, 0x95, 0x0d, 0x2a, 0xa7, 0x62, 0x41, 0xce, 0x78, 0xff, 0x84, 0x25, 0x01, 0x9e, 0x7e,
+	0x32, 0x56, 0x15, 0x06, 0xef, 0x6f, 0xb4, 0x47, 0xb3, 0x32, 0x39, 0xbd, 0xa7, 0x97, 0x00, 0x59,
+	0xf3, 0x20, 0x0f, 0x4f, 0xaa, 0x43, 0x48, 0x54, 0xd6, 0x34, 0xa2, 0x9e, 0xed, 0x16, 0xd0, 0xe9,
+	0xca, 0xeb, 0xdc, 0x95, 0xa5, 0xc1, 0x20, 0x23, 0x2e, 0xdb, 0x21, 0xd9, 0x88, 0x7f, 0x3d, 0xef,
+	0xfe, 0x0f, 0x00, 0xa4, 0xab, 0x3e, 0x48, 0x8e, 0x04, 0x00, 0x00,
 }
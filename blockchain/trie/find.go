@@ -34,12 +34,9 @@ func (node *MerkleTrieNode) findClosest(key string, lookup LookupFn) (*MerkleTri
 		return nextNode.findClosest(key[1:], lookup)
 	}
 
+	// Children are placed after ExtKey, so it must be fully matched
 	lcpLength := longestCommonPrefixLength(key, node.ExtKey)
-	if lcpLength == 0 {
-		return node, key, nil
-	}
-
-	if lcpLength >= len(key) {
+	if lcpLength < len(node.ExtKey) || lcpLength >= len(key) {
 		return node, key, nil
 	}
 
@@ -69,7 +66,7 @@ func (node *MerkleTrieNode) FindValue(key string, lookup LookupFn) ([]byte, erro
 	if err != nil {
 		return nil, err
 	}
-	if remainingKeyPart != closestNode.ExtKey {
+	if remainingKeyPart != closestNode.ExtKey || closestNode.Type&hasValue == 0 {
 		return nil, nil
 	}
 	return closestNode.Value, nil
@@ -2,7 +2,6 @@ package trie
 
 func (node *MerkleTrieNode) putNewChild(subkey string, value []byte) *MerkleTrieNode {
 	newLeaf := new(MerkleTrieNode)
-	newLeaf.Parent = node
 	newLeaf.Type = hasValue
 	newLeaf.Value = value
 
@@ -11,79 +10,85 @@ func (node *MerkleTrieNode) putNewChild(subkey string, value []byte) *MerkleTrie
 		newLeaf.ExtKey = subkey[1:]
 	}
 
-	newLeafHash := newLeaf.CalculateHash()
-
-	node.Children[hexChar(subkey[0])] = newLeafHash
+	node.Type |= hasChildren
+	if node.Children == nil {
+		node.Children = make(map[uint8][]byte)
+	}
+	node.Children[hexChar(subkey[0])] = newLeaf.CalculateHash()
 
 	newLeaf.Parent = node
 	newLeaf.ParentKey = hexChar(subkey[0])
 
-	return node
+	return newLeaf
+}
+
+// updateParentHashes recalculates children hashes from node up to the root
+func (node *MerkleTrieNode) updateParentHashes() {
+	for current := node; current.Parent != nil; current = current.Parent {
+		current.Parent.Children[current.ParentKey] = current.CalculateHash()
+	}
 }
 
 // Put adds new or modifies existing node with specific key and setting its value.
-// Returns deepest updated node in copy of previous tree
-func (node *MerkleTrieNode) Put(key string, value []byte, lookup LookupFn) (*MerkleTrieNode, error) {
+// Returns updated nodes in copy of previous tree: deepest updated node on key's path
+// and nodes created aside of it. All of them should be saved with their parents.
+func (node *MerkleTrieNode) Put(key string, value []byte, lookup LookupFn) ([]*MerkleTrieNode, error) {
 	closestNode, remainingKeyPart, err := node.findClosest(key, lookup)
 	if err != nil {
 		return nil, err
 	}
 
 	if remainingKeyPart == closestNode.ExtKey {
+		closestNode.Type |= hasValue
 		closestNode.Value = value
-		return closestNode, nil
-	}
-
-	closestNode.Type |= hasChildren
-	if closestNode.Children == nil {
-		closestNode.Children = make(map[uint8][]byte)
-	}
-
-	if len(closestNode.ExtKey) == 0 {
-		if len(closestNode.Children) > 0 {
-			closestNode.ExtKey = remainingKeyPart
-			closestNode.Value = value
-			return closestNode, nil
-		}
-
-		return closestNode.putNewChild(remainingKeyPart, value), nil
+		closestNode.updateParentHashes()
+		return []*MerkleTrieNode{closestNode}, nil
 	}
 
 	// Example case: remainingKeyPart=[faba], node ExtKey=[fab]
 	lcpLength := longestCommonPrefixLength(remainingKeyPart, closestNode.ExtKey)
 	if lcpLength == len(closestNode.ExtKey) {
-		return closestNode.putNewChild(remainingKeyPart[lcpLength:], value), nil
+		newLeaf := closestNode.putNewChild(remainingKeyPart[lcpLength:], value)
+		newLeaf.updateParentHashes()
+		return []*MerkleTrieNode{newLeaf}, nil
 	}
 
 	/*
-		Split node with extended key: create new child with key remainingKeyPart[lcpLength],
-		move children to splitted node
-		Set node.ExtNode to node.ExtKey[:lcpLength], insert new leaf and update hashes
+		Split node with extended key: move node's value and children to new child
+		with key ExtKey[lcpLength], set node.ExtKey to node.ExtKey[:lcpLength],
+		insert new leaf and update hashes
 		Example case: remainingKeyPart=[faba], node ExtKey=[fac0]
 	*/
 	splittedNodeKey := hexChar(closestNode.ExtKey[lcpLength])
 
 	splittedNode := new(MerkleTrieNode)
 	splittedNode.Parent = closestNode
-	splittedNode.Type = hasChildren
-	if closestNode.Type&hasValue > 0 {
-		splittedNode.Value = closestNode.Value
-		closestNode.Type ^= hasValue
-	}
+	splittedNode.ParentKey = splittedNodeKey
+	splittedNode.Type = closestNode.Type &^ hasExtKey
+	splittedNode.Value = closestNode.Value
+	splittedNode.Children = closestNode.Children
 	if len(closestNode.ExtKey) > lcpLength+1 {
 		splittedNode.Type |= hasExtKey
 		splittedNode.ExtKey = closestNode.ExtKey[lcpLength+1:]
 	}
-	splittedNode.Children = closestNode.Children
 
+	closestNode.Type = hasChildren
+	closestNode.Value = nil
 	closestNode.ExtKey = closestNode.ExtKey[:lcpLength]
+	if lcpLength > 0 {
+		closestNode.Type |= hasExtKey
+	}
 	closestNode.Children = make(map[uint8][]byte)
 	closestNode.Children[splittedNodeKey] = splittedNode.CalculateHash()
 
 	if lcpLength == len(remainingKeyPart) {
+		closestNode.Type |= hasValue
 		closestNode.Value = value
-		return closestNode, nil
+		closestNode.updateParentHashes()
+		return []*MerkleTrieNode{closestNode, splittedNode}, nil
 	}
 
-	return closestNode.putNewChild(remainingKeyPart[lcpLength:], value), nil
+	newLeaf := closestNode.putNewChild(remainingKeyPart[lcpLength:], value)
+	newLeaf.updateParentHashes()
+	return []*MerkleTrieNode{newLeaf, splittedNode}, nil
 }
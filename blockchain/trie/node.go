@@ -127,13 +127,16 @@ func (node *MerkleTrieNode) parseExtKey(data []byte, offset *int) error {
 	return nil
 }
 func (node *MerkleTrieNode) parseValue(data []byte, offset *int) error {
-	if len(data) <= *offset+4 {
+	if len(data) < *offset+4 {
 		return ErrCorruptData
 	}
 
 	valueLen := binary.LittleEndian.Uint32(data[*offset : *offset+4])
 	*offset += 4
 
+	if len(data) < *offset+int(valueLen) {
+		return ErrCorruptData
+	}
 	node.Value = data[*offset : *offset+int(valueLen)]
 	return nil
 }
@@ -198,7 +201,12 @@ func (node MerkleTrieNode) ToBytes() []byte {
 			bitmap        uint16 = 0
 			childrenBytes        = make([]byte, 0, len(node.Children)*32)
 		)
-		for index, childHash := range node.Children {
+		// Children are encoded in order of their keys
+		for index := uint8(0); index < 16; index++ {
+			childHash, exists := node.Children[index]
+			if !exists {
+				continue
+			}
 			bitmap |= 1 << (15 - index)
 			childrenBytes = append(childrenBytes, childHash...)
 		}
@@ -0,0 +1,113 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+type memoryDataSource map[string][]byte
+
+func (dataSource memoryDataSource) lookup(key []byte) ([]byte, error) {
+	return dataSource[hex.EncodeToString(key)], nil
+}
+
+func (dataSource memoryDataSource) save(nodes []*MerkleTrieNode) {
+	for _, node := range nodes {
+		for ; node != nil; node = node.Parent {
+			dataSource[hex.EncodeToString(node.CalculateHash())] = node.ToBytes()
+		}
+	}
+}
+
+func buildTrie(t *testing.T, keys []string) (*MerkleTrieNode, memoryDataSource) {
+	dataSource := make(memoryDataSource)
+	root := new(MerkleTrieNode)
+	if err := root.SetBytes(NullTrie.ToBytes()); err != nil {
+		t.Fatalf("SetBytes failed: %+v", err)
+	}
+
+	for _, key := range keys {
+		updatedNodes, err := root.Put(key, []byte(key), dataSource.lookup)
+		if err != nil {
+			t.Fatalf("Put(%s) failed: %+v", key, err)
+		}
+		dataSource.save(updatedNodes)
+	}
+	return root, dataSource
+}
+
+func TestPutAndFind(t *testing.T) {
+	keys := []string{"fab0", "fac0", "fa", "faba", "0123", "f", "fab"}
+	root, dataSource := buildTrie(t, keys)
+
+	for _, key := range keys {
+		value, err := root.FindValue(key, dataSource.lookup)
+		if err != nil {
+			t.Fatalf("FindValue(%s) failed: %+v", key, err)
+		}
+		if bytes.Compare(value, []byte(key)) != 0 {
+			t.Errorf("Unexpected value for key %s: %s", key, string(value))
+		}
+	}
+
+	for _, key := range []string{"fa0", "fabb", "01", "1"} {
+		value, err := root.FindValue(key, dataSource.lookup)
+		if err != nil {
+			t.Fatalf("FindValue(%s) failed: %+v", key, err)
+		}
+		if value != nil {
+			t.Errorf("Found value for missing key %s: %s", key, string(value))
+		}
+	}
+
+	// Root saved in data source must lead to the same values
+	savedRoot := new(MerkleTrieNode)
+	if err := savedRoot.SetBytes(dataSource[hex.EncodeToString(root.CalculateHash())]); err != nil {
+		t.Fatalf("Root node wasn't saved: %+v", err)
+	}
+	value, err := savedRoot.FindValue("faba", dataSource.lookup)
+	if err != nil || bytes.Compare(value, []byte("faba")) != 0 {
+		t.Errorf("FindValue on saved root failed: %+v", err)
+	}
+}
+
+func TestRootHashIsDeterministic(t *testing.T) {
+	root1, _ := buildTrie(t, []string{"ab12", "ab34", "cd"})
+	root2, _ := buildTrie(t, []string{"cd", "ab34", "ab12"})
+
+	if bytes.Compare(root1.CalculateHash(), root2.CalculateHash()) != 0 {
+		t.Error("Root hash depends on insertion order")
+	}
+}
+
+func TestNodeBytes(t *testing.T) {
+	/*
+		1. Node with extended key, children and value is decoded from its encoding
+		2. Children are encoded in order of keys
+		3. Encoding with truncated value isn't decoded
+	*/
+	node := MerkleTrieNode{
+		Type:     hasChildren | hasExtKey | hasValue,
+		ExtKey:   "abc",
+		Value:    []byte("value"),
+		Children: map[uint8][]byte{0x0f: bytes.Repeat([]byte{0xaf}, 32), 0x01: bytes.Repeat([]byte{0xa1}, 32)},
+	}
+	data := node.ToBytes()
+
+	decoded := new(MerkleTrieNode)
+	if err := decoded.SetBytes(data); err != nil {
+		t.Fatalf("SetBytes failed: %+v", err)
+	}
+	if decoded.ExtKey != node.ExtKey || bytes.Compare(decoded.Value, node.Value) != 0 || len(decoded.Children) != 2 {
+		t.Fatalf("Unexpected decoded node: %+v", decoded)
+	}
+	childrenOffset := bytes.Index(data, node.Children[0x01])
+	if childrenOffset < 0 || bytes.Index(data, node.Children[0x0f]) != childrenOffset+32 {
+		t.Error("Children aren't encoded in order of keys")
+	}
+
+	if err := new(MerkleTrieNode).SetBytes(data[:len(data)-1]); err != ErrCorruptData {
+		t.Errorf("Truncated node was decoded, err: %+v", err)
+	}
+}
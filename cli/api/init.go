@@ -6,10 +6,12 @@ import (
 	"net/http"
 
 	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/cli/net"
 )
 
 var server = http.Server{}
 var localStorage *db.LocalStorage
+var networkNode *net.NetworkNode
 
 type jsonObject map[string]interface{}
 
@@ -19,17 +21,22 @@ func Close() error {
 }
 
 // InitAPI creates HTTP JSON API listener on specific port
-func InitAPI(port int, storage *db.LocalStorage) chan error {
+func InitAPI(port int, storage *db.LocalStorage, netNode *net.NetworkNode) chan error {
 	if localStorage != nil {
 		panic("InitAPI is called twice")
 	}
 	localStorage = storage
+	networkNode = netNode
 
 	server.Addr = fmt.Sprintf("0.0.0.0:%d", port)
 
 	// TODO: register API listeners
 	http.HandleFunc("/api/v1/blockchain", GetBlockchain)
 	http.HandleFunc("/api/v1/block", GetBlockData)
+	http.HandleFunc("/api/v1/peers", GetPeers)
+	http.HandleFunc("/api/v1/bans", GetBans)
+	http.HandleFunc("/api/v1/ban", BanPeer)
+	http.HandleFunc("/api/v1/unban", UnbanPeer)
 
 	resultChan := make(chan error)
 	go func() {
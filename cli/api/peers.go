@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	stdnet "net"
+	"net/http"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/cli/net"
+)
+
+// banTarget parses node ID or IP address from query parameters "node" and "ip"
+func banTarget(r *http.Request) ([]byte, stdnet.IP, bool) {
+	query := r.URL.Query()
+	if nodeString := query.Get("node"); nodeString != "" {
+		nodeID, err := hex.DecodeString(nodeString)
+		return nodeID, nil, err == nil && len(nodeID) > 0
+	}
+	ip := stdnet.ParseIP(query.Get("ip"))
+	return nil, ip, ip != nil
+}
+
+// GetPeers retrieves connected peers and their scores.
+// API endpoint: /api/v1/peers
+func GetPeers(w http.ResponseWriter, r *http.Request) {
+	peers := make([]jsonObject, 0)
+	for _, peer := range networkNode.Peers.Peers() {
+		peers = append(peers, jsonObject{
+			"nodeID":   hex.EncodeToString(peer.RemoteID),
+			"address":  peer.RemoteAddr(),
+			"outgoing": peer.Outgoing,
+			"score":    networkNode.Peers.Score(peer),
+			"rtt":      peer.RTT().String(),
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(peers)
+}
+
+// GetBans retrieves active bans of node IDs and IP addresses.
+// API endpoint: /api/v1/bans
+func GetBans(w http.ResponseWriter, r *http.Request) {
+	bans := make([]jsonObject, 0)
+	for _, ban := range networkNode.Peers.Bans() {
+		banObject := jsonObject{"until": ban.Until.Unix(), "reason": ban.Reason}
+		if ban.NodeID != nil {
+			banObject["node"] = hex.EncodeToString(ban.NodeID)
+		} else {
+			banObject["ip"] = ban.IP.String()
+		}
+		bans = append(bans, banObject)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(bans)
+}
+
+// BanPeer bans node ID or IP address, connected peers matching ban are disconnected.
+// API endpoint: /api/v1/ban?node=<node ID>&ip=<IP address>&duration=<duration>&reason=<reason>
+// Query parameters: node - hex-encoded node ID, ip - IP address, only one of them is used;
+// duration - ban duration, e.g. "1h30m", net.DefaultBanDuration is used if it is empty
+func BanPeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	nodeID, ip, ok := banTarget(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	duration := net.DefaultBanDuration
+	if durationString := r.URL.Query().Get("duration"); durationString != "" {
+		var err error
+		if duration, err = time.ParseDuration(durationString); err != nil || duration <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	reason := r.URL.Query().Get("reason")
+	var err error
+	if nodeID != nil {
+		err = networkNode.Peers.BanNode(nodeID, duration, reason)
+	} else {
+		err = networkNode.Peers.BanIP(ip, duration, reason)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Ban failed: %+v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UnbanPeer removes ban of node ID or IP address.
+// API endpoint: /api/v1/unban?node=<node ID>&ip=<IP address>
+// Query parameters: node - hex-encoded node ID, ip - IP address, only one of them is used
+func UnbanPeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	nodeID, ip, ok := banTarget(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		removed bool
+		err     error
+	)
+	if nodeID != nil {
+		removed, err = networkNode.Peers.UnbanNode(nodeID)
+	} else {
+		removed, err = networkNode.Peers.UnbanIP(ip)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Unban failed: %+v", err)
+		return
+	}
+	if !removed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
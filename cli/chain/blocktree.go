@@ -0,0 +1,134 @@
+package chain
+
+import (
+	"encoding/hex"
+)
+
+/*
+	Block tree contains blocks of current chain and side chains known to dispatcher.
+	Every node references its parent and stores state root after block evaluation,
+	so dispatcher can switch to any known branch without re-evaluating blocks.
+	Tree is pruned incrementally: when root moves up along current chain, only
+	nodes between old and new root and side branches forking from them are visited.
+*/
+
+// maxReorgDepth is count of blocks below head for which side chains are kept
+const maxReorgDepth = 1024
+
+type blockTreeNode struct {
+	hash        []byte
+	index       uint64
+	stateRoot   []byte
+	totalWeight uint64
+
+	parent   *blockTreeNode
+	children []*blockTreeNode
+}
+
+type blockTree struct {
+	head  *blockTreeNode
+	nodes map[string]*blockTreeNode
+}
+
+func newBlockTree() *blockTree {
+	return &blockTree{
+		nodes: make(map[string]*blockTreeNode),
+	}
+}
+
+func (tree *blockTree) get(hash []byte) *blockTreeNode {
+	return tree.nodes[hex.EncodeToString(hash)]
+}
+
+// add inserts node, its parent should be set before
+func (tree *blockTree) add(node *blockTreeNode) {
+	tree.nodes[hex.EncodeToString(node.hash)] = node
+	if node.parent != nil {
+		node.parent.children = append(node.parent.children, node)
+	}
+}
+
+// remove removes leaf node, e.g. block which failed to become head
+func (tree *blockTree) remove(node *blockTreeNode) {
+	delete(tree.nodes, hex.EncodeToString(node.hash))
+	if node.parent == nil {
+		return
+	}
+	siblings := node.parent.children
+	for i := range siblings {
+		if siblings[i] == node {
+			node.parent.children = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+}
+
+// findFork returns common ancestor of two nodes and branches leading to them from ancestor.
+// Branches are ordered from ancestor's child to the node. Ancestor is nil if nodes are in different trees.
+func (tree *blockTree) findFork(oldHead, newHead *blockTreeNode) (*blockTreeNode, []*blockTreeNode, []*blockTreeNode) {
+	var detached, attached []*blockTreeNode
+
+	for oldHead != nil && newHead != nil && oldHead.index > newHead.index {
+		detached = append(detached, oldHead)
+		oldHead = oldHead.parent
+	}
+	for oldHead != nil && newHead != nil && newHead.index > oldHead.index {
+		attached = append(attached, newHead)
+		newHead = newHead.parent
+	}
+	for oldHead != nil && newHead != nil && oldHead != newHead {
+		detached = append(detached, oldHead)
+		attached = append(attached, newHead)
+		oldHead = oldHead.parent
+		newHead = newHead.parent
+	}
+	if oldHead == nil || newHead == nil {
+		return nil, nil, nil
+	}
+
+	reverseNodes(detached)
+	reverseNodes(attached)
+	return oldHead, detached, attached
+}
+
+// prune removes nodes which cannot be used for chain reorganization anymore:
+// nodes below specified index and nodes which don't descend from current chain's node at this index.
+// Current chain's nodes below new root and side branches forking from them are removed
+func (tree *blockTree) prune(index uint64) {
+	root := tree.head
+	for root != nil && root.index > index {
+		root = root.parent
+	}
+	if root == nil {
+		return
+	}
+
+	for node := root; node.parent != nil; node = node.parent {
+		for _, sibling := range node.parent.children {
+			if sibling != node {
+				tree.removeBranch(sibling)
+			}
+		}
+		delete(tree.nodes, hex.EncodeToString(node.parent.hash))
+	}
+	if root.parent != nil {
+		root.parent.children = nil
+		root.parent = nil
+	}
+}
+
+// removeBranch removes node and all its descendants
+func (tree *blockTree) removeBranch(node *blockTreeNode) {
+	branch := []*blockTreeNode{node}
+	for len(branch) > 0 {
+		node := branch[len(branch)-1]
+		branch = append(branch[:len(branch)-1], node.children...)
+		delete(tree.nodes, hex.EncodeToString(node.hash))
+	}
+}
+
+func reverseNodes(nodes []*blockTreeNode) {
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+}
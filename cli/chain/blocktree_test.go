@@ -0,0 +1,49 @@
+package chain
+
+import (
+	"testing"
+)
+
+func TestBlockTreePrune(t *testing.T) {
+	/*
+		1. Tree has current chain 0..5, side branch 1 -> s2 -> s3 and side branch 3 -> s4
+		2. After pruning at index 2 nodes below 2 and branch s2 -> s3 are removed
+		3. After pruning at index 4 branch s4 is removed
+	*/
+	tree := newBlockTree()
+	newNode := func(name string, index uint64, parent *blockTreeNode) *blockTreeNode {
+		node := &blockTreeNode{hash: []byte(name), index: index, parent: parent}
+		tree.add(node)
+		return node
+	}
+	var current []*blockTreeNode
+	var parent *blockTreeNode
+	for i := uint64(0); i <= 5; i++ {
+		parent = newNode(string(rune('a'+i)), i, parent)
+		current = append(current, parent)
+	}
+	sideNode := newNode("s2", 2, current[1])
+	newNode("s3", 3, sideNode)
+	newNode("s4", 4, current[3])
+	tree.head = current[5]
+
+	has := func(name string) bool {
+		return tree.get([]byte(name)) != nil
+	}
+	tree.prune(2)
+	if len(tree.nodes) != 5 || !has("c") || !has("s4") || has("b") || has("s3") {
+		t.Fatalf("Unexpected nodes after pruning at index 2: %d", len(tree.nodes))
+	}
+	if current[2].parent != nil {
+		t.Error("Root references pruned parent")
+	}
+
+	tree.prune(4)
+	if len(tree.nodes) != 2 || !has("e") || !has("f") {
+		t.Fatalf("Unexpected nodes after pruning at index 4: %d", len(tree.nodes))
+	}
+	tree.prune(4)
+	if len(tree.nodes) != 2 {
+		t.Fatal("Repeated pruning removed nodes")
+	}
+}
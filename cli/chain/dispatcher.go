@@ -10,6 +10,7 @@ import (
 	"github.com/buuzcoin/go-buuzcoin/blockchain"
 	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
 	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
 	"github.com/buuzcoin/go-buuzcoin/network/validation"
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
@@ -23,9 +24,14 @@ import (
 var (
 	// ErrCorruptDatabase is returned if data in local storage is corrupt
 	ErrCorruptDatabase = errors.New("dispatcher: Corrupt database")
-	// ErrDifferentRoots is returned by ApplyBlock function if current block's hash is not
-	// equal to to-be-applied block's prevHash
+	// ErrDifferentRoots is returned by ApplyBlock function if to-be-applied block's
+	// prevHash doesn't reference any known block
 	ErrDifferentRoots = errors.New("dispatcher: block cannot be applied: different roots")
+	// ErrKnownBlock is returned by ApplyBlock function if block was already applied
+	ErrKnownBlock = errors.New("dispatcher: block is already known")
+	// ErrReorgTooDeep is returned by ApplyBlock function if block forks from current chain
+	// deeper than side chains are kept
+	ErrReorgTooDeep = errors.New("dispatcher: block forks from current chain too deep")
 )
 
 type blockchainDispatcher struct {
@@ -33,6 +39,17 @@ type blockchainDispatcher struct {
 	genesisBlock  *blockchain.Block
 	currentChain  *blockchain.Blockchain
 	stateTrieRoot *trie.MerkleTrieNode
+	proofAlgo     consensus.ProofAlgorithm
+
+	blockTree        *blockTree
+	blockWeight      WeightFn
+	reorgSubscribers []chan ReorgEvent
+	blockSubscribers []chan blockchain.Block
+
+	finalityGadget  *consensus.FinalityGadget
+	broadcast       BroadcastFn
+	lastFinalized   *blockchain.FinalityCertificate
+	pendingFinality *blockchain.FinalityCertificate
 
 	lock *sync.RWMutex
 }
@@ -41,7 +58,7 @@ type blockchainDispatcher struct {
 var BlockchainDispatcher *blockchainDispatcher
 
 // InitBlockchainDispatcher creates new instance and runs listener loop in separate goroutine
-func initBlockchainDispatcher(localStorage *db.LocalStorage) {
+func initBlockchainDispatcher(localStorage *db.LocalStorage, proofAlgo consensus.ProofAlgorithm) {
 	if BlockchainDispatcher != nil {
 		panic("InitBlockchainDispatcher is called twice")
 	}
@@ -53,6 +70,9 @@ func initBlockchainDispatcher(localStorage *db.LocalStorage) {
 			LastBlockIndex:  0,
 		},
 		stateTrieRoot: trie.NullTrie.Clone(),
+		proofAlgo:     proofAlgo,
+		blockTree:     newBlockTree(),
+		blockWeight:   proofAlgo.CalcWeight,
 		lock:          &sync.RWMutex{},
 	}
 }
@@ -93,23 +113,70 @@ func (dispatcher *blockchainDispatcher) GetAccountState(address []byte) (blockch
 	return *accountState, err
 }
 
-// ApplyBlock applies block's transactions to state trie.
+// ApplyBlock validates block, evaluates its transactions on parent's state and adds block to block tree.
+// transactions are used if block doesn't contain encoded transactions. Validation failures are
+// returned as *validation.StageError. Current chain is extended or reorganized if block's branch
+// is preferred by fork-choice rule.
 func (dispatcher *blockchainDispatcher) ApplyBlock(block blockchain.Block, transactions []blockchain.TX) error {
 	dispatcher.lock.Lock()
 	defer dispatcher.lock.Unlock()
 
-	if bytes.Compare(block.PrevBlockHash, dispatcher.currentChain.LastBlockHash) != 0 {
+	blockHash := block.CalculateHash()
+	if dispatcher.blockTree.get(blockHash) != nil {
+		return ErrKnownBlock
+	}
+
+	var (
+		parentBlock       *blockchain.Block
+		parentStateRoot   []byte
+		parentTotalWeight uint64
+	)
+	parent := dispatcher.blockTree.get(block.PrevBlockHash)
+	if parent != nil {
+		var err error
+		if parentBlock, err = dispatcher.localStorage.GetBlock(parent.hash); err != nil {
+			return errors.Wrap(err, "ApplyBlock: failed to load parent block")
+		}
+		if parentBlock == nil {
+			return ErrCorruptDatabase
+		}
+		parentStateRoot = parent.stateRoot
+		parentTotalWeight = parent.totalWeight
+	} else if dispatcher.blockTree.head == nil && bytes.Compare(block.PrevBlockHash, dispatcher.currentChain.LastBlockHash) == 0 {
+		// Block tree is empty only before genesis block is applied
+		parentStateRoot = dispatcher.currentChain.StateMerkleRoot
+		parentTotalWeight = dispatcher.currentChain.TotalWeight
+	} else {
 		return ErrDifferentRoots
 	}
+	if dispatcher.lastFinalized != nil && block.Index <= dispatcher.lastFinalized.BlockIndex {
+		return ErrFinalizedBlock
+	}
+	// Block which can never become part of current chain isn't saved
+	if parent != nil {
+		ancestor, _, _ := dispatcher.blockTree.findFork(dispatcher.blockTree.head, parent)
+		if err := dispatcher.checkForkPoint(ancestor); err != nil {
+			return err
+		}
+	}
+
+	// Full block is saved, so transactions can be restored if block is detached from chain
+	if len(block.Transactions) == 0 && len(transactions) > 0 {
+		block.Transactions = make([][]byte, len(transactions))
+		for i := range transactions {
+			txData, err := proto.Marshal(&transactions[i])
+			if err != nil {
+				return errors.Wrap(err, "applyBlock: failed to encode transaction")
+			}
+			block.Transactions[i] = txData
+		}
+	}
 
 	if err := dispatcher.localStorage.Env.Update(func(txn *lmdb.Txn) error {
 		retrieve := db.RetrieveFn(txn, dispatcher.localStorage.State)
-		updatedChildren, isValidBlock, err := validation.ApplyBlockInMemory(dispatcher.currentChain.StateMerkleRoot, block, transactions, retrieve)
+		updatedChildren, err := validation.ValidateAndExecute(block, parentBlock, parentStateRoot, dispatcher.proofAlgo, retrieve)
 		if err != nil {
-			return errors.Wrap(err, "ApplyBlock: failed to evaluate transaction in memory")
-		}
-		if !isValidBlock {
-			return validation.ErrMalformedBlock
+			return err
 		}
 
 		// Save updated trie up to root node
@@ -118,30 +185,70 @@ func (dispatcher *blockchainDispatcher) ApplyBlock(block blockchain.Block, trans
 				return errors.Wrap(err, "applyBlock: failed to save state trie")
 			}
 		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := dispatcher.localStorage.SaveBlock(block); err != nil {
+		return err
+	}
 
-		// Update blockchain
-		extendedChain := new(blockchain.Blockchain)
-		extendedChain.LastBlockHash = block.CalculateHash()
-		extendedChain.LastBlockIndex = block.Index
-		extendedChain.StateMerkleRoot = dispatcher.stateTrieRoot.CalculateHash()
+	node := &blockTreeNode{
+		hash:        blockHash,
+		index:       block.Index,
+		stateRoot:   block.StateMerkleRoot,
+		totalWeight: parentTotalWeight + dispatcher.blockWeight(block),
+		parent:      parent,
+	}
+	dispatcher.blockTree.add(node)
+	dispatcher.emitBlock(block)
 
-		blockchainData, err := proto.Marshal(extendedChain)
-		if err != nil {
-			return errors.Wrap(err, "applyBlock: failed to encode blockchain data")
-		}
-		if err = txn.Put(dispatcher.localStorage.Blockchain, []byte("chainState"), blockchainData, 0); err != nil {
-			return errors.Wrap(err, "applyBlock: failed to save blockchain data")
+	head := dispatcher.blockTree.head
+	if head == nil || head == parent {
+		if err := dispatcher.setHead(node, []*blockTreeNode{node}, nil); err != nil {
+			dispatcher.blockTree.remove(node)
+			return err
 		}
+		Mempool.Remove(block.TxHashes...)
+		log.Printf("Applied block %s", hex.EncodeToString(blockHash))
+		return dispatcher.voteForBlock(block)
+	}
 
-		if len(updatedChildren) > 0 {
-			dispatcher.stateTrieRoot = updatedChildren[0].Root()
-		}
-		dispatcher.currentChain = extendedChain
+	if node.totalWeight <= head.totalWeight {
+		log.Printf("Saved side chain block %s", hex.EncodeToString(blockHash))
 		return nil
-	}); err != nil {
+	}
+	if err := dispatcher.reorganize(node); err != nil {
+		dispatcher.blockTree.remove(node)
 		return err
 	}
+	return dispatcher.voteForBlock(block)
+}
+
+// blockEventsBufferSize is size of subscriber's channel, blocks are dropped for slow subscribers
+const blockEventsBufferSize = 16
+
+// SubscribeBlocks returns channel receiving blocks successfully applied by ApplyBlock,
+// including blocks of side chains
+func (dispatcher *blockchainDispatcher) SubscribeBlocks() <-chan blockchain.Block {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	blocks := make(chan blockchain.Block, blockEventsBufferSize)
+	dispatcher.blockSubscribers = append(dispatcher.blockSubscribers, blocks)
+	return blocks
+}
+
+func (dispatcher *blockchainDispatcher) emitBlock(block blockchain.Block) {
+	for _, subscriber := range dispatcher.blockSubscribers {
+		select {
+		case subscriber <- block:
+		default:
+		}
+	}
+}
 
-	log.Printf("Applied block %s", hex.EncodeToString(block.CalculateHash()))
-	return dispatcher.localStorage.SaveBlock(block)
+// GetBlock retrieves block with specific hash from local storage. Returns nil if block isn't found
+func (dispatcher *blockchainDispatcher) GetBlock(hash []byte) (*blockchain.Block, error) {
+	return dispatcher.localStorage.GetBlock(hash)
 }
@@ -0,0 +1,168 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+/*
+	Finality layer: authority set votes for applied blocks using consensus.FinalityGadget.
+	Votes and finality certificates are sent to peers using BroadcastFn,
+	finalized blocks cannot be reverted by dispatcher.
+*/
+
+var (
+	// ErrFinalizedBlock is returned if block conflicts with finalized part of chain
+	ErrFinalizedBlock = errors.New("dispatcher: block conflicts with finalized chain")
+	// ErrFinalityNotInitialized is returned if finality gadget wasn't set
+	ErrFinalityNotInitialized = errors.New("dispatcher: finality gadget is not initialized")
+)
+
+// BroadcastFn sends protobuf message with specific ID to all connected peers
+type BroadcastFn = func(messageID byte, message proto.Message)
+
+// SetFinalityGadget enables finality layer in dispatcher.
+// Votes and certificates created by gadget are sent using broadcast function
+func (dispatcher *blockchainDispatcher) SetFinalityGadget(gadget *consensus.FinalityGadget, broadcast BroadcastFn) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	dispatcher.finalityGadget = gadget
+	dispatcher.broadcast = broadcast
+	if dispatcher.lastFinalized != nil {
+		gadget.SetFinalized(dispatcher.lastFinalized.BlockIndex)
+	}
+}
+
+// GetLastFinalized returns certificate of last finalized block or nil if nothing was finalized
+func (dispatcher *blockchainDispatcher) GetLastFinalized() *blockchain.FinalityCertificate {
+	dispatcher.lock.RLock()
+	defer dispatcher.lock.RUnlock()
+	return dispatcher.lastFinalized
+}
+
+// HandleVote processes finality vote received from peer
+func (dispatcher *blockchainDispatcher) HandleVote(vote *protocol.Vote) error {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	if dispatcher.finalityGadget == nil {
+		return ErrFinalityNotInitialized
+	}
+
+	outgoing, cert, err := dispatcher.finalityGadget.AddVote(vote)
+	if err != nil {
+		return err
+	}
+	return dispatcher.processFinalityResult(outgoing, cert)
+}
+
+// ApplyFinalityCertificate verifies certificate received from peer and marks block as finalized
+func (dispatcher *blockchainDispatcher) ApplyFinalityCertificate(cert *blockchain.FinalityCertificate) error {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	if dispatcher.finalityGadget == nil {
+		return ErrFinalityNotInitialized
+	}
+	if err := dispatcher.finalityGadget.VerifyCertificate(cert); err != nil {
+		return err
+	}
+	return dispatcher.finalize(cert)
+}
+
+// voteForBlock creates local authority votes for applied block, dispatcher.lock must be held
+func (dispatcher *blockchainDispatcher) voteForBlock(block blockchain.Block) error {
+	if dispatcher.finalityGadget == nil {
+		return nil
+	}
+	if dispatcher.pendingFinality != nil && dispatcher.pendingFinality.BlockIndex <= block.Index {
+		if err := dispatcher.finalize(dispatcher.pendingFinality); err != nil {
+			log.Printf("Pending finality certificate rejected: %+v", err)
+			dispatcher.pendingFinality = nil
+		}
+	}
+	outgoing, cert := dispatcher.finalityGadget.Prevote(block)
+	return dispatcher.processFinalityResult(outgoing, cert)
+}
+
+func (dispatcher *blockchainDispatcher) processFinalityResult(outgoing []*protocol.Vote, cert *blockchain.FinalityCertificate) error {
+	if dispatcher.broadcast != nil {
+		for _, vote := range outgoing {
+			dispatcher.broadcast(protocol.MessageVote, vote)
+		}
+	}
+	if cert == nil {
+		return nil
+	}
+
+	if err := dispatcher.finalize(cert); err != nil {
+		return err
+	}
+	if dispatcher.broadcast != nil {
+		dispatcher.broadcast(protocol.MessageFinalityCertificate, cert)
+	}
+	return nil
+}
+
+// finalize saves certificate for block on current chain, dispatcher.lock must be held
+func (dispatcher *blockchainDispatcher) finalize(cert *blockchain.FinalityCertificate) error {
+	if dispatcher.lastFinalized != nil && cert.BlockIndex <= dispatcher.lastFinalized.BlockIndex {
+		return nil
+	}
+	// Block may be not applied yet, certificate is retried after next applied block
+	if cert.BlockIndex > dispatcher.currentChain.LastBlockIndex {
+		dispatcher.pendingFinality = cert
+		return nil
+	}
+
+	onCurrentChain, err := dispatcher.isOnCurrentChain(cert.BlockHash, cert.BlockIndex)
+	if err != nil {
+		return errors.Wrap(err, "finalize: failed to lookup finalized block")
+	}
+	if !onCurrentChain {
+		return ErrFinalizedBlock
+	}
+
+	if err := dispatcher.localStorage.SaveFinalityCertificate(*cert); err != nil {
+		return errors.Wrap(err, "finalize: failed to save finality certificate")
+	}
+	dispatcher.lastFinalized = cert
+	dispatcher.pendingFinality = nil
+	dispatcher.finalityGadget.SetFinalized(cert.BlockIndex)
+
+	log.Printf("Finalized block %s", hex.EncodeToString(cert.BlockHash))
+	return nil
+}
+
+// isOnCurrentChain checks whether if block with specific hash and index belongs to current chain
+func (dispatcher *blockchainDispatcher) isOnCurrentChain(blockHash []byte, blockIndex uint64) (bool, error) {
+	if blockIndex > dispatcher.currentChain.LastBlockIndex {
+		return false, nil
+	}
+
+	currentHash := dispatcher.currentChain.LastBlockHash
+	for {
+		block, err := dispatcher.localStorage.GetBlock(currentHash)
+		if err != nil {
+			return false, err
+		}
+		if block == nil {
+			return false, ErrCorruptDatabase
+		}
+		if block.Index == blockIndex {
+			return bytes.Compare(currentHash, blockHash) == 0, nil
+		}
+		if block.Index < blockIndex {
+			return false, nil
+		}
+		currentHash = block.PrevBlockHash
+	}
+}
@@ -0,0 +1,88 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestFinalityVoting(t *testing.T) {
+	/*
+		1. Local authority prevotes applied head A1, side chain block B1 isn't voted for
+		2. Threshold of remote prevotes for B1 doesn't make local authority precommit B1
+		3. Threshold of prevotes for A1 makes local authority precommit A1
+		4. Threshold of precommits finalizes A1, certificate is broadcasted
+	*/
+	defer initTestDispatcher(t)()
+	dispatcher := BlockchainDispatcher
+
+	privKeys := make([]ed25519.PrivateKey, 4)
+	authorities := make([][]byte, 4)
+	for i := range privKeys {
+		privKeys[i] = generateTestKey(t)
+		authorities[i] = privKeys[i].Public().(ed25519.PublicKey)
+	}
+	var sent []proto.Message
+	dispatcher.SetFinalityGadget(consensus.NewFinalityGadget(authorities, privKeys[0]), func(messageID byte, message proto.Message) {
+		sent = append(sent, message)
+	})
+	sentVotes := func(voteType uint32, blockHash []byte) int {
+		count := 0
+		for _, message := range sent {
+			if vote, ok := message.(*protocol.Vote); ok && vote.Type == voteType && bytes.Equal(vote.BlockHash, blockHash) {
+				count++
+			}
+		}
+		return count
+	}
+
+	applyNewBlock := func(parent *blockchain.Block, key ed25519.PrivateKey) *blockchain.Block {
+		block := createTestBlock(t, parent, key)
+		if err := dispatcher.ApplyBlock(*block, nil); err != nil {
+			t.Fatalf("ApplyBlock failed: %+v", err)
+		}
+		return block
+	}
+	genesis := applyNewBlock(nil, generateTestKey(t))
+	hashA := applyNewBlock(genesis, generateTestKey(t)).CalculateHash()
+	hashB := applyNewBlock(genesis, generateTestKey(t)).CalculateHash()
+	if sentVotes(consensus.VotePrevote, hashA) != 1 || sentVotes(consensus.VotePrevote, hashB) != 0 {
+		t.Fatal("Unexpected prevotes of local authority")
+	}
+
+	for i := 1; i < 4; i++ {
+		if err := dispatcher.HandleVote(consensus.SignVote(privKeys[i], consensus.VotePrevote, 1, hashB)); err != nil {
+			t.Fatalf("HandleVote failed: %+v", err)
+		}
+	}
+	if sentVotes(consensus.VotePrecommit, hashB) != 0 {
+		t.Fatal("Local authority precommitted competing block")
+	}
+
+	for i := 1; i < 3; i++ {
+		if err := dispatcher.HandleVote(consensus.SignVote(privKeys[i], consensus.VotePrevote, 1, hashA)); err != nil {
+			t.Fatalf("HandleVote failed: %+v", err)
+		}
+	}
+	if sentVotes(consensus.VotePrecommit, hashA) != 1 {
+		t.Fatal("Local authority didn't precommit head")
+	}
+
+	for i := 1; i < 3; i++ {
+		if err := dispatcher.HandleVote(consensus.SignVote(privKeys[i], consensus.VotePrecommit, 1, hashA)); err != nil {
+			t.Fatalf("HandleVote failed: %+v", err)
+		}
+	}
+	lastFinalized := dispatcher.GetLastFinalized()
+	if lastFinalized == nil || !bytes.Equal(lastFinalized.BlockHash, hashA) {
+		t.Fatal("Head wasn't finalized")
+	}
+	if _, ok := sent[len(sent)-1].(*blockchain.FinalityCertificate); !ok {
+		t.Error("Finality certificate wasn't broadcasted")
+	}
+}
@@ -0,0 +1,220 @@
+package chain
+
+import (
+	"encoding/hex"
+	"log"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+/*
+	Fork-choice rule: dispatcher follows branch with greatest total weight.
+	Weight of every block is calculated by proof algorithm's CalcWeight by default,
+	if weight of every block is 1, this is longest chain rule.
+	On equal weights first seen branch is preferred.
+*/
+
+// WeightFn returns weight of block used by fork-choice rule
+type WeightFn = func(block blockchain.Block) uint64
+
+// LongestChainWeight is WeightFn for longest chain rule
+func LongestChainWeight(block blockchain.Block) uint64 {
+	return 1
+}
+
+// ReorgEvent is emitted when dispatcher switches current chain to another branch
+type ReorgEvent struct {
+	OldHead        []byte
+	NewHead        []byte
+	CommonAncestor []byte
+
+	// DetachedBlocks and AttachedBlocks are hashes of blocks ordered by index
+	DetachedBlocks [][]byte
+	AttachedBlocks [][]byte
+	// OrphanedTxs is count of transactions of detached blocks returned to mempool
+	OrphanedTxs int
+}
+
+// reorgEventsBufferSize is size of subscriber's channel, events are dropped for slow subscribers
+const reorgEventsBufferSize = 16
+
+// SetForkChoice overrides block weight function used by fork-choice rule
+func (dispatcher *blockchainDispatcher) SetForkChoice(weight WeightFn) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	dispatcher.blockWeight = weight
+}
+
+// SubscribeReorgs returns channel receiving chain reorganization events
+func (dispatcher *blockchainDispatcher) SubscribeReorgs() <-chan ReorgEvent {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	events := make(chan ReorgEvent, reorgEventsBufferSize)
+	dispatcher.reorgSubscribers = append(dispatcher.reorgSubscribers, events)
+	return events
+}
+
+func (dispatcher *blockchainDispatcher) emitReorg(event ReorgEvent) {
+	for _, subscriber := range dispatcher.reorgSubscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// setHead switches current chain to specific node and saves chain state, dispatcher.lock must be held.
+// attached and detached are branches from common ancestor to new and old heads accordingly.
+func (dispatcher *blockchainDispatcher) setHead(head *blockTreeNode, attached, detached []*blockTreeNode) error {
+	chainState := &blockchain.Blockchain{
+		LastBlockHash:   head.hash,
+		StateMerkleRoot: head.stateRoot,
+		LastBlockIndex:  head.index,
+		TotalWeight:     head.totalWeight,
+	}
+
+	stateTrieRoot := new(trie.MerkleTrieNode)
+	if err := dispatcher.localStorage.Env.Update(func(txn *lmdb.Txn) error {
+		rootData, err := txn.Get(dispatcher.localStorage.State, head.stateRoot)
+		if err != nil {
+			return errors.Wrap(err, "setHead: failed to get root state from local storage")
+		}
+		if err = stateTrieRoot.SetBytes(append([]byte{}, rootData...)); err != nil {
+			return errors.Wrap(err, "setHead: failed to init root node")
+		}
+
+		for _, node := range detached {
+			if node.index > head.index {
+				if err = dispatcher.localStorage.DeleteCanonicalHash(txn, node.index); err != nil {
+					return errors.Wrap(err, "setHead: failed to update chain index")
+				}
+			}
+		}
+		for _, node := range attached {
+			if err = dispatcher.localStorage.PutCanonicalHash(txn, node.index, node.hash); err != nil {
+				return errors.Wrap(err, "setHead: failed to update chain index")
+			}
+		}
+
+		blockchainData, err := proto.Marshal(chainState)
+		if err != nil {
+			return errors.Wrap(err, "setHead: failed to encode blockchain data")
+		}
+		if err = txn.Put(dispatcher.localStorage.Blockchain, []byte("chainState"), blockchainData, 0); err != nil {
+			return errors.Wrap(err, "setHead: failed to save blockchain data")
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	dispatcher.currentChain = chainState
+	dispatcher.stateTrieRoot = stateTrieRoot
+	dispatcher.blockTree.head = head
+
+	if head.index > maxReorgDepth {
+		pruneIndex := head.index - maxReorgDepth
+		if dispatcher.lastFinalized != nil && dispatcher.lastFinalized.BlockIndex > pruneIndex {
+			pruneIndex = dispatcher.lastFinalized.BlockIndex
+		}
+		dispatcher.blockTree.prune(pruneIndex)
+	}
+	return nil
+}
+
+// checkForkPoint returns error if current chain can't be reorganized to branch forking from it at ancestor:
+// ancestor is unknown, below last finalized block or deeper than maxReorgDepth. dispatcher.lock must be held
+func (dispatcher *blockchainDispatcher) checkForkPoint(ancestor *blockTreeNode) error {
+	if ancestor == nil {
+		return ErrDifferentRoots
+	}
+	if dispatcher.lastFinalized != nil && ancestor.index < dispatcher.lastFinalized.BlockIndex {
+		return ErrFinalizedBlock
+	}
+	if dispatcher.blockTree.head.index > ancestor.index+maxReorgDepth {
+		return ErrReorgTooDeep
+	}
+	return nil
+}
+
+// reorganize switches current chain to branch ending with newHead, dispatcher.lock must be held.
+// Transactions of detached blocks which are not included in new branch are returned to mempool.
+func (dispatcher *blockchainDispatcher) reorganize(newHead *blockTreeNode) error {
+	oldHead := dispatcher.blockTree.head
+	ancestor, detached, attached := dispatcher.blockTree.findFork(oldHead, newHead)
+	if err := dispatcher.checkForkPoint(ancestor); err != nil {
+		return err
+	}
+
+	var includedHashes [][]byte
+	includedTxs := make(map[string]bool)
+	for _, node := range attached {
+		block, err := dispatcher.localStorage.GetBlock(node.hash)
+		if err != nil {
+			return errors.Wrap(err, "reorganize: failed to load attached block")
+		}
+		if block == nil {
+			return ErrCorruptDatabase
+		}
+		for _, txHash := range block.TxHashes {
+			includedTxs[hex.EncodeToString(txHash)] = true
+		}
+		includedHashes = append(includedHashes, block.TxHashes...)
+	}
+
+	var orphanedTxs []blockchain.TX
+	for _, node := range detached {
+		block, err := dispatcher.localStorage.GetBlock(node.hash)
+		if err != nil {
+			return errors.Wrap(err, "reorganize: failed to load detached block")
+		}
+		if block == nil {
+			return ErrCorruptDatabase
+		}
+		for _, txData := range block.Transactions {
+			tx := blockchain.TX{}
+			if err := proto.Unmarshal(txData, &tx); err != nil {
+				return ErrCorruptDatabase
+			}
+			if !includedTxs[hex.EncodeToString(tx.Hash)] {
+				orphanedTxs = append(orphanedTxs, tx)
+			}
+		}
+	}
+
+	// Mempool is changed only after head is switched, so failed reorganization keeps it intact
+	if err := dispatcher.setHead(newHead, attached, detached); err != nil {
+		return err
+	}
+	Mempool.Remove(includedHashes...)
+	for _, tx := range orphanedTxs {
+		if err := Mempool.Add(tx); err != nil {
+			log.Printf("Dropped orphaned transaction %s: %+v", hex.EncodeToString(tx.Hash), err)
+		}
+	}
+
+	event := ReorgEvent{
+		OldHead:        oldHead.hash,
+		NewHead:        newHead.hash,
+		CommonAncestor: ancestor.hash,
+		DetachedBlocks: make([][]byte, len(detached)),
+		AttachedBlocks: make([][]byte, len(attached)),
+		OrphanedTxs:    len(orphanedTxs),
+	}
+	for i, node := range detached {
+		event.DetachedBlocks[i] = node.hash
+	}
+	for i, node := range attached {
+		event.AttachedBlocks[i] = node.hash
+	}
+	dispatcher.emitReorg(event)
+
+	log.Printf("Chain reorganized: new head %s, %d blocks detached, %d blocks attached",
+		hex.EncodeToString(newHead.hash), len(detached), len(attached))
+	return nil
+}
@@ -0,0 +1,280 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/validation"
+)
+
+func initTestDispatcher(t *testing.T) func() {
+	path, err := ioutil.TempDir("", "buuzcoin-chain-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	localStorage, err := db.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed: %+v", err)
+	}
+	if err = InitNullState(localStorage); err != nil {
+		t.Fatalf("InitNullState failed: %+v", err)
+	}
+
+	BlockchainDispatcher = nil
+	initBlockchainDispatcher(localStorage, &testProofAlgo{})
+	return func() {
+		localStorage.Env.Close()
+		os.RemoveAll(path)
+	}
+}
+
+// testProofAlgo accepts headers of all blocks, rewards are applied by PoA rules
+type testProofAlgo struct {
+	consensus.ProofOfAuthority
+}
+
+func (algo *testProofAlgo) IsValidBlock(block blockchain.Block) (bool, error) {
+	return true, nil
+}
+
+func (algo *testProofAlgo) VerifyHeader(block, parent blockchain.Block) error {
+	return nil
+}
+
+func generateTestKey(t *testing.T) ed25519.PrivateKey {
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	return privKey
+}
+
+// createTestBlock creates block on top of parent with evaluated state root signed by beneficiary
+func createTestBlock(t *testing.T, parent *blockchain.Block, beneficiary ed25519.PrivateKey) *blockchain.Block {
+	pubKey := beneficiary.Public().(ed25519.PublicKey)
+	block := &blockchain.Block{
+		Version:       1,
+		PrevBlockHash: bytes.Repeat([]byte{0x00}, 32),
+		TxMerkleRoot:  blockchain.CalculateMerkleRoot(nil),
+		Beneficiary:   network.DeriveAddress(pubKey),
+		ProofData:     []byte{0x01},
+	}
+	parentStateRoot := trie.NullTrieHash
+	if parent != nil {
+		block.Index = parent.Index + 1
+		block.PrevBlockHash = parent.CalculateHash()
+		parentStateRoot = parent.StateMerkleRoot
+	}
+
+	dispatcher := BlockchainDispatcher
+	if err := dispatcher.localStorage.Env.View(func(txn *lmdb.Txn) error {
+		retrieve := db.RetrieveFn(txn, dispatcher.localStorage.State)
+		updatedChildren, _, err := validation.ApplyBlockInMemory(parentStateRoot, *block, nil, dispatcher.proofAlgo, retrieve)
+		if err != nil {
+			return err
+		}
+		block.StateMerkleRoot = updatedChildren[0].Root().CalculateHash()
+		return nil
+	}); err != nil {
+		t.Fatalf("ApplyBlockInMemory failed: %+v", err)
+	}
+
+	block.Signature = append(ed25519.Sign(beneficiary, block.CalculateHash()), pubKey...)
+	return block
+}
+
+func TestChainReorganization(t *testing.T) {
+	/*
+		1. Apply genesis -> A1
+		2. Apply side chain genesis -> B1, it has equal weight and shouldn't become head
+		3. Apply B1 -> B2, chain should be reorganized to B2
+	*/
+	defer initTestDispatcher(t)()
+	dispatcher := BlockchainDispatcher
+	reorgs := dispatcher.SubscribeReorgs()
+
+	// Parent's state must be saved before child block is created
+	applyNewBlock := func(parent *blockchain.Block, key ed25519.PrivateKey) *blockchain.Block {
+		block := createTestBlock(t, parent, key)
+		if err := dispatcher.ApplyBlock(*block, nil); err != nil {
+			t.Fatalf("ApplyBlock failed: %+v", err)
+		}
+		return block
+	}
+
+	keyB := generateTestKey(t)
+	genesis := applyNewBlock(nil, generateTestKey(t))
+	blockA1 := applyNewBlock(genesis, generateTestKey(t))
+	blockB1 := applyNewBlock(genesis, keyB)
+	if bytes.Compare(dispatcher.GetBlockchainState().LastBlockHash, blockA1.CalculateHash()) != 0 {
+		t.Fatal("Side chain block with equal weight became head")
+	}
+	if err := dispatcher.ApplyBlock(*blockB1, nil); err != ErrKnownBlock {
+		t.Errorf("Unexpected error on known block: %+v", err)
+	}
+
+	blockB2 := applyNewBlock(blockB1, keyB)
+	chainState := dispatcher.GetBlockchainState()
+	if bytes.Compare(chainState.LastBlockHash, blockB2.CalculateHash()) != 0 || chainState.LastBlockIndex != 2 {
+		t.Fatal("Chain wasn't reorganized to heavier branch")
+	}
+
+	select {
+	case event := <-reorgs:
+		if len(event.DetachedBlocks) != 1 || len(event.AttachedBlocks) != 2 {
+			t.Errorf("Unexpected reorg event: %d detached, %d attached", len(event.DetachedBlocks), len(event.AttachedBlocks))
+		}
+		if bytes.Compare(event.CommonAncestor, genesis.CalculateHash()) != 0 {
+			t.Error("Unexpected common ancestor")
+		}
+	default:
+		t.Error("Reorg event wasn't emitted")
+	}
+
+	canonicalHash, err := dispatcher.localStorage.GetCanonicalHash(1)
+	if err != nil || bytes.Compare(canonicalHash, blockB1.CalculateHash()) != 0 {
+		t.Errorf("Chain index wasn't updated: %+v", err)
+	}
+
+	account, err := dispatcher.GetAccountState(blockA1.Beneficiary)
+	if err != nil {
+		t.Fatalf("GetAccountState failed: %+v", err)
+	}
+	if account.Balance != 0 {
+		t.Errorf("Detached block's reward is in state: %d", account.Balance)
+	}
+}
+
+func TestApplyBlockValidation(t *testing.T) {
+	/*
+		1. Block with wrong state root should fail on state root stage
+		2. Block with transactions not matching TxHashes should fail on transactions stage
+		3. Block without signature should fail on structure stage
+	*/
+	defer initTestDispatcher(t)()
+	dispatcher := BlockchainDispatcher
+	key := generateTestKey(t)
+
+	genesis := createTestBlock(t, nil, key)
+	if err := dispatcher.ApplyBlock(*genesis, nil); err != nil {
+		t.Fatalf("ApplyBlock failed: %+v", err)
+	}
+
+	checkStage := func(block *blockchain.Block, stage validation.Stage) {
+		err := dispatcher.ApplyBlock(*block, nil)
+		stageErr, ok := err.(*validation.StageError)
+		if !ok || stageErr.Stage != stage {
+			t.Errorf("Expected %s stage error, got: %+v", stage, err)
+		}
+	}
+
+	block := createTestBlock(t, genesis, key)
+	block.StateMerkleRoot = genesis.StateMerkleRoot
+	block.Signature = append(ed25519.Sign(key, block.CalculateHash()), key.Public().(ed25519.PublicKey)...)
+	checkStage(block, validation.StageStateRoot)
+
+	block = createTestBlock(t, genesis, key)
+	block.Transactions = [][]byte{{0x01}}
+	checkStage(block, validation.StageTransactions)
+
+	block = createTestBlock(t, genesis, key)
+	block.Signature = nil
+	checkStage(block, validation.StageStructure)
+}
+
+func TestFailedReorganizationKeepsMempool(t *testing.T) {
+	/*
+		1. Transaction of side chain block is in mempool
+		2. Switching head to side chain block fails because its state is missing
+		3. Transaction should stay in mempool
+	*/
+	defer initTestDispatcher(t)()
+	dispatcher := BlockchainDispatcher
+	genesis := createTestBlock(t, nil, generateTestKey(t))
+	if err := dispatcher.ApplyBlock(*genesis, nil); err != nil {
+		t.Fatalf("ApplyBlock failed: %+v", err)
+	}
+
+	privKey := generateTestKey(t)
+	pubKey := privKey.Public().(ed25519.PublicKey)
+	tx := blockchain.TX{
+		Version:  network.CurrentTxVersion,
+		From:     network.DeriveAddress(pubKey),
+		To:       make([]byte, network.AddressSize),
+		GasPrice: network.MinimalGasFee,
+	}
+	tx.Hash = tx.CalculateHash()
+	tx.Signature = append(ed25519.Sign(privKey, tx.Hash), pubKey...)
+	if err := Mempool.Add(tx); err != nil {
+		t.Fatalf("Mempool.Add failed: %+v", err)
+	}
+	defer Mempool.Remove(tx.Hash)
+
+	block := blockchain.Block{Index: 1, PrevBlockHash: genesis.CalculateHash(), TxHashes: [][]byte{tx.Hash}}
+	if err := dispatcher.localStorage.SaveBlock(block); err != nil {
+		t.Fatalf("SaveBlock failed: %+v", err)
+	}
+	node := &blockTreeNode{
+		hash:        block.CalculateHash(),
+		index:       1,
+		stateRoot:   bytes.Repeat([]byte{0xFF}, 32),
+		totalWeight: dispatcher.blockTree.head.totalWeight + 1,
+		parent:      dispatcher.blockTree.head,
+	}
+	dispatcher.blockTree.add(node)
+
+	dispatcher.lock.Lock()
+	err := dispatcher.reorganize(node)
+	dispatcher.lock.Unlock()
+	if err == nil {
+		t.Fatal("Head was switched to block without state")
+	}
+	if Mempool.Get(tx.Hash) == nil {
+		t.Error("Transaction was removed from mempool after failed reorganization")
+	}
+}
+
+func TestSideBlockBelowFinalized(t *testing.T) {
+	/*
+		1. Apply genesis -> A1 -> A2 and side chain genesis -> B1 -> B2
+		2. A2 is finalized, B3 forks from current chain below finalized block
+		3. B3 should be refused and not added to block tree
+	*/
+	defer initTestDispatcher(t)()
+	dispatcher := BlockchainDispatcher
+
+	applyNewBlock := func(parent *blockchain.Block, key ed25519.PrivateKey) *blockchain.Block {
+		block := createTestBlock(t, parent, key)
+		if err := dispatcher.ApplyBlock(*block, nil); err != nil {
+			t.Fatalf("ApplyBlock failed: %+v", err)
+		}
+		return block
+	}
+	keyA, keyB := generateTestKey(t), generateTestKey(t)
+	genesis := applyNewBlock(nil, generateTestKey(t))
+	blockA1 := applyNewBlock(genesis, keyA)
+	blockA2 := applyNewBlock(blockA1, keyA)
+	blockB2 := applyNewBlock(applyNewBlock(genesis, keyB), keyB)
+	dispatcher.lastFinalized = &blockchain.FinalityCertificate{BlockIndex: 2, BlockHash: blockA2.CalculateHash()}
+
+	blockB3 := createTestBlock(t, blockB2, keyB)
+	if err := dispatcher.ApplyBlock(*blockB3, nil); err != ErrFinalizedBlock {
+		t.Fatalf("Unexpected error: %+v, expected ErrFinalizedBlock", err)
+	}
+	if dispatcher.blockTree.get(blockB3.CalculateHash()) != nil {
+		t.Error("Refused block was added to block tree")
+	}
+	if len(dispatcher.blockTree.get(blockB2.CalculateHash()).children) != 0 {
+		t.Error("Refused block is child of its parent")
+	}
+}
@@ -79,7 +79,7 @@ func loadGenesisBlock(genesisBlockFile string, localStorage *db.LocalStorage,
 // If it is not found, blockchain is initialized from genesisBlockFile
 func InitBlockchainState(genesisBlockFile string, localStorage *db.LocalStorage,
 	proofAlgo consensus.ProofAlgorithm) error {
-	initBlockchainDispatcher(localStorage)
+	initBlockchainDispatcher(localStorage, proofAlgo)
 
 	genesisBlock, err := loadGenesisBlock(genesisBlockFile, localStorage, proofAlgo)
 	if err != nil {
@@ -107,6 +107,15 @@ func InitBlockchainState(genesisBlockFile string, localStorage *db.LocalStorage,
 		return errors.New("InitBlockchainState: corrupt database, cannot load blockchain state")
 	}
 	BlockchainDispatcher.currentChain = blockchainCurrentState
+	lastFinalized, err := localStorage.GetLastFinalityCertificate()
+	if err != nil {
+		return errors.Wrap(err, "InitBlockchainState: failed to load last finality certificate")
+	}
+	BlockchainDispatcher.lastFinalized = lastFinalized
+
+	if err := BlockchainDispatcher.loadBlockTree(); err != nil {
+		return errors.Wrap(err, "InitBlockchainState: failed to load block tree")
+	}
 	log.Printf("Loaded blockchain, last block is: %s\n", hex.EncodeToString(blockchainCurrentState.LastBlockHash))
 
 	if err := BlockchainDispatcher.loadStateTrie(); err != nil {
@@ -116,6 +125,56 @@ func InitBlockchainState(genesisBlockFile string, localStorage *db.LocalStorage,
 	return nil
 }
 
+// loadBlockTree adds blocks of current chain down to prune index to block tree,
+// so blocks forking from them can be applied after restart
+func (dispatcher *blockchainDispatcher) loadBlockTree() error {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	chainState := dispatcher.currentChain
+	var pruneIndex uint64
+	if chainState.LastBlockIndex > maxReorgDepth {
+		pruneIndex = chainState.LastBlockIndex - maxReorgDepth
+	}
+	if dispatcher.lastFinalized != nil && dispatcher.lastFinalized.BlockIndex > pruneIndex {
+		pruneIndex = dispatcher.lastFinalized.BlockIndex
+	}
+
+	// Nodes are loaded from head, total weight of parent is weight of child without child's weight
+	var nodes []*blockTreeNode
+	blockHash := chainState.LastBlockHash
+	totalWeight := chainState.TotalWeight
+	for {
+		block, err := dispatcher.localStorage.GetBlock(blockHash)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return ErrCorruptDatabase
+		}
+		nodes = append(nodes, &blockTreeNode{
+			hash:        blockHash,
+			index:       block.Index,
+			stateRoot:   block.StateMerkleRoot,
+			totalWeight: totalWeight,
+		})
+		if block.Index <= pruneIndex {
+			break
+		}
+		blockHash = block.PrevBlockHash
+		totalWeight -= dispatcher.blockWeight(*block)
+	}
+
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if i < len(nodes)-1 {
+			nodes[i].parent = nodes[i+1]
+		}
+		dispatcher.blockTree.add(nodes[i])
+	}
+	dispatcher.blockTree.head = nodes[0]
+	return nil
+}
+
 func initStateFromGenesisBlock(localStorage *db.LocalStorage) error {
 	if err := BlockchainDispatcher.ApplyBlock(*BlockchainDispatcher.genesisBlock, []blockchain.TX{}); err != nil {
 		return errors.Wrap(err, "initStateFromGenesisBlock: applying block failed")
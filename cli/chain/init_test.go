@@ -0,0 +1,57 @@
+package chain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+)
+
+func TestLoadBlockTree(t *testing.T) {
+	/*
+		1. Apply chain genesis -> A1 -> A2 -> A3
+		2. Block tree is reloaded from local storage, as after restart
+		3. Canonical blocks are loaded with parents and total weights, head is A3
+		4. Block forking from A1 can be applied
+	*/
+	defer initTestDispatcher(t)()
+	dispatcher := BlockchainDispatcher
+
+	var blocks []*blockchain.Block
+	var parent *blockchain.Block
+	for i := 0; i < 4; i++ {
+		parent = createTestBlock(t, parent, generateTestKey(t))
+		if err := dispatcher.ApplyBlock(*parent, nil); err != nil {
+			t.Fatalf("ApplyBlock failed: %+v", err)
+		}
+		blocks = append(blocks, parent)
+	}
+	expected := dispatcher.blockTree.head
+
+	dispatcher.blockTree = newBlockTree()
+	if err := dispatcher.loadBlockTree(); err != nil {
+		t.Fatalf("loadBlockTree failed: %+v", err)
+	}
+	head := dispatcher.blockTree.head
+	if !bytes.Equal(head.hash, expected.hash) || head.totalWeight != expected.totalWeight {
+		t.Fatal("Unexpected head of loaded block tree")
+	}
+	if len(dispatcher.blockTree.nodes) != len(blocks) {
+		t.Fatalf("Unexpected count of loaded blocks: %d", len(dispatcher.blockTree.nodes))
+	}
+	node := head
+	for i := len(blocks) - 1; i > 0; i-- {
+		if node.parent == nil || !bytes.Equal(node.parent.hash, blocks[i-1].CalculateHash()) {
+			t.Fatalf("Block %d isn't linked to parent", i)
+		}
+		if node.totalWeight <= node.parent.totalWeight {
+			t.Fatalf("Unexpected total weight of block %d", i)
+		}
+		node = node.parent
+	}
+
+	fork := createTestBlock(t, blocks[1], generateTestKey(t))
+	if err := dispatcher.ApplyBlock(*fork, nil); err != nil {
+		t.Fatalf("Fork of loaded block wasn't applied: %+v", err)
+	}
+}
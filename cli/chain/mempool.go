@@ -0,0 +1,103 @@
+package chain
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/network/validation"
+)
+
+// TxPool stores transactions waiting to be included in block
+type TxPool struct {
+	transactions map[string]blockchain.TX
+	subscribers  []chan blockchain.TX
+	lock         *sync.RWMutex
+}
+
+// txEventsBufferSize is size of subscriber's channel, transactions are dropped for slow subscribers
+const txEventsBufferSize = 256
+
+// Mempool is pool of pending transactions of local node
+var Mempool = NewTxPool()
+
+// NewTxPool creates empty transaction pool
+func NewTxPool() *TxPool {
+	return &TxPool{
+		transactions: make(map[string]blockchain.TX),
+		lock:         &sync.RWMutex{},
+	}
+}
+
+// Add puts transaction in pool. Transaction hash and signature are checked before insertion,
+// verified transaction is cached, so it isn't verified again on block import
+func (pool *TxPool) Add(tx blockchain.TX) error {
+	if err := validation.PreverifyTransactions([]blockchain.TX{tx}, validation.VerifiedTxs); err != nil {
+		return err
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	key := hex.EncodeToString(tx.Hash)
+	if _, exists := pool.transactions[key]; exists {
+		return nil
+	}
+	pool.transactions[key] = tx
+	for _, subscriber := range pool.subscribers {
+		select {
+		case subscriber <- tx:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns channel receiving transactions added to pool
+func (pool *TxPool) Subscribe() <-chan blockchain.TX {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	transactions := make(chan blockchain.TX, txEventsBufferSize)
+	pool.subscribers = append(pool.subscribers, transactions)
+	return transactions
+}
+
+// Remove deletes transactions with specific hashes from pool
+func (pool *TxPool) Remove(txHashes ...[]byte) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	for _, txHash := range txHashes {
+		delete(pool.transactions, hex.EncodeToString(txHash))
+	}
+}
+
+// Get retrieves transaction with specific hash. Returns nil if transaction is not in pool
+func (pool *TxPool) Get(txHash []byte) *blockchain.TX {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	tx, exists := pool.transactions[hex.EncodeToString(txHash)]
+	if !exists {
+		return nil
+	}
+	return &tx
+}
+
+// Pending returns all transactions in pool
+func (pool *TxPool) Pending() []blockchain.TX {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	result := make([]blockchain.TX, 0, len(pool.transactions))
+	for _, tx := range pool.transactions {
+		result = append(result, tx)
+	}
+	return result
+}
+
+// Size returns count of transactions in pool
+func (pool *TxPool) Size() int {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	return len(pool.transactions)
+}
@@ -0,0 +1,78 @@
+package chain
+
+import (
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network/validation"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+/*
+	New block is produced on top of current head:
+	1. Proof algorithm prepares consensus fields of block
+	2. Transactions are evaluated in memory to get state root
+	3. Proof algorithm seals block
+	Produced block isn't applied, it should be passed to ApplyBlock.
+*/
+
+// CreateBlock produces sealed block with specific transactions on top of current chain
+func (dispatcher *blockchainDispatcher) CreateBlock(transactions []blockchain.TX, additionalData []byte) (*blockchain.Block, error) {
+	dispatcher.lock.RLock()
+	defer dispatcher.lock.RUnlock()
+
+	parent, err := dispatcher.localStorage.GetBlock(dispatcher.currentChain.LastBlockHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateBlock: failed to load parent block")
+	}
+	if parent == nil {
+		return nil, ErrCorruptDatabase
+	}
+
+	if err = validation.PreverifyTransactions(transactions, validation.VerifiedTxs); err != nil {
+		return nil, errors.Wrap(err, "CreateBlock: invalid transaction")
+	}
+
+	block := &blockchain.Block{
+		Version:        validation.CurrentBlockVersion,
+		AdditionalData: additionalData,
+		TxHashes:       make([][]byte, len(transactions)),
+		Transactions:   make([][]byte, len(transactions)),
+	}
+	for i := range transactions {
+		block.TxHashes[i] = transactions[i].Hash
+		if block.Transactions[i], err = proto.Marshal(&transactions[i]); err != nil {
+			return nil, errors.Wrap(err, "CreateBlock: failed to encode transaction")
+		}
+	}
+	block.TxMerkleRoot = blockchain.CalculateMerkleRoot(block.TxHashes)
+
+	if err = dispatcher.proofAlgo.Prepare(block, *parent); err != nil {
+		return nil, errors.Wrap(err, "CreateBlock: failed to prepare block")
+	}
+
+	block.StateMerkleRoot = dispatcher.currentChain.StateMerkleRoot
+	if err = dispatcher.localStorage.Env.View(func(txn *lmdb.Txn) error {
+		retrieve := db.RetrieveFn(txn, dispatcher.localStorage.State)
+		updatedChildren, isValidBlock, err := validation.ApplyBlockInMemory(
+			dispatcher.currentChain.StateMerkleRoot, *block, transactions, dispatcher.proofAlgo, retrieve)
+		if err != nil {
+			return err
+		}
+		if !isValidBlock {
+			return validation.ErrMalformedBlock
+		}
+		if len(updatedChildren) > 0 {
+			block.StateMerkleRoot = updatedChildren[0].Root().CalculateHash()
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "CreateBlock: failed to evaluate transactions")
+	}
+
+	if err = dispatcher.proofAlgo.Seal(block); err != nil {
+		return nil, errors.Wrap(err, "CreateBlock: failed to seal block")
+	}
+	return block, nil
+}
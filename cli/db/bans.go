@@ -0,0 +1,133 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+/*
+	Bans are stored in node DBI under key "ban:"+kind+value, where kind is 'n' for
+	banned node ID and 'i' for banned IP address (16 bytes). Value format:
+	BannedUntil (8 bytes, unix time) | Reason
+*/
+
+const (
+	banKindNode = 'n'
+	banKindIP   = 'i'
+)
+
+var banPrefix = []byte("ban:")
+
+// ErrInvalidBan is returned if ban has neither node ID nor IP address
+var ErrInvalidBan = errors.New("db: ban has no node ID or IP address")
+
+// Ban is ban of node ID or IP address saved in local storage, only one of NodeID and IP is set
+type Ban struct {
+	NodeID []byte
+	IP     net.IP
+	Until  time.Time
+	Reason string
+}
+
+// Expired checks whether ban is expired at specific time
+func (ban *Ban) Expired(now time.Time) bool {
+	return !now.Before(ban.Until)
+}
+
+func (ban *Ban) key() ([]byte, error) {
+	key := append([]byte{}, banPrefix...)
+	if ban.NodeID != nil {
+		return append(append(key, banKindNode), ban.NodeID...), nil
+	}
+	if ip := ban.IP.To16(); ip != nil {
+		return append(append(key, banKindIP), ip...), nil
+	}
+	return nil, ErrInvalidBan
+}
+
+func decodeBan(key, value []byte) *Ban {
+	if len(key) <= len(banPrefix)+1 || len(value) < 8 {
+		return nil
+	}
+	ban := &Ban{
+		Until:  time.Unix(int64(binary.LittleEndian.Uint64(value[0:8])), 0),
+		Reason: string(value[8:]),
+	}
+	data := append([]byte{}, key[len(banPrefix)+1:]...)
+	switch key[len(banPrefix)] {
+	case banKindNode:
+		ban.NodeID = data
+	case banKindIP:
+		if len(data) != net.IPv6len {
+			return nil
+		}
+		ban.IP = net.IP(data)
+	default:
+		return nil
+	}
+	return ban
+}
+
+// SaveBan saves ban of node ID or IP address, existing ban is replaced
+func (storage *LocalStorage) SaveBan(ban *Ban) error {
+	key, err := ban.key()
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 8, 8+len(ban.Reason))
+	binary.LittleEndian.PutUint64(value, uint64(ban.Until.Unix()))
+	value = append(value, ban.Reason...)
+	return storage.Env.Update(func(txn *lmdb.Txn) error {
+		return txn.Put(storage.Node, key, value, 0)
+	})
+}
+
+// RemoveBan removes ban of ban.NodeID or ban.IP. Returns false if it wasn't banned
+func (storage *LocalStorage) RemoveBan(ban *Ban) (bool, error) {
+	key, err := ban.key()
+	if err != nil {
+		return false, err
+	}
+	err = storage.Env.Update(func(txn *lmdb.Txn) error {
+		return txn.Del(storage.Node, key, nil)
+	})
+	if lmdb.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetBans removes expired and invalid bans from local storage and returns active bans
+func (storage *LocalStorage) GetBans() ([]*Ban, error) {
+	var bans []*Ban
+	now := time.Now()
+	err := storage.Env.Update(func(txn *lmdb.Txn) error {
+		cursor, err := txn.OpenCursor(storage.Node)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+
+		key, value, err := cursor.Get(banPrefix, nil, lmdb.SetRange)
+		for ; err == nil && bytes.HasPrefix(key, banPrefix); key, value, err = cursor.Get(nil, nil, lmdb.Next) {
+			ban := decodeBan(key, value)
+			if ban != nil && !ban.Expired(now) {
+				bans = append(bans, ban)
+				continue
+			}
+			if err = cursor.Del(0); err != nil {
+				return err
+			}
+		}
+		if err != nil && !lmdb.IsNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	return bans, err
+}
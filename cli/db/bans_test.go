@@ -0,0 +1,56 @@
+package db
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBans(t *testing.T) {
+	/*
+		1. Ban node ID and IP address, expired ban is saved too
+		2. GetBans returns active bans and removes expired one
+		3. Removed ban isn't returned
+	*/
+	storage, cleanup := initTestStorage(t)
+	defer cleanup()
+
+	nodeID := bytes.Repeat([]byte{0x01}, 20)
+	bans := []*Ban{
+		{NodeID: nodeID, Until: time.Now().Add(time.Hour), Reason: "invalid block"},
+		{IP: net.ParseIP("8.8.8.8"), Until: time.Now().Add(time.Hour)},
+		{NodeID: bytes.Repeat([]byte{0x02}, 20), Until: time.Now().Add(-time.Hour)},
+	}
+	for _, ban := range bans {
+		if err := storage.SaveBan(ban); err != nil {
+			t.Fatalf("SaveBan failed: %+v", err)
+		}
+	}
+	if err := storage.SaveBan(&Ban{Until: time.Now().Add(time.Hour)}); err != ErrInvalidBan {
+		t.Errorf("Ban without node ID and IP was saved: %+v", err)
+	}
+
+	active, err := storage.GetBans()
+	if err != nil || len(active) != 2 {
+		t.Fatalf("Unexpected active bans count: %d, %+v", len(active), err)
+	}
+	for _, ban := range active {
+		if ban.NodeID != nil && (!bytes.Equal(ban.NodeID, nodeID) || ban.Reason != "invalid block") {
+			t.Errorf("Unexpected node ban: %x, %s", ban.NodeID, ban.Reason)
+		}
+		if ban.IP != nil && !ban.IP.Equal(net.ParseIP("8.8.8.8")) {
+			t.Errorf("Unexpected IP ban: %s", ban.IP)
+		}
+	}
+	if removed, err := storage.RemoveBan(bans[2]); err != nil || removed {
+		t.Errorf("Expired ban wasn't removed by GetBans: %+v", err)
+	}
+
+	if removed, err := storage.RemoveBan(&Ban{IP: net.ParseIP("8.8.8.8")}); err != nil || !removed {
+		t.Fatalf("RemoveBan failed: %+v", err)
+	}
+	if active, _ = storage.GetBans(); len(active) != 1 || active[0].NodeID == nil {
+		t.Error("Removed ban is still active")
+	}
+}
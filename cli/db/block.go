@@ -1,13 +1,16 @@
 package db
 
 import (
+	"bytes"
+	"encoding/binary"
+
 	"github.com/bmatsuo/lmdb-go/lmdb"
 	"github.com/buuzcoin/go-buuzcoin/blockchain"
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 )
 
-// SaveBlock saves block to local storage
+// SaveBlock saves block to local storage and indexes its transactions
 func (storage *LocalStorage) SaveBlock(block blockchain.Block) error {
 	blockHash := block.CalculateHash()
 	blockData, err := proto.Marshal(&block)
@@ -16,7 +19,15 @@ func (storage *LocalStorage) SaveBlock(block blockchain.Block) error {
 	}
 
 	return storage.Env.Update(func(txn *lmdb.Txn) error {
-		return txn.Put(storage.Blockchain, blockHash, blockData, 0)
+		if err := txn.Put(storage.Blockchain, blockHash, blockData, 0); err != nil {
+			return err
+		}
+		for _, txHash := range block.TxHashes {
+			if err := txn.Put(storage.Blockchain, txLocationKey(txHash), blockHash, 0); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
@@ -41,3 +52,77 @@ func (storage *LocalStorage) GetBlock(hash []byte) (*blockchain.Block, error) {
 	}
 	return block, nil
 }
+
+func canonicalKey(index uint64) []byte {
+	key := append([]byte("index:"), make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[len(key)-8:], index)
+	return key
+}
+
+// PutCanonicalHash saves hash of current chain's block with specific index
+func (storage *LocalStorage) PutCanonicalHash(txn *lmdb.Txn, index uint64, hash []byte) error {
+	return txn.Put(storage.Blockchain, canonicalKey(index), hash, 0)
+}
+
+// DeleteCanonicalHash removes current chain's block with specific index
+func (storage *LocalStorage) DeleteCanonicalHash(txn *lmdb.Txn, index uint64) error {
+	if err := txn.Del(storage.Blockchain, canonicalKey(index), nil); err != nil && !lmdb.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// GetCanonicalHash retrieves hash of current chain's block with specific index.
+// Returns nil if block was not found.
+func (storage *LocalStorage) GetCanonicalHash(index uint64) ([]byte, error) {
+	var hash []byte
+	if err := storage.Env.View(func(txn *lmdb.Txn) error {
+		data, err := txn.Get(storage.Blockchain, canonicalKey(index))
+		if err != nil {
+			return err
+		}
+		hash = make([]byte, len(data))
+		copy(hash, data)
+		return nil
+	}); err != nil {
+		if lmdb.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hash, nil
+}
+
+func txLocationKey(txHash []byte) []byte {
+	return append([]byte("tx:"), txHash...)
+}
+
+// GetTransaction retrieves encoded transaction with specific hash from saved blocks.
+// Returns nil if transaction was not found.
+func (storage *LocalStorage) GetTransaction(txHash []byte) ([]byte, error) {
+	var blockHash []byte
+	if err := storage.Env.View(func(txn *lmdb.Txn) error {
+		data, err := txn.Get(storage.Blockchain, txLocationKey(txHash))
+		if err != nil {
+			return err
+		}
+		blockHash = append([]byte{}, data...)
+		return nil
+	}); err != nil {
+		if lmdb.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	block, err := storage.GetBlock(blockHash)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	for i, blockTxHash := range block.TxHashes {
+		if bytes.Compare(blockTxHash, txHash) == 0 && i < len(block.Transactions) {
+			return block.Transactions[i], nil
+		}
+	}
+	return nil, nil
+}
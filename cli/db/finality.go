@@ -0,0 +1,61 @@
+package db
+
+import (
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+var lastFinalizedKey = []byte("lastFinalized")
+
+func finalityKey(blockHash []byte) []byte {
+	return append([]byte("finality:"), blockHash...)
+}
+
+// SaveFinalityCertificate saves certificate alongside block and marks block as last finalized one
+func (storage *LocalStorage) SaveFinalityCertificate(cert blockchain.FinalityCertificate) error {
+	certData, err := proto.Marshal(&cert)
+	if err != nil {
+		return errors.Wrap(err, "SaveFinalityCertificate: certificate marshalling failed")
+	}
+
+	return storage.Env.Update(func(txn *lmdb.Txn) error {
+		if err := txn.Put(storage.Blockchain, finalityKey(cert.BlockHash), certData, 0); err != nil {
+			return err
+		}
+		return txn.Put(storage.Blockchain, lastFinalizedKey, certData, 0)
+	})
+}
+
+func (storage *LocalStorage) getFinalityCertificate(key []byte) (*blockchain.FinalityCertificate, error) {
+	var certData []byte
+	if err := storage.Env.View(func(txn *lmdb.Txn) error {
+		var err error
+		certData, err = txn.Get(storage.Blockchain, key)
+		return err
+	}); err != nil {
+		if lmdb.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	cert := new(blockchain.FinalityCertificate)
+	if err := proto.Unmarshal(certData, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// GetFinalityCertificate retrieves finality certificate of block from local storage.
+// Returns nil pointer if block is not finalized.
+func (storage *LocalStorage) GetFinalityCertificate(blockHash []byte) (*blockchain.FinalityCertificate, error) {
+	return storage.getFinalityCertificate(finalityKey(blockHash))
+}
+
+// GetLastFinalityCertificate retrieves certificate of last finalized block from local storage.
+// Returns nil pointer if no blocks were finalized.
+func (storage *LocalStorage) GetLastFinalityCertificate() (*blockchain.FinalityCertificate, error) {
+	return storage.getFinalityCertificate(lastFinalizedKey)
+}
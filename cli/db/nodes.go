@@ -0,0 +1,205 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Known nodes are stored in node DBI under key "knownNode:"+nodeID in format:
+	LastSeen (8 bytes, unix time) | Failures (4 bytes) | SeqID (4 bytes) | SealedNodeRecord
+	Only record with highest SeqID is kept for each node.
+*/
+
+// MaxNodeFailures is count of consecutive failed contacts after which known node is removed
+const MaxNodeFailures = 5
+
+const knownNodeHeaderSize = 8 + 4 + 4
+
+var knownNodePrefix = []byte("knownNode:")
+
+// ErrInvalidKnownNode is returned if stored known node cannot be decoded
+var ErrInvalidKnownNode = errors.New("db: invalid known node")
+
+// KnownNode is record of remote node saved in local storage
+type KnownNode struct {
+	SealedRecord *protocol.SealedNodeRecord
+	SeqID        uint32
+	LastSeen     time.Time
+	Failures     uint32
+}
+
+func knownNodeKey(nodeID []byte) []byte {
+	return append(append([]byte{}, knownNodePrefix...), nodeID...)
+}
+
+func (knownNode *KnownNode) toBytes() ([]byte, error) {
+	recordData, err := proto.Marshal(knownNode.SealedRecord)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, knownNodeHeaderSize, knownNodeHeaderSize+len(recordData))
+	binary.LittleEndian.PutUint64(data[0:8], uint64(knownNode.LastSeen.Unix()))
+	binary.LittleEndian.PutUint32(data[8:12], knownNode.Failures)
+	binary.LittleEndian.PutUint32(data[12:16], knownNode.SeqID)
+	return append(data, recordData...), nil
+}
+
+func decodeKnownNode(data []byte) (*KnownNode, error) {
+	if len(data) < knownNodeHeaderSize {
+		return nil, ErrInvalidKnownNode
+	}
+	knownNode := &KnownNode{
+		LastSeen:     time.Unix(int64(binary.LittleEndian.Uint64(data[0:8])), 0),
+		Failures:     binary.LittleEndian.Uint32(data[8:12]),
+		SeqID:        binary.LittleEndian.Uint32(data[12:16]),
+		SealedRecord: new(protocol.SealedNodeRecord),
+	}
+	if err := proto.Unmarshal(data[knownNodeHeaderSize:], knownNode.SealedRecord); err != nil {
+		return nil, ErrInvalidKnownNode
+	}
+	return knownNode, nil
+}
+
+func (storage *LocalStorage) getKnownNode(txn *lmdb.Txn, nodeID []byte) (*KnownNode, error) {
+	data, err := txn.Get(storage.Node, knownNodeKey(nodeID))
+	if lmdb.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKnownNode(data)
+}
+
+func (storage *LocalStorage) putKnownNode(txn *lmdb.Txn, nodeID []byte, knownNode *KnownNode) error {
+	data, err := knownNode.toBytes()
+	if err != nil {
+		return err
+	}
+	return txn.Put(storage.Node, knownNodeKey(nodeID), data, 0)
+}
+
+// NodeSeen saves record of node which was successfully contacted and resets its failure count.
+// Stored record is replaced only if nodeRecord has higher SeqID
+func (storage *LocalStorage) NodeSeen(nodeRecord *protocol.NodeRecord) error {
+	if nodeRecord.Sealed == nil {
+		return errors.New("NodeSeen: node record isn't sealed")
+	}
+	return storage.Env.Update(func(txn *lmdb.Txn) error {
+		knownNode, err := storage.getKnownNode(txn, nodeRecord.NodeID)
+		if err != nil && err != ErrInvalidKnownNode {
+			return err
+		}
+		if knownNode == nil || knownNode.SeqID < nodeRecord.SeqID {
+			knownNode = &KnownNode{SealedRecord: nodeRecord.Sealed, SeqID: nodeRecord.SeqID}
+		}
+		knownNode.LastSeen = time.Now()
+		knownNode.Failures = 0
+		return storage.putKnownNode(txn, nodeRecord.NodeID, knownNode)
+	})
+}
+
+// UpdateNodeRecord replaces stored record of known node if nodeRecord has higher SeqID.
+// Returns true if record was replaced
+func (storage *LocalStorage) UpdateNodeRecord(nodeRecord *protocol.NodeRecord) (bool, error) {
+	if nodeRecord.Sealed == nil {
+		return false, errors.New("UpdateNodeRecord: node record isn't sealed")
+	}
+	updated := false
+	err := storage.Env.Update(func(txn *lmdb.Txn) error {
+		knownNode, err := storage.getKnownNode(txn, nodeRecord.NodeID)
+		if err != nil || knownNode == nil || knownNode.SeqID >= nodeRecord.SeqID {
+			return err
+		}
+		knownNode.SealedRecord = nodeRecord.Sealed
+		knownNode.SeqID = nodeRecord.SeqID
+		updated = true
+		return storage.putKnownNode(txn, nodeRecord.NodeID, knownNode)
+	})
+	return updated, err
+}
+
+// NodeFailed increments failure count of known node. Node is removed after MaxNodeFailures
+// failures, then true is returned
+func (storage *LocalStorage) NodeFailed(nodeID []byte) (bool, error) {
+	removed := false
+	err := storage.Env.Update(func(txn *lmdb.Txn) error {
+		knownNode, err := storage.getKnownNode(txn, nodeID)
+		if err != nil && err != ErrInvalidKnownNode {
+			return err
+		}
+		if knownNode == nil {
+			return nil
+		}
+
+		knownNode.Failures++
+		if err == ErrInvalidKnownNode || knownNode.Failures >= MaxNodeFailures {
+			removed = true
+			return txn.Del(storage.Node, knownNodeKey(nodeID), nil)
+		}
+		return storage.putKnownNode(txn, nodeID, knownNode)
+	})
+	return removed, err
+}
+
+// forEachKnownNode iterates over known nodes in node DBI
+func (storage *LocalStorage) forEachKnownNode(txn *lmdb.Txn, fn func(cursor *lmdb.Cursor, knownNode *KnownNode) error) error {
+	cursor, err := txn.OpenCursor(storage.Node)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	key, value, err := cursor.Get(knownNodePrefix, nil, lmdb.SetRange)
+	for ; err == nil && bytes.HasPrefix(key, knownNodePrefix); key, value, err = cursor.Get(nil, nil, lmdb.Next) {
+		knownNode, decodeErr := decodeKnownNode(value)
+		if decodeErr != nil {
+			knownNode = nil
+		}
+		if err = fn(cursor, knownNode); err != nil {
+			return err
+		}
+	}
+	if err != nil && !lmdb.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// GetKnownNodes returns all known nodes from local storage
+func (storage *LocalStorage) GetKnownNodes() ([]*KnownNode, error) {
+	var knownNodes []*KnownNode
+	err := storage.Env.View(func(txn *lmdb.Txn) error {
+		return storage.forEachKnownNode(txn, func(_ *lmdb.Cursor, knownNode *KnownNode) error {
+			if knownNode != nil {
+				knownNodes = append(knownNodes, knownNode)
+			}
+			return nil
+		})
+	})
+	return knownNodes, err
+}
+
+// ExpireKnownNodes removes known nodes which weren't seen for maxAge and
+// entries which cannot be decoded. Returns count of removed nodes
+func (storage *LocalStorage) ExpireKnownNodes(maxAge time.Duration) (int, error) {
+	removed := 0
+	expiration := time.Now().Add(-maxAge)
+	err := storage.Env.Update(func(txn *lmdb.Txn) error {
+		return storage.forEachKnownNode(txn, func(cursor *lmdb.Cursor, knownNode *KnownNode) error {
+			if knownNode != nil && !knownNode.LastSeen.Before(expiration) {
+				return nil
+			}
+			removed++
+			return cursor.Del(0)
+		})
+	})
+	return removed, err
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+)
+
+func initTestStorage(t *testing.T) (*LocalStorage, func()) {
+	path, err := ioutil.TempDir("", "buuzcoin-db-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	storage, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed: %+v", err)
+	}
+	return storage, func() {
+		storage.Env.Close()
+		os.RemoveAll(path)
+	}
+}
+
+func createSealedRecord(t *testing.T, pubKey ed25519.PublicKey, privKey ed25519.PrivateKey, seqID uint32) *protocol.NodeRecord {
+	record := &protocol.NodeRecord{
+		PublicKey: pubKey,
+		SeqID:     seqID,
+		NodeID:    network.DeriveAddress(pubKey),
+		IPv4:      []byte{8, 8, 8, 8},
+		QUICPort:  7000,
+	}
+	sealed, err := record.Seal(privKey)
+	if err != nil {
+		t.Fatalf("Seal failed: %+v", err)
+	}
+
+	record = sealed.Unseal()
+	if record == nil {
+		t.Fatal("Unseal failed")
+	}
+	return record
+}
+
+func TestKnownNodes(t *testing.T) {
+	/*
+		1. Save node record, record with lower SeqID shouldn't replace it
+		2. Node is removed after MaxNodeFailures failures
+		3. Successful contact resets failure count
+	*/
+	storage, cleanup := initTestStorage(t)
+	defer cleanup()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	if err = storage.NodeSeen(createSealedRecord(t, pubKey, privKey, 5)); err != nil {
+		t.Fatalf("NodeSeen failed: %+v", err)
+	}
+	if err = storage.NodeSeen(createSealedRecord(t, pubKey, privKey, 3)); err != nil {
+		t.Fatalf("NodeSeen failed: %+v", err)
+	}
+
+	knownNodes, err := storage.GetKnownNodes()
+	if err != nil || len(knownNodes) != 1 {
+		t.Fatalf("GetKnownNodes failed: %+v", err)
+	}
+	if record := knownNodes[0].SealedRecord.Unseal(); knownNodes[0].SeqID != 5 || record == nil || record.SeqID != 5 {
+		t.Errorf("Record was replaced with older one: %d", knownNodes[0].SeqID)
+	}
+
+	nodeID := network.DeriveAddress(pubKey)
+	for i := 1; i < MaxNodeFailures; i++ {
+		if removed, err := storage.NodeFailed(nodeID); err != nil || removed {
+			t.Fatalf("Node was removed after %d failures: %+v", i, err)
+		}
+	}
+	storage.NodeSeen(createSealedRecord(t, pubKey, privKey, 6))
+	if knownNodes, _ = storage.GetKnownNodes(); len(knownNodes) != 1 || knownNodes[0].Failures != 0 || knownNodes[0].SeqID != 6 {
+		t.Fatal("Failure count wasn't reset")
+	}
+
+	for i := 1; i <= MaxNodeFailures; i++ {
+		removed, err := storage.NodeFailed(nodeID)
+		if err != nil {
+			t.Fatalf("NodeFailed failed: %+v", err)
+		}
+		if removed != (i == MaxNodeFailures) {
+			t.Fatalf("Unexpected removal after %d failures", i)
+		}
+	}
+	if knownNodes, _ = storage.GetKnownNodes(); len(knownNodes) != 0 {
+		t.Error("Failed node wasn't removed")
+	}
+}
+
+func TestExpireKnownNodes(t *testing.T) {
+	/*
+		1. Save 3 nodes, one of them wasn't seen for long time
+		2. Stale node should be removed, other entries in node DBI should be kept
+	*/
+	storage, cleanup := initTestStorage(t)
+	defer cleanup()
+
+	var records []*protocol.NodeRecord
+	for i := 0; i < 3; i++ {
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+		}
+		records = append(records, createSealedRecord(t, pubKey, privKey, 1))
+		if err = storage.NodeSeen(records[i]); err != nil {
+			t.Fatalf("NodeSeen failed: %+v", err)
+		}
+	}
+
+	if err := storage.Env.Update(func(txn *lmdb.Txn) error {
+		if err := txn.Put(storage.Node, []byte("nodePubKey"), []byte{0x01}, 0); err != nil {
+			return err
+		}
+		knownNode, err := storage.getKnownNode(txn, records[1].NodeID)
+		if err != nil {
+			return err
+		}
+		knownNode.LastSeen = time.Now().Add(-48 * time.Hour)
+		return storage.putKnownNode(txn, records[1].NodeID, knownNode)
+	}); err != nil {
+		t.Fatalf("Updating known node failed: %+v", err)
+	}
+
+	removed, err := storage.ExpireKnownNodes(24 * time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("ExpireKnownNodes failed: %d, %+v", removed, err)
+	}
+	knownNodes, err := storage.GetKnownNodes()
+	if err != nil || len(knownNodes) != 2 {
+		t.Fatalf("Unexpected known nodes count: %d, %+v", len(knownNodes), err)
+	}
+	for _, knownNode := range knownNodes {
+		if knownNode.SealedRecord.Unseal().SeqID != 1 || knownNode.LastSeen.Before(time.Now().Add(-time.Hour)) {
+			t.Error("Stale node wasn't removed")
+		}
+	}
+
+	if err = storage.Env.View(func(txn *lmdb.Txn) error {
+		_, err := txn.Get(storage.Node, []byte("nodePubKey"))
+		return err
+	}); err != nil {
+		t.Errorf("Other entry of node DBI was removed: %+v", err)
+	}
+}
@@ -19,3 +19,22 @@ func SaveTrie(node *trie.MerkleTrieNode, dbi lmdb.DBI, txn *lmdb.Txn) error {
 	}
 	return SaveTrie(node.Parent, dbi, txn)
 }
+
+// GetStateNode retrieves state trie node with specific hash. Returns nil if node was not found.
+func (storage *LocalStorage) GetStateNode(nodeHash []byte) ([]byte, error) {
+	var nodeData []byte
+	if err := storage.Env.View(func(txn *lmdb.Txn) error {
+		data, err := txn.Get(storage.State, nodeHash)
+		if err != nil {
+			return err
+		}
+		nodeData = append([]byte{}, data...)
+		return nil
+	}); err != nil {
+		if lmdb.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return nodeData, nil
+}
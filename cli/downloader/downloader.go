@@ -0,0 +1,535 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/network/validation"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Header-first synchronization is performed in following way:
+	1. After hello handshake peer with greater lastBlockIndex is passed to Synchronise
+	2. Common ancestor of local and peer's chain is searched backwards from local head:
+	   header at index of local head is requested first, then headers are requested
+	   in batches of MaxHeadersBatch and compared with local chain
+	3. Block headers after common ancestor are requested from this peer in batches
+	4. Header chain is validated with validation.CheckBlock and proof algorithm
+	5. Transactions of blocks are requested in parallel from all registered peers.
+	   Transactions of single block are requested in batches of MaxTransactionsBatch,
+	   response may be partial because of response size limit of peer, so missing
+	   transactions are requested again until block is complete
+	6. Complete blocks are applied to chain in order
+
+	Peers sending invalid data or not responding in time are penalized,
+	failed requests are retried with other peers.
+*/
+
+const (
+	// MaxHeadersBatch is maximal count of headers requested at once
+	MaxHeadersBatch = 192
+	// MaxTransactionsBatch is maximal count of transactions requested at once,
+	// it doesn't exceed count of transactions served by peers in single response
+	MaxTransactionsBatch = 1024
+	// DefaultRequestTimeout is time given to peer to respond to request
+	DefaultRequestTimeout = 10 * time.Second
+	// MaxRequestRetries is count of retries of failed bodies request before synchronization is aborted
+	MaxRequestRetries = 4
+	// MaxPeerPenalty is penalty after which peer is not used for synchronization
+	MaxPeerPenalty = 3
+)
+
+var (
+	// ErrNothingToSync is returned if peer's chain is not longer than local one
+	ErrNothingToSync = errors.New("downloader: peer's chain isn't longer than local one")
+	// ErrAlreadySyncing is returned if synchronization is already in progress
+	ErrAlreadySyncing = errors.New("downloader: synchronization is already in progress")
+	// ErrTimeout is returned if peer didn't respond in time
+	ErrTimeout = errors.New("downloader: request timed out")
+	// ErrEmptyResponse is returned if peer didn't return data it announced
+	ErrEmptyResponse = errors.New("downloader: empty response")
+	// ErrUnrequestedData is returned if peer responded with data which wasn't requested
+	ErrUnrequestedData = errors.New("downloader: unrequested data in response")
+	// ErrInvalidHeaders is returned if peer sent invalid header chain
+	ErrInvalidHeaders = errors.New("downloader: invalid block headers")
+	// ErrInvalidBody is returned if peer sent transactions not matching header
+	ErrInvalidBody = errors.New("downloader: invalid block body")
+	// ErrUnknownAncestor is returned if peer's chain doesn't extend local chain
+	ErrUnknownAncestor = errors.New("downloader: peer's chain doesn't extend local chain")
+	// ErrNoPeers is returned if there are no peers left to download data from
+	ErrNoPeers = errors.New("downloader: no peers to download from")
+)
+
+// Peer is remote node chain data is downloaded from
+type Peer interface {
+	// ID returns unique identifier of peer
+	ID() string
+	// LastBlockIndex returns index of last block announced by peer
+	LastBlockIndex() uint64
+	// RequestBlockHeaders sends GetBlockHeaders request and waits for response
+	RequestBlockHeaders(request *protocol.GetBlockHeaders) (*protocol.BlockHeaders, error)
+	// RequestTransactions sends GetTransactions request and waits for response
+	RequestTransactions(request *protocol.GetTransactions) (*protocol.Transactions, error)
+}
+
+// Chain is local blockchain downloaded blocks are applied to
+type Chain interface {
+	GetBlockchainState() blockchain.Blockchain
+	GetBlock(hash []byte) (*blockchain.Block, error)
+	ApplyBlock(block blockchain.Block, transactions []blockchain.TX) error
+}
+
+// PenaltyFn is called when peer is penalized for invalid response or timeout
+type PenaltyFn = func(peer Peer, reason error)
+
+// Downloader synchronizes local chain with remote peers
+type Downloader struct {
+	// RequestTimeout is time given to peer to respond to request
+	RequestTimeout time.Duration
+	// OnPenalty is optional callback, it is called when peer is penalized
+	OnPenalty PenaltyFn
+
+	chain     Chain
+	proofAlgo consensus.ProofAlgorithm
+
+	peers     map[string]Peer
+	penalties map[string]int
+	syncing   bool
+
+	lock *sync.Mutex
+}
+
+// New creates downloader applying blocks to chain
+func New(localChain Chain, proofAlgo consensus.ProofAlgorithm) *Downloader {
+	return &Downloader{
+		RequestTimeout: DefaultRequestTimeout,
+
+		chain:     localChain,
+		proofAlgo: proofAlgo,
+		peers:     make(map[string]Peer),
+		penalties: make(map[string]int),
+		lock:      &sync.Mutex{},
+	}
+}
+
+// RegisterPeer adds peer which block bodies may be downloaded from
+func (downloader *Downloader) RegisterPeer(peer Peer) {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
+	downloader.peers[peer.ID()] = peer
+}
+
+// UnregisterPeer removes peer with specific ID
+func (downloader *Downloader) UnregisterPeer(peerID string) {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
+	delete(downloader.peers, peerID)
+	delete(downloader.penalties, peerID)
+}
+
+// Penalty returns current penalty of peer with specific ID
+func (downloader *Downloader) Penalty(peerID string) int {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
+	return downloader.penalties[peerID]
+}
+
+func (downloader *Downloader) penalize(peer Peer, reason error) {
+	downloader.lock.Lock()
+	downloader.penalties[peer.ID()]++
+	onPenalty := downloader.OnPenalty
+	downloader.lock.Unlock()
+
+	log.Printf("Peer %s penalized: %v", peer.ID(), reason)
+	if onPenalty != nil {
+		onPenalty(peer, reason)
+	}
+}
+
+func (downloader *Downloader) isUsable(peer Peer) bool {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
+	_, registered := downloader.peers[peer.ID()]
+	return registered && downloader.penalties[peer.ID()] < MaxPeerPenalty
+}
+
+// bodyPeers returns usable peers for downloading block bodies, origin peer is always included
+func (downloader *Downloader) bodyPeers(origin Peer) []Peer {
+	downloader.lock.Lock()
+	defer downloader.lock.Unlock()
+
+	peers := []Peer{origin}
+	for id, peer := range downloader.peers {
+		if id != origin.ID() && downloader.penalties[id] < MaxPeerPenalty {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Synchronise downloads blocks from peer until local chain reaches peer's last block index
+func (downloader *Downloader) Synchronise(peer Peer) error {
+	downloader.lock.Lock()
+	if downloader.syncing {
+		downloader.lock.Unlock()
+		return ErrAlreadySyncing
+	}
+	downloader.syncing = true
+	if _, registered := downloader.peers[peer.ID()]; !registered {
+		downloader.peers[peer.ID()] = peer
+	}
+	downloader.lock.Unlock()
+
+	defer func() {
+		downloader.lock.Lock()
+		downloader.syncing = false
+		downloader.lock.Unlock()
+	}()
+
+	localState := downloader.chain.GetBlockchainState()
+	if peer.LastBlockIndex() <= localState.LastBlockIndex {
+		return ErrNothingToSync
+	}
+
+	head, err := downloader.chain.GetBlock(localState.LastBlockHash)
+	if err != nil {
+		return err
+	}
+	if head == nil {
+		return ErrUnknownAncestor
+	}
+	parent, err := downloader.findAncestor(peer, head)
+	if err != nil {
+		return err
+	}
+
+	for parent.Index < peer.LastBlockIndex() {
+		if !downloader.isUsable(peer) {
+			return ErrNoPeers
+		}
+
+		headers, err := downloader.fetchHeaders(peer, parent)
+		if err != nil {
+			return err
+		}
+		if err = downloader.fetchAndApply(peer, headers); err != nil {
+			return err
+		}
+		parent = headers[len(headers)-1]
+	}
+	return nil
+}
+
+func (downloader *Downloader) requestHeaders(peer Peer, request *protocol.GetBlockHeaders) (*protocol.BlockHeaders, error) {
+	type response struct {
+		headers *protocol.BlockHeaders
+		err     error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		headers, err := peer.RequestBlockHeaders(request)
+		responses <- response{headers, err}
+	}()
+
+	select {
+	case response := <-responses:
+		return response.headers, response.err
+	case <-time.After(downloader.RequestTimeout):
+		return nil, ErrTimeout
+	}
+}
+
+func (downloader *Downloader) requestTransactions(peer Peer, request *protocol.GetTransactions) (*protocol.Transactions, error) {
+	type response struct {
+		transactions *protocol.Transactions
+		err          error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		transactions, err := peer.RequestTransactions(request)
+		responses <- response{transactions, err}
+	}()
+
+	select {
+	case response := <-responses:
+		return response.transactions, response.err
+	case <-time.After(downloader.RequestTimeout):
+		return nil, ErrTimeout
+	}
+}
+
+// findAncestor searches backwards from local head for latest block of local chain which is in peer's chain
+func (downloader *Downloader) findAncestor(peer Peer, head *blockchain.Block) (*blockchain.Block, error) {
+	local := head
+	// Peer usually extends local head, so only header of head is requested first
+	span := uint64(1)
+	for {
+		start := uint64(0)
+		if local.Index+1 > span {
+			start = local.Index + 1 - span
+		}
+		response, err := downloader.requestHeaders(peer, &protocol.GetBlockHeaders{
+			StartBlockIndex: start,
+			MaxBlocks:       local.Index - start + 1,
+		})
+		if err != nil {
+			downloader.penalize(peer, err)
+			return nil, err
+		}
+
+		remoteHashes := make(map[uint64][]byte, len(response.GetBlockHeaders()))
+		for _, headerData := range response.GetBlockHeaders() {
+			header, err := protocol.DecodeBlockHeader(headerData)
+			if err != nil {
+				downloader.penalize(peer, ErrInvalidHeaders)
+				return nil, ErrInvalidHeaders
+			}
+			remoteHashes[header.Index] = header.CalculateHash()
+		}
+
+		for {
+			if bytes.Compare(remoteHashes[local.Index], local.CalculateHash()) == 0 {
+				return local, nil
+			}
+			if local.Index == 0 {
+				return nil, ErrUnknownAncestor
+			}
+			if local, err = downloader.chain.GetBlock(local.PrevBlockHash); err != nil {
+				return nil, err
+			}
+			if local == nil {
+				return nil, ErrUnknownAncestor
+			}
+			if local.Index < start {
+				break
+			}
+		}
+		span = MaxHeadersBatch
+	}
+}
+
+// fetchHeaders requests next batch of headers after parent and validates header chain
+func (downloader *Downloader) fetchHeaders(peer Peer, parent *blockchain.Block) ([]*blockchain.Block, error) {
+	count := peer.LastBlockIndex() - parent.Index
+	if count > MaxHeadersBatch {
+		count = MaxHeadersBatch
+	}
+
+	response, err := downloader.requestHeaders(peer, &protocol.GetBlockHeaders{
+		StartBlockIndex: parent.Index + 1,
+		MaxBlocks:       count,
+	})
+	if err != nil {
+		downloader.penalize(peer, err)
+		return nil, err
+	}
+	if len(response.GetBlockHeaders()) == 0 || uint64(len(response.GetBlockHeaders())) > count {
+		downloader.penalize(peer, ErrEmptyResponse)
+		return nil, ErrEmptyResponse
+	}
+
+	headers := make([]*blockchain.Block, len(response.BlockHeaders))
+	prevHeader := parent
+	for i, headerData := range response.BlockHeaders {
+		header, err := protocol.DecodeBlockHeader(headerData)
+		if err != nil {
+			downloader.penalize(peer, ErrInvalidHeaders)
+			return nil, ErrInvalidHeaders
+		}
+		if i == 0 && bytes.Compare(header.PrevBlockHash, parent.CalculateHash()) != 0 {
+			return nil, ErrUnknownAncestor
+		}
+		if err = downloader.checkHeader(*header, *prevHeader); err != nil {
+			downloader.penalize(peer, err)
+			return nil, ErrInvalidHeaders
+		}
+		headers[i] = header
+		prevHeader = header
+	}
+	return headers, nil
+}
+
+func (downloader *Downloader) checkHeader(header, parent blockchain.Block) error {
+	valid, err := validation.CheckBlock(header, parent)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidHeaders
+	}
+	return downloader.proofAlgo.VerifyHeader(header, parent)
+}
+
+// fetchBody requests transactions of block from peer in batches of MaxTransactionsBatch
+// until all of them are received. Peer should respond with at least one requested
+// transaction, response with transactions which weren't requested is invalid
+func (downloader *Downloader) fetchBody(peer Peer, header *blockchain.Block) (*blockchain.Block, error) {
+	received := make(map[string][]byte, len(header.TxHashes))
+	missing := header.TxHashes
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > MaxTransactionsBatch {
+			batch = batch[:MaxTransactionsBatch]
+		}
+		response, err := downloader.requestTransactions(peer, &protocol.GetTransactions{TxHashes: batch})
+		if err != nil {
+			return nil, err
+		}
+		if len(response.GetTransactions()) == 0 {
+			return nil, ErrEmptyResponse
+		}
+
+		requested := make(map[string]bool, len(batch))
+		for _, txHash := range batch {
+			requested[string(txHash)] = true
+		}
+		for _, txData := range response.GetTransactions() {
+			var tx blockchain.TX
+			if err := proto.Unmarshal(txData, &tx); err != nil {
+				return nil, ErrInvalidBody
+			}
+			txHash := string(tx.CalculateHash())
+			if !requested[txHash] {
+				return nil, ErrUnrequestedData
+			}
+			received[txHash] = txData
+		}
+
+		missing = missing[:0:0]
+		for _, txHash := range header.TxHashes {
+			if _, exists := received[string(txHash)]; !exists {
+				missing = append(missing, txHash)
+			}
+		}
+	}
+
+	transactions := make([][]byte, len(header.TxHashes))
+	for i, txHash := range header.TxHashes {
+		transactions[i] = received[string(txHash)]
+	}
+	return checkBody(header, transactions)
+}
+
+// checkBody verifies that transactions match header and returns complete block
+func checkBody(header *blockchain.Block, transactions [][]byte) (*blockchain.Block, error) {
+	block := *header
+	block.Transactions = transactions
+	if _, err := validation.DecodeTransactions(block); err != nil {
+		return nil, ErrInvalidBody
+	}
+	return &block, nil
+}
+
+type bodyTask struct {
+	index   int
+	retries int
+}
+
+type bodyResult struct {
+	index int
+	block *blockchain.Block
+	err   error
+}
+
+// fetchAndApply downloads transactions of blocks in parallel and applies blocks in order
+func (downloader *Downloader) fetchAndApply(origin Peer, headers []*blockchain.Block) error {
+	blocks := make([]*blockchain.Block, len(headers))
+	tasks := make(chan *bodyTask, len(headers))
+	for i, header := range headers {
+		if len(header.TxHashes) == 0 {
+			blocks[i] = header
+			continue
+		}
+		tasks <- &bodyTask{index: i}
+	}
+
+	peers := downloader.bodyPeers(origin)
+	results := make(chan bodyResult)
+	exited := make(chan struct{}, len(peers))
+	quit := make(chan struct{})
+	defer close(quit)
+
+	activeWorkers := 0
+	if len(tasks) > 0 {
+		activeWorkers = len(peers)
+		for _, peer := range peers {
+			go downloader.bodyWorker(peer, headers, tasks, results, exited, quit)
+		}
+	}
+
+	next := 0
+	for next < len(blocks) {
+		for next < len(blocks) && blocks[next] != nil {
+			// Block may be already received from block propagation
+			if err := downloader.chain.ApplyBlock(*blocks[next], nil); err != nil && err != chain.ErrKnownBlock {
+				if _, invalid := err.(*validation.StageError); invalid {
+					downloader.penalize(origin, err)
+				}
+				return err
+			}
+			next++
+		}
+		if next == len(blocks) {
+			break
+		}
+
+		select {
+		case result := <-results:
+			if result.err != nil {
+				return result.err
+			}
+			blocks[result.index] = result.block
+		case <-exited:
+			activeWorkers--
+			if activeWorkers == 0 {
+				return ErrNoPeers
+			}
+		}
+	}
+	return nil
+}
+
+// bodyWorker downloads transactions of blocks from specific peer, failed tasks are returned to queue
+func (downloader *Downloader) bodyWorker(peer Peer, headers []*blockchain.Block,
+	tasks chan *bodyTask, results chan<- bodyResult, exited chan<- struct{}, quit <-chan struct{}) {
+	defer func() { exited <- struct{}{} }()
+
+	for downloader.isUsable(peer) {
+		var task *bodyTask
+		select {
+		case task = <-tasks:
+		case <-quit:
+			return
+		}
+
+		block, err := downloader.fetchBody(peer, headers[task.index])
+
+		if err != nil {
+			downloader.penalize(peer, err)
+			task.retries++
+			if task.retries > MaxRequestRetries {
+				select {
+				case results <- bodyResult{index: task.index, err: err}:
+				case <-quit:
+				}
+				return
+			}
+			tasks <- task
+			continue
+		}
+
+		select {
+		case results <- bodyResult{index: task.index, block: block}:
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+// testChain is in-memory chain accepting blocks with known parent, longest chain is head
+type testChain struct {
+	blocks map[string]*blockchain.Block
+	head   *blockchain.Block
+	lock   *sync.Mutex
+}
+
+func newTestChain(genesis *blockchain.Block) *testChain {
+	return &testChain{
+		blocks: map[string]*blockchain.Block{hex.EncodeToString(genesis.CalculateHash()): genesis},
+		head:   genesis,
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (chain *testChain) GetBlockchainState() blockchain.Blockchain {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	return blockchain.Blockchain{LastBlockHash: chain.head.CalculateHash(), LastBlockIndex: chain.head.Index}
+}
+
+func (chain *testChain) GetBlock(hash []byte) (*blockchain.Block, error) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	return chain.blocks[hex.EncodeToString(hash)], nil
+}
+
+func (chain *testChain) ApplyBlock(block blockchain.Block, transactions []blockchain.TX) error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	if _, known := chain.blocks[hex.EncodeToString(block.PrevBlockHash)]; !known {
+		return errors.New("block with unknown parent")
+	}
+	if len(block.Transactions) != len(block.TxHashes) {
+		return errors.New("block without transactions")
+	}
+	chain.blocks[hex.EncodeToString(block.CalculateHash())] = &block
+	if block.Index > chain.head.Index {
+		chain.head = &block
+	}
+	return nil
+}
+
+// testPeer serves blocks, corruptTxs makes it respond with invalid transactions,
+// maxServed limits count of transactions in response if it isn't zero
+type testPeer struct {
+	id         string
+	blocks     []*blockchain.Block
+	corruptTxs bool
+	maxServed  int
+	delay      time.Duration
+	hang       chan struct{}
+}
+
+func (peer *testPeer) ID() string {
+	return peer.id
+}
+
+func (peer *testPeer) LastBlockIndex() uint64 {
+	return peer.blocks[len(peer.blocks)-1].Index
+}
+
+func (peer *testPeer) RequestBlockHeaders(request *protocol.GetBlockHeaders) (*protocol.BlockHeaders, error) {
+	response := &protocol.BlockHeaders{}
+	for _, block := range peer.blocks {
+		if block.Index >= request.StartBlockIndex && block.Index < request.StartBlockIndex+request.MaxBlocks {
+			header, err := protocol.EncodeBlockHeader(*block)
+			if err != nil {
+				return nil, err
+			}
+			response.BlockHeaders = append(response.BlockHeaders, header)
+		}
+	}
+	response.BlockCount = uint64(len(response.BlockHeaders))
+	return response, nil
+}
+
+func (peer *testPeer) RequestTransactions(request *protocol.GetTransactions) (*protocol.Transactions, error) {
+	if peer.hang != nil {
+		<-peer.hang
+	}
+	time.Sleep(peer.delay)
+	requested := make(map[string]bool, len(request.TxHashes))
+	for _, txHash := range request.TxHashes {
+		requested[string(txHash)] = true
+	}
+	response := &protocol.Transactions{}
+	for _, block := range peer.blocks {
+		for i, txHash := range block.TxHashes {
+			if peer.maxServed != 0 && len(response.Transactions) == peer.maxServed {
+				return response, nil
+			}
+			if requested[string(txHash)] {
+				txData := block.Transactions[i]
+				if peer.corruptTxs {
+					txData = append([]byte{0xFF}, txData...)
+				}
+				response.Transactions = append(response.Transactions, txData)
+			}
+		}
+	}
+	return response, nil
+}
+
+func createTestChain(t *testing.T, length int) ([]*blockchain.Block, consensus.ProofAlgorithm) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	poa := &consensus.ProofOfAuthority{AuthorityPublicKey: pubKey, AuthorityPrivateKey: privKey}
+
+	blocks := []*blockchain.Block{{Index: 0, PrevBlockHash: make([]byte, 32)}}
+	for i := 1; i <= length; i++ {
+		// Every second block contains transactions
+		blocks = append(blocks, createTestBlock(t, poa, blocks[i-1], i%2*3))
+	}
+	return blocks, poa
+}
+
+// createTestBlock creates sealed block on top of parent with txCount transactions
+func createTestBlock(t *testing.T, poa *consensus.ProofOfAuthority, parent *blockchain.Block, txCount int) *blockchain.Block {
+	block := &blockchain.Block{Version: 1}
+	for j := 0; j < txCount; j++ {
+		tx := blockchain.TX{Version: 1, Nonce: (parent.Index+1)*10 + uint64(j), From: make([]byte, 20), To: make([]byte, 20)}
+		tx.Hash = tx.CalculateHash()
+		txData, err := proto.Marshal(&tx)
+		if err != nil {
+			t.Fatalf("proto.Marshal failed: %+v", err)
+		}
+		block.TxHashes = append(block.TxHashes, tx.Hash)
+		block.Transactions = append(block.Transactions, txData)
+	}
+	block.TxMerkleRoot = blockchain.CalculateMerkleRoot(block.TxHashes)
+	block.StateMerkleRoot = make([]byte, 32)
+
+	if err := poa.Prepare(block, *parent); err != nil {
+		t.Fatalf("Prepare failed: %+v", err)
+	}
+	if err := poa.Seal(block); err != nil {
+		t.Fatalf("Seal failed: %+v", err)
+	}
+	return block
+}
+
+func TestSynchronise(t *testing.T) {
+	/*
+		1. Origin peer has chain of 10 blocks
+		2. One peer responds with corrupt transactions, another one doesn't respond at all
+		3. All blocks should be applied in order, broken peers should be penalized
+	*/
+	blocks, poa := createTestChain(t, 10)
+	localChain := newTestChain(blocks[0])
+	downloader := New(localChain, poa)
+	downloader.RequestTimeout = 50 * time.Millisecond
+
+	hang := make(chan struct{})
+	defer close(hang)
+	downloader.RegisterPeer(&testPeer{id: "corrupt", blocks: blocks, corruptTxs: true})
+	downloader.RegisterPeer(&testPeer{id: "hanging", blocks: blocks, hang: hang})
+
+	// Origin responds with delay, so every peer receives request
+	origin := &testPeer{id: "origin", blocks: blocks, delay: 10 * time.Millisecond}
+	if err := downloader.Synchronise(origin); err != nil {
+		t.Fatalf("Synchronise failed: %+v", err)
+	}
+	if bytes.Compare(localChain.GetBlockchainState().LastBlockHash, blocks[10].CalculateHash()) != 0 {
+		t.Fatal("Local chain wasn't synchronized")
+	}
+
+	if downloader.Penalty("origin") != 0 {
+		t.Errorf("Honest peer was penalized: %d", downloader.Penalty("origin"))
+	}
+	if downloader.Penalty("corrupt") == 0 || downloader.Penalty("hanging") == 0 {
+		t.Errorf("Broken peers weren't penalized: %d, %d", downloader.Penalty("corrupt"), downloader.Penalty("hanging"))
+	}
+
+	if err := downloader.Synchronise(origin); err != ErrNothingToSync {
+		t.Errorf("Unexpected error on synchronized chain: %+v", err)
+	}
+}
+
+func TestSynchroniseInvalidHeaders(t *testing.T) {
+	/*
+		1. Peer sends header chain with block modified after sealing
+		2. Synchronization should fail without applying blocks, peer should be penalized
+	*/
+	blocks, poa := createTestChain(t, 4)
+	forgedBlock := *blocks[3]
+	forgedBlock.AdditionalData = []byte("forged")
+	forgedBlocks := append(append([]*blockchain.Block{}, blocks[:3]...), &forgedBlock)
+
+	localChain := newTestChain(blocks[0])
+	downloader := New(localChain, poa)
+	if err := downloader.Synchronise(&testPeer{id: "forger", blocks: forgedBlocks}); err != ErrInvalidHeaders {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if localChain.GetBlockchainState().LastBlockIndex != 0 {
+		t.Error("Blocks of invalid header chain were applied")
+	}
+	if downloader.Penalty("forger") != 1 {
+		t.Errorf("Unexpected penalty: %d", downloader.Penalty("forger"))
+	}
+}
+
+func TestSynchroniseFork(t *testing.T) {
+	/*
+		1. Local chain has fork of 150 blocks on top of block 60 of peer's chain
+		2. Peer has chain of 300 blocks
+		3. Common ancestor should be found, local chain should be reorganized to peer's chain
+	*/
+	blocks, poa := createTestChain(t, 300)
+	localChain := newTestChain(blocks[0])
+	for _, block := range blocks[1:61] {
+		if err := localChain.ApplyBlock(*block, nil); err != nil {
+			t.Fatalf("ApplyBlock failed: %+v", err)
+		}
+	}
+	parent := blocks[60]
+	for i := 0; i < 150; i++ {
+		block := &blockchain.Block{Index: parent.Index + 1, PrevBlockHash: parent.CalculateHash(), AdditionalData: []byte("fork")}
+		if err := localChain.ApplyBlock(*block, nil); err != nil {
+			t.Fatalf("ApplyBlock failed: %+v", err)
+		}
+		parent = block
+	}
+
+	downloader := New(localChain, poa)
+	peer := &testPeer{id: "origin", blocks: blocks}
+	ancestor, err := downloader.findAncestor(peer, parent)
+	if err != nil || bytes.Compare(ancestor.CalculateHash(), blocks[60].CalculateHash()) != 0 {
+		t.Fatalf("Unexpected common ancestor: %+v", err)
+	}
+	if err = downloader.Synchronise(peer); err != nil {
+		t.Fatalf("Synchronise failed: %+v", err)
+	}
+	if bytes.Compare(localChain.GetBlockchainState().LastBlockHash, blocks[300].CalculateHash()) != 0 {
+		t.Fatal("Local chain wasn't reorganized")
+	}
+
+	otherBlocks, _ := createTestChain(t, 400)
+	otherBlocks[0] = &blockchain.Block{Index: 0, PrevBlockHash: make([]byte, 32), AdditionalData: []byte("other")}
+	if err = downloader.Synchronise(&testPeer{id: "other", blocks: otherBlocks}); err != ErrUnknownAncestor {
+		t.Fatalf("Unexpected error for chain with other genesis: %+v", err)
+	}
+}
+
+func TestSynchronisePartialBodies(t *testing.T) {
+	/*
+		1. Peer has block with more transactions than MaxTransactionsBatch
+		2. Peer serves only part of requested transactions in every response
+		3. Missing transactions should be requested again, peer shouldn't be penalized
+	*/
+	blocks, poa := createTestChain(t, 2)
+	blocks = append(blocks, createTestBlock(t, poa.(*consensus.ProofOfAuthority), blocks[2], MaxTransactionsBatch+500))
+	localChain := newTestChain(blocks[0])
+	downloader := New(localChain, poa)
+
+	peer := &testPeer{id: "origin", blocks: blocks, maxServed: 700}
+	if err := downloader.Synchronise(peer); err != nil {
+		t.Fatalf("Synchronise failed: %+v", err)
+	}
+	if bytes.Compare(localChain.GetBlockchainState().LastBlockHash, blocks[3].CalculateHash()) != 0 {
+		t.Fatal("Block with partially served transactions wasn't applied")
+	}
+	if downloader.Penalty("origin") != 0 {
+		t.Errorf("Peer serving partial responses was penalized: %d", downloader.Penalty("origin"))
+	}
+}
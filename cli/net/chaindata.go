@@ -0,0 +1,170 @@
+package net
+
+import (
+	"errors"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Chain data is served to remote nodes from local storage:
+	GetBlockHeaders - headers of canonical chain starting from startBlockIndex,
+	                  or single header if blockHash is specified
+	GetTransactions - transactions from saved blocks or mempool
+	GetNodeData     - state trie nodes in format nodeHash||nodeValue
+	Responses are limited by item count and total size, unknown items are skipped.
+	So Transactions response may contain only part of requested transactions,
+	requester should request missing transactions again.
+*/
+
+const (
+	// MaxServedHeaders is maximal count of block headers in BlockHeaders response
+	MaxServedHeaders = 192
+	// MaxServedTransactions is maximal count of transactions in Transactions response
+	MaxServedTransactions = 1024
+	// MaxServedNodes is maximal count of trie nodes in NodeData response
+	MaxServedNodes = 384
+	// MaxResponseSize is soft limit of response data size in bytes
+	MaxResponseSize = 2 * 1024 * 1024
+)
+
+// ErrMalformedRequest is returned if request from remote node cannot be decoded
+var ErrMalformedRequest = errors.New("net: malformed request")
+
+// ChainDataServer answers requests for chain data
+type ChainDataServer struct {
+	LocalStorage *db.LocalStorage
+	Mempool      *chain.TxPool
+}
+
+// RegisterHandlers registers chain data request handlers in router
+func (server *ChainDataServer) RegisterHandlers(router *Router) {
+	router.Handle(protocol.MessageGetBlockHeaders, server.HandleGetBlockHeaders)
+	router.Handle(protocol.MessageGetTransactions, server.HandleGetTransactions)
+	router.Handle(protocol.MessageGetNodeData, server.HandleGetNodeData)
+}
+
+// HandleGetBlockHeaders responds with BlockHeaders message
+func (server *ChainDataServer) HandleGetBlockHeaders(payload []byte) (byte, proto.Message, error) {
+	request := new(protocol.GetBlockHeaders)
+	if err := proto.Unmarshal(payload, request); err != nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	response := new(protocol.BlockHeaders)
+
+	if len(request.BlockHash) != 0 {
+		block, err := server.LocalStorage.GetBlock(request.BlockHash)
+		if err != nil {
+			return 0, nil, err
+		}
+		if block != nil {
+			if err = appendHeader(response, *block); err != nil {
+				return 0, nil, err
+			}
+		}
+		return protocol.MessageBlockHeaders, response, nil
+	}
+
+	maxBlocks := request.MaxBlocks
+	if maxBlocks > MaxServedHeaders {
+		maxBlocks = MaxServedHeaders
+	}
+	responseSize := 0
+	for index := request.StartBlockIndex; index-request.StartBlockIndex < maxBlocks && responseSize < MaxResponseSize; index++ {
+		blockHash, err := server.LocalStorage.GetCanonicalHash(index)
+		if err != nil {
+			return 0, nil, err
+		}
+		if blockHash == nil {
+			break
+		}
+		block, err := server.LocalStorage.GetBlock(blockHash)
+		if err != nil {
+			return 0, nil, err
+		}
+		if block == nil {
+			break
+		}
+		if err = appendHeader(response, *block); err != nil {
+			return 0, nil, err
+		}
+		responseSize += len(response.BlockHeaders[len(response.BlockHeaders)-1])
+	}
+	return protocol.MessageBlockHeaders, response, nil
+}
+
+func appendHeader(response *protocol.BlockHeaders, block blockchain.Block) error {
+	header, err := protocol.EncodeBlockHeader(block)
+	if err != nil {
+		return err
+	}
+	response.BlockHeaders = append(response.BlockHeaders, header)
+	response.BlockCount++
+	return nil
+}
+
+// HandleGetTransactions responds with Transactions message
+func (server *ChainDataServer) HandleGetTransactions(payload []byte) (byte, proto.Message, error) {
+	request := new(protocol.GetTransactions)
+	if err := proto.Unmarshal(payload, request); err != nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	response := new(protocol.Transactions)
+
+	responseSize := 0
+	for _, txHash := range request.TxHashes {
+		if len(response.Transactions) >= MaxServedTransactions || responseSize >= MaxResponseSize {
+			break
+		}
+
+		txData, err := server.LocalStorage.GetTransaction(txHash)
+		if err != nil {
+			return 0, nil, err
+		}
+		if txData == nil && server.Mempool != nil {
+			if tx := server.Mempool.Get(txHash); tx != nil {
+				if txData, err = proto.Marshal(tx); err != nil {
+					return 0, nil, err
+				}
+			}
+		}
+		if txData == nil {
+			continue
+		}
+		response.Transactions = append(response.Transactions, txData)
+		responseSize += len(txData)
+	}
+	return protocol.MessageTransactions, response, nil
+}
+
+// HandleGetNodeData responds with NodeData message
+func (server *ChainDataServer) HandleGetNodeData(payload []byte) (byte, proto.Message, error) {
+	request := new(protocol.GetNodeData)
+	if err := proto.Unmarshal(payload, request); err != nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	response := new(protocol.NodeData)
+
+	responseSize := 0
+	for _, nodeHash := range request.NodeHashes {
+		if len(response.Nodes) >= MaxServedNodes || responseSize >= MaxResponseSize {
+			break
+		}
+
+		nodeData, err := server.LocalStorage.GetStateNode(nodeHash)
+		if err != nil {
+			return 0, nil, err
+		}
+		if nodeData == nil {
+			continue
+		}
+		node := append(append([]byte{}, nodeHash...), nodeData...)
+		response.Nodes = append(response.Nodes, node)
+		responseSize += len(node)
+	}
+	return protocol.MessageNodeData, response, nil
+}
@@ -0,0 +1,127 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+func initTestChainData(t *testing.T, length int) (*ChainDataServer, []blockchain.Block, func()) {
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	localStorage, err := db.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed: %+v", err)
+	}
+
+	blocks := make([]blockchain.Block, length)
+	for i := range blocks {
+		tx := blockchain.TX{Version: 1, Nonce: uint64(i + 1)}
+		tx.Hash = tx.CalculateHash()
+		txData, err := proto.Marshal(&tx)
+		if err != nil {
+			t.Fatalf("proto.Marshal failed: %+v", err)
+		}
+
+		blocks[i] = blockchain.Block{Index: uint64(i), TxHashes: [][]byte{tx.Hash}, Transactions: [][]byte{txData}}
+		if err = localStorage.SaveBlock(blocks[i]); err != nil {
+			t.Fatalf("SaveBlock failed: %+v", err)
+		}
+		if err = localStorage.Env.Update(func(txn *lmdb.Txn) error {
+			return localStorage.PutCanonicalHash(txn, uint64(i), blocks[i].CalculateHash())
+		}); err != nil {
+			t.Fatalf("PutCanonicalHash failed: %+v", err)
+		}
+	}
+
+	server := &ChainDataServer{LocalStorage: localStorage, Mempool: chain.NewTxPool()}
+	return server, blocks, func() {
+		localStorage.Env.Close()
+		os.RemoveAll(path)
+	}
+}
+
+func TestHandleGetBlockHeaders(t *testing.T) {
+	/*
+		1. Request headers by start index, response is limited by chain length
+		2. Request single header by hash
+		3. Malformed request should be rejected
+	*/
+	server, blocks, cleanup := initTestChainData(t, 5)
+	defer cleanup()
+
+	payload, _ := proto.Marshal(&protocol.GetBlockHeaders{StartBlockIndex: 2, MaxBlocks: 10})
+	responseID, response, err := server.HandleGetBlockHeaders(payload)
+	if err != nil || responseID != protocol.MessageBlockHeaders {
+		t.Fatalf("HandleGetBlockHeaders failed: %+v", err)
+	}
+	headers := response.(*protocol.BlockHeaders)
+	if headers.BlockCount != 3 || len(headers.BlockHeaders) != 3 {
+		t.Fatalf("Unexpected header count: %d", len(headers.BlockHeaders))
+	}
+	header, err := protocol.DecodeBlockHeader(headers.BlockHeaders[0])
+	if err != nil || bytes.Compare(header.CalculateHash(), blocks[2].CalculateHash()) != 0 || header.Transactions != nil {
+		t.Errorf("Unexpected header: %+v", err)
+	}
+
+	payload, _ = proto.Marshal(&protocol.GetBlockHeaders{BlockHash: blocks[4].CalculateHash(), MaxBlocks: 10})
+	_, response, err = server.HandleGetBlockHeaders(payload)
+	if err != nil || len(response.(*protocol.BlockHeaders).BlockHeaders) != 1 {
+		t.Errorf("Header wasn't found by hash: %+v", err)
+	}
+
+	if _, _, err = server.HandleGetBlockHeaders([]byte{0xFF}); err != ErrMalformedRequest {
+		t.Errorf("Malformed request was accepted: %+v", err)
+	}
+}
+
+func TestHandleGetTransactions(t *testing.T) {
+	/*
+		Request transaction from block, transaction from mempool and unknown transaction,
+		unknown transaction should be skipped
+	*/
+	server, blocks, cleanup := initTestChainData(t, 2)
+	defer cleanup()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	mempoolTx := blockchain.TX{
+		Version:  1,
+		From:     network.DeriveAddress(pubKey),
+		To:       make([]byte, network.AddressSize),
+		Nonce:    1,
+		GasPrice: network.MinimalGasFee,
+	}
+	mempoolTx.Hash = mempoolTx.CalculateHash()
+	mempoolTx.Signature = append(ed25519.Sign(privKey, mempoolTx.Hash), pubKey...)
+	if err = server.Mempool.Add(mempoolTx); err != nil {
+		t.Fatalf("Mempool.Add failed: %+v", err)
+	}
+
+	payload, _ := proto.Marshal(&protocol.GetTransactions{
+		TxHashes: [][]byte{blocks[1].TxHashes[0], bytes.Repeat([]byte{0x01}, 32), mempoolTx.Hash},
+	})
+	_, response, err := server.HandleGetTransactions(payload)
+	if err != nil {
+		t.Fatalf("HandleGetTransactions failed: %+v", err)
+	}
+	transactions := response.(*protocol.Transactions).Transactions
+	if len(transactions) != 2 || bytes.Compare(transactions[0], blocks[1].Transactions[0]) != 0 {
+		t.Errorf("Unexpected transactions: %d", len(transactions))
+	}
+}
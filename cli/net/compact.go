@@ -0,0 +1,302 @@
+package net
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Compact block relay:
+	NewBlock message contains block header without transaction hashes and txHashList.
+	1. Receiver verifies that TxMerkleRoot of header matches txHashList
+	2. Transactions are taken from mempool and local storage
+	3. Missing transactions are requested with GetTransactions from sending peer
+	4. Block is applied when all transactions are available and their hashes match TxMerkleRoot
+	If hash list is invalid, transactions aren't received in CompactFetchTimeout or reconstructed
+	block doesn't match TxMerkleRoot, full block is fetched: header is requested by hash
+	from peer which knows block and all its transactions are requested from same peer.
+*/
+
+// CompactFetchTimeout is time of waiting for missing transactions of compact block before full block is fetched
+const CompactFetchTimeout = 5 * time.Second
+
+// ErrInvalidNewBlock is returned if transaction hash list of NewBlock message doesn't match block header
+var ErrInvalidNewBlock = errors.New("net: NewBlock hash list doesn't match header")
+
+// CompactBlockStats are counters of compact block relay
+type CompactBlockStats struct {
+	// Blocks is count of received compact blocks
+	Blocks uint64
+	// Reconstructed is count of compact blocks rebuilt without fetching transactions
+	Reconstructed uint64
+	// Transactions is count of transactions of received compact blocks
+	Transactions uint64
+	// LocalTransactions is count of transactions found in mempool or local storage
+	LocalTransactions uint64
+	// FetchedTransactions is count of transactions requested with GetTransactions
+	FetchedTransactions uint64
+	// FullFetches is count of blocks fetched in full after compact relay failed
+	FullFetches uint64
+}
+
+// Stats returns counters of compact block relay
+func (gossip *Gossip) Stats() CompactBlockStats {
+	return CompactBlockStats{
+		Blocks:              atomic.LoadUint64(&gossip.stats.Blocks),
+		Reconstructed:       atomic.LoadUint64(&gossip.stats.Reconstructed),
+		Transactions:        atomic.LoadUint64(&gossip.stats.Transactions),
+		LocalTransactions:   atomic.LoadUint64(&gossip.stats.LocalTransactions),
+		FetchedTransactions: atomic.LoadUint64(&gossip.stats.FetchedTransactions),
+		FullFetches:         atomic.LoadUint64(&gossip.stats.FullFetches),
+	}
+}
+
+// pendingBlock is received block waiting for its transactions
+type pendingBlock struct {
+	block *blockchain.Block
+	txs   map[string]blockchain.TX
+	// peer is connection missing transactions were requested from
+	peer     *Connection
+	full     bool
+	received time.Time
+}
+
+// missing returns hashes of transactions which aren't available yet
+func (pending *pendingBlock) missing() [][]byte {
+	var missing [][]byte
+	for _, txHash := range pending.block.TxHashes {
+		if _, exists := pending.txs[string(txHash)]; !exists {
+			missing = append(missing, txHash)
+		}
+	}
+	return missing
+}
+
+// encodeCompactHeader encodes block header for NewBlock message, transaction hashes are sent in txHashList
+func encodeCompactHeader(block blockchain.Block) ([]byte, error) {
+	block.TxHashes = nil
+	return protocol.EncodeBlockHeader(block)
+}
+
+// decodeCompactBlock decodes block from NewBlock message and checks its transaction hash list
+func decodeCompactBlock(message *protocol.NewBlock) (*blockchain.Block, error) {
+	block, err := protocol.DecodeBlockHeader(message.BlockHeader)
+	if err != nil {
+		return nil, ErrMalformedRequest
+	}
+	if len(block.TxHashes) == 0 {
+		block.TxHashes = message.TxHashList
+		return block, nil
+	}
+
+	if len(block.TxHashes) != len(message.TxHashList) {
+		return nil, ErrInvalidNewBlock
+	}
+	for i := range message.TxHashList {
+		if bytes.Compare(message.TxHashList[i], block.TxHashes[i]) != 0 {
+			return nil, ErrInvalidNewBlock
+		}
+	}
+	return block, nil
+}
+
+// HandleNewBlock processes compact block sent by peer
+func (gossip *Gossip) HandleNewBlock(connection *Connection, payload []byte) error {
+	message := new(protocol.NewBlock)
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return ErrMalformedRequest
+	}
+	block, err := decodeCompactBlock(message)
+	if err != nil {
+		return err
+	}
+
+	blockHash := block.CalculateHash()
+	connection.knownBlocks.Add(blockHash)
+	if bytes.Compare(blockchain.CalculateMerkleRoot(block.TxHashes), block.TxMerkleRoot) != 0 {
+		log.Printf("Compact block %s has invalid transaction list, fetching full block", hex.EncodeToString(blockHash))
+		if !gossip.isKnownBlock(blockHash) {
+			gossip.requestFullBlock(blockHash, connection)
+		}
+		return nil
+	}
+	gossip.receiveBlock(connection, block, false)
+	return nil
+}
+
+// receiveBlock applies block if all its transactions are available, otherwise missing transactions
+// are requested from peer. If full is true, all transactions are requested
+func (gossip *Gossip) receiveBlock(connection *Connection, block *blockchain.Block, full bool) {
+	blockHash := block.CalculateHash()
+	if gossip.isKnownBlock(blockHash) {
+		return
+	}
+
+	pending := &pendingBlock{
+		block:    block,
+		txs:      make(map[string]blockchain.TX),
+		peer:     connection,
+		full:     full,
+		received: time.Now(),
+	}
+	if !full {
+		gossip.reconstruct(pending)
+	}
+	missing := pending.missing()
+	if !full {
+		atomic.AddUint64(&gossip.stats.Blocks, 1)
+		atomic.AddUint64(&gossip.stats.Transactions, uint64(len(block.TxHashes)))
+		atomic.AddUint64(&gossip.stats.LocalTransactions, uint64(len(block.TxHashes)-len(missing)))
+	}
+	if len(missing) == 0 {
+		if !full {
+			atomic.AddUint64(&gossip.stats.Reconstructed, 1)
+		}
+		gossip.completeBlock(pending)
+		return
+	}
+
+	gossip.lock.Lock()
+	if _, exists := gossip.pending[string(blockHash)]; exists || len(gossip.pending) >= MaxPendingBlocks {
+		gossip.lock.Unlock()
+		return
+	}
+	gossip.pending[string(blockHash)] = pending
+	gossip.lock.Unlock()
+
+	if !full {
+		atomic.AddUint64(&gossip.stats.FetchedTransactions, uint64(len(missing)))
+	}
+	connection.Send(protocol.MessageGetTransactions, &protocol.GetTransactions{TxHashes: missing})
+}
+
+// reconstruct collects transactions of pending block from mempool and local storage
+func (gossip *Gossip) reconstruct(pending *pendingBlock) {
+	for _, txHash := range pending.block.TxHashes {
+		if tx := gossip.mempool.Get(txHash); tx != nil {
+			pending.txs[string(txHash)] = *tx
+			continue
+		}
+		txData, err := gossip.netNode.localStorage.GetTransaction(txHash)
+		if err != nil || txData == nil {
+			continue
+		}
+		tx := blockchain.TX{}
+		if proto.Unmarshal(txData, &tx) == nil {
+			pending.txs[string(txHash)] = tx
+		}
+	}
+}
+
+// fillPendingBlocks adds transactions received from peer to pending blocks.
+// Complete blocks are applied, remaining transactions are requested again if response was partial
+func (gossip *Gossip) fillPendingBlocks(connection *Connection, received map[string]blockchain.TX) {
+	type request struct {
+		peer    *Connection
+		missing [][]byte
+	}
+	var (
+		ready    []*pendingBlock
+		requests []request
+	)
+
+	gossip.lock.Lock()
+	for key, pending := range gossip.pending {
+		progress := false
+		for _, txHash := range pending.block.TxHashes {
+			if _, exists := pending.txs[string(txHash)]; exists {
+				continue
+			}
+			if tx, exists := received[string(txHash)]; exists {
+				pending.txs[string(txHash)] = tx
+				progress = true
+			}
+		}
+
+		missing := pending.missing()
+		if len(missing) == 0 {
+			ready = append(ready, pending)
+			delete(gossip.pending, key)
+		} else if progress && pending.peer == connection {
+			// Response is limited by MaxServedTransactions and MaxResponseSize
+			requests = append(requests, request{pending.peer, missing})
+		}
+	}
+	gossip.lock.Unlock()
+
+	for _, request := range requests {
+		request.peer.Send(protocol.MessageGetTransactions, &protocol.GetTransactions{TxHashes: request.missing})
+	}
+	for _, pending := range ready {
+		gossip.completeBlock(pending)
+	}
+}
+
+// completeBlock verifies transactions of block with TxMerkleRoot and applies it,
+// applied block is sent to other peers by dispatcher subscription. Score of peer
+// block was received from is adjusted by result of validation
+func (gossip *Gossip) completeBlock(pending *pendingBlock) {
+	block := pending.block
+	blockHash := block.CalculateHash()
+
+	transactions := make([]blockchain.TX, len(block.TxHashes))
+	txHashes := make([][]byte, len(block.TxHashes))
+	for i, txHash := range block.TxHashes {
+		transactions[i] = pending.txs[string(txHash)]
+		txHashes[i] = transactions[i].CalculateHash()
+	}
+	if bytes.Compare(blockchain.CalculateMerkleRoot(txHashes), block.TxMerkleRoot) != 0 {
+		if pending.full {
+			log.Printf("Transactions of block %s don't match header, block is dropped", hex.EncodeToString(blockHash))
+			gossip.netNode.Peers.Report(pending.peer, EventInvalidBlock)
+			return
+		}
+		log.Printf("Reconstructed block %s doesn't match header, fetching full block", hex.EncodeToString(blockHash))
+		gossip.requestFullBlock(blockHash, pending.peer)
+		return
+	}
+
+	err := chain.BlockchainDispatcher.ApplyBlock(*block, transactions)
+	if err == nil {
+		gossip.netNode.Peers.Report(pending.peer, EventUsefulData)
+		return
+	}
+	if err != chain.ErrKnownBlock {
+		log.Printf("Received block %s wasn't applied: %v", hex.EncodeToString(blockHash), err)
+	}
+	if event, ok := blockEvent(err); ok {
+		gossip.netNode.Peers.Report(pending.peer, event)
+	}
+}
+
+// requestFullBlock requests header of block from peer which knows it, all transactions of block
+// are requested after header is received. Peers other than failed peer are preferred
+func (gossip *Gossip) requestFullBlock(blockHash []byte, failed *Connection) {
+	peer := failed
+	for _, connection := range gossip.netNode.Connections() {
+		if connection != failed && connection.knownBlocks.Has(blockHash) {
+			peer = connection
+			break
+		}
+	}
+	if peer == nil {
+		return
+	}
+
+	gossip.lock.Lock()
+	delete(gossip.pending, string(blockHash))
+	gossip.requested[string(blockHash)] = &blockRequest{time: time.Now(), full: true}
+	gossip.lock.Unlock()
+
+	atomic.AddUint64(&gossip.stats.FullFetches, 1)
+	peer.Send(protocol.MessageGetBlockHeaders, &protocol.GetBlockHeaders{BlockHash: blockHash})
+}
@@ -0,0 +1,105 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCompactBlockEncoding(t *testing.T) {
+	block := blockchain.Block{
+		Index:    5,
+		TxHashes: [][]byte{bytes.Repeat([]byte{0x01}, 32), bytes.Repeat([]byte{0x02}, 32)},
+	}
+	block.TxMerkleRoot = blockchain.CalculateMerkleRoot(block.TxHashes)
+
+	header, err := encodeCompactHeader(block)
+	if err != nil {
+		t.Fatalf("encodeCompactHeader failed: %+v", err)
+	}
+	if fullHeader, _ := protocol.EncodeBlockHeader(block); len(header) >= len(fullHeader) {
+		t.Error("Transaction hashes are included in compact header")
+	}
+
+	decoded, err := decodeCompactBlock(&protocol.NewBlock{BlockHeader: header, TxHashList: block.TxHashes})
+	if err != nil {
+		t.Fatalf("decodeCompactBlock failed: %+v", err)
+	}
+	if bytes.Compare(decoded.CalculateHash(), block.CalculateHash()) != 0 || len(decoded.TxHashes) != 2 {
+		t.Errorf("Unexpected decoded block: %+v", decoded)
+	}
+
+	// Header with transaction hashes should match hash list
+	fullHeader, _ := protocol.EncodeBlockHeader(block)
+	if _, err = decodeCompactBlock(&protocol.NewBlock{BlockHeader: fullHeader, TxHashList: block.TxHashes[:1]}); err != ErrInvalidNewBlock {
+		t.Errorf("Mismatching hash list was accepted: %+v", err)
+	}
+}
+
+func TestReconstructBlock(t *testing.T) {
+	/*
+		Block contains transaction from mempool, confirmed transaction from local storage
+		and unknown transaction, only unknown transaction should be missing
+	*/
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	defer os.RemoveAll(path)
+	netNode, cleanup := initTestNode(t, path)
+	defer cleanup()
+
+	gossip := netNode.NewGossip()
+	gossip.mempool = chain.NewTxPool()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	createTx := func(nonce uint64) blockchain.TX {
+		tx := blockchain.TX{
+			Version:  1,
+			From:     network.DeriveAddress(pubKey),
+			To:       make([]byte, network.AddressSize),
+			Nonce:    nonce,
+			GasPrice: network.MinimalGasFee,
+		}
+		tx.Hash = tx.CalculateHash()
+		tx.Signature = append(ed25519.Sign(privKey, tx.Hash), pubKey...)
+		return tx
+	}
+	mempoolTx, confirmedTx, unknownTx := createTx(1), createTx(2), createTx(3)
+	if err = gossip.mempool.Add(mempoolTx); err != nil {
+		t.Fatalf("Mempool.Add failed: %+v", err)
+	}
+	txData, _ := proto.Marshal(&confirmedTx)
+	if err = netNode.localStorage.SaveBlock(blockchain.Block{
+		Index:        1,
+		TxHashes:     [][]byte{confirmedTx.Hash},
+		Transactions: [][]byte{txData},
+	}); err != nil {
+		t.Fatalf("SaveBlock failed: %+v", err)
+	}
+
+	pending := &pendingBlock{
+		block: &blockchain.Block{Index: 2, TxHashes: [][]byte{mempoolTx.Hash, confirmedTx.Hash, unknownTx.Hash}},
+		txs:   make(map[string]blockchain.TX),
+	}
+	gossip.reconstruct(pending)
+	missing := pending.missing()
+	if len(missing) != 1 || bytes.Compare(missing[0], unknownTx.Hash) != 0 {
+		t.Fatalf("Unexpected missing transactions: %d", len(missing))
+	}
+	if bytes.Compare(pending.txs[string(confirmedTx.Hash)].Signature, confirmedTx.Signature) != 0 {
+		t.Error("Confirmed transaction wasn't restored from local storage")
+	}
+}
@@ -1,170 +1,105 @@
 package net
 
 import (
-	"context"
-	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+	"time"
 
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
 	"github.com/lucas-clemente/quic-go"
 )
 
-// Connection represents network connection
+// Connection represents network connection with remote node
 type Connection struct {
-	done chan interface{}
-
-	session quic.Session
+	*conn.Peer
 	netNode *NetworkNode
 
-	outgoingStream quic.SendStream
-	incomingStream quic.ReceiveStream
-
-	incomingRawMessages chan []byte
-	outgoingRawMessages chan []byte
+	// RemoteHello and RemoteRecord are set after initialization stage
+	RemoteHello  *protocol.HelloMessage
+	RemoteRecord *protocol.NodeRecord
+	// Outgoing is true if connection was initiated by local node
+	Outgoing bool
+	// static is true if remote is static peer
+	static bool
+	// remoteIP is IP address connection was established with
+	remoteIP net.IP
+	// score is reputation of peer, it is guarded by lock of peer manager
+	score int
+
+	// pings are channels of sent Ping messages waiting for Pong by nonce
+	pings     map[uint64]chan *protocol.Pong
+	pingsLock *sync.Mutex
+	rtt       time.Duration
+
+	// deferred are messages of other streams received during initialization stage
+	deferred []deferredMessage
+
+	// knownBlocks and knownTxs are hashes sent to or received from remote
+	knownBlocks *knownHashes
+	knownTxs    *knownHashes
+}
 
-	RemoteID        []byte
-	RemotePublicKey []byte
+// HandleConnection handles new connection and serves requests of remote node
+func (netNode *NetworkNode) HandleConnection(sess quic.Session) {
+	netNode.handlePeer(conn.NewPeer(sess))
 }
 
-func (conn *Connection) listenLoop() {
-	n := 0
-	buffer := make([]byte, 4096)
+// handlePeer performs initialization stage of incoming connection and serves it
+func (netNode *NetworkNode) handlePeer(peer *conn.Peer) {
+	connection := netNode.newConnection(peer)
+	defer connection.Close()
 
-	var writeChan chan []byte
-	for {
-		if n > 0 {
-			writeChan = conn.incomingRawMessages
-		} else {
-			writeChan = nil
-		}
-		select {
-		case <-conn.done:
-			return
-		case writeChan <- buffer[:n]:
-			n = 0
-			continue
-		default:
-			if n == 0 {
-				var err error
-				n, err = conn.incomingStream.Read(buffer)
-				if err != nil {
-					close(conn.done)
-					return
-				}
-			}
-		}
+	if netNode.Peers.IsBanned(nil, connection.remoteIP) {
+		connection.disconnect(protocol.DisconnectBanned)
+		return
 	}
-}
-func (conn *Connection) writeLoop() {
-	var outgoingRawMessages chan []byte
-	for {
-		select {
-		case outgoingRawMessage := <-outgoingRawMessages:
-			_, err := conn.outgoingStream.Write(outgoingRawMessage)
-			if err != nil {
-				close(conn.done)
-				return
-			}
-		case <-conn.done:
-			return
-		}
+	if err := connection.startPeerConnection(false, false); err != nil {
+		log.Printf("Initialization with %s failed: %v", connection.RemoteAddr(), err)
+		return
 	}
-}
-
-// Read blocks goroutine and reads data from connection
-func (conn *Connection) Read() []byte {
-	offset := 0
-	lenBuffer := make([]byte, 4)
-
-	var data []byte
-	for offset < 4 {
-		select {
-		case data = <-conn.incomingRawMessages:
-			copy(lenBuffer[offset:], data)
-			offset += len(data)
-		case <-conn.done:
-			return nil
-		}
+	if connection.RemoteHello.Temporary {
+		// Temporary connection is closed after single discovery request
+		timer := time.AfterFunc(FindNodeTimeout, connection.Close)
+		defer timer.Stop()
+		netNode.router.Serve(connection)
+		return
 	}
-	offset -= 4
-
-	messageLength := int(binary.LittleEndian.Uint32(lenBuffer))
-	buffer := make([]byte, messageLength)
-	for offset < messageLength {
-		select {
-		case data = <-conn.incomingRawMessages:
-			copy(buffer[offset:], data)
-			offset += len(data)
-		case <-conn.done:
-			return nil
-		}
+	if reason, ok := netNode.Peers.add(connection); !ok {
+		connection.disconnect(reason)
+		return
 	}
-
-	return buffer
+	netNode.serve(connection)
 }
 
-// Write blocks goroutine and writes data to peer
-func (conn *Connection) Write(data []byte) bool {
-	lenBuffer := make([]byte, 4)
-	binary.LittleEndian.PutUint32(lenBuffer, uint32(len(data)))
-
-loop:
-	for {
-		select {
-		case conn.outgoingRawMessages <- lenBuffer:
-			break loop
-		case <-conn.done:
-			return false
-		}
-	}
-
-	offset := 0
-	for offset < len(data) {
-		var remainingLength int
-		if len(data)-offset < 1024 {
-			remainingLength = len(data) - offset
-		} else {
-			remainingLength = 1024
-		}
+// serve serves requests of registered peer until connection or node is closed
+func (netNode *NetworkNode) serve(connection *Connection) {
+	go func() {
 		select {
-		case conn.outgoingRawMessages <- data[offset : offset+remainingLength]:
-			offset += remainingLength
-		case <-conn.done:
-			return false
+		case <-netNode.done:
+			connection.Close()
+		case <-connection.Done():
 		}
-	}
-
-	return true
-}
-
-// HandleConnection handles new connection and assignes state to it
-func (netNode *NetworkNode) HandleConnection(sess quic.Session) {
-	defer sess.Close()
-	conn := &Connection{
-		done:    make(chan interface{}),
-		session: sess,
-		netNode: netNode,
-	}
+	}()
 
-	outgoingStream, err := sess.OpenUniStream()
-	if err != nil {
-		return
-	}
-	defer outgoingStream.Close()
-	conn.outgoingStream = outgoingStream
+	go connection.keepAlive()
+	unregister := netNode.registerSyncPeer(connection)
+	defer unregister()
 
-	incomingStream, err := sess.AcceptUniStream(context.Background())
-	if err != nil {
-		return
+	netNode.router.Serve(connection)
+	netNode.Peers.remove(connection)
+	if err := connection.Err(); err != nil {
+		log.Printf("Connection with %x closed: %v", connection.RemoteID, err)
 	}
-	conn.incomingStream = incomingStream
+}
 
-	go conn.writeLoop()
-	go conn.listenLoop()
+// connectionTo returns established connection with node, nil is returned if node isn't connected
+func (netNode *NetworkNode) connectionTo(nodeID []byte) *Connection {
+	return netNode.Peers.Peer(nodeID)
+}
 
-	for {
-		select {
-		case <-netNode.done:
-			close(conn.done)
-			return
-		}
-	}
+// Connections returns list of connected peers
+func (netNode *NetworkNode) Connections() []*Connection {
+	return netNode.Peers.Peers()
 }
@@ -0,0 +1,289 @@
+package net
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Node discovery:
+	1. Seed nodes are asked for nodes closest to local node
+	2. Lookup of local node ID fills buckets near local node
+	3. Lookups of random targets are repeated periodically to refresh distant buckets
+	Nodes found by lookups are sent to Candidates channel of discovery service.
+	Contacted nodes are saved in local storage and loaded in routing table on startup,
+	nodes which failed to respond MaxNodeFailures times or weren't seen for
+	KnownNodeExpiration are removed.
+*/
+
+const (
+	// DiscoveryRefreshInterval is interval between routing table refreshes
+	DiscoveryRefreshInterval = 5 * time.Minute
+	// FindNodeTimeout is timeout of single FindNode request including initialization stage
+	FindNodeTimeout = 10 * time.Second
+	// MaxCandidates is capacity of Candidates channel, candidates are dropped if it is full
+	MaxCandidates = 64
+	// KnownNodeExpiration is duration after which known node which wasn't seen is removed from local storage
+	KnownNodeExpiration = 72 * time.Hour
+)
+
+// ErrFindNodeTimeout is returned if remote node doesn't respond to FindNode in time
+var ErrFindNodeTimeout = errors.New("net: FindNode timed out")
+
+// Discovery keeps routing table populated and finds candidate peers
+type Discovery struct {
+	netNode   *NetworkNode
+	seedNodes []string
+
+	// Candidates receives records of discovered nodes
+	Candidates chan *protocol.NodeRecord
+}
+
+// HandleFindNode responds with nodes from routing table closest to requested target
+func (netNode *NetworkNode) HandleFindNode(payload []byte) (byte, proto.Message, error) {
+	request := new(protocol.FindNode)
+	if err := proto.Unmarshal(payload, request); err != nil || len(request.Target) != network.AddressSize {
+		return 0, nil, ErrMalformedRequest
+	}
+
+	nearestNodes := netNode.routingTable.LookupNearestNodes(request.Target)
+
+	response := new(protocol.Neighbours)
+	for _, node := range nearestNodes {
+		if node.Sealed != nil {
+			response.Nodes = append(response.Nodes, node.Sealed)
+		}
+	}
+	response.Total = uint32(len(response.Nodes))
+	return protocol.MessageNeighbours, response, nil
+}
+
+// dial connects to node on address presenting node's certificate, transport of node is used if it is set.
+// Connection is aborted if nodeID isn't nil and remote has other node ID
+func (netNode *NetworkNode) dial(address string, nodeID []byte) (*conn.Peer, error) {
+	if netNode.transport != nil {
+		return netNode.transport.Dial(address, nodeID)
+	}
+	return conn.DialWithOptions(ALPNProtocolName, address, &conn.DialOptions{
+		NodeID:      nodeID,
+		Certificate: &netNode.tlsConfig.Certificates[0],
+		Config:      peerConfig,
+	})
+}
+
+// dialNode connects to node on address and performs initialization stage of temporary connection.
+// Node ID is verified if it isn't nil. Connection is closed when timer fires
+func (netNode *NetworkNode) dialNode(address string, nodeID []byte, timeout time.Duration) (*Connection, *time.Timer, error) {
+	peer, err := netNode.dial(address, nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	connection := netNode.newConnection(peer)
+	timer := time.AfterFunc(timeout, connection.Close)
+
+	if err = connection.startPeerConnection(true, true); err != nil {
+		timer.Stop()
+		connection.Close()
+		return nil, nil, err
+	}
+	return connection, timer, nil
+}
+
+// PingNode checks whether node is alive, it is protocol.PingFn used by routing table.
+// If node is connected, Ping is sent over existing connection. Otherwise node is dialed
+// and Ping/Pong messages are exchanged during initialization stage
+func (netNode *NetworkNode) PingNode(nodeRecord *protocol.NodeRecord) error {
+	if connection := netNode.connectionTo(nodeRecord.NodeID); connection != nil {
+		_, err := connection.Ping()
+		if err == ErrPingTimeout {
+			netNode.Peers.Report(connection, EventTimeout)
+		}
+		return err
+	}
+
+	connection, timer, err := netNode.dialNode(nodeRecord.Address(), nodeRecord.NodeID, FindNodeTimeout)
+	if err != nil {
+		return err
+	}
+	timer.Stop()
+	connection.disconnect(protocol.DisconnectRequested)
+	return nil
+}
+
+// checkNode pings least-recently seen node of full bucket, node is evicted if it doesn't respond
+func (netNode *NetworkNode) checkNode(nodeRecord *protocol.NodeRecord) {
+	if err := netNode.PingNode(nodeRecord); err != nil {
+		log.Printf("Node %x doesn't respond, evicting from routing table: %v", nodeRecord.NodeID, err)
+		netNode.routingTable.Evict(nodeRecord.NodeID)
+		netNode.nodeFailed(nodeRecord)
+	}
+}
+
+// nodeFailed increments failure count of known node, it is evicted from routing table if it was removed
+func (netNode *NetworkNode) nodeFailed(nodeRecord *protocol.NodeRecord) {
+	removed, err := netNode.localStorage.NodeFailed(nodeRecord.NodeID)
+	if err != nil {
+		log.Printf("Failed to save failure of node %x: %v", nodeRecord.NodeID, err)
+		return
+	}
+	if removed {
+		netNode.routingTable.Evict(nodeRecord.NodeID)
+	}
+}
+
+// loadKnownNodes removes expired known nodes from local storage and inserts the rest in routing table
+func (netNode *NetworkNode) loadKnownNodes() {
+	if _, err := netNode.localStorage.ExpireKnownNodes(KnownNodeExpiration); err != nil {
+		log.Printf("Failed to expire known nodes: %v", err)
+	}
+	knownNodes, err := netNode.localStorage.GetKnownNodes()
+	if err != nil {
+		log.Printf("Failed to load known nodes: %v", err)
+		return
+	}
+
+	loaded := 0
+	for _, knownNode := range knownNodes {
+		nodeRecord := knownNode.SealedRecord.Unseal()
+		if nodeRecord == nil {
+			continue
+		}
+		if _, err = netNode.routingTable.Insert(nodeRecord); err == nil {
+			loaded++
+		}
+	}
+	log.Printf("Loaded %d known nodes", loaded)
+}
+
+// FindNode connects to node on address and requests nodes closest to target.
+// Connection is aborted if nodeID isn't nil and remote has other node ID,
+// nodeID is nil only for seed nodes with unknown ID
+func (netNode *NetworkNode) FindNode(address string, nodeID, target []byte) ([]*protocol.NodeRecord, error) {
+	connection, timer, err := netNode.dialNode(address, nodeID, FindNodeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	defer timer.Stop()
+
+	if err = connection.Send(protocol.MessageFindNode, &protocol.FindNode{Target: target}); err != nil {
+		return nil, err
+	}
+	neighbours := new(protocol.Neighbours)
+	if err = connection.expectMessage(protocol.MessageNeighbours, neighbours); err != nil {
+		if !timer.Stop() {
+			return nil, ErrFindNodeTimeout
+		}
+		return nil, err
+	}
+
+	var nodes []*protocol.NodeRecord
+	for i, sealedRecord := range neighbours.Nodes {
+		if i == protocol.NodeListMaxSize {
+			break
+		}
+		if sealedRecord == nil {
+			continue
+		}
+		if node := sealedRecord.Unseal(); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	connection.disconnect(protocol.DisconnectRequested)
+	return nodes, nil
+}
+
+// NewDiscovery creates discovery service bootstrapping from seed node addresses
+func (netNode *NetworkNode) NewDiscovery(seedNodes []string) *Discovery {
+	netNode.router.Handle(protocol.MessageFindNode, netNode.HandleFindNode)
+	return &Discovery{
+		netNode:    netNode,
+		seedNodes:  seedNodes,
+		Candidates: make(chan *protocol.NodeRecord, MaxCandidates),
+	}
+}
+
+// Run bootstraps routing table and refreshes it until node is closed
+func (discovery *Discovery) Run() {
+	discovery.Bootstrap()
+
+	ticker := time.NewTicker(DiscoveryRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-discovery.netNode.done:
+			return
+		case <-ticker.C:
+			discovery.Refresh()
+		}
+	}
+}
+
+// Bootstrap requests nodes from seed nodes and performs lookup of local node
+func (discovery *Discovery) Bootstrap() {
+	localID := discovery.netNode.nodeAddress
+	for _, address := range discovery.seedNodes {
+		nodes, err := discovery.netNode.FindNode(address, nil, localID)
+		if err != nil {
+			log.Printf("Failed to bootstrap from seed node %s: %v", address, err)
+			continue
+		}
+		discovery.offerCandidates(nodes)
+	}
+	discovery.Lookup(localID)
+}
+
+// Refresh revalidates routing table and performs lookups of local node and random target
+func (discovery *Discovery) Refresh() {
+	netNode := discovery.netNode
+	if _, err := netNode.localStorage.ExpireKnownNodes(KnownNodeExpiration); err != nil {
+		log.Printf("Failed to expire known nodes: %v", err)
+	}
+	netNode.routingTable.FlushReplacementCache(netNode.PingNode)
+	discovery.Lookup(netNode.nodeAddress)
+
+	target := make([]byte, network.AddressSize)
+	if _, err := rand.Read(target); err != nil {
+		log.Printf("Failed to generate random lookup target: %v", err)
+		return
+	}
+	discovery.Lookup(target)
+}
+
+// Lookup performs iterative lookup of target and returns closest found nodes
+func (discovery *Discovery) Lookup(target []byte) []*protocol.NodeRecord {
+	netNode := discovery.netNode
+	seeds := netNode.routingTable.LookupNearestNodes(target)
+	if len(seeds) == 0 {
+		log.Printf("Routing table is empty, lookup of %x skipped", target)
+		return nil
+	}
+
+	nodes := protocol.IterativeLookup(target, netNode.nodeAddress, seeds, func(node *protocol.NodeRecord, target []byte) ([]*protocol.NodeRecord, error) {
+		nodes, err := netNode.FindNode(node.Address(), node.NodeID, target)
+		if err != nil {
+			netNode.nodeFailed(node)
+		}
+		return nodes, err
+	})
+	discovery.offerCandidates(nodes)
+	return nodes
+}
+
+// offerCandidates sends nodes to Candidates channel without blocking
+func (discovery *Discovery) offerCandidates(nodes []*protocol.NodeRecord) {
+	for _, node := range nodes {
+		select {
+		case discovery.Candidates <- node:
+		default:
+			return
+		}
+	}
+}
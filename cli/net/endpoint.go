@@ -0,0 +1,170 @@
+package net
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Node record endpoint:
+	Port is taken from listener address. External IP is learned from Pong.RecipientAddress
+	reported by remote nodes, it is applied after EndpointVotesRequired distinct nodes
+	reported same IP. When endpoint changes, SeqID is incremented, record is resealed and
+	sent to connected peers in NodeRecord message. SeqID is saved in local storage,
+	so record created after restart supersedes records known by other nodes.
+*/
+
+// EndpointVotesRequired is count of distinct nodes which should report same external IP
+const EndpointVotesRequired = 2
+
+// maxEndpointVotes is maximal count of stored votes, votes are reset if it is exceeded
+const maxEndpointVotes = 32
+
+var nodeSeqIDKey = []byte("nodeSeqID")
+
+// loadSeqID returns SeqID of last node record saved in local storage
+func (netNode *NetworkNode) loadSeqID() (uint32, error) {
+	var seqID uint32
+	err := netNode.localStorage.Env.View(func(txn *lmdb.Txn) error {
+		data, err := txn.Get(netNode.localStorage.Node, nodeSeqIDKey)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(data) == 4 {
+			seqID = binary.LittleEndian.Uint32(data)
+		}
+		return nil
+	})
+	return seqID, err
+}
+
+// saveSeqID saves SeqID of node record to local storage
+func (netNode *NetworkNode) saveSeqID(seqID uint32) error {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, seqID)
+	return netNode.localStorage.Env.Update(func(txn *lmdb.Txn) error {
+		return txn.Put(netNode.localStorage.Node, nodeSeqIDKey, data, 0)
+	})
+}
+
+// ReportEndpoint registers address of local node reported by remote node with reporterID.
+// Endpoint is updated when EndpointVotesRequired nodes reported same IP
+func (netNode *NetworkNode) ReportEndpoint(address string, reporterID []byte) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		return
+	}
+	if isIPv4 := ip.To4() != nil; netNode.ipNetwork == protocol.NetworkIPv4 && !isIPv4 ||
+		netNode.ipNetwork == protocol.NetworkIPv6 && isIPv4 {
+		return
+	}
+
+	netNode.endpointLock.Lock()
+	if len(netNode.endpointVotes) >= maxEndpointVotes {
+		netNode.endpointVotes = make(map[string]string)
+	}
+	netNode.endpointVotes[string(reporterID)] = ip.String()
+	votes := 0
+	for _, votedIP := range netNode.endpointVotes {
+		if votedIP == ip.String() {
+			votes++
+		}
+	}
+	netNode.endpointLock.Unlock()
+
+	if votes >= EndpointVotesRequired {
+		netNode.nodeRecordLock.RLock()
+		port := netNode.nodeRecord.QUICPort
+		netNode.nodeRecordLock.RUnlock()
+		netNode.UpdateEndpoint(ip, port)
+	}
+}
+
+// UpdateEndpoint sets IP address and port of node record. If they are changed, SeqID is incremented,
+// record is resealed and sent to connected peers. Returns true if record was updated
+func (netNode *NetworkNode) UpdateEndpoint(ip net.IP, port uint16) bool {
+	ipv4 := ip.To4()
+	if ipv4 == nil && ip.To16() == nil {
+		return false
+	}
+
+	netNode.nodeRecordLock.Lock()
+	nodeRecord := netNode.nodeRecord
+	if ipv4 != nil && net.IP(nodeRecord.IPv4).Equal(ipv4) && nodeRecord.QUICPort == port ||
+		ipv4 == nil && net.IP(nodeRecord.IPv6).Equal(ip) && nodeRecord.QUICPort == port {
+		netNode.nodeRecordLock.Unlock()
+		return false
+	}
+	if ipv4 != nil {
+		nodeRecord.IPv4 = ipv4
+	} else {
+		nodeRecord.IPv6 = ip.To16()
+	}
+	nodeRecord.QUICPort = port
+	nodeRecord.UDPPort = port
+	nodeRecord.SeqID++
+	seqID := nodeRecord.SeqID
+	netNode.nodeRecordLock.Unlock()
+
+	if err := netNode.saveSeqID(seqID); err != nil {
+		log.Printf("Failed to save node record SeqID: %v", err)
+	}
+	netNode.SealNodeRecord()
+	log.Printf("Node endpoint updated: %s, SeqID: %d", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), seqID)
+
+	netNode.broadcastNodeRecord()
+	return true
+}
+
+// broadcastNodeRecord sends sealed node record to all connected peers
+func (netNode *NetworkNode) broadcastNodeRecord() {
+	netNode.nodeRecordLock.RLock()
+	sealedNodeRecord := &protocol.SealedNodeRecord{
+		NodeRecord: netNode.sealedNodeRecord.NodeRecord,
+		Signature:  netNode.sealedNodeRecord.Signature,
+	}
+	netNode.nodeRecordLock.RUnlock()
+
+	for _, connection := range netNode.Connections() {
+		go connection.Send(protocol.MessageNodeRecord, sealedNodeRecord)
+	}
+}
+
+// HandleNodeRecord updates record of known node if received record has higher SeqID
+func (netNode *NetworkNode) HandleNodeRecord(payload []byte) (byte, proto.Message, error) {
+	sealedNodeRecord := new(protocol.SealedNodeRecord)
+	if err := proto.Unmarshal(payload, sealedNodeRecord); err != nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	nodeRecord := sealedNodeRecord.Unseal()
+	if nodeRecord == nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	netNode.updateNodeRecord(nodeRecord)
+	return 0, nil, nil
+}
+
+// updateNodeRecord replaces record of known node in routing table and local storage if it has higher SeqID
+func (netNode *NetworkNode) updateNodeRecord(nodeRecord *protocol.NodeRecord) {
+	if updated, err := netNode.routingTable.Update(nodeRecord); err != nil {
+		log.Printf("Record of node %x wasn't updated: %v", nodeRecord.NodeID, err)
+	} else if updated {
+		log.Printf("Record of node %x updated, SeqID: %d", nodeRecord.NodeID, nodeRecord.SeqID)
+	}
+	if _, err := netNode.localStorage.UpdateNodeRecord(nodeRecord); err != nil {
+		log.Printf("Failed to save record of node %x: %v", nodeRecord.NodeID, err)
+	}
+}
@@ -0,0 +1,148 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+// initTestNode creates network node with local storage, but without listener
+func initTestNode(t *testing.T, path string) (*NetworkNode, func()) {
+	localStorage, err := db.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed: %+v", err)
+	}
+	netNode := &NetworkNode{
+		done:           make(chan interface{}),
+		localStorage:   localStorage,
+		router:         NewRouter(),
+		nodeRecordLock: &sync.RWMutex{},
+		endpointVotes:  make(map[string]string),
+		endpointLock:   &sync.Mutex{},
+	}
+	if err = netNode.LoadNodeKeys(false); err != nil {
+		t.Fatalf("LoadNodeKeys failed: %+v", err)
+	}
+	netNode.routingTable = protocol.NewKademliaTable(netNode.nodeAddress)
+	netNode.Peers = netNode.NewPeerManager(0, 0, nil, nil)
+	netNode.CreateInitialNodeRecord(protocol.NetworkIPv4, 7000)
+	return netNode, func() {
+		localStorage.Env.Close()
+	}
+}
+
+func TestUpdateEndpoint(t *testing.T) {
+	/*
+		1. Single report of external IP shouldn't change endpoint
+		2. After EndpointVotesRequired reports from distinct nodes endpoint is updated and SeqID is incremented
+		3. SeqID is incremented after restart
+	*/
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	defer os.RemoveAll(path)
+
+	netNode, cleanup := initTestNode(t, path)
+	initialSeqID := netNode.nodeRecord.SeqID
+
+	netNode.ReportEndpoint("8.8.8.8:45000", []byte{0x01})
+	netNode.ReportEndpoint("8.8.8.8:45001", []byte{0x01})
+	if netNode.nodeRecord.SeqID != initialSeqID {
+		t.Fatal("Endpoint was updated by single node")
+	}
+	netNode.ReportEndpoint("8.8.8.8:45002", []byte{0x02})
+
+	sealedRecord := netNode.sealedNodeRecord.Unseal()
+	if sealedRecord == nil {
+		t.Fatal("Node record wasn't resealed")
+	}
+	if sealedRecord.SeqID != initialSeqID+1 || !sealedRecord.IP().Equal(net.IPv4(8, 8, 8, 8)) ||
+		sealedRecord.QUICPort != 7000 || !sealedRecord.HasCapability(NodeCapability) {
+		t.Errorf("Unexpected node record: %+v", sealedRecord)
+	}
+	if netNode.UpdateEndpoint(net.IPv4(8, 8, 8, 8), 7000) {
+		t.Error("Unchanged endpoint was updated")
+	}
+	cleanup()
+
+	netNode, cleanup = initTestNode(t, path)
+	defer cleanup()
+	if netNode.nodeRecord.SeqID != initialSeqID+2 {
+		t.Errorf("Unexpected SeqID after restart: %d", netNode.nodeRecord.SeqID)
+	}
+}
+
+func TestHandleNodeRecord(t *testing.T) {
+	/*
+		1. Known node sends record with higher SeqID, it should replace known record
+		2. Record with lower SeqID should be ignored
+		3. Malformed record should be rejected
+	*/
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	defer os.RemoveAll(path)
+	netNode, cleanup := initTestNode(t, path)
+	defer cleanup()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	sealRecord := func(seqID uint32, ip []byte) []byte {
+		remote := &NetworkNode{
+			nodeAddress:    network.DeriveAddress(pubKey),
+			nodePubKey:     pubKey,
+			nodePrivKey:    privKey,
+			nodeRecordLock: &sync.RWMutex{},
+			nodeRecord: &protocol.NodeRecord{
+				PublicKey: pubKey,
+				SeqID:     seqID,
+				NodeID:    network.DeriveAddress(pubKey),
+				QUICPort:  7000,
+				IPv4:      ip,
+			},
+			sealedNodeRecord: new(protocol.SealedNodeRecord),
+		}
+		remote.SealNodeRecord()
+		payload, _ := proto.Marshal(remote.sealedNodeRecord)
+		return payload
+	}
+
+	initialRecord := new(protocol.SealedNodeRecord)
+	proto.Unmarshal(sealRecord(2, []byte{1, 1, 1, 1}), initialRecord)
+	if _, err = netNode.routingTable.Insert(initialRecord.Unseal()); err != nil {
+		t.Fatalf("Insert failed: %+v", err)
+	}
+
+	if _, _, err = netNode.HandleNodeRecord(sealRecord(3, []byte{2, 2, 2, 2})); err != nil {
+		t.Fatalf("HandleNodeRecord failed: %+v", err)
+	}
+	if record := netNode.routingTable.Get(network.DeriveAddress(pubKey)); record.SeqID != 3 ||
+		bytes.Compare(record.IPv4, []byte{2, 2, 2, 2}) != 0 {
+		t.Errorf("Record wasn't updated: %+v", record)
+	}
+
+	netNode.HandleNodeRecord(sealRecord(1, []byte{3, 3, 3, 3}))
+	if record := netNode.routingTable.Get(network.DeriveAddress(pubKey)); record.SeqID != 3 {
+		t.Errorf("Record was replaced with older one: %d", record.SeqID)
+	}
+
+	forged := sealRecord(4, []byte{4, 4, 4, 4})
+	forged[len(forged)-1] ^= 0xFF
+	if _, _, err = netNode.HandleNodeRecord(forged); err != ErrMalformedRequest {
+		t.Errorf("Forged record was accepted: %+v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package net
+
+import (
+	"crypto/ed25519"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Finality messages:
+	Vote and FinalityCertificate messages are passed to finality layer of blockchain dispatcher,
+	votes and certificates created by local node are sent to all connected peers.
+	If authority set isn't known, finality is disabled and received messages are ignored.
+*/
+
+// initFinality creates finality gadget for authority set and enables it in blockchain dispatcher.
+// If authorities are not specified, authority of PoA algorithm is the only member of authority set
+func (netNode *NetworkNode) initFinality(authorities [][]byte, privKey ed25519.PrivateKey, proofAlgo consensus.ProofAlgorithm) {
+	netNode.router.Handle(protocol.MessageVote, netNode.HandleVote)
+	netNode.router.Handle(protocol.MessageFinalityCertificate, netNode.HandleFinalityCertificate)
+
+	if poa, ok := proofAlgo.(*consensus.ProofOfAuthority); ok && len(authorities) == 0 {
+		authorities = [][]byte{poa.AuthorityPublicKey}
+		if privKey == nil {
+			privKey = poa.AuthorityPrivateKey
+		}
+	}
+	if len(authorities) == 0 || chain.BlockchainDispatcher == nil {
+		return
+	}
+	gadget := consensus.NewFinalityGadget(authorities, privKey)
+	chain.BlockchainDispatcher.SetFinalityGadget(gadget, netNode.broadcast)
+}
+
+// broadcast sends message to all connected peers
+func (netNode *NetworkNode) broadcast(messageID byte, message proto.Message) {
+	for _, connection := range netNode.Connections() {
+		go connection.Send(messageID, message)
+	}
+}
+
+// HandleVote passes finality vote received from peer to blockchain dispatcher
+func (netNode *NetworkNode) HandleVote(payload []byte) (byte, proto.Message, error) {
+	vote := new(protocol.Vote)
+	if err := proto.Unmarshal(payload, vote); err != nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	if err := chain.BlockchainDispatcher.HandleVote(vote); err != nil && err != chain.ErrFinalityNotInitialized {
+		return 0, nil, err
+	}
+	return 0, nil, nil
+}
+
+// HandleFinalityCertificate passes finality certificate received from peer to blockchain dispatcher
+func (netNode *NetworkNode) HandleFinalityCertificate(payload []byte) (byte, proto.Message, error) {
+	cert := new(blockchain.FinalityCertificate)
+	if err := proto.Unmarshal(payload, cert); err != nil {
+		return 0, nil, ErrMalformedRequest
+	}
+	if err := chain.BlockchainDispatcher.ApplyFinalityCertificate(cert); err != nil && err != chain.ErrFinalityNotInitialized {
+		return 0, nil, err
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,385 @@
+package net
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Gossip propagation:
+	1. Block applied by dispatcher is sent in NewBlock message to sqrt(n) random peers,
+	   other peers receive its hash in NewBlockHashes message. NewBlock is compact block
+	   described in compact.go
+	2. Transactions added to mempool are sent in Transactions message
+	3. Hashes of blocks and transactions sent to and received from peer are remembered,
+	   so they aren't sent back
+	Announced unknown blocks are requested with GetBlockHeaders and processed as compact blocks.
+*/
+
+const (
+	// MaxKnownBlocks is count of block hashes remembered for each peer
+	MaxKnownBlocks = 1024
+	// MaxKnownTxs is count of transaction hashes remembered for each peer
+	MaxKnownTxs = 32768
+	// MaxAnnouncedBlocks is maximal count of handled hashes in NewBlockHashes message
+	MaxAnnouncedBlocks = 32
+	// MaxGossipTxs is maximal count of transactions sent in single Transactions message
+	MaxGossipTxs = 256
+	// MaxPendingBlocks is maximal count of blocks waiting for transactions or headers
+	MaxPendingBlocks = 64
+	// BlockFetchTimeout is duration after which requested block or its transactions are dropped
+	BlockFetchTimeout = 30 * time.Second
+)
+
+// expireInterval is interval between checks of timed out requests
+const expireInterval = time.Second
+
+// knownHashes is set of hashes with limited size, oldest hashes are removed when limit is reached
+type knownHashes struct {
+	hashes map[string]struct{}
+	queue  []string
+	next   int
+	lock   *sync.Mutex
+}
+
+func newKnownHashes(limit int) *knownHashes {
+	return &knownHashes{
+		hashes: make(map[string]struct{}),
+		queue:  make([]string, limit),
+		lock:   &sync.Mutex{},
+	}
+}
+
+// Add puts hash in set, returns false if it was already known
+func (known *knownHashes) Add(hash []byte) bool {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	key := string(hash)
+	if _, exists := known.hashes[key]; exists {
+		return false
+	}
+	if oldest := known.queue[known.next]; oldest != "" {
+		delete(known.hashes, oldest)
+	}
+	known.queue[known.next] = key
+	known.next = (known.next + 1) % len(known.queue)
+	known.hashes[key] = struct{}{}
+	return true
+}
+
+// Has checks whether hash is in set
+func (known *knownHashes) Has(hash []byte) bool {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+	_, exists := known.hashes[string(hash)]
+	return exists
+}
+
+// blockRequest is sent request of block header
+type blockRequest struct {
+	time time.Time
+	// full is true if all transactions of block should be fetched from peer
+	full bool
+}
+
+// Gossip propagates blocks and transactions between connected peers
+type Gossip struct {
+	stats CompactBlockStats
+
+	netNode *NetworkNode
+	mempool *chain.TxPool
+
+	pending   map[string]*pendingBlock
+	requested map[string]*blockRequest
+	lock      *sync.Mutex
+}
+
+// NewGossip creates gossip service and registers its message handlers
+func (netNode *NetworkNode) NewGossip() *Gossip {
+	gossip := &Gossip{
+		netNode:   netNode,
+		mempool:   chain.Mempool,
+		pending:   make(map[string]*pendingBlock),
+		requested: make(map[string]*blockRequest),
+		lock:      &sync.Mutex{},
+	}
+	netNode.router.HandlePeer(protocol.MessageNewBlock, gossip.peerHandler(gossip.HandleNewBlock))
+	netNode.router.HandlePeer(protocol.MessageNewBlockHashes, gossip.peerHandler(gossip.HandleNewBlockHashes))
+	netNode.router.HandlePeer(protocol.MessageBlockHeaders, gossip.peerHandler(gossip.HandleBlockHeaders))
+	netNode.router.HandlePeer(protocol.MessageTransactions, gossip.peerHandler(gossip.HandleTransactions))
+	return gossip
+}
+
+// peerHandler converts gossip handler to PeerHandlerFn, gossip messages are accepted only from network connections
+func (gossip *Gossip) peerHandler(handler func(*Connection, []byte) error) PeerHandlerFn {
+	return func(messageConn MessageConn, payload []byte) (byte, proto.Message, error) {
+		connection, ok := messageConn.(*Connection)
+		if !ok {
+			return 0, nil, errors.New("net: gossip message from unknown connection")
+		}
+		err := handler(connection, payload)
+		if err == ErrMalformedRequest || err == ErrInvalidNewBlock {
+			gossip.netNode.Peers.Report(connection, EventMalformedMessage)
+		}
+		return 0, nil, err
+	}
+}
+
+// Run sends blocks applied by dispatcher and transactions added to mempool until node is closed
+func (gossip *Gossip) Run() {
+	blocks := chain.BlockchainDispatcher.SubscribeBlocks()
+	transactions := gossip.mempool.Subscribe()
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gossip.netNode.done:
+			return
+		case <-ticker.C:
+			gossip.expire()
+		case block := <-blocks:
+			gossip.BroadcastBlock(block)
+		case tx := <-transactions:
+			batch := []blockchain.TX{tx}
+		collect:
+			for len(batch) < MaxGossipTxs {
+				select {
+				case tx = <-transactions:
+					batch = append(batch, tx)
+				default:
+					break collect
+				}
+			}
+			gossip.BroadcastTransactions(batch)
+		}
+	}
+}
+
+// splitPeers randomly selects sqrt(n) peers receiving full block, other peers receive block hash
+func splitPeers(peers []*Connection) (full, announce []*Connection) {
+	if len(peers) == 0 {
+		return nil, nil
+	}
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	count := int(math.Sqrt(float64(len(peers))))
+	if count == 0 {
+		count = 1
+	}
+	return peers[:count], peers[count:]
+}
+
+// BroadcastBlock sends block to peers which don't know it
+func (gossip *Gossip) BroadcastBlock(block blockchain.Block) {
+	blockHash := block.CalculateHash()
+	header, err := encodeCompactHeader(block)
+	if err != nil {
+		log.Printf("Failed to encode block header %s: %v", hex.EncodeToString(blockHash), err)
+		return
+	}
+
+	var peers []*Connection
+	for _, connection := range gossip.netNode.Connections() {
+		if connection.knownBlocks.Add(blockHash) {
+			peers = append(peers, connection)
+		}
+	}
+
+	full, announce := splitPeers(peers)
+	newBlock := &protocol.NewBlock{BlockHeader: header, TxHashList: block.TxHashes}
+	for _, connection := range full {
+		go connection.Send(protocol.MessageNewBlock, newBlock)
+	}
+	newBlockHashes := &protocol.NewBlockHashes{BlockHashes: [][]byte{blockHash}}
+	for _, connection := range announce {
+		go connection.Send(protocol.MessageNewBlockHashes, newBlockHashes)
+	}
+}
+
+// BroadcastTransactions sends transactions to peers which don't know them
+func (gossip *Gossip) BroadcastTransactions(transactions []blockchain.TX) {
+	encoded := make([][]byte, len(transactions))
+	for i := range transactions {
+		txData, err := proto.Marshal(&transactions[i])
+		if err != nil {
+			log.Printf("Failed to encode transaction %s: %v", hex.EncodeToString(transactions[i].Hash), err)
+			return
+		}
+		encoded[i] = txData
+	}
+
+	for _, connection := range gossip.netNode.Connections() {
+		message := new(protocol.Transactions)
+		for i := range transactions {
+			if connection.knownTxs.Add(transactions[i].Hash) {
+				message.Transactions = append(message.Transactions, encoded[i])
+			}
+		}
+		if len(message.Transactions) > 0 {
+			go connection.Send(protocol.MessageTransactions, message)
+		}
+	}
+}
+
+// isKnownBlock checks whether block is saved in local storage
+func (gossip *Gossip) isKnownBlock(blockHash []byte) bool {
+	block, err := gossip.netNode.localStorage.GetBlock(blockHash)
+	return err == nil && block != nil
+}
+
+// HandleNewBlockHashes requests headers of announced unknown blocks
+func (gossip *Gossip) HandleNewBlockHashes(connection *Connection, payload []byte) error {
+	request := new(protocol.NewBlockHashes)
+	if err := proto.Unmarshal(payload, request); err != nil {
+		return ErrMalformedRequest
+	}
+
+	for i, blockHash := range request.BlockHashes {
+		if i == MaxAnnouncedBlocks {
+			break
+		}
+		connection.knownBlocks.Add(blockHash)
+		if gossip.isKnownBlock(blockHash) || !gossip.markRequested(blockHash) {
+			continue
+		}
+		if err := connection.Send(protocol.MessageGetBlockHeaders, &protocol.GetBlockHeaders{BlockHash: blockHash}); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// markRequested registers request of block header, returns false if block was already requested
+func (gossip *Gossip) markRequested(blockHash []byte) bool {
+	gossip.lock.Lock()
+	defer gossip.lock.Unlock()
+
+	if _, exists := gossip.requested[string(blockHash)]; exists || len(gossip.requested) >= MaxPendingBlocks {
+		return false
+	}
+	gossip.requested[string(blockHash)] = &blockRequest{time: time.Now()}
+	return true
+}
+
+// expire removes timed out requests and pending blocks,
+// compact blocks which didn't receive their transactions in time are fetched in full
+func (gossip *Gossip) expire() {
+	type fallback struct {
+		blockHash []byte
+		peer      *Connection
+	}
+	var (
+		fallbacks []fallback
+		timedOut  []*Connection
+	)
+
+	now := time.Now()
+	gossip.lock.Lock()
+	for key, request := range gossip.requested {
+		if now.Sub(request.time) > BlockFetchTimeout {
+			delete(gossip.requested, key)
+		}
+	}
+	for key, pending := range gossip.pending {
+		if !pending.full && now.Sub(pending.received) > CompactFetchTimeout {
+			fallbacks = append(fallbacks, fallback{[]byte(key), pending.peer})
+			delete(gossip.pending, key)
+		} else if now.Sub(pending.received) > BlockFetchTimeout {
+			timedOut = append(timedOut, pending.peer)
+			delete(gossip.pending, key)
+		}
+	}
+	gossip.lock.Unlock()
+
+	for _, fallback := range fallbacks {
+		gossip.netNode.Peers.Report(fallback.peer, EventTimeout)
+	}
+	for _, peer := range timedOut {
+		gossip.netNode.Peers.Report(peer, EventTimeout)
+	}
+
+	for _, fallback := range fallbacks {
+		gossip.requestFullBlock(fallback.blockHash, fallback.peer)
+	}
+}
+
+// HandleBlockHeaders processes headers of requested blocks, other headers are ignored
+func (gossip *Gossip) HandleBlockHeaders(connection *Connection, payload []byte) error {
+	response := new(protocol.BlockHeaders)
+	if err := proto.Unmarshal(payload, response); err != nil {
+		return ErrMalformedRequest
+	}
+
+	for _, header := range response.BlockHeaders {
+		block, err := protocol.DecodeBlockHeader(header)
+		if err != nil {
+			return ErrMalformedRequest
+		}
+		blockHash := block.CalculateHash()
+
+		gossip.lock.Lock()
+		request := gossip.requested[string(blockHash)]
+		delete(gossip.requested, string(blockHash))
+		gossip.lock.Unlock()
+		if request == nil {
+			continue
+		}
+		if bytes.Compare(blockchain.CalculateMerkleRoot(block.TxHashes), block.TxMerkleRoot) != 0 {
+			log.Printf("Received header of block %s with invalid transaction list", hex.EncodeToString(blockHash))
+			gossip.netNode.Peers.Report(connection, EventInvalidBlock)
+			continue
+		}
+		gossip.receiveBlock(connection, block, request.full)
+	}
+	return nil
+}
+
+// HandleTransactions adds received transactions to mempool and applies pending blocks waiting for them.
+// Rejected transactions decrease score of peer
+func (gossip *Gossip) HandleTransactions(connection *Connection, payload []byte) error {
+	response := new(protocol.Transactions)
+	if err := proto.Unmarshal(payload, response); err != nil {
+		return ErrMalformedRequest
+	}
+
+	received := make(map[string]blockchain.TX)
+	for _, txData := range response.Transactions {
+		tx := blockchain.TX{}
+		if err := proto.Unmarshal(txData, &tx); err != nil {
+			return ErrMalformedRequest
+		}
+		connection.knownTxs.Add(tx.Hash)
+		received[string(tx.Hash)] = tx
+	}
+	gossip.fillPendingBlocks(connection, received)
+
+	for _, tx := range received {
+		// Transactions of received blocks may be already confirmed
+		if txData, err := gossip.netNode.localStorage.GetTransaction(tx.Hash); err != nil || txData != nil {
+			continue
+		}
+		known := gossip.mempool.Get(tx.Hash) != nil
+		if err := gossip.mempool.Add(tx); err != nil {
+			log.Printf("Transaction %s received from %x was rejected: %v", hex.EncodeToString(tx.Hash), connection.RemoteID, err)
+			gossip.netNode.Peers.Report(connection, txEvent(err))
+			continue
+		}
+		if !known {
+			gossip.netNode.Peers.Report(connection, EventUsefulData)
+		}
+	}
+	return nil
+}
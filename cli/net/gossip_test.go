@@ -0,0 +1,52 @@
+package net
+
+import (
+	"testing"
+)
+
+func TestKnownHashes(t *testing.T) {
+	/*
+		1. Added hash is known, second Add returns false
+		2. Oldest hash is removed when limit is reached
+	*/
+	known := newKnownHashes(3)
+	for i := byte(0); i < 3; i++ {
+		if !known.Add([]byte{i}) {
+			t.Fatalf("Hash %d is known before it was added", i)
+		}
+	}
+	if known.Add([]byte{1}) || !known.Has([]byte{0}) {
+		t.Fatal("Added hash isn't known")
+	}
+
+	known.Add([]byte{3})
+	if known.Has([]byte{0}) {
+		t.Error("Oldest hash wasn't removed")
+	}
+	for i := byte(1); i <= 3; i++ {
+		if !known.Has([]byte{i}) {
+			t.Errorf("Hash %d was removed", i)
+		}
+	}
+}
+
+func TestSplitPeers(t *testing.T) {
+	for count, expected := range map[int]int{0: 0, 1: 1, 2: 1, 4: 2, 10: 3, 100: 10} {
+		peers := make([]*Connection, count)
+		for i := range peers {
+			peers[i] = &Connection{}
+		}
+
+		full, announce := splitPeers(peers)
+		if len(full) != expected || len(full)+len(announce) != count {
+			t.Errorf("Unexpected split of %d peers: %d/%d", count, len(full), len(announce))
+		}
+		selected := make(map[*Connection]bool)
+		for _, connection := range append(full, announce...) {
+			if selected[connection] {
+				t.Fatal("Peer was selected twice")
+			}
+			selected[connection] = true
+		}
+	}
+}
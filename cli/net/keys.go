@@ -14,6 +14,7 @@ import (
 
 	"github.com/bmatsuo/lmdb-go/lmdb"
 	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
 	"github.com/pkg/errors"
 )
 
@@ -50,13 +51,13 @@ func (netNode *NetworkNode) GenerateCertificate(pubKey, privKey []byte) (*tls.Ce
 		BasicConstraintsValid: true,
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, pubKey, privKey)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, ed25519.PublicKey(pubKey), ed25519.PrivateKey(privKey))
 	if err != nil {
 		return nil, errors.Wrap(err, "GenerateCertificate failed")
 	}
 
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	privBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	privBytes, err := x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(privKey))
 	if err != nil {
 		return nil, errors.Wrap(err, "GenerateCertificate: failed to marshal private key")
 	}
@@ -70,6 +71,7 @@ func (netNode *NetworkNode) GenerateCertificate(pubKey, privKey []byte) (*tls.Ce
 }
 
 // LoadTLSConfig generates TLS configuration using node's ed25519 keys.
+// Incoming connections are mutually authenticated, remotes should present Ed25519 certificate
 func (netNode *NetworkNode) LoadTLSConfig() error {
 	tlsCert, err := netNode.GenerateCertificate(netNode.nodePubKey, netNode.nodePrivKey)
 	if err != nil {
@@ -78,8 +80,11 @@ func (netNode *NetworkNode) LoadTLSConfig() error {
 
 	netNode.tlsConfig = &tls.Config{
 		Certificates:       []tls.Certificate{*tlsCert},
-		NextProtos:         []string{"bzc-blockchain"},
+		NextProtos:         []string{ALPNProtocolName},
 		InsecureSkipVerify: true,
+
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: conn.VerifyCertificate,
 	}
 	return nil
 }
@@ -3,13 +3,14 @@ package net
 import (
 	"context"
 
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
 	quic "github.com/lucas-clemente/quic-go"
 	"github.com/pkg/errors"
 )
 
 // Listen opens node listener on specified address
 func (netNode *NetworkNode) Listen(addr string) error {
-	listener, err := quic.ListenAddr(addr, netNode.tlsConfig, nil)
+	listener, err := quic.ListenAddr(addr, netNode.tlsConfig, peerConfig.QUICConfig())
 	if err != nil {
 		return errors.Wrap(err, "Listen: starting QUIC listener failed")
 	}
@@ -50,3 +51,19 @@ func (netNode *NetworkNode) Listen(addr string) error {
 		}
 	}
 }
+
+// ServeTransport handles connections accepted by transport until node is closed, then transport is closed
+func (netNode *NetworkNode) ServeTransport(transport conn.Transport) {
+	defer transport.Close()
+	for {
+		select {
+		case peer, ok := <-transport.Incoming():
+			if !ok {
+				return
+			}
+			go netNode.handlePeer(peer)
+		case <-netNode.done:
+			return
+		}
+	}
+}
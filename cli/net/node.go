@@ -1,24 +1,29 @@
 package net
 
 import (
-	"bytes"
 	"crypto/ed25519"
 	"crypto/tls"
 	"fmt"
+	"log"
 	"os"
 	"sync"
 
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
 	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/cli/downloader"
 	"github.com/buuzcoin/go-buuzcoin/network/consensus"
 	"github.com/buuzcoin/go-buuzcoin/network/protocol"
-	"golang.org/x/crypto/sha3"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
 )
 
 // NetworkNode is responsible for managing incoming and outgoing connections
 type NetworkNode struct {
-	done         chan interface{}
-	tlsConfig    *tls.Config
+	done      chan interface{}
+	tlsConfig *tls.Config
+	// transport is used instead of QUIC listener and dialer if it isn't nil
+	transport    conn.Transport
 	localStorage *db.LocalStorage
+	router       *Router
 
 	nodeAddress             []byte
 	nodePubKey, nodePrivKey []byte
@@ -26,6 +31,20 @@ type NetworkNode struct {
 	nodeRecord       *protocol.NodeRecord
 	nodeRecordLock   *sync.RWMutex
 	sealedNodeRecord *protocol.SealedNodeRecord
+
+	routingTable  *protocol.KademliaTable
+	ipNetwork     byte
+	endpointVotes map[string]string
+	endpointLock  *sync.Mutex
+
+	// Peers is set of connected peers
+	Peers *PeerManager
+	// Discovery finds nodes and keeps routing table populated
+	Discovery *Discovery
+	// Gossip propagates blocks and transactions to connected peers
+	Gossip *Gossip
+	// Downloader synchronizes local chain with chains of connected peers
+	Downloader *downloader.Downloader
 }
 
 // InitNodeOptions are options passed to InitNode function
@@ -33,9 +52,31 @@ type InitNodeOptions struct {
 	Port int
 	// Whether to regenerate TLS certificates
 	ForceRegenerate bool
-	IPNetwork       byte
-	LocalStorage    *db.LocalStorage
-	ProofAlgorithm  consensus.ProofAlgorithm
+	// IPNetwork is network of external address, protocol.NetworkIPv4 or protocol.NetworkIPv6.
+	// If it is zero, addresses of both networks are accepted
+	IPNetwork      byte
+	LocalStorage   *db.LocalStorage
+	ProofAlgorithm consensus.ProofAlgorithm
+	// SeedNodes are addresses of nodes used to bootstrap routing table
+	SeedNodes []string
+
+	// MaxPeers is maximal count of connected peers, DefaultMaxPeers is used if it is zero
+	MaxPeers int
+	// TargetOutbound is count of outbound peers, DefaultTargetOutbound is used if it is zero
+	TargetOutbound int
+	// StaticPeers are addresses of nodes which are always connected
+	StaticPeers []string
+	// TrustedPeers are IDs of nodes which are accepted regardless of peer slots
+	TrustedPeers [][]byte
+	// NewTransport creates transport presenting node's certificate, it is used instead of
+	// QUIC listener on Port if it isn't nil, e.g. to run nodes over conn.MemoryNetwork in tests
+	NewTransport func(certificate tls.Certificate) (conn.Transport, error)
+
+	// FinalityAuthorities are public keys of authority set voting for finality, authority
+	// of ProofAlgorithm is used if it is empty and ProofAlgorithm is PoA
+	FinalityAuthorities [][]byte
+	// FinalityKey is private key of local authority, it is nil if local node doesn't vote
+	FinalityKey ed25519.PrivateKey
 }
 
 // InitNode initializes node and returns new ConnectionnetNode instance
@@ -43,8 +84,16 @@ func InitNode(options *InitNodeOptions) *NetworkNode {
 	netNode := &NetworkNode{
 		done:           make(chan interface{}),
 		localStorage:   options.LocalStorage,
+		router:         NewRouter(),
 		nodeRecordLock: &sync.RWMutex{},
+
+		endpointVotes: make(map[string]string),
+		endpointLock:  &sync.Mutex{},
 	}
+	netNode.router.Handle(protocol.MessageNodeRecord, netNode.HandleNodeRecord)
+	chainDataServer := &ChainDataServer{LocalStorage: options.LocalStorage, Mempool: chain.Mempool}
+	chainDataServer.RegisterHandlers(netNode.router)
+	netNode.initFinality(options.FinalityAuthorities, options.FinalityKey, options.ProofAlgorithm)
 
 	address := fmt.Sprintf("0.0.0.0:%d", options.Port)
 	if err := netNode.LoadNodeKeys(options.ForceRegenerate); err != nil {
@@ -52,17 +101,38 @@ func InitNode(options *InitNodeOptions) *NetworkNode {
 		os.Exit(1)
 	}
 
-	netNode.CreateInitialNodeRecord(options.IPNetwork)
+	netNode.routingTable = protocol.NewKademliaTable(netNode.nodeAddress)
+	netNode.Peers = netNode.NewPeerManager(options.MaxPeers, options.TargetOutbound, options.StaticPeers, options.TrustedPeers)
+	netNode.Peers.loadBans()
+	netNode.Downloader = netNode.NewDownloader(options.ProofAlgorithm)
+	netNode.loadKnownNodes()
+	netNode.CreateInitialNodeRecord(options.IPNetwork, uint16(options.Port))
 	if err := netNode.LoadTLSConfig(); err != nil {
 		fmt.Fprintf(os.Stderr, "[fatal] Failed to init listener on %s: %+v\n", address, err)
 		os.Exit(1)
 	}
-	go func() {
-		if err := netNode.Listen(address); err != nil {
-			fmt.Fprintf(os.Stderr, "[fatal] Failed to init listener on %s: %s", address, err)
+	if options.NewTransport != nil {
+		transport, err := options.NewTransport(netNode.tlsConfig.Certificates[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[fatal] Failed to create transport: %+v\n", err)
 			os.Exit(1)
 		}
-	}()
+		netNode.transport = transport
+		go netNode.ServeTransport(transport)
+	} else {
+		go func() {
+			if err := netNode.Listen(address); err != nil {
+				fmt.Fprintf(os.Stderr, "[fatal] Failed to init listener on %s: %s", address, err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	netNode.Discovery = netNode.NewDiscovery(options.SeedNodes)
+	go netNode.Discovery.Run()
+	netNode.Gossip = netNode.NewGossip()
+	go netNode.Gossip.Run()
+	go netNode.Peers.Run(netNode.Discovery.Candidates)
 	return netNode
 }
 
@@ -71,17 +141,30 @@ func (netNode *NetworkNode) Close() {
 	close(netNode.done)
 }
 
-// CreateInitialNodeRecord initializes NodeRecord structure
-func (netNode *NetworkNode) CreateInitialNodeRecord(ipNetwork byte) {
+// CreateInitialNodeRecord initializes NodeRecord structure without IP address,
+// only reported addresses of ipNetwork are applied later. SeqID is incremented
+// from SeqID of last record saved in local storage
+func (netNode *NetworkNode) CreateInitialNodeRecord(ipNetwork byte, port uint16) {
+	seqID, err := netNode.loadSeqID()
+	if err != nil {
+		log.Printf("Failed to load node record SeqID: %v", err)
+	}
+	seqID++
+	if err = netNode.saveSeqID(seqID); err != nil {
+		log.Printf("Failed to save node record SeqID: %v", err)
+	}
+
 	netNode.nodeRecordLock.Lock()
 
-	netNode.nodeRecord = new(protocol.NodeRecord)
-	netNode.nodeRecord.Port = 0
-	netNode.nodeRecord.SeqID = 0
-	netNode.nodeRecord.NodeID = netNode.nodeAddress
-	netNode.nodeRecord.Network = ipNetwork
-	netNode.nodeRecord.IPAddress = bytes.Repeat([]byte{0x00}, int(ipNetwork))
-	netNode.nodeRecord.PublicKey = netNode.nodePubKey
+	netNode.ipNetwork = ipNetwork
+	netNode.nodeRecord = &protocol.NodeRecord{
+		PublicKey:    netNode.nodePubKey,
+		SeqID:        seqID,
+		NodeID:       netNode.nodeAddress,
+		QUICPort:     port,
+		UDPPort:      port,
+		Capabilities: []string{NodeCapability},
+	}
 
 	netNode.nodeRecordLock.Unlock()
 
@@ -94,7 +177,11 @@ func (netNode *NetworkNode) SealNodeRecord() {
 	netNode.nodeRecordLock.Lock()
 	defer netNode.nodeRecordLock.Unlock()
 
-	netNode.sealedNodeRecord.NodeRecord = netNode.nodeRecord.ToBytes()
-	hash := sha3.Sum256(netNode.sealedNodeRecord.NodeRecord)
-	netNode.sealedNodeRecord.Signature = ed25519.Sign(netNode.nodePrivKey, hash[:])
+	sealedNodeRecord, err := netNode.nodeRecord.Seal(netNode.nodePrivKey)
+	if err != nil {
+		log.Printf("Failed to seal node record: %v", err)
+		return
+	}
+	netNode.sealedNodeRecord.NodeRecord = sealedNodeRecord.NodeRecord
+	netNode.sealedNodeRecord.Signature = sealedNodeRecord.Signature
 }
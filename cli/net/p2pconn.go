@@ -1,12 +1,30 @@
 package net
 
 import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
 	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
 )
 
 // CurrentProtocolVersion is current version of protocol sent to remote
 const CurrentProtocolVersion = 1
 
+// NetworkID is ID of Buuzcoin network sent to remote, testnet v1 has ID 1
+const NetworkID = 1
+
+// ALPNProtocolName is name of application protocol used in TLS handshake
+const ALPNProtocolName = "bzc-blockchain"
+
+// NodeCapability is capability of blockchain protocol advertised in node record
+const NodeCapability = "bzc/1"
+
 /*
 	Initialization stage:
 	1. Send HelloMessage to remote
@@ -14,10 +32,233 @@ const CurrentProtocolVersion = 1
 	3. Send Ping message (for node with less ID)
 	4. Send Pong message (node with greater ID)
 	5. Add nodes to routing table
+
+	Outgoing side sends HelloMessage first. If remote is incompatible,
+	Disconnect message with reason code is sent and connection is closed.
+	Outgoing side sets temporary flag of HelloMessage if connection is used
+	for single discovery request, such connection isn't registered as peer.
+	HelloMessages advertise supported compression algorithms, first algorithm of local list
+	supported by remote is used to compress large messages sent after HelloMessage.
+	Initialization stage messages are sent over control stream. Remote may send messages
+	of other streams as soon as it completed initialization stage, they are deferred
+	and returned by ReadMessage after local side completed it too.
 */
 
-// startPeerConnection perform initialization stage with remote peer
-func (conn *Connection) startPeerConnection() {
+// maxDeferredMessages is maximal count of messages deferred during initialization stage
+const maxDeferredMessages = 64
+
+// deferredMessage is message of non-control stream received during initialization stage
+type deferredMessage struct {
+	id      byte
+	payload []byte
+}
+
+// DisconnectError is returned if connection was closed during initialization stage
+type DisconnectError struct {
+	Reason uint32
+	// Remote is true if Disconnect message was received from remote
+	Remote bool
+}
+
+func (err *DisconnectError) Error() string {
+	if err.Remote {
+		return fmt.Sprintf("net: disconnected by remote: %s", protocol.DisconnectReasonString(err.Reason))
+	}
+	return fmt.Sprintf("net: disconnected: %s", protocol.DisconnectReasonString(err.Reason))
+}
+
+// Connect dials node on specific address, performs initialization stage and registers it as peer
+func (netNode *NetworkNode) Connect(address string) (*Connection, error) {
+	connection, err := netNode.Peers.Connect(address, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Connect failed")
+	}
+	return connection, nil
+}
+
+// disconnect sends Disconnect message to remote and closes connection
+func (connection *Connection) disconnect(reason uint32) error {
+	connection.Send(protocol.MessageDisconnect, &protocol.Disconnect{Reason: reason})
+	connection.Close()
+	return &DisconnectError{Reason: reason}
+}
+
+// expectMessage reads message with specific ID, Disconnect message is handled.
+// Messages of other streams are deferred
+func (connection *Connection) expectMessage(expectedID byte, message proto.Message) error {
+	messageID, payload := connection.ReadMessage()
+	for payload != nil && protocol.MessageStream(messageID) != protocol.StreamControl {
+		if len(connection.deferred) >= maxDeferredMessages {
+			return connection.disconnect(protocol.DisconnectProtocolError)
+		}
+		connection.deferred = append(connection.deferred, deferredMessage{messageID, payload})
+		messageID, payload = connection.ReadMessage()
+	}
+	if payload == nil {
+		return errors.New("expectMessage: connection closed")
+	}
+
+	if messageID == protocol.MessageDisconnect {
+		disconnect := new(protocol.Disconnect)
+		if err := proto.Unmarshal(payload, disconnect); err != nil {
+			connection.Close()
+			return &DisconnectError{Reason: protocol.DisconnectProtocolError}
+		}
+		connection.Close()
+		return &DisconnectError{Reason: disconnect.Reason, Remote: true}
+	}
+	if messageID != expectedID {
+		return connection.disconnect(protocol.DisconnectProtocolError)
+	}
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return connection.disconnect(protocol.DisconnectProtocolError)
+	}
+	return nil
+}
+
+func (netNode *NetworkNode) createHelloMessage(temporary bool) *protocol.HelloMessage {
 	helloMessage := new(protocol.HelloMessage)
-	helloMessage.ProtoVersion = 1
+	helloMessage.ProtoVersion = CurrentProtocolVersion
+	helloMessage.NetworkID = NetworkID
+	helloMessage.Temporary = temporary
+	helloMessage.Compression = conn.SupportedCompression
+
+	genesisBlock := chain.BlockchainDispatcher.GetGenesisBlock()
+	chainState := chain.BlockchainDispatcher.GetBlockchainState()
+	helloMessage.GenesisHash = genesisBlock.CalculateHash()
+	helloMessage.LastBlockHash = chainState.LastBlockHash
+	helloMessage.LastBlockIndex = chainState.LastBlockIndex
+
+	netNode.nodeRecordLock.RLock()
+	helloMessage.SealedNodeRecord = &protocol.SealedNodeRecord{
+		NodeRecord: netNode.sealedNodeRecord.NodeRecord,
+		Signature:  netNode.sealedNodeRecord.Signature,
+	}
+	netNode.nodeRecordLock.RUnlock()
+	return helloMessage
+}
+
+// checkHelloMessage verifies remote's HelloMessage and returns remote's node record
+func (connection *Connection) checkHelloMessage(local, remote *protocol.HelloMessage) (*protocol.NodeRecord, error) {
+	if remote.ProtoVersion != CurrentProtocolVersion {
+		return nil, connection.disconnect(protocol.DisconnectIncompatibleVersion)
+	}
+	if remote.NetworkID != local.NetworkID {
+		return nil, connection.disconnect(protocol.DisconnectNetworkMismatch)
+	}
+	if bytes.Compare(remote.GenesisHash, local.GenesisHash) != 0 {
+		return nil, connection.disconnect(protocol.DisconnectGenesisMismatch)
+	}
+
+	if remote.SealedNodeRecord == nil {
+		return nil, connection.disconnect(protocol.DisconnectInvalidNodeRecord)
+	}
+	nodeRecord := remote.SealedNodeRecord.Unseal()
+	if nodeRecord == nil {
+		return nil, connection.disconnect(protocol.DisconnectInvalidNodeRecord)
+	}
+	// Node record should be signed by key of certificate presented in TLS handshake,
+	// remote without Ed25519 certificate can't prove it owns node record
+	if connection.RemotePublicKey == nil || bytes.Compare(connection.RemotePublicKey, nodeRecord.PublicKey) != 0 {
+		return nil, connection.disconnect(protocol.DisconnectInvalidNodeRecord)
+	}
+	if bytes.Compare(nodeRecord.NodeID, connection.netNode.nodeAddress) == 0 {
+		return nil, connection.disconnect(protocol.DisconnectSelf)
+	}
+
+	connection.RemoteID = nodeRecord.NodeID
+	return nodeRecord, nil
+}
+
+// sendPing sends Ping to remote, verifies received Pong and measures round-trip time
+func (connection *Connection) sendPing(remoteRecord *protocol.NodeRecord) error {
+	ping, err := protocol.NewPing(connection.netNode.nodeAddress, remoteRecord.NodeID)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	if err = connection.Send(protocol.MessagePing, ping); err != nil {
+		return err
+	}
+
+	pong := new(protocol.Pong)
+	if err = connection.expectMessage(protocol.MessagePong, pong); err != nil {
+		return err
+	}
+	connection.setRTT(time.Since(startTime))
+	if err = connection.checkPong(pong, ping); err != nil {
+		return connection.disconnect(protocol.DisconnectProtocolError)
+	}
+	return nil
+}
+
+// sendPong waits for Ping from remote and responds with Pong
+func (connection *Connection) sendPong(remoteRecord *protocol.NodeRecord) error {
+	ping := new(protocol.Ping)
+	if err := connection.expectMessage(protocol.MessagePing, ping); err != nil {
+		return err
+	}
+	if err := protocol.VerifyPing(ping, connection.netNode.nodeAddress, remoteRecord.NodeID); err != nil {
+		return connection.disconnect(protocol.DisconnectProtocolError)
+	}
+	return connection.Send(protocol.MessagePong, connection.createPong(ping))
+}
+
+// startPeerConnection perform initialization stage with remote peer.
+// outgoing specifies whether if connection was initiated by local node,
+// temporary outgoing connection is used for single discovery request
+func (connection *Connection) startPeerConnection(outgoing, temporary bool) error {
+	netNode := connection.netNode
+	connection.Outgoing = outgoing
+	helloMessage := netNode.createHelloMessage(temporary)
+
+	remoteHello := new(protocol.HelloMessage)
+	if outgoing {
+		if err := connection.Send(protocol.MessageHello, helloMessage); err != nil {
+			return err
+		}
+		if err := connection.expectMessage(protocol.MessageHello, remoteHello); err != nil {
+			return err
+		}
+	} else {
+		if err := connection.expectMessage(protocol.MessageHello, remoteHello); err != nil {
+			return err
+		}
+		if err := connection.Send(protocol.MessageHello, helloMessage); err != nil {
+			return err
+		}
+	}
+
+	remoteRecord, err := connection.checkHelloMessage(helloMessage, remoteHello)
+	if err != nil {
+		return err
+	}
+	if algorithm := conn.NegotiateCompression(conn.SupportedCompression, remoteHello.Compression); len(algorithm) > 0 {
+		connection.EnableCompression(algorithm)
+	}
+
+	if bytes.Compare(netNode.nodeAddress, remoteRecord.NodeID) < 0 {
+		err = connection.sendPing(remoteRecord)
+	} else {
+		err = connection.sendPong(remoteRecord)
+	}
+	if err != nil {
+		return err
+	}
+
+	connection.RemoteHello = remoteHello
+	connection.RemoteRecord = remoteRecord
+	lruNode, err := netNode.routingTable.Insert(remoteRecord)
+	if err != nil {
+		log.Printf("Node %x wasn't added to routing table: %v", remoteRecord.NodeID, err)
+	}
+	if lruNode != nil {
+		go netNode.checkNode(lruNode)
+	}
+	if err = netNode.localStorage.NodeSeen(remoteRecord); err != nil {
+		log.Printf("Failed to save node %x: %v", remoteRecord.NodeID, err)
+	}
+
+	log.Printf("Connected to node %x, last block index: %d", remoteRecord.NodeID, remoteHello.LastBlockIndex)
+	return nil
 }
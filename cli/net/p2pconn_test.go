@@ -0,0 +1,131 @@
+package net
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+)
+
+func TestCheckHelloMessage(t *testing.T) {
+	/*
+		1. HelloMessage of compatible remote with record sealed by key of its certificate is accepted
+		2. Incompatible protocol version, network ID and genesis hash are rejected with their reasons
+		3. Missing, forged, foreign record or remote without certificate key are rejected as invalid record
+		4. Record with node ID of local node is rejected as self
+	*/
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	defer os.RemoveAll(path)
+	netNode, closeNode := initTestNode(t, path)
+	defer closeNode()
+
+	generateKey := func() (ed25519.PublicKey, ed25519.PrivateKey) {
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+		}
+		return pubKey, privKey
+	}
+	memNet := conn.NewMemoryNetwork(1)
+	newTransport := func(address string, pubKey ed25519.PublicKey, privKey ed25519.PrivateKey) *conn.MemoryTransport {
+		cert, err := netNode.GenerateCertificate(pubKey, privKey)
+		if err != nil {
+			t.Fatalf("GenerateCertificate failed: %+v", err)
+		}
+		transport, err := memNet.NewTransport(address, *cert)
+		if err != nil {
+			t.Fatalf("NewTransport failed: %+v", err)
+		}
+		return transport
+	}
+	remoteKey, remotePrivKey := generateKey()
+	localTransport := newTransport("local", netNode.nodePubKey, netNode.nodePrivKey)
+	remoteTransport := newTransport("remote", remoteKey, remotePrivKey)
+	selfTransport := newTransport("self", netNode.nodePubKey, netNode.nodePrivKey)
+	defer localTransport.Close()
+	defer remoteTransport.Close()
+	defer selfTransport.Close()
+
+	sealRecord := func(pubKey ed25519.PublicKey, privKey ed25519.PrivateKey) *protocol.SealedNodeRecord {
+		nodeRecord := protocol.NodeRecord{
+			PublicKey:    pubKey,
+			SeqID:        1,
+			QUICPort:     7000,
+			UDPPort:      7000,
+			Capabilities: []string{NodeCapability},
+		}
+		sealedRecord, err := nodeRecord.Seal(privKey)
+		if err != nil {
+			t.Fatalf("Seal failed: %+v", err)
+		}
+		return sealedRecord
+	}
+	otherKey, otherPrivKey := generateKey()
+	remoteRecord := sealRecord(remoteKey, remotePrivKey)
+	forgedRecord := sealRecord(remoteKey, remotePrivKey)
+	forgedRecord.Signature[0] ^= 0xff
+
+	local := &protocol.HelloMessage{ProtoVersion: CurrentProtocolVersion, NetworkID: NetworkID, GenesisHash: []byte{1}}
+	newHello := func(sealedRecord *protocol.SealedNodeRecord) *protocol.HelloMessage {
+		return &protocol.HelloMessage{
+			ProtoVersion:     CurrentProtocolVersion,
+			NetworkID:        NetworkID,
+			GenesisHash:      []byte{1},
+			SealedNodeRecord: sealedRecord,
+		}
+	}
+	tests := []struct {
+		name          string
+		remote        *protocol.HelloMessage
+		address       string
+		noCertificate bool
+		reason        uint32
+	}{
+		{"valid", newHello(remoteRecord), "remote", false, 0},
+		{"protocol version", &protocol.HelloMessage{ProtoVersion: CurrentProtocolVersion + 1, NetworkID: NetworkID,
+			GenesisHash: []byte{1}, SealedNodeRecord: remoteRecord}, "remote", false, protocol.DisconnectIncompatibleVersion},
+		{"network ID", &protocol.HelloMessage{ProtoVersion: CurrentProtocolVersion, NetworkID: NetworkID + 1,
+			GenesisHash: []byte{1}, SealedNodeRecord: remoteRecord}, "remote", false, protocol.DisconnectNetworkMismatch},
+		{"genesis hash", &protocol.HelloMessage{ProtoVersion: CurrentProtocolVersion, NetworkID: NetworkID,
+			GenesisHash: []byte{2}, SealedNodeRecord: remoteRecord}, "remote", false, protocol.DisconnectGenesisMismatch},
+		{"missing record", newHello(nil), "remote", false, protocol.DisconnectInvalidNodeRecord},
+		{"forged record", newHello(forgedRecord), "remote", false, protocol.DisconnectInvalidNodeRecord},
+		{"foreign record", newHello(sealRecord(otherKey, otherPrivKey)), "remote", false, protocol.DisconnectInvalidNodeRecord},
+		{"no certificate key", newHello(remoteRecord), "remote", true, protocol.DisconnectInvalidNodeRecord},
+		{"self", newHello(netNode.sealedNodeRecord), "self", false, protocol.DisconnectSelf},
+	}
+	for _, test := range tests {
+		localPeer, err := localTransport.Dial(test.address, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %+v", err)
+		}
+		var remotePeer *conn.Peer
+		if test.address == "self" {
+			remotePeer = <-selfTransport.Incoming()
+		} else {
+			remotePeer = <-remoteTransport.Incoming()
+		}
+		connection := netNode.newConnection(localPeer)
+		if test.noCertificate {
+			connection.RemotePublicKey = nil
+		}
+
+		nodeRecord, err := connection.checkHelloMessage(local, test.remote)
+		if test.reason == 0 {
+			if err != nil || nodeRecord == nil || string(connection.RemoteID) != string(nodeRecord.NodeID) {
+				t.Errorf("%s: HelloMessage wasn't accepted: %+v", test.name, err)
+			}
+		} else if disconnectErr, ok := err.(*DisconnectError); !ok || disconnectErr.Reason != test.reason {
+			t.Errorf("%s: unexpected error: %+v", test.name, err)
+		}
+		localPeer.Close()
+		remotePeer.Close()
+	}
+}
@@ -0,0 +1,368 @@
+package net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+)
+
+/*
+	Peer manager:
+	Every established connection is registered in peer manager, only one connection
+	with each node is kept. If nodes dialed each other simultaneously, connection
+	initiated by node with lower ID is kept by both sides.
+	MaxPeers connections are allowed: TargetOutbound slots are used for outbound peers,
+	other slots are used for inbound peers. While outbound slots are free, candidates
+	from discovery and nodes of routing table are dialed.
+	Static peers are dialed on start and reconnected with exponential backoff.
+	Static and trusted peers don't use peer slots.
+	Temporary connections used for discovery requests aren't registered.
+	Banned nodes aren't dialed or registered, bans are described in reputation.go.
+*/
+
+const (
+	// DefaultMaxPeers is default maximal count of connected peers
+	DefaultMaxPeers = 50
+	// DefaultTargetOutbound is default count of outbound peers
+	DefaultTargetOutbound = 10
+	// MaxPendingDials is maximal count of simultaneous outbound dials
+	MaxPendingDials = 8
+	// MinReconnectBackoff is delay before first reconnection to static peer
+	MinReconnectBackoff = 5 * time.Second
+	// MaxReconnectBackoff is maximal delay between reconnections to static peer
+	MaxReconnectBackoff = 5 * time.Minute
+)
+
+// peerManagerInterval is interval between checks of peer slots and static peers
+const peerManagerInterval = 5 * time.Second
+
+// ErrNotAdmitted is returned by Connect if remote wasn't registered as peer
+var ErrNotAdmitted = errors.New("net: peer wasn't admitted")
+
+// staticPeer is state of connection to static peer
+type staticPeer struct {
+	connection *Connection
+	backoff    time.Duration
+	nextDial   time.Time
+}
+
+// PeerManager keeps set of connected peers and dials new peers
+type PeerManager struct {
+	netNode        *NetworkNode
+	maxPeers       int
+	targetOutbound int
+
+	peers       map[string]*Connection
+	trusted     map[string]bool
+	staticPeers map[string]*staticPeer
+	dialing     map[string]bool
+	bannedNodes map[string]*db.Ban
+	bannedIPs   map[string]*db.Ban
+	lock        *sync.Mutex
+}
+
+// NewPeerManager creates peer manager of network node. staticPeers are addresses of
+// always connected nodes, trustedPeers are IDs of nodes which don't use peer slots
+func (netNode *NetworkNode) NewPeerManager(maxPeers, targetOutbound int, staticPeers []string, trustedPeers [][]byte) *PeerManager {
+	if maxPeers <= 0 {
+		maxPeers = DefaultMaxPeers
+	}
+	if targetOutbound <= 0 {
+		targetOutbound = DefaultTargetOutbound
+	}
+	if targetOutbound > maxPeers {
+		targetOutbound = maxPeers
+	}
+
+	manager := &PeerManager{
+		netNode:        netNode,
+		maxPeers:       maxPeers,
+		targetOutbound: targetOutbound,
+
+		peers:       make(map[string]*Connection),
+		trusted:     make(map[string]bool),
+		staticPeers: make(map[string]*staticPeer),
+		dialing:     make(map[string]bool),
+		bannedNodes: make(map[string]*db.Ban),
+		bannedIPs:   make(map[string]*db.Ban),
+		lock:        &sync.Mutex{},
+	}
+	for _, nodeID := range trustedPeers {
+		manager.trusted[string(nodeID)] = true
+	}
+	for _, address := range staticPeers {
+		manager.staticPeers[address] = &staticPeer{backoff: MinReconnectBackoff}
+	}
+	return manager
+}
+
+// initiatorID returns ID of node which initiated connection
+func initiatorID(localID []byte, connection *Connection) []byte {
+	if connection.Outgoing {
+		return localID
+	}
+	return connection.RemoteID
+}
+
+// keepExisting decides which of two connections with same node is kept:
+// connection initiated by node with lower ID is preferred, otherwise newer connection is kept
+func keepExisting(localID []byte, existing, connection *Connection) bool {
+	existingInitiator, initiator := initiatorID(localID, existing), initiatorID(localID, connection)
+	if bytes.Compare(existingInitiator, initiator) == 0 {
+		return false
+	}
+	return bytes.Compare(existingInitiator, initiator) < 0
+}
+
+// exempt checks whether connection doesn't use peer slots, manager.lock must be held
+func (manager *PeerManager) exempt(connection *Connection) bool {
+	return connection.static || manager.trusted[string(connection.RemoteID)]
+}
+
+// counts returns counts of inbound and outbound peers using peer slots, manager.lock must be held
+func (manager *PeerManager) counts() (inbound, outbound int) {
+	for _, peer := range manager.peers {
+		if manager.exempt(peer) {
+			continue
+		}
+		if peer.Outgoing {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+	return
+}
+
+// add registers connection as peer. If connection isn't admitted, disconnect reason is returned.
+// Replaced duplicate connection is closed
+func (manager *PeerManager) add(connection *Connection) (uint32, bool) {
+	manager.lock.Lock()
+
+	if manager.isBanned(connection.RemoteID, connection.remoteIP) {
+		manager.lock.Unlock()
+		return protocol.DisconnectBanned, false
+	}
+	nodeID := string(connection.RemoteID)
+	existing := manager.peers[nodeID]
+	if existing != nil && keepExisting(manager.netNode.nodeAddress, existing, connection) {
+		manager.lock.Unlock()
+		return protocol.DisconnectDuplicate, false
+	}
+	if existing == nil && !manager.exempt(connection) {
+		inbound, outbound := manager.counts()
+		if inbound+outbound >= manager.maxPeers ||
+			!connection.Outgoing && inbound >= manager.maxPeers-manager.targetOutbound {
+			manager.lock.Unlock()
+			return protocol.DisconnectTooManyPeers, false
+		}
+	}
+	if connection.static {
+		manager.trusted[nodeID] = true
+	}
+	manager.peers[nodeID] = connection
+	manager.lock.Unlock()
+
+	if existing != nil {
+		existing.disconnect(protocol.DisconnectDuplicate)
+	}
+	return 0, true
+}
+
+// remove unregisters closed connection
+func (manager *PeerManager) remove(connection *Connection) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.peers[string(connection.RemoteID)] == connection {
+		delete(manager.peers, string(connection.RemoteID))
+	}
+}
+
+// Peers returns connected peers
+func (manager *PeerManager) Peers() []*Connection {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	peers := make([]*Connection, 0, len(manager.peers))
+	for _, peer := range manager.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Peer returns connection with node, nil is returned if node isn't connected
+func (manager *PeerManager) Peer(nodeID []byte) *Connection {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	return manager.peers[string(nodeID)]
+}
+
+// PeerCount returns counts of connected inbound and outbound peers
+func (manager *PeerManager) PeerCount() (inbound, outbound int) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	for _, peer := range manager.peers {
+		if peer.Outgoing {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+	return
+}
+
+// Connect dials node on address, performs initialization stage and registers connection as peer
+func (manager *PeerManager) Connect(address string, static bool) (*Connection, error) {
+	return manager.connect(address, nil, static)
+}
+
+// connect dials node on address and registers connection as peer. Node ID is verified if it isn't nil
+func (manager *PeerManager) connect(address string, nodeID []byte, static bool) (*Connection, error) {
+	netNode := manager.netNode
+	peer, err := netNode.dial(address, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := netNode.newConnection(peer)
+	connection.static = static
+	if err = connection.startPeerConnection(true, false); err != nil {
+		connection.Close()
+		return nil, err
+	}
+	if reason, ok := manager.add(connection); !ok {
+		connection.disconnect(reason)
+		return nil, ErrNotAdmitted
+	}
+	go netNode.serve(connection)
+	return connection, nil
+}
+
+// startDial marks address as being dialed, returns false if address is already dialed or limit is reached
+func (manager *PeerManager) startDial(address string) bool {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.dialing[address] || len(manager.dialing) >= MaxPendingDials {
+		return false
+	}
+	manager.dialing[address] = true
+	return true
+}
+
+func (manager *PeerManager) finishDial(address string) {
+	manager.lock.Lock()
+	delete(manager.dialing, address)
+	manager.lock.Unlock()
+}
+
+// freeOutbound returns count of free outbound slots excluding pending dials
+func (manager *PeerManager) freeOutbound() int {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	_, outbound := manager.counts()
+	return manager.targetOutbound - outbound - len(manager.dialing)
+}
+
+// dialCandidate connects to discovered node if outbound slots are free
+func (manager *PeerManager) dialCandidate(nodeRecord *protocol.NodeRecord) {
+	if manager.freeOutbound() <= 0 || manager.Peer(nodeRecord.NodeID) != nil ||
+		nodeRecord.IP() == nil || nodeRecord.QUICPort == 0 || manager.IsBanned(nodeRecord.NodeID, nodeRecord.IP()) {
+		return
+	}
+	address := nodeRecord.Address()
+	if !manager.startDial(address) {
+		return
+	}
+
+	go func() {
+		defer manager.finishDial(address)
+		if _, err := manager.connect(address, nodeRecord.NodeID, false); err != nil && err != ErrNotAdmitted {
+			log.Printf("Failed to connect to node %x: %v", nodeRecord.NodeID, err)
+			manager.netNode.nodeFailed(nodeRecord)
+		}
+	}()
+}
+
+// dialStaticPeers connects to static peers which aren't connected, failed dials are retried with backoff
+func (manager *PeerManager) dialStaticPeers() {
+	now := time.Now()
+	var addresses []string
+	manager.lock.Lock()
+	for address, static := range manager.staticPeers {
+		if static.connection != nil {
+			select {
+			case <-static.connection.Done():
+				static.connection = nil
+			default:
+				continue
+			}
+		}
+		if now.Before(static.nextDial) || manager.dialing[address] {
+			continue
+		}
+		manager.dialing[address] = true
+		addresses = append(addresses, address)
+	}
+	manager.lock.Unlock()
+
+	for _, address := range addresses {
+		go func(address string) {
+			defer manager.finishDial(address)
+			connection, err := manager.Connect(address, true)
+
+			manager.lock.Lock()
+			defer manager.lock.Unlock()
+			static := manager.staticPeers[address]
+			if err != nil {
+				log.Printf("Failed to connect to static peer %s, retrying in %s: %v", address, static.backoff, err)
+				static.nextDial = time.Now().Add(static.backoff)
+				if static.backoff *= 2; static.backoff > MaxReconnectBackoff {
+					static.backoff = MaxReconnectBackoff
+				}
+				return
+			}
+			static.connection = connection
+			static.backoff = MinReconnectBackoff
+		}(address)
+	}
+}
+
+// fillOutbound dials nodes of routing table close to random target if outbound slots are free
+func (manager *PeerManager) fillOutbound() {
+	if manager.freeOutbound() <= 0 {
+		return
+	}
+	target := make([]byte, network.AddressSize)
+	if _, err := rand.Read(target); err != nil {
+		return
+	}
+	for _, nodeRecord := range manager.netNode.routingTable.LookupNearestNodes(target) {
+		manager.dialCandidate(nodeRecord)
+	}
+}
+
+// Run dials static peers and discovered nodes until node is closed
+func (manager *PeerManager) Run(candidates <-chan *protocol.NodeRecord) {
+	manager.dialStaticPeers()
+
+	ticker := time.NewTicker(peerManagerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-manager.netNode.done:
+			return
+		case nodeRecord := <-candidates:
+			manager.dialCandidate(nodeRecord)
+		case <-ticker.C:
+			manager.dialStaticPeers()
+			manager.fillOutbound()
+		}
+	}
+}
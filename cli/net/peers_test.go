@@ -0,0 +1,78 @@
+package net
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+)
+
+func createTestPeer(id byte, outgoing bool) *Connection {
+	return &Connection{Peer: &conn.Peer{RemoteID: bytes.Repeat([]byte{id}, 20)}, Outgoing: outgoing}
+}
+
+func TestKeepExisting(t *testing.T) {
+	lowID, highID := bytes.Repeat([]byte{0x01}, 20), bytes.Repeat([]byte{0x02}, 20)
+	outgoing := &Connection{Peer: &conn.Peer{RemoteID: highID}, Outgoing: true}
+	incoming := &Connection{Peer: &conn.Peer{RemoteID: highID}}
+
+	// Local node has lower ID, so its outgoing connection is kept on both sides
+	if !keepExisting(lowID, outgoing, incoming) || keepExisting(lowID, incoming, outgoing) {
+		t.Error("Connection initiated by lower ID wasn't kept")
+	}
+	outgoing.RemoteID, incoming.RemoteID = lowID, lowID
+	if keepExisting(highID, outgoing, incoming) || !keepExisting(highID, incoming, outgoing) {
+		t.Error("Connection initiated by lower ID wasn't kept by node with higher ID")
+	}
+	// Reconnection of same initiator replaces existing connection
+	if keepExisting(highID, incoming, &Connection{Peer: &conn.Peer{RemoteID: lowID}}) {
+		t.Error("Existing connection wasn't replaced by reconnection")
+	}
+}
+
+func TestPeerSlots(t *testing.T) {
+	/*
+		1. MaxPeers is 4, TargetOutbound is 1, so 3 inbound peers are accepted
+		2. Outbound peer is accepted, then all slots are used
+		3. Trusted peer is accepted regardless of slots
+		4. Duplicate connection is rejected, removed peer frees slot
+	*/
+	netNode := &NetworkNode{nodeAddress: bytes.Repeat([]byte{0x00}, 20)}
+	manager := netNode.NewPeerManager(4, 1, nil, [][]byte{bytes.Repeat([]byte{0xFF}, 20)})
+
+	for i := byte(1); i <= 4; i++ {
+		reason, ok := manager.add(createTestPeer(i, false))
+		if i <= 3 && !ok {
+			t.Fatalf("Inbound peer %d wasn't accepted: %d", i, reason)
+		}
+		if i == 4 && (ok || reason != protocol.DisconnectTooManyPeers) {
+			t.Fatalf("Inbound peer was accepted without free slots")
+		}
+	}
+	if _, ok := manager.add(createTestPeer(5, true)); !ok {
+		t.Fatal("Outbound peer wasn't accepted")
+	}
+	if _, ok := manager.add(createTestPeer(6, true)); ok {
+		t.Fatal("Outbound peer was accepted without free slots")
+	}
+	if _, ok := manager.add(createTestPeer(0xFF, false)); !ok {
+		t.Fatal("Trusted peer wasn't accepted")
+	}
+	if inbound, outbound := manager.PeerCount(); inbound != 4 || outbound != 1 {
+		t.Fatalf("Unexpected peer count: %d/%d", inbound, outbound)
+	}
+
+	// Local node has lowest ID, its outgoing connection is kept
+	if reason, ok := manager.add(createTestPeer(5, false)); ok || reason != protocol.DisconnectDuplicate {
+		t.Errorf("Duplicate connection was accepted: %d", reason)
+	}
+
+	manager.remove(manager.Peer(bytes.Repeat([]byte{0x01}, 20)))
+	if _, ok := manager.add(createTestPeer(7, false)); !ok {
+		t.Error("Inbound peer wasn't accepted after peer was removed")
+	}
+	if len(manager.Peers()) != 5 {
+		t.Errorf("Unexpected peers count: %d", len(manager.Peers()))
+	}
+}
@@ -0,0 +1,233 @@
+package net
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Liveness:
+	Ping/Pong messages are exchanged during initialization stage and may be sent
+	over established connection at any time. Ping messages of established connection
+	are answered by connection reader, Pong messages are matched with sent Ping by nonce.
+	Round-trip time of last exchange is kept in connection.
+	Registered peers are pinged every KeepAliveInterval, peer which doesn't respond
+	is disconnected. Transport limits and timeouts are set by peerConfig.
+*/
+
+// PingTimeout is maximal time of waiting for Pong
+const PingTimeout = 5 * time.Second
+
+// KeepAliveInterval is interval between keep-alive pings of registered peers
+const KeepAliveInterval = 30 * time.Second
+
+// peerConfig is transport configuration of connections, message sizes are limited by protocol.MessageSizeLimits
+var peerConfig = func() *conn.PeerConfig {
+	config := conn.DefaultPeerConfig
+	config.MessageSizeLimits = protocol.MessageSizeLimits
+	return &config
+}()
+
+var (
+	// ErrPingTimeout is returned if remote node doesn't respond to Ping in time
+	ErrPingTimeout = errors.New("net: ping timed out")
+	// ErrConnectionClosed is returned if connection was closed while waiting for response
+	ErrConnectionClosed = errors.New("net: connection closed")
+)
+
+// newConnection creates connection of network node with remote peer
+func (netNode *NetworkNode) newConnection(peer *conn.Peer) *Connection {
+	peer.StreamOf = protocol.MessageStream
+	peer.Config = peerConfig
+	return &Connection{
+		Peer:      peer,
+		netNode:   netNode,
+		remoteIP:  remoteIP(peer.RemoteAddr()),
+		pings:     make(map[uint64]chan *protocol.Pong),
+		pingsLock: &sync.Mutex{},
+
+		knownBlocks: newKnownHashes(MaxKnownBlocks),
+		knownTxs:    newKnownHashes(MaxKnownTxs),
+	}
+}
+
+// ReadMessage reads message from remote. After initialization stage messages deferred during it
+// are returned first, Ping messages are answered and Pong messages are passed to waiting Ping calls,
+// they aren't returned. nil is returned if connection was closed
+func (connection *Connection) ReadMessage() (byte, []byte) {
+	if len(connection.deferred) > 0 && connection.RemoteRecord != nil {
+		deferred := connection.deferred[0]
+		connection.deferred = connection.deferred[1:]
+		return deferred.id, deferred.payload
+	}
+	for {
+		messageID, payload := connection.Peer.ReadMessage()
+		if payload == nil || connection.RemoteRecord == nil {
+			return messageID, payload
+		}
+
+		var err error
+		switch messageID {
+		case protocol.MessagePing:
+			err = connection.handlePing(payload)
+		case protocol.MessagePong:
+			err = connection.handlePong(payload)
+		default:
+			return messageID, payload
+		}
+		if err != nil {
+			log.Printf("Invalid liveness message from %x: %v", connection.RemoteID, err)
+			connection.disconnect(protocol.DisconnectProtocolError)
+			return 0, nil
+		}
+	}
+}
+
+// createPong creates signed Pong responding to ping
+func (connection *Connection) createPong(ping *protocol.Ping) *protocol.Pong {
+	netNode := connection.netNode
+	netNode.nodeRecordLock.RLock()
+	sealedNodeRecord := &protocol.SealedNodeRecord{
+		NodeRecord: netNode.sealedNodeRecord.NodeRecord,
+		Signature:  netNode.sealedNodeRecord.Signature,
+	}
+	netNode.nodeRecordLock.RUnlock()
+	return protocol.NewPong(ping, sealedNodeRecord, connection.RemoteAddr(), netNode.nodePrivKey)
+}
+
+// handlePing responds to Ping received over established connection
+func (connection *Connection) handlePing(payload []byte) error {
+	ping := new(protocol.Ping)
+	if err := proto.Unmarshal(payload, ping); err != nil {
+		return err
+	}
+	if err := protocol.VerifyPing(ping, connection.netNode.nodeAddress, connection.RemoteRecord.NodeID); err != nil {
+		return err
+	}
+	connection.Send(protocol.MessagePong, connection.createPong(ping))
+	return nil
+}
+
+// handlePong passes Pong to Ping call waiting for it, Pong of timed out Ping is ignored
+func (connection *Connection) handlePong(payload []byte) error {
+	pong := new(protocol.Pong)
+	if err := proto.Unmarshal(payload, pong); err != nil {
+		return err
+	}
+
+	connection.pingsLock.Lock()
+	response, ok := connection.pings[pong.Nonce]
+	delete(connection.pings, pong.Nonce)
+	connection.pingsLock.Unlock()
+
+	if ok {
+		response <- pong
+	}
+	return nil
+}
+
+// Ping sends Ping over established connection, waits for Pong and verifies it.
+// Returns round-trip time, connection is closed if Pong is invalid
+func (connection *Connection) Ping() (time.Duration, error) {
+	netNode := connection.netNode
+	remoteRecord := connection.RemoteRecord
+	if remoteRecord == nil {
+		return 0, errors.New("net: ping before initialization stage")
+	}
+	ping, err := protocol.NewPing(netNode.nodeAddress, remoteRecord.NodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	response := make(chan *protocol.Pong, 1)
+	connection.pingsLock.Lock()
+	connection.pings[ping.Nonce] = response
+	connection.pingsLock.Unlock()
+	defer func() {
+		connection.pingsLock.Lock()
+		delete(connection.pings, ping.Nonce)
+		connection.pingsLock.Unlock()
+	}()
+
+	startTime := time.Now()
+	if err = connection.Send(protocol.MessagePing, ping); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(PingTimeout)
+	defer timer.Stop()
+	select {
+	case pong := <-response:
+		rtt := time.Since(startTime)
+		if err = connection.checkPong(pong, ping); err != nil {
+			connection.disconnect(protocol.DisconnectProtocolError)
+			return 0, err
+		}
+		connection.setRTT(rtt)
+		return rtt, nil
+	case <-timer.C:
+		return 0, ErrPingTimeout
+	case <-connection.Done():
+		return 0, ErrConnectionClosed
+	}
+}
+
+// checkPong verifies Pong responding to ping, reported endpoint and newer node record of remote are applied
+func (connection *Connection) checkPong(pong *protocol.Pong, ping *protocol.Ping) error {
+	netNode := connection.netNode
+	nodeRecord, err := protocol.VerifyPong(pong, ping, connection.RemotePublicKey)
+	if err != nil {
+		return err
+	}
+	if connection.RemoteRecord != nil && nodeRecord.SeqID > connection.RemoteRecord.SeqID {
+		netNode.updateNodeRecord(nodeRecord)
+	}
+	netNode.ReportEndpoint(pong.RecipientAddress, nodeRecord.NodeID)
+	return nil
+}
+
+// RTT returns round-trip time measured by last Ping/Pong exchange
+func (connection *Connection) RTT() time.Duration {
+	connection.pingsLock.Lock()
+	defer connection.pingsLock.Unlock()
+	return connection.rtt
+}
+
+func (connection *Connection) setRTT(rtt time.Duration) {
+	connection.pingsLock.Lock()
+	connection.rtt = rtt
+	connection.pingsLock.Unlock()
+}
+
+// keepAlive pings peer every KeepAliveInterval until connection is closed.
+// Peer which doesn't respond in PingTimeout is disconnected
+func (connection *Connection) keepAlive() {
+	ticker := time.NewTicker(KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-connection.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := connection.Ping()
+		select {
+		case <-connection.Done():
+			return
+		default:
+		}
+		if err != nil {
+			log.Printf("Peer %x doesn't respond to keep-alive ping: %v", connection.RemoteID, err)
+			connection.netNode.Peers.Report(connection, EventTimeout)
+			connection.disconnect(protocol.DisconnectTimeout)
+			return
+		}
+	}
+}
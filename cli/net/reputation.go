@@ -0,0 +1,285 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/cli/db"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/network/validation"
+)
+
+/*
+	Peer reputation:
+	Every peer has score starting at zero, it is adjusted by events reported by message handlers.
+	Invalid blocks, bad signatures, malformed messages and timeouts decrease score, useful data
+	increases it up to MaxPeerScore. When score falls below BanThreshold, peer is disconnected and
+	its node ID and IP address are banned for DefaultBanDuration. Static and trusted peers aren't
+	banned by score, but they may be banned manually.
+	Bans are saved in node DBI, so they are kept across restarts. Incoming connections from banned
+	IP addresses are rejected before initialization stage, banned nodes aren't dialed or registered.
+*/
+
+const (
+	// MaxPeerScore is maximal score of peer
+	MaxPeerScore = 100
+	// BanThreshold is score below which peer is banned
+	BanThreshold = -100
+	// DefaultBanDuration is duration of ban of peer which score fell below BanThreshold
+	DefaultBanDuration = 24 * time.Hour
+)
+
+// PeerEvent is event reported by message handlers which changes score of peer
+type PeerEvent int
+
+// Events changing score of peer
+const (
+	// EventUsefulData is reported when peer sent new block or transaction
+	EventUsefulData PeerEvent = iota + 1
+	// EventTimeout is reported when peer didn't respond to request in time
+	EventTimeout
+	// EventInvalidTransaction is reported when peer sent transaction which was rejected
+	EventInvalidTransaction
+	// EventMalformedMessage is reported when message of peer cannot be decoded
+	EventMalformedMessage
+	// EventBadSignature is reported when peer sent block or transaction with invalid signature
+	EventBadSignature
+	// EventInvalidBlock is reported when peer sent block which failed validation
+	EventInvalidBlock
+)
+
+func (event PeerEvent) String() string {
+	switch event {
+	case EventUsefulData:
+		return "useful data"
+	case EventTimeout:
+		return "timeout"
+	case EventInvalidTransaction:
+		return "invalid transaction"
+	case EventMalformedMessage:
+		return "malformed message"
+	case EventBadSignature:
+		return "bad signature"
+	case EventInvalidBlock:
+		return "invalid block"
+	}
+	return fmt.Sprintf("unknown(%d)", int(event))
+}
+
+// Score returns change of peer score caused by event
+func (event PeerEvent) Score() int {
+	switch event {
+	case EventUsefulData:
+		return 1
+	case EventTimeout:
+		return -10
+	case EventInvalidTransaction:
+		return -20
+	case EventMalformedMessage:
+		return -30
+	case EventBadSignature, EventInvalidBlock:
+		return -50
+	}
+	return 0
+}
+
+// remoteIP returns IP address of remote network address, nil is returned if it cannot be parsed
+func remoteIP(address string) net.IP {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// blockEvent returns event reported for peer which sent block rejected with err.
+// Only validation failures are penalized, unknown parent or storage errors don't change score
+func blockEvent(err error) (PeerEvent, bool) {
+	var stageErr *validation.StageError
+	if !errors.As(err, &stageErr) {
+		return 0, false
+	}
+	if stageErr.Stage == validation.StageConsensus ||
+		stageErr.Stage == validation.StageTransactions && stageErr.Err == validation.ErrMalformedTx {
+		return EventBadSignature, true
+	}
+	return EventInvalidBlock, true
+}
+
+// txEvent returns event reported for peer which sent transaction rejected by mempool with err
+func txEvent(err error) PeerEvent {
+	if err == validation.ErrMalformedTx {
+		return EventBadSignature
+	}
+	return EventInvalidTransaction
+}
+
+// Score returns current score of peer
+func (manager *PeerManager) Score(connection *Connection) int {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	return connection.score
+}
+
+// Report adjusts score of peer by event. If score falls below BanThreshold,
+// node ID and IP address of peer are banned for DefaultBanDuration
+func (manager *PeerManager) Report(connection *Connection, event PeerEvent) {
+	manager.lock.Lock()
+	if connection.score += event.Score(); connection.score > MaxPeerScore {
+		connection.score = MaxPeerScore
+	}
+	score := connection.score
+	ban := score < BanThreshold && !manager.exempt(connection)
+	manager.lock.Unlock()
+
+	if !ban {
+		return
+	}
+	reason := fmt.Sprintf("score %d after %s", score, event)
+	log.Printf("Banning node %x: %s", connection.RemoteID, reason)
+	if err := manager.BanNode(connection.RemoteID, DefaultBanDuration, reason); err != nil {
+		log.Printf("Failed to ban node %x: %v", connection.RemoteID, err)
+	}
+	if connection.remoteIP != nil {
+		if err := manager.BanIP(connection.remoteIP, DefaultBanDuration, reason); err != nil {
+			log.Printf("Failed to ban IP %s: %v", connection.remoteIP, err)
+		}
+	}
+}
+
+// isBanned checks whether node ID or IP address is banned, expired bans are removed.
+// manager.lock must be held
+func (manager *PeerManager) isBanned(nodeID []byte, ip net.IP) bool {
+	now := time.Now()
+	if ban := manager.bannedNodes[string(nodeID)]; nodeID != nil && ban != nil {
+		if !ban.Expired(now) {
+			return true
+		}
+		delete(manager.bannedNodes, string(nodeID))
+	}
+	if ban := manager.bannedIPs[string(ip.To16())]; ip != nil && ban != nil {
+		if !ban.Expired(now) {
+			return true
+		}
+		delete(manager.bannedIPs, string(ip.To16()))
+	}
+	return false
+}
+
+// IsBanned checks whether node ID or IP address is banned, nil values aren't checked
+func (manager *PeerManager) IsBanned(nodeID []byte, ip net.IP) bool {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	return manager.isBanned(nodeID, ip)
+}
+
+// addBan saves ban in local storage and disconnects banned peers
+func (manager *PeerManager) addBan(ban *db.Ban) error {
+	if err := manager.netNode.localStorage.SaveBan(ban); err != nil {
+		return err
+	}
+
+	var banned []*Connection
+	manager.lock.Lock()
+	if ban.NodeID != nil {
+		manager.bannedNodes[string(ban.NodeID)] = ban
+	} else {
+		manager.bannedIPs[string(ban.IP.To16())] = ban
+	}
+	for _, peer := range manager.peers {
+		if manager.isBanned(peer.RemoteID, peer.remoteIP) {
+			banned = append(banned, peer)
+		}
+	}
+	manager.lock.Unlock()
+
+	for _, peer := range banned {
+		peer.disconnect(protocol.DisconnectBanned)
+	}
+	return nil
+}
+
+// BanNode bans node ID for duration, connected node is disconnected
+func (manager *PeerManager) BanNode(nodeID []byte, duration time.Duration, reason string) error {
+	return manager.addBan(&db.Ban{
+		NodeID: append([]byte{}, nodeID...),
+		Until:  time.Now().Add(duration),
+		Reason: reason,
+	})
+}
+
+// BanIP bans IP address for duration, peers connected from this address are disconnected
+func (manager *PeerManager) BanIP(ip net.IP, duration time.Duration, reason string) error {
+	if ip.To16() == nil {
+		return db.ErrInvalidBan
+	}
+	return manager.addBan(&db.Ban{IP: ip.To16(), Until: time.Now().Add(duration), Reason: reason})
+}
+
+// removeBan removes ban from local storage, returns false if node ID or IP address wasn't banned
+func (manager *PeerManager) removeBan(ban *db.Ban) (bool, error) {
+	manager.lock.Lock()
+	if ban.NodeID != nil {
+		delete(manager.bannedNodes, string(ban.NodeID))
+	} else {
+		delete(manager.bannedIPs, string(ban.IP.To16()))
+	}
+	manager.lock.Unlock()
+	return manager.netNode.localStorage.RemoveBan(ban)
+}
+
+// UnbanNode removes ban of node ID, returns false if node wasn't banned
+func (manager *PeerManager) UnbanNode(nodeID []byte) (bool, error) {
+	return manager.removeBan(&db.Ban{NodeID: nodeID})
+}
+
+// UnbanIP removes ban of IP address, returns false if address wasn't banned
+func (manager *PeerManager) UnbanIP(ip net.IP) (bool, error) {
+	if ip.To16() == nil {
+		return false, db.ErrInvalidBan
+	}
+	return manager.removeBan(&db.Ban{IP: ip})
+}
+
+// Bans returns active bans of node IDs and IP addresses
+func (manager *PeerManager) Bans() []*db.Ban {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	now := time.Now()
+	bans := make([]*db.Ban, 0, len(manager.bannedNodes)+len(manager.bannedIPs))
+	for _, ban := range manager.bannedNodes {
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	for _, ban := range manager.bannedIPs {
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// loadBans loads active bans from local storage, expired bans are removed
+func (manager *PeerManager) loadBans() {
+	bans, err := manager.netNode.localStorage.GetBans()
+	if err != nil {
+		log.Printf("Failed to load bans: %v", err)
+		return
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	for _, ban := range bans {
+		if ban.NodeID != nil {
+			manager.bannedNodes[string(ban.NodeID)] = ban
+		} else {
+			manager.bannedIPs[string(ban.IP.To16())] = ban
+		}
+	}
+	log.Printf("Loaded %d bans", len(bans))
+}
@@ -0,0 +1,81 @@
+package net
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+)
+
+func TestPeerReputation(t *testing.T) {
+	/*
+		1. Useful data increases score up to MaxPeerScore
+		2. Peer is banned by node ID and IP when score falls below BanThreshold
+		3. Trusted peer isn't banned by score
+		4. Bans are loaded from local storage, unbanned node is accepted again
+	*/
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	defer os.RemoveAll(path)
+	netNode, cleanup := initTestNode(t, path)
+	defer cleanup()
+
+	trustedID := bytes.Repeat([]byte{0xFF}, 20)
+	manager := netNode.NewPeerManager(0, 0, nil, [][]byte{trustedID})
+	netNode.Peers = manager
+
+	peer := createTestPeer(0x01, false)
+	peer.remoteIP = net.ParseIP("8.8.8.8")
+	for i := 0; i < MaxPeerScore+10; i++ {
+		manager.Report(peer, EventUsefulData)
+	}
+	if score := manager.Score(peer); score != MaxPeerScore {
+		t.Fatalf("Unexpected score: %d", score)
+	}
+
+	for manager.Score(peer) >= BanThreshold {
+		if manager.IsBanned(peer.RemoteID, nil) {
+			t.Fatalf("Peer was banned with score %d", manager.Score(peer))
+		}
+		manager.Report(peer, EventInvalidBlock)
+	}
+	if !manager.IsBanned(peer.RemoteID, nil) || !manager.IsBanned(nil, net.ParseIP("8.8.8.8")) {
+		t.Fatal("Peer wasn't banned after score fell below threshold")
+	}
+	if reason, ok := manager.add(createTestPeer(0x01, false)); ok || reason != protocol.DisconnectBanned {
+		t.Fatal("Banned peer was accepted")
+	}
+	if len(manager.Bans()) != 2 {
+		t.Errorf("Unexpected bans count: %d", len(manager.Bans()))
+	}
+
+	trusted := &Connection{Peer: &conn.Peer{RemoteID: trustedID}}
+	for i := 0; i < 10; i++ {
+		manager.Report(trusted, EventBadSignature)
+	}
+	if manager.IsBanned(trustedID, nil) {
+		t.Error("Trusted peer was banned by score")
+	}
+
+	if err = manager.BanNode(bytes.Repeat([]byte{0x02}, 20), -time.Second, "expired"); err != nil {
+		t.Fatalf("BanNode failed: %+v", err)
+	}
+	restored := netNode.NewPeerManager(0, 0, nil, nil)
+	restored.loadBans()
+	if len(restored.Bans()) != 2 || !restored.IsBanned(peer.RemoteID, nil) {
+		t.Fatalf("Bans weren't restored: %d", len(restored.Bans()))
+	}
+	if removed, err := restored.UnbanNode(peer.RemoteID); err != nil || !removed {
+		t.Fatalf("UnbanNode failed: %+v", err)
+	}
+	if _, ok := restored.add(createTestPeer(0x01, false)); !ok {
+		t.Error("Unbanned peer wasn't accepted")
+	}
+}
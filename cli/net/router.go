@@ -0,0 +1,63 @@
+package net
+
+import (
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Router dispatches messages received from remote node to handlers by message ID
+	using conn.Dispatcher with registry of protocol messages. Messages of each stream
+	selected by protocol.MessageStream are handled by separate goroutine, so e.g. blocks
+	applied by gossip handlers don't delay chain data and discovery requests.
+	Handler may return response, which is sent back to remote node.
+	Connection is closed if message has unknown ID or handler returned error.
+	Requests sent with Request are matched with responses by request ID.
+*/
+
+// MessageConn is connection messages are received from and sent to
+type MessageConn = conn.MessageConn
+
+// HandlerFn handles message payload. If response is not nil, it is sent to remote with responseID
+type HandlerFn = func(payload []byte) (responseID byte, response proto.Message, err error)
+
+// PeerHandlerFn is HandlerFn which also receives connection message was received from
+type PeerHandlerFn = conn.RawHandlerFn
+
+// Router dispatches received messages to registered handlers
+type Router struct {
+	dispatcher *conn.Dispatcher
+}
+
+// NewRouter creates router without handlers
+func NewRouter() *Router {
+	dispatcher := conn.NewDispatcher(protocol.MessageTypes)
+	dispatcher.StreamOf = protocol.MessageStream
+	return &Router{dispatcher: dispatcher}
+}
+
+// Handle registers handler for messages with specific ID
+func (router *Router) Handle(messageID byte, handler HandlerFn) {
+	router.HandlePeer(messageID, func(connection MessageConn, payload []byte) (byte, proto.Message, error) {
+		return handler(payload)
+	})
+}
+
+// HandlePeer registers handler for messages with specific ID, which depends on sending connection
+func (router *Router) HandlePeer(messageID byte, handler PeerHandlerFn) {
+	router.dispatcher.HandleRaw(messageID, handler)
+}
+
+// Serve reads messages from connection and dispatches them until connection is closed
+func (router *Router) Serve(connection MessageConn) {
+	router.dispatcher.Serve(connection)
+}
+
+// Request sends request to connection and waits for response with responseID.
+// Request ID of request is set by router
+func (router *Router) Request(connection MessageConn, requestID byte, request proto.Message, responseID byte, timeout time.Duration) (proto.Message, error) {
+	return router.dispatcher.Request(connection, requestID, request, responseID, timeout)
+}
@@ -0,0 +1,106 @@
+package net
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"github.com/buuzcoin/go-buuzcoin/cli/chain"
+	"github.com/buuzcoin/go-buuzcoin/cli/downloader"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+)
+
+/*
+	Synchronization:
+	Registered peers are passed to downloader.Downloader, which downloads block bodies from them.
+	After peer is registered, local chain is synchronized with it if its HelloMessage announced
+	longer chain. Requests of downloader are matched with responses by router, penalties of
+	downloader decrease score of peer.
+*/
+
+// ErrUnexpectedResponse is returned if remote responded to request with message of other type
+var ErrUnexpectedResponse = errors.New("net: unexpected response")
+
+// syncPeer is connection used by downloader
+type syncPeer struct {
+	connection *Connection
+	router     *Router
+}
+
+// ID returns hex-encoded node ID of remote
+func (peer *syncPeer) ID() string {
+	return hex.EncodeToString(peer.connection.RemoteID)
+}
+
+// LastBlockIndex returns index of last block announced in HelloMessage of remote
+func (peer *syncPeer) LastBlockIndex() uint64 {
+	return peer.connection.RemoteHello.LastBlockIndex
+}
+
+// RequestBlockHeaders sends GetBlockHeaders request and waits for response
+func (peer *syncPeer) RequestBlockHeaders(request *protocol.GetBlockHeaders) (*protocol.BlockHeaders, error) {
+	response, err := peer.router.Request(peer.connection, protocol.MessageGetBlockHeaders, request, protocol.MessageBlockHeaders, 0)
+	if err != nil {
+		return nil, err
+	}
+	headers, ok := response.(*protocol.BlockHeaders)
+	if !ok {
+		return nil, ErrUnexpectedResponse
+	}
+	return headers, nil
+}
+
+// RequestTransactions sends GetTransactions request and waits for response
+func (peer *syncPeer) RequestTransactions(request *protocol.GetTransactions) (*protocol.Transactions, error) {
+	response, err := peer.router.Request(peer.connection, protocol.MessageGetTransactions, request, protocol.MessageTransactions, 0)
+	if err != nil {
+		return nil, err
+	}
+	transactions, ok := response.(*protocol.Transactions)
+	if !ok {
+		return nil, ErrUnexpectedResponse
+	}
+	return transactions, nil
+}
+
+// NewDownloader creates downloader applying blocks to blockchain dispatcher, penalties are reported to peer manager
+func (netNode *NetworkNode) NewDownloader(proofAlgo consensus.ProofAlgorithm) *downloader.Downloader {
+	blockDownloader := downloader.New(chain.BlockchainDispatcher, proofAlgo)
+	blockDownloader.OnPenalty = func(peer downloader.Peer, reason error) {
+		if syncPeer, ok := peer.(*syncPeer); ok {
+			netNode.Peers.Report(syncPeer.connection, penaltyEvent(reason))
+		}
+	}
+	return blockDownloader
+}
+
+// penaltyEvent returns event reported for peer penalized by downloader
+func penaltyEvent(reason error) PeerEvent {
+	if reason == downloader.ErrTimeout {
+		return EventTimeout
+	}
+	if event, ok := blockEvent(reason); ok {
+		return event
+	}
+	return EventMalformedMessage
+}
+
+// registerSyncPeer registers connection in downloader and synchronizes chain with it.
+// Returned function unregisters peer
+func (netNode *NetworkNode) registerSyncPeer(connection *Connection) func() {
+	if netNode.Downloader == nil {
+		return func() {}
+	}
+	peer := &syncPeer{connection: connection, router: netNode.router}
+	netNode.Downloader.RegisterPeer(peer)
+	go func() {
+		err := netNode.Downloader.Synchronise(peer)
+		if err != nil && err != downloader.ErrNothingToSync && err != downloader.ErrAlreadySyncing {
+			log.Printf("Synchronization with %s failed: %v", peer.ID(), err)
+		}
+	}()
+	return func() {
+		netNode.Downloader.UnregisterPeer(peer.ID())
+	}
+}
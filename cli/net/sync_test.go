@@ -0,0 +1,195 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/cli/downloader"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+	"github.com/golang/protobuf/proto"
+)
+
+// initTestSyncPeer connects local node to remote serving chain data and returns sync peer of remote
+func initTestSyncPeer(t *testing.T, server *ChainDataServer, lastBlockIndex uint64) (*syncPeer, func()) {
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	netNode, closeNode := initTestNode(t, path)
+
+	memNet := conn.NewMemoryNetwork(1)
+	newTransport := func(address string) *conn.MemoryTransport {
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+		}
+		cert, err := netNode.GenerateCertificate(pubKey, privKey)
+		if err != nil {
+			t.Fatalf("GenerateCertificate failed: %+v", err)
+		}
+		transport, err := memNet.NewTransport(address, *cert)
+		if err != nil {
+			t.Fatalf("NewTransport failed: %+v", err)
+		}
+		return transport
+	}
+	localTransport, remoteTransport := newTransport("local"), newTransport("remote")
+	cleanup := func() {
+		localTransport.Close()
+		remoteTransport.Close()
+		closeNode()
+		os.RemoveAll(path)
+	}
+
+	localPeer, err := localTransport.Dial("remote", nil)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Dial failed: %+v", err)
+	}
+	remotePeer := <-remoteTransport.Incoming()
+	remotePeer.StreamOf = protocol.MessageStream
+	remoteRouter := NewRouter()
+	server.RegisterHandlers(remoteRouter)
+	go remoteRouter.Serve(remotePeer)
+
+	connection := netNode.newConnection(localPeer)
+	connection.RemoteHello = &protocol.HelloMessage{LastBlockIndex: lastBlockIndex}
+	go netNode.router.Serve(connection)
+	return &syncPeer{connection: connection, router: netNode.router}, cleanup
+}
+
+func TestSyncPeer(t *testing.T) {
+	/*
+		1. Headers and transactions are requested from remote serving chain data
+		2. Responses are matched with requests and aren't passed to handlers of router
+		3. Timeouts and invalid data are reported as peer events
+	*/
+	server, blocks, cleanup := initTestChainData(t, 5)
+	defer cleanup()
+	peer, closePeer := initTestSyncPeer(t, server, 4)
+	defer closePeer()
+	if peer.LastBlockIndex() != 4 {
+		t.Fatalf("Unexpected last block index: %d", peer.LastBlockIndex())
+	}
+
+	headers, err := peer.RequestBlockHeaders(&protocol.GetBlockHeaders{StartBlockIndex: 1, MaxBlocks: 10})
+	if err != nil || len(headers.BlockHeaders) != 4 {
+		t.Fatalf("RequestBlockHeaders failed: %+v", err)
+	}
+	transactions, err := peer.RequestTransactions(&protocol.GetTransactions{TxHashes: blocks[2].TxHashes})
+	if err != nil || len(transactions.Transactions) != 1 {
+		t.Fatalf("RequestTransactions failed: %+v", err)
+	}
+
+	if penaltyEvent(downloader.ErrTimeout) != EventTimeout || penaltyEvent(downloader.ErrInvalidBody) != EventMalformedMessage {
+		t.Error("Unexpected events of downloader penalties")
+	}
+}
+
+// testSyncChain is in-memory chain accepting blocks with known parent
+type testSyncChain struct {
+	blocks map[string]*blockchain.Block
+	head   *blockchain.Block
+	lock   sync.Mutex
+}
+
+func (chain *testSyncChain) GetBlockchainState() blockchain.Blockchain {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	return blockchain.Blockchain{LastBlockHash: chain.head.CalculateHash(), LastBlockIndex: chain.head.Index}
+}
+
+func (chain *testSyncChain) GetBlock(hash []byte) (*blockchain.Block, error) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	return chain.blocks[hex.EncodeToString(hash)], nil
+}
+
+func (chain *testSyncChain) ApplyBlock(block blockchain.Block, transactions []blockchain.TX) error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	if _, known := chain.blocks[hex.EncodeToString(block.PrevBlockHash)]; !known {
+		return errors.New("block with unknown parent")
+	}
+	chain.blocks[hex.EncodeToString(block.CalculateHash())] = &block
+	chain.head = &block
+	return nil
+}
+
+func TestSyncLargeBlock(t *testing.T) {
+	/*
+		1. Remote serves block with more transactions than MaxServedTransactions
+		2. Transactions responses of remote are partial, downloader requests missing transactions again
+		3. Block should be applied to local chain, remote shouldn't be penalized
+	*/
+	server, _, cleanup := initTestChainData(t, 0)
+	defer cleanup()
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	poa := &consensus.ProofOfAuthority{AuthorityPublicKey: pubKey, AuthorityPrivateKey: privKey}
+
+	genesis := blockchain.Block{PrevBlockHash: make([]byte, 32)}
+	block := blockchain.Block{Version: 1}
+	for i := 0; i < MaxServedTransactions+500; i++ {
+		tx := blockchain.TX{Version: 1, Nonce: uint64(i), From: make([]byte, 20), To: make([]byte, 20)}
+		tx.Hash = tx.CalculateHash()
+		txData, err := proto.Marshal(&tx)
+		if err != nil {
+			t.Fatalf("proto.Marshal failed: %+v", err)
+		}
+		block.TxHashes = append(block.TxHashes, tx.Hash)
+		block.Transactions = append(block.Transactions, txData)
+	}
+	block.TxMerkleRoot = blockchain.CalculateMerkleRoot(block.TxHashes)
+	block.StateMerkleRoot = make([]byte, 32)
+	if err = poa.Prepare(&block, genesis); err != nil {
+		t.Fatalf("Prepare failed: %+v", err)
+	}
+	if err = poa.Seal(&block); err != nil {
+		t.Fatalf("Seal failed: %+v", err)
+	}
+
+	localStorage := server.LocalStorage
+	for _, saved := range []blockchain.Block{genesis, block} {
+		if err = localStorage.SaveBlock(saved); err != nil {
+			t.Fatalf("SaveBlock failed: %+v", err)
+		}
+		if err = localStorage.Env.Update(func(txn *lmdb.Txn) error {
+			return localStorage.PutCanonicalHash(txn, saved.Index, saved.CalculateHash())
+		}); err != nil {
+			t.Fatalf("PutCanonicalHash failed: %+v", err)
+		}
+	}
+
+	peer, closePeer := initTestSyncPeer(t, server, 1)
+	defer closePeer()
+	localChain := &testSyncChain{
+		blocks: map[string]*blockchain.Block{hex.EncodeToString(genesis.CalculateHash()): &genesis},
+		head:   &genesis,
+	}
+	blockDownloader := downloader.New(localChain, poa)
+	if err = blockDownloader.Synchronise(peer); err != nil {
+		t.Fatalf("Synchronise failed: %+v", err)
+	}
+
+	head, _ := localChain.GetBlock(localChain.GetBlockchainState().LastBlockHash)
+	if bytes.Compare(head.CalculateHash(), block.CalculateHash()) != 0 || len(head.Transactions) != len(block.TxHashes) {
+		t.Fatal("Block with partially served transactions wasn't applied")
+	}
+	if blockDownloader.Penalty(peer.ID()) != 0 {
+		t.Errorf("Remote serving partial responses was penalized: %d", blockDownloader.Penalty(peer.ID()))
+	}
+}
@@ -0,0 +1,82 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/quic-transport/conn"
+)
+
+func TestNodeTransport(t *testing.T) {
+	/*
+		1. Node dials over its transport, node ID of remote is verified by dial and FindNode
+		2. Transport is closed when node is closed
+	*/
+	path, err := ioutil.TempDir("", "buuzcoin-net-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %+v", err)
+	}
+	defer os.RemoveAll(path)
+	netNode, closeNode := initTestNode(t, path)
+	defer closeNode()
+	if err = netNode.LoadTLSConfig(); err != nil {
+		t.Fatalf("LoadTLSConfig failed: %+v", err)
+	}
+
+	memNet := conn.NewMemoryNetwork(1)
+	transport, err := memNet.NewTransport("10.0.0.1:7000", netNode.tlsConfig.Certificates[0])
+	if err != nil {
+		t.Fatalf("NewTransport failed: %+v", err)
+	}
+	netNode.transport = transport
+	served := make(chan struct{})
+	go func() {
+		netNode.ServeTransport(transport)
+		close(served)
+	}()
+
+	remoteKey, remotePrivKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	remoteCert, err := netNode.GenerateCertificate(remoteKey, remotePrivKey)
+	if err != nil {
+		t.Fatalf("GenerateCertificate failed: %+v", err)
+	}
+	remote, err := memNet.NewTransport("10.0.0.2:7000", *remoteCert)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %+v", err)
+	}
+	defer remote.Close()
+
+	peer, err := netNode.dial("10.0.0.2:7000", network.DeriveAddress(remoteKey))
+	if err != nil {
+		t.Fatalf("dial failed: %+v", err)
+	}
+	defer peer.Close()
+	if incoming := <-remote.Incoming(); !bytes.Equal(incoming.RemoteID, netNode.nodeAddress) {
+		t.Fatal("Remote received unexpected node ID")
+	}
+	if _, err = netNode.dial("10.0.0.2:7000", netNode.nodeAddress); err != conn.ErrUnexpectedNode {
+		t.Fatalf("Dial to unexpected node didn't fail: %+v", err)
+	}
+	if _, err = netNode.FindNode("10.0.0.2:7000", netNode.nodeAddress, netNode.nodeAddress); err != conn.ErrUnexpectedNode {
+		t.Fatalf("FindNode sent to unexpected node: %+v", err)
+	}
+
+	netNode.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("ServeTransport didn't return after node was closed")
+	}
+	if _, ok := <-transport.Incoming(); ok {
+		t.Fatal("Transport wasn't closed")
+	}
+}
@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/bmatsuo/lmdb-go v1.8.0
 	github.com/golang/protobuf v1.3.5
+	github.com/golang/snappy v0.0.1
 	github.com/lucas-clemente/quic-go v0.14.0
 	github.com/pixelbender/go-stun v0.0.0-20170612184125-229529726602
 	github.com/pkg/errors v0.9.1
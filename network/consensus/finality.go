@@ -0,0 +1,305 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/network/protocol"
+	"golang.org/x/crypto/sha3"
+)
+
+/*
+	This file defines BFT finality gadget working on top of block production algorithm.
+	Authority set votes for blocks in two steps:
+	1. Every authority signs prevote for imported block
+	2. When more than 2/3 of authorities prevoted for block, authority signs precommit
+	3. Block is final when more than 2/3 of authorities precommitted for it,
+	   collected precommits are FinalityCertificate of block
+	Authority signs at most one prevote and one precommit for each height, competing
+	blocks at height local authority already voted for are not voted for.
+
+	Vote hash is calculated in following way: SHA3(Type || BlockIndex || BlockHash)
+	Type - 4 bytes
+	BlockIndex - 8 bytes
+	BlockHash - 32 bytes
+
+	Numbers are encoded in little-endian format.
+*/
+
+const (
+	// VotePrevote is type of prevote message
+	VotePrevote uint32 = 0x01
+	// VotePrecommit is type of precommit message
+	VotePrecommit uint32 = 0x02
+)
+
+var (
+	// ErrInvalidVote is returned if vote data is malformed
+	ErrInvalidVote = errors.New("finality: malformed vote")
+	// ErrUnknownAuthority is returned if vote is signed by key which is not in authority set
+	ErrUnknownAuthority = errors.New("finality: unknown authority")
+	// ErrInvalidVoteSignature is returned if vote signature verification failed
+	ErrInvalidVoteSignature = errors.New("finality: invalid vote signature")
+	// ErrInvalidCertificate is returned if finality certificate doesn't have enough valid precommits
+	ErrInvalidCertificate = errors.New("finality: invalid finality certificate")
+)
+
+// VoteHash returns hash signed by authority for vote with specific parameters
+func VoteHash(voteType uint32, blockIndex uint64, blockHash []byte) []byte {
+	data := make([]byte, 4+8, 4+8+len(blockHash))
+	binary.LittleEndian.PutUint32(data[:4], voteType)
+	binary.LittleEndian.PutUint64(data[4:4+8], blockIndex)
+	data = append(data, blockHash...)
+
+	hash := sha3.Sum256(data)
+	return hash[:]
+}
+
+// SignVote creates vote for block signed by authority's private key
+func SignVote(privKey ed25519.PrivateKey, voteType uint32, blockIndex uint64, blockHash []byte) *protocol.Vote {
+	signature := ed25519.Sign(privKey, VoteHash(voteType, blockIndex, blockHash))
+	return &protocol.Vote{
+		Type:       voteType,
+		BlockIndex: blockIndex,
+		BlockHash:  blockHash,
+		Signature:  append(signature, privKey.Public().(ed25519.PublicKey)...),
+	}
+}
+
+type blockVotes struct {
+	blockIndex uint64
+	blockHash  []byte
+	// prevotes and precommits are signatures of authorities mapped by hex-encoded public key
+	prevotes   map[string][]byte
+	precommits map[string][]byte
+}
+
+// FinalityGadget collects votes of authority set and creates finality certificates
+type FinalityGadget struct {
+	// Authorities is list of public keys of authority set
+	Authorities [][]byte
+	// PrivateKey is set if local node is member of authority set
+	PrivateKey ed25519.PrivateKey
+
+	// lastFinalized is index of last finalized block, votes for older blocks are ignored
+	lastFinalized uint64
+	votes         map[string]*blockVotes
+	// prevoted and precommitted are hashes of blocks local authority voted for by block index
+	prevoted     map[uint64][]byte
+	precommitted map[uint64][]byte
+
+	lock *sync.Mutex
+}
+
+// NewFinalityGadget creates finality gadget for authority set specified.
+// privKey should be nil if local node is not an authority
+func NewFinalityGadget(authorities [][]byte, privKey ed25519.PrivateKey) *FinalityGadget {
+	return &FinalityGadget{
+		Authorities:  authorities,
+		PrivateKey:   privKey,
+		votes:        make(map[string]*blockVotes),
+		prevoted:     make(map[uint64][]byte),
+		precommitted: make(map[uint64][]byte),
+		lock:         &sync.Mutex{},
+	}
+}
+
+// Threshold returns minimal count of votes required: more than 2/3 of authority set
+func (gadget *FinalityGadget) Threshold() int {
+	return len(gadget.Authorities)*2/3 + 1
+}
+
+func (gadget *FinalityGadget) isAuthority(pubKey []byte) bool {
+	for _, authority := range gadget.Authorities {
+		if bytes.Compare(authority, pubKey) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyVote checks whether if vote is signed by member of authority set
+func (gadget *FinalityGadget) VerifyVote(vote *protocol.Vote) error {
+	if vote.Type != VotePrevote && vote.Type != VotePrecommit {
+		return ErrInvalidVote
+	}
+	if len(vote.BlockHash) != 32 {
+		return ErrInvalidVote
+	}
+	if len(vote.Signature) != ed25519.SignatureSize+ed25519.PublicKeySize {
+		return ErrInvalidVote
+	}
+
+	pubKey := vote.Signature[ed25519.SignatureSize:]
+	if !gadget.isAuthority(pubKey) {
+		return ErrUnknownAuthority
+	}
+	if !ed25519.Verify(pubKey, VoteHash(vote.Type, vote.BlockIndex, vote.BlockHash), vote.Signature[:ed25519.SignatureSize]) {
+		return ErrInvalidVoteSignature
+	}
+	return nil
+}
+
+// VerifyCertificate checks whether if certificate contains precommits of more than 2/3 of authority set
+func (gadget *FinalityGadget) VerifyCertificate(cert *blockchain.FinalityCertificate) error {
+	if len(cert.BlockHash) != 32 {
+		return ErrInvalidCertificate
+	}
+
+	signers := make(map[string]bool)
+	for _, precommit := range cert.Precommits {
+		vote := &protocol.Vote{
+			Type:       VotePrecommit,
+			BlockIndex: cert.BlockIndex,
+			BlockHash:  cert.BlockHash,
+			Signature:  precommit,
+		}
+		if err := gadget.VerifyVote(vote); err != nil {
+			return ErrInvalidCertificate
+		}
+		signers[hex.EncodeToString(precommit[ed25519.SignatureSize:])] = true
+	}
+
+	if len(signers) < gadget.Threshold() {
+		return ErrInvalidCertificate
+	}
+	return nil
+}
+
+// Prevote creates local authority's prevote for imported block.
+// Returns votes which should be broadcasted and certificate if block became final.
+func (gadget *FinalityGadget) Prevote(block blockchain.Block) ([]*protocol.Vote, *blockchain.FinalityCertificate) {
+	if gadget.PrivateKey == nil {
+		return nil, nil
+	}
+
+	gadget.lock.Lock()
+	defer gadget.lock.Unlock()
+
+	if block.Index <= gadget.lastFinalized {
+		return nil, nil
+	}
+	blockHash := block.CalculateHash()
+	if _, prevoted := gadget.prevoted[block.Index]; prevoted || gadget.conflicts(block.Index, blockHash) {
+		return nil, nil
+	}
+	gadget.prevoted[block.Index] = blockHash
+
+	vote := SignVote(gadget.PrivateKey, VotePrevote, block.Index, blockHash)
+	outgoing, cert := gadget.addVote(vote)
+	return append([]*protocol.Vote{vote}, outgoing...), cert
+}
+
+// AddVote verifies and records vote received from network.
+// Returns votes which should be broadcasted and certificate if block became final.
+func (gadget *FinalityGadget) AddVote(vote *protocol.Vote) ([]*protocol.Vote, *blockchain.FinalityCertificate, error) {
+	if err := gadget.VerifyVote(vote); err != nil {
+		return nil, nil, err
+	}
+
+	gadget.lock.Lock()
+	defer gadget.lock.Unlock()
+
+	if vote.BlockIndex <= gadget.lastFinalized {
+		return nil, nil, nil
+	}
+
+	outgoing, cert := gadget.addVote(vote)
+	return outgoing, cert, nil
+}
+
+// SetFinalized prunes votes for blocks with index less or equal to specified one
+func (gadget *FinalityGadget) SetFinalized(blockIndex uint64) {
+	gadget.lock.Lock()
+	defer gadget.lock.Unlock()
+	gadget.setFinalized(blockIndex)
+}
+
+func (gadget *FinalityGadget) setFinalized(blockIndex uint64) {
+	if blockIndex < gadget.lastFinalized {
+		return
+	}
+	gadget.lastFinalized = blockIndex
+	for key, votes := range gadget.votes {
+		if votes.blockIndex <= blockIndex {
+			delete(gadget.votes, key)
+		}
+	}
+	for index := range gadget.prevoted {
+		if index <= blockIndex {
+			delete(gadget.prevoted, index)
+		}
+	}
+	for index := range gadget.precommitted {
+		if index <= blockIndex {
+			delete(gadget.precommitted, index)
+		}
+	}
+}
+
+// conflicts checks whether if local authority voted for other block at same height, gadget.lock must be held
+func (gadget *FinalityGadget) conflicts(blockIndex uint64, blockHash []byte) bool {
+	if prevoted, exists := gadget.prevoted[blockIndex]; exists && bytes.Compare(prevoted, blockHash) != 0 {
+		return true
+	}
+	if precommitted, exists := gadget.precommitted[blockIndex]; exists && bytes.Compare(precommitted, blockHash) != 0 {
+		return true
+	}
+	return false
+}
+
+// addVote records verified vote, gadget.lock must be held
+func (gadget *FinalityGadget) addVote(vote *protocol.Vote) ([]*protocol.Vote, *blockchain.FinalityCertificate) {
+	key := hex.EncodeToString(vote.BlockHash)
+	votes, exists := gadget.votes[key]
+	if !exists {
+		votes = &blockVotes{
+			blockIndex: vote.BlockIndex,
+			blockHash:  vote.BlockHash,
+			prevotes:   make(map[string][]byte),
+			precommits: make(map[string][]byte),
+		}
+		gadget.votes[key] = votes
+	}
+	if votes.blockIndex != vote.BlockIndex {
+		return nil, nil
+	}
+
+	signer := hex.EncodeToString(vote.Signature[ed25519.SignatureSize:])
+	if vote.Type == VotePrevote {
+		votes.prevotes[signer] = vote.Signature
+	} else {
+		votes.precommits[signer] = vote.Signature
+	}
+
+	var outgoing []*protocol.Vote
+	if gadget.PrivateKey != nil && len(votes.prevotes) >= gadget.Threshold() {
+		_, precommitted := gadget.precommitted[votes.blockIndex]
+		if !precommitted && !gadget.conflicts(votes.blockIndex, votes.blockHash) {
+			localKey := hex.EncodeToString(gadget.PrivateKey.Public().(ed25519.PublicKey))
+			precommit := SignVote(gadget.PrivateKey, VotePrecommit, votes.blockIndex, votes.blockHash)
+			gadget.precommitted[votes.blockIndex] = votes.blockHash
+			votes.precommits[localKey] = precommit.Signature
+			outgoing = append(outgoing, precommit)
+		}
+	}
+
+	if len(votes.precommits) < gadget.Threshold() {
+		return outgoing, nil
+	}
+
+	cert := &blockchain.FinalityCertificate{
+		BlockIndex: votes.blockIndex,
+		BlockHash:  votes.blockHash,
+		Precommits: make([][]byte, 0, len(votes.precommits)),
+	}
+	for _, precommit := range votes.precommits {
+		cert.Precommits = append(cert.Precommits, precommit)
+	}
+	return outgoing, cert
+}
@@ -0,0 +1,136 @@
+package consensus
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+)
+
+func generateAuthorities(t *testing.T, count int) ([][]byte, []ed25519.PrivateKey) {
+	authorities := make([][]byte, count)
+	privKeys := make([]ed25519.PrivateKey, count)
+	for i := 0; i < count; i++ {
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+		}
+		authorities[i] = pubKey
+		privKeys[i] = privKey
+	}
+	return authorities, privKeys
+}
+
+func TestFinalityGadget(t *testing.T) {
+	/*
+		1. Create 4 authorities, threshold is 3 votes
+		2. Local authority prevotes, remote authorities send prevotes
+		3. After third prevote local authority should precommit
+		4. After third precommit certificate should be created and verified
+	*/
+	authorities, privKeys := generateAuthorities(t, 4)
+	gadget := NewFinalityGadget(authorities, privKeys[0])
+	if gadget.Threshold() != 3 {
+		t.Fatalf("Unexpected threshold: %d, expected 3", gadget.Threshold())
+	}
+
+	block := blockchain.Block{Index: 1, PrevBlockHash: make([]byte, 32)}
+	blockHash := block.CalculateHash()
+
+	outgoing, cert := gadget.Prevote(block)
+	if len(outgoing) != 1 || outgoing[0].Type != VotePrevote || cert != nil {
+		t.Fatal("Prevote should return only local prevote")
+	}
+
+	outgoing, cert, err := gadget.AddVote(SignVote(privKeys[1], VotePrevote, 1, blockHash))
+	if err != nil || len(outgoing) != 0 || cert != nil {
+		t.Fatalf("Unexpected AddVote result: %v %v %+v", outgoing, cert, err)
+	}
+
+	outgoing, cert, err = gadget.AddVote(SignVote(privKeys[2], VotePrevote, 1, blockHash))
+	if err != nil || len(outgoing) != 1 || outgoing[0].Type != VotePrecommit || cert != nil {
+		t.Fatalf("Local authority should precommit after threshold: %v %v %+v", outgoing, cert, err)
+	}
+
+	_, cert, err = gadget.AddVote(SignVote(privKeys[1], VotePrecommit, 1, blockHash))
+	if err != nil || cert != nil {
+		t.Fatalf("Block finalized before threshold: %v %+v", cert, err)
+	}
+
+	_, cert, err = gadget.AddVote(SignVote(privKeys[3], VotePrecommit, 1, blockHash))
+	if err != nil || cert == nil {
+		t.Fatalf("Block should be finalized: %+v", err)
+	}
+	if err = gadget.VerifyCertificate(cert); err != nil {
+		t.Errorf("VerifyCertificate failed: %+v", err)
+	}
+
+	cert.Precommits = cert.Precommits[:2]
+	if err = gadget.VerifyCertificate(cert); err != ErrInvalidCertificate {
+		t.Errorf("Certificate with 2 precommits verified, err: %+v", err)
+	}
+}
+
+func TestFinalityGadgetRejectsUnknownAuthority(t *testing.T) {
+	authorities, _ := generateAuthorities(t, 3)
+	_, outsiders := generateAuthorities(t, 1)
+	gadget := NewFinalityGadget(authorities, nil)
+
+	vote := SignVote(outsiders[0], VotePrevote, 1, make([]byte, 32))
+	if _, _, err := gadget.AddVote(vote); err != ErrUnknownAuthority {
+		t.Errorf("Unexpected error: %+v, expected ErrUnknownAuthority", err)
+	}
+
+	vote = SignVote(outsiders[0], VotePrevote, 1, make([]byte, 32))
+	vote.Signature = append(vote.Signature[:ed25519.SignatureSize], authorities[0]...)
+	if _, _, err := gadget.AddVote(vote); err != ErrInvalidVoteSignature {
+		t.Errorf("Unexpected error: %+v, expected ErrInvalidVoteSignature", err)
+	}
+}
+
+func TestFinalityGadgetEquivocation(t *testing.T) {
+	/*
+		1. Local authority prevotes block A at height 1
+		2. Competing block B at height 1 isn't prevoted
+		3. After threshold of prevotes for A local authority precommits A
+		4. After threshold of prevotes for B local authority doesn't precommit B
+		5. Authority which precommitted B doesn't prevote A
+	*/
+	authorities, privKeys := generateAuthorities(t, 4)
+	gadget := NewFinalityGadget(authorities, privKeys[0])
+
+	blockA := blockchain.Block{Index: 1, PrevBlockHash: make([]byte, 32)}
+	blockB := blockchain.Block{Index: 1, PrevBlockHash: make([]byte, 32), Timestamp: 1}
+	if outgoing, _ := gadget.Prevote(blockA); len(outgoing) != 1 {
+		t.Fatal("Block A wasn't prevoted")
+	}
+	if outgoing, _ := gadget.Prevote(blockB); len(outgoing) != 0 {
+		t.Fatal("Competing block B was prevoted")
+	}
+
+	hashA, hashB := blockA.CalculateHash(), blockB.CalculateHash()
+	gadget.AddVote(SignVote(privKeys[1], VotePrevote, 1, hashA))
+	outgoing, _, err := gadget.AddVote(SignVote(privKeys[2], VotePrevote, 1, hashA))
+	if err != nil || len(outgoing) != 1 || outgoing[0].Type != VotePrecommit {
+		t.Fatalf("Block A wasn't precommitted: %v %+v", outgoing, err)
+	}
+
+	for i := 1; i < 4; i++ {
+		outgoing, _, err = gadget.AddVote(SignVote(privKeys[i], VotePrevote, 1, hashB))
+		if err != nil || len(outgoing) != 0 {
+			t.Fatalf("Competing block B was precommitted: %v %+v", outgoing, err)
+		}
+	}
+
+	gadget = NewFinalityGadget(authorities, privKeys[0])
+	for i := 1; i < 4; i++ {
+		outgoing, _, _ = gadget.AddVote(SignVote(privKeys[i], VotePrevote, 1, hashB))
+	}
+	if len(outgoing) != 1 || outgoing[0].Type != VotePrecommit {
+		t.Fatal("Block B wasn't precommitted")
+	}
+	if outgoing, _ := gadget.Prevote(blockA); len(outgoing) != 0 {
+		t.Fatal("Competing block A was prevoted after precommit for B")
+	}
+}
@@ -1,13 +1,48 @@
 package consensus
 
-import "github.com/buuzcoin/go-buuzcoin/blockchain"
+import (
+	"errors"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
+)
 
 /*
 	After block data in validated, it needs to be checked whether if block
 	satisfies proof algorithm requirements. E.g: Proof-of-Work, Proof-of-Stake, etc.
+
+	Proof algorithm also participates in block lifecycle:
+	1. Prepare - consensus fields of new block are initialized on top of parent block
+	2. Seal - proof data is created for block with evaluated transactions
+	3. VerifyHeader - imported block is checked against its parent
+	4. Finalize - consensus rewards are applied to state after transactions evaluation
+	5. CalcWeight - weight of block is used by fork-choice rule
 */
 
+var (
+	// ErrInvalidProof is returned if block doesn't satisfy proof algorithm requirements
+	ErrInvalidProof = errors.New("consensus: invalid block proof")
+	// ErrInvalidParent is returned if block doesn't extend parent block
+	ErrInvalidParent = errors.New("consensus: block doesn't extend parent")
+	// ErrNotSealer is returned if local node is not allowed to seal blocks
+	ErrNotSealer = errors.New("consensus: local node cannot seal blocks")
+)
+
 // ProofAlgorithm is abstract representation of block verification algorithm
 type ProofAlgorithm interface {
+	// IsValidBlock checks whether if block's proof data satisfies algorithm requirements
 	IsValidBlock(block blockchain.Block) (bool, error)
+	// VerifyHeader checks whether if block header is valid continuation of parent block
+	VerifyHeader(block, parent blockchain.Block) error
+
+	// Prepare initializes consensus fields of new block created on top of parent block
+	Prepare(block *blockchain.Block, parent blockchain.Block) error
+	// Seal creates proof data for block, all other block fields must be set before sealing
+	Seal(block *blockchain.Block) error
+
+	// CalcWeight returns weight of block used by fork-choice rule
+	CalcWeight(block blockchain.Block) uint64
+	// Finalize applies consensus rewards to state after block's transactions evaluation,
+	// fees is amount collected from block's transactions. Returns updated trie nodes
+	Finalize(block blockchain.Block, fees uint64, stateRoot *trie.MerkleTrieNode, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, error)
 }
@@ -3,8 +3,10 @@ package consensus
 import (
 	"bytes"
 	"crypto/ed25519"
+	"time"
 
 	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
 	"github.com/buuzcoin/go-buuzcoin/network"
 	"golang.org/x/crypto/sha3"
 )
@@ -14,11 +16,24 @@ import (
 	ProofData in block is calculated in following way:
 	1. Get hash to be signed by authority: SHA3(Beneficiary || AdditionalData || BlockHash)
 	2. Sign hash and append public key of beneficiary
+
+	Every block has equal weight, so fork-choice rule is longest chain.
+	Beneficiary receives block reward and fees of block's transactions.
 */
 
 // ProofOfAuthority defines proof of authority algorithm used in testnet v1
 type ProofOfAuthority struct {
 	AuthorityPublicKey []byte
+	// AuthorityPrivateKey is set only if local node seals blocks
+	AuthorityPrivateKey ed25519.PrivateKey
+}
+
+func (poa *ProofOfAuthority) proofHash(block blockchain.Block) []byte {
+	hash := sha3.New256()
+	hash.Write(block.Beneficiary)
+	hash.Write(block.AdditionalData)
+	hash.Write(block.CalculateHash())
+	return hash.Sum(nil)
 }
 
 // IsValidBlock checks if block satisfies PoA algorithm requirements specified above
@@ -37,12 +52,75 @@ func (poa *ProofOfAuthority) IsValidBlock(block blockchain.Block) (bool, error)
 		return false, nil
 	}
 
-	hash := sha3.New256()
-	hash.Write(block.Beneficiary)
-	hash.Write(block.AdditionalData)
-	hash.Write(block.CalculateHash())
-	if !ed25519.Verify(pubKey, hash.Sum(nil), signature) {
+	if !ed25519.Verify(pubKey, poa.proofHash(block), signature) {
 		return false, nil
 	}
 	return true, nil
 }
+
+// VerifyHeader checks whether if block extends parent and is signed by authority
+func (poa *ProofOfAuthority) VerifyHeader(block, parent blockchain.Block) error {
+	if block.Index != parent.Index+1 {
+		return ErrInvalidParent
+	}
+	if bytes.Compare(block.PrevBlockHash, parent.CalculateHash()) != 0 {
+		return ErrInvalidParent
+	}
+	if block.Timestamp < parent.Timestamp {
+		return ErrInvalidParent
+	}
+
+	valid, err := poa.IsValidBlock(block)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// Prepare sets block's parent, index, timestamp and authority as beneficiary
+func (poa *ProofOfAuthority) Prepare(block *blockchain.Block, parent blockchain.Block) error {
+	block.Index = parent.Index + 1
+	block.PrevBlockHash = parent.CalculateHash()
+	block.Beneficiary = network.DeriveAddress(poa.AuthorityPublicKey)
+
+	block.Timestamp = time.Now().Unix()
+	if block.Timestamp < parent.Timestamp {
+		block.Timestamp = parent.Timestamp
+	}
+	return nil
+}
+
+// Seal signs block with authority's private key. As authority is beneficiary of block,
+// block signature is also set
+func (poa *ProofOfAuthority) Seal(block *blockchain.Block) error {
+	if poa.AuthorityPrivateKey == nil {
+		return ErrNotSealer
+	}
+	pubKey := poa.AuthorityPrivateKey.Public().(ed25519.PublicKey)
+	if bytes.Compare(pubKey, poa.AuthorityPublicKey) != 0 {
+		return ErrNotSealer
+	}
+
+	block.ProofData = append(ed25519.Sign(poa.AuthorityPrivateKey, poa.proofHash(*block)), pubKey...)
+	block.Signature = append(ed25519.Sign(poa.AuthorityPrivateKey, block.CalculateHash()), pubKey...)
+	return nil
+}
+
+// CalcWeight returns equal weight for every block
+func (poa *ProofOfAuthority) CalcWeight(block blockchain.Block) uint64 {
+	return 1
+}
+
+// Finalize adds block reward and transaction fees to beneficiary's balance
+func (poa *ProofOfAuthority) Finalize(block blockchain.Block, fees uint64, stateRoot *trie.MerkleTrieNode, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, error) {
+	beneficiaryAccountState, err := blockchain.GetAccountState(block.Beneficiary, stateRoot, retrieve)
+	if err != nil {
+		return nil, err
+	}
+
+	beneficiaryAccountState.Balance += network.BlockReward(block.Index) + fees
+	return beneficiaryAccountState.Save(block.Beneficiary, stateRoot, retrieve)
+}
@@ -0,0 +1,46 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+)
+
+func TestProofOfAuthoritySealing(t *testing.T) {
+	/*
+		1. Prepare block on top of parent and seal it with authority key
+		2. Sealed block should pass header verification
+		3. Block sealed by another key or modified after sealing should be rejected
+	*/
+	authorities, privKeys := generateAuthorities(t, 2)
+	poa := &ProofOfAuthority{AuthorityPublicKey: authorities[0], AuthorityPrivateKey: privKeys[0]}
+
+	parent := blockchain.Block{Index: 5, PrevBlockHash: make([]byte, 32)}
+	block := &blockchain.Block{Version: 1}
+	if err := poa.Prepare(block, parent); err != nil {
+		t.Fatalf("Prepare failed: %+v", err)
+	}
+	if block.Index != 6 {
+		t.Fatalf("Unexpected block index: %d", block.Index)
+	}
+	if err := poa.Seal(block); err != nil {
+		t.Fatalf("Seal failed: %+v", err)
+	}
+	if err := poa.VerifyHeader(*block, parent); err != nil {
+		t.Fatalf("VerifyHeader failed: %+v", err)
+	}
+
+	modifiedBlock := *block
+	modifiedBlock.AdditionalData = []byte("modified")
+	if err := poa.VerifyHeader(modifiedBlock, parent); err != ErrInvalidProof {
+		t.Errorf("Modified block passed verification: %+v", err)
+	}
+	if err := poa.VerifyHeader(*block, *block); err != ErrInvalidParent {
+		t.Errorf("Block with wrong parent passed verification: %+v", err)
+	}
+
+	otherPoa := &ProofOfAuthority{AuthorityPublicKey: authorities[0], AuthorityPrivateKey: privKeys[1]}
+	if err := otherPoa.Seal(block); err != ErrNotSealer {
+		t.Errorf("Block sealed with foreign key: %+v", err)
+	}
+}
@@ -25,6 +25,7 @@ type GetBlockHeaders struct {
 	StartBlockIndex      uint64   `protobuf:"varint,1,opt,name=startBlockIndex,proto3" json:"startBlockIndex,omitempty"`
 	BlockHash            []byte   `protobuf:"bytes,2,opt,name=blockHash,proto3" json:"blockHash,omitempty"`
 	MaxBlocks            uint64   `protobuf:"varint,3,opt,name=maxBlocks,proto3" json:"maxBlocks,omitempty"`
+	RequestID            uint64   `protobuf:"varint,4,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -76,10 +77,18 @@ func (m *GetBlockHeaders) GetMaxBlocks() uint64 {
 	return 0
 }
 
+func (m *GetBlockHeaders) GetRequestID() uint64 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
 // BlockHeaders is response to GetBlockHeaders message. It may be empty if no blocks found
 type BlockHeaders struct {
 	BlockCount           uint64   `protobuf:"varint,1,opt,name=blockCount,proto3" json:"blockCount,omitempty"`
 	BlockHeaders         [][]byte `protobuf:"bytes,2,rep,name=blockHeaders,proto3" json:"blockHeaders,omitempty"`
+	RequestID            uint64   `protobuf:"varint,3,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -124,6 +133,13 @@ func (m *BlockHeaders) GetBlockHeaders() [][]byte {
 	return nil
 }
 
+func (m *BlockHeaders) GetRequestID() uint64 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
 // NewBlockHashes message it propagated to nodes if new block have appeared in network
 type NewBlockHashes struct {
 	BlockHashes          [][]byte `protobuf:"bytes,1,rep,name=blockHashes,proto3" json:"blockHashes,omitempty"`
@@ -167,6 +183,7 @@ func (m *NewBlockHashes) GetBlockHashes() [][]byte {
 // GetTransactions is request for transactions data
 type GetTransactions struct {
 	TxHashes             [][]byte `protobuf:"bytes,1,rep,name=txHashes,proto3" json:"txHashes,omitempty"`
+	RequestID            uint64   `protobuf:"varint,2,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -204,9 +221,17 @@ func (m *GetTransactions) GetTxHashes() [][]byte {
 	return nil
 }
 
+func (m *GetTransactions) GetRequestID() uint64 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
 // Transactions is message containing transaction data
 type Transactions struct {
 	Transactions         [][]byte `protobuf:"bytes,1,rep,name=transactions,proto3" json:"transactions,omitempty"`
+	RequestID            uint64   `protobuf:"varint,2,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -244,8 +269,17 @@ func (m *Transactions) GetTransactions() [][]byte {
 	return nil
 }
 
-// NewBlock is notification that new block appeared in the network
+func (m *Transactions) GetRequestID() uint64 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
+// NewBlock is notification that new block appeared in the network.
+// It is compact block: transactions are restored by receiver from its mempool
 type NewBlock struct {
+	// BlockHeader is encoded block header without txHashes
 	BlockHeader          []byte   `protobuf:"bytes,1,opt,name=blockHeader,proto3" json:"blockHeader,omitempty"`
 	TxHashList           [][]byte `protobuf:"bytes,2,rep,name=txHashList,proto3" json:"txHashList,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -295,6 +329,7 @@ func (m *NewBlock) GetTxHashList() [][]byte {
 // GetNodeData is request for data from state trie
 type GetNodeData struct {
 	NodeHashes           [][]byte `protobuf:"bytes,1,rep,name=nodeHashes,proto3" json:"nodeHashes,omitempty"`
+	RequestID            uint64   `protobuf:"varint,2,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -332,9 +367,17 @@ func (m *GetNodeData) GetNodeHashes() [][]byte {
 	return nil
 }
 
+func (m *GetNodeData) GetRequestID() uint64 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
 // NodeData is message containing node data in format: nodeHash||nodeValue
 type NodeData struct {
 	Nodes                [][]byte `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	RequestID            uint64   `protobuf:"varint,2,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -372,6 +415,80 @@ func (m *NodeData) GetNodes() [][]byte {
 	return nil
 }
 
+func (m *NodeData) GetRequestID() uint64 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
+// Vote is finality vote of authority for specific block
+type Vote struct {
+	// Type is 0x01 for prevote and 0x02 for precommit
+	Type       uint32 `protobuf:"varint,1,opt,name=type,proto3" json:"type,omitempty"`
+	BlockIndex uint64 `protobuf:"varint,2,opt,name=blockIndex,proto3" json:"blockIndex,omitempty"`
+	BlockHash  []byte `protobuf:"bytes,3,opt,name=blockHash,proto3" json:"blockHash,omitempty"`
+	// Signature is calculated over SHA3(type||blockIndex||blockHash),
+	// format: signature||publicKey
+	Signature            []byte   `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Vote) Reset()         { *m = Vote{} }
+func (m *Vote) String() string { return proto.CompactTextString(m) }
+func (*Vote) ProtoMessage()    {}
+func (*Vote) Descriptor() ([]byte, []int) {
+	return fileDescriptor_011ecb1cf68a50b8, []int{8}
+}
+
+func (m *Vote) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Vote.Unmarshal(m, b)
+}
+func (m *Vote) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Vote.Marshal(b, m, deterministic)
+}
+func (m *Vote) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Vote.Merge(m, src)
+}
+func (m *Vote) XXX_Size() int {
+	return xxx_messageInfo_Vote.Size(m)
+}
+func (m *Vote) XXX_DiscardUnknown() {
+	xxx_messageInfo_Vote.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Vote proto.InternalMessageInfo
+
+func (m *Vote) GetType() uint32 {
+	if m != nil {
+		return m.Type
+	}
+	return 0
+}
+
+func (m *Vote) GetBlockIndex() uint64 {
+	if m != nil {
+		return m.BlockIndex
+	}
+	return 0
+}
+
+func (m *Vote) GetBlockHash() []byte {
+	if m != nil {
+		return m.BlockHash
+	}
+	return nil
+}
+
+func (m *Vote) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*GetBlockHeaders)(nil), "protocol.GetBlockHeaders")
 	proto.RegisterType((*BlockHeaders)(nil), "protocol.BlockHeaders")
@@ -381,28 +498,34 @@ func init() {
 	proto.RegisterType((*NewBlock)(nil), "protocol.NewBlock")
 	proto.RegisterType((*GetNodeData)(nil), "protocol.GetNodeData")
 	proto.RegisterType((*NodeData)(nil), "protocol.NodeData")
+	proto.RegisterType((*Vote)(nil), "protocol.Vote")
 }
 
 func init() { proto.RegisterFile("protocol/connection.proto", fileDescriptor_011ecb1cf68a50b8) }
 
 var fileDescriptor_011ecb1cf68a50b8 = []byte{
-	// 283 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0x92, 0x4f, 0x4b, 0xc3, 0x40,
-	0x10, 0xc5, 0x49, 0xab, 0x12, 0xa7, 0x8b, 0x85, 0xc5, 0x43, 0x14, 0x91, 0xb0, 0xa7, 0x5c, 0xaa,
-	0x50, 0xbf, 0x41, 0x15, 0xaa, 0x50, 0x7a, 0x08, 0x7e, 0x81, 0x4d, 0x32, 0x60, 0xb1, 0xee, 0x4a,
-	0x76, 0xa4, 0xf9, 0xf8, 0x92, 0xc9, 0xbf, 0xdd, 0x1e, 0xdf, 0x6f, 0xde, 0xcb, 0xbc, 0x1d, 0x02,
-	0x77, 0xbf, 0xb5, 0x25, 0x5b, 0xda, 0xe3, 0x73, 0x69, 0x8d, 0xc1, 0x92, 0x0e, 0xd6, 0x3c, 0x31,
-	0x93, 0xf1, 0x30, 0x52, 0x27, 0x58, 0x6e, 0x91, 0x36, 0x47, 0x5b, 0x7e, 0xbf, 0xa3, 0xae, 0xb0,
-	0x76, 0x32, 0x83, 0xa5, 0x23, 0x5d, 0x77, 0xf0, 0xc3, 0x54, 0xd8, 0x24, 0x51, 0x1a, 0x65, 0x17,
-	0xf9, 0x39, 0x96, 0x0f, 0x70, 0x5d, 0x70, 0x52, 0xbb, 0xaf, 0x64, 0x96, 0x46, 0x99, 0xc8, 0x27,
-	0xd0, 0x4e, 0x7f, 0x74, 0xc3, 0x76, 0x97, 0xcc, 0xf9, 0x0b, 0x13, 0x50, 0x39, 0x88, 0x60, 0xeb,
-	0x23, 0x00, 0x47, 0x5f, 0xed, 0x9f, 0xa1, 0x7e, 0xa1, 0x47, 0xa4, 0x02, 0x51, 0x78, 0xfe, 0x64,
-	0x96, 0xce, 0x33, 0x91, 0x07, 0x4c, 0xad, 0xe1, 0x66, 0x8f, 0xa7, 0xcd, 0xd0, 0x00, 0x9d, 0x4c,
-	0x61, 0x51, 0x4c, 0x32, 0x89, 0x38, 0xe4, 0x23, 0xb5, 0xe2, 0x03, 0x7c, 0xd6, 0xda, 0x38, 0xcd,
-	0x27, 0x72, 0xf2, 0x1e, 0x62, 0x6a, 0x82, 0xc4, 0xa8, 0xd5, 0x1a, 0x44, 0xe0, 0x55, 0x20, 0xc8,
-	0xd3, 0xbd, 0x3f, 0x60, 0x6a, 0x07, 0xf1, 0x50, 0x6b, 0x2a, 0xc4, 0x95, 0xf9, 0x9d, 0x63, 0x21,
-	0x46, 0xed, 0x21, 0xba, 0x6d, 0xbb, 0x83, 0xa3, 0xfe, 0x99, 0x1e, 0x51, 0x2b, 0x58, 0x6c, 0x91,
-	0xf6, 0xb6, 0xc2, 0x37, 0x4d, 0xba, 0xb5, 0x1b, 0x5b, 0x61, 0x50, 0xd7, 0x23, 0x2a, 0x85, 0x78,
-	0xf4, 0xde, 0xc2, 0x65, 0x3b, 0x19, 0x6c, 0x9d, 0x28, 0xae, 0xf8, 0x67, 0x78, 0xf9, 0x0f, 0x00,
-	0x00, 0xff, 0xff, 0xdd, 0xd6, 0x63, 0xa2, 0x30, 0x02, 0x00, 0x00,
+	// 358 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x93, 0xcf, 0x4a, 0xc3, 0x40,
+	0x10, 0xc6, 0xc9, 0x1f, 0x25, 0x4e, 0x57, 0x0b, 0x8b, 0x87, 0x28, 0x22, 0x21, 0xa7, 0x9c, 0x14,
+	0xf4, 0xee, 0xa1, 0x16, 0x6a, 0x69, 0x29, 0x12, 0xc4, 0xfb, 0x26, 0x19, 0xb4, 0x58, 0x77, 0x6b,
+	0x76, 0xab, 0xf5, 0x41, 0x7c, 0x5f, 0xc9, 0xa6, 0x49, 0x76, 0x4b, 0x21, 0xb7, 0xcc, 0x6f, 0x66,
+	0xbe, 0xfd, 0x66, 0x98, 0xc0, 0xc5, 0xba, 0x14, 0x4a, 0xe4, 0x62, 0x75, 0x9b, 0x0b, 0xce, 0x31,
+	0x57, 0x4b, 0xc1, 0x6f, 0x34, 0xa3, 0x41, 0x93, 0x8a, 0xff, 0x1c, 0x18, 0x4e, 0x50, 0x8d, 0x56,
+	0x22, 0xff, 0x78, 0x42, 0x56, 0x60, 0x29, 0x69, 0x02, 0x43, 0xa9, 0x58, 0x59, 0xc3, 0x29, 0x2f,
+	0x70, 0x1b, 0x3a, 0x91, 0x93, 0xf8, 0xe9, 0x3e, 0xa6, 0x57, 0x70, 0x92, 0xe9, 0x4e, 0x26, 0xdf,
+	0x43, 0x37, 0x72, 0x12, 0x92, 0x76, 0xa0, 0xca, 0x7e, 0xb2, 0xad, 0x2e, 0x97, 0xa1, 0xa7, 0x15,
+	0x3a, 0x50, 0x65, 0x4b, 0xfc, 0xda, 0xa0, 0x54, 0xd3, 0x71, 0xe8, 0xd7, 0xd9, 0x16, 0xc4, 0x6b,
+	0x20, 0x96, 0xa7, 0x6b, 0x00, 0x2d, 0xfc, 0x28, 0x36, 0x5c, 0xed, 0xec, 0x18, 0x84, 0xc6, 0x40,
+	0x32, 0xa3, 0x3e, 0x74, 0x23, 0x2f, 0x21, 0xa9, 0xc5, 0xec, 0x17, 0xbd, 0xfd, 0x17, 0xef, 0xe0,
+	0x6c, 0x81, 0x3f, 0xa3, 0xc6, 0x3d, 0x4a, 0x1a, 0xc1, 0x20, 0xeb, 0xc2, 0xd0, 0xd1, 0x92, 0x26,
+	0x8a, 0x67, 0x7a, 0x79, 0x2f, 0x25, 0xe3, 0x92, 0xe9, 0xfd, 0x4a, 0x7a, 0x09, 0x81, 0xda, 0x5a,
+	0x1d, 0x6d, 0x6c, 0x1b, 0x70, 0xf7, 0x0d, 0x3c, 0x03, 0xb1, 0x94, 0x62, 0x20, 0xca, 0x88, 0x77,
+	0x6a, 0x16, 0xeb, 0x51, 0x9c, 0x43, 0xd0, 0x8c, 0xd4, 0x0d, 0xa3, 0x97, 0xa1, 0x37, 0x48, 0x52,
+	0x13, 0x55, 0x2b, 0xae, 0x9d, 0xce, 0x97, 0x52, 0xed, 0x16, 0x68, 0x90, 0x78, 0x06, 0x83, 0x09,
+	0xaa, 0x85, 0x28, 0x70, 0xcc, 0x14, 0xab, 0xca, 0xb9, 0x28, 0xd0, 0x1a, 0xd5, 0x20, 0x3d, 0xd6,
+	0x1e, 0x20, 0x68, 0x95, 0xce, 0xe1, 0xa8, 0xea, 0x6b, 0x44, 0xea, 0xa0, 0xa7, 0xff, 0x1b, 0xfc,
+	0x57, 0xa1, 0x90, 0x52, 0xf0, 0xd5, 0xef, 0x1a, 0xf5, 0x3c, 0xa7, 0xa9, 0xfe, 0x6e, 0x6f, 0xa5,
+	0x3e, 0x5d, 0xd7, 0xb8, 0x95, 0x03, 0x57, 0xeb, 0x1d, 0xb8, 0x5a, 0xb9, 0x7c, 0xe3, 0x4c, 0x6d,
+	0x4a, 0xd4, 0x77, 0x49, 0xd2, 0x0e, 0x64, 0xc7, 0xfa, 0xcf, 0xb9, 0xff, 0x1f, 0x00, 0x67, 0x56,
+	0xe4, 0x56, 0x5d, 0x03, 0x00, 0x00,
 }
@@ -0,0 +1,59 @@
+package protocol
+
+import "fmt"
+
+// Reason codes sent in Disconnect message
+const (
+	// DisconnectRequested is sent if node closes connection without specific reason
+	DisconnectRequested uint32 = 0x00
+	// DisconnectProtocolError is sent if remote violated protocol
+	DisconnectProtocolError uint32 = 0x01
+	// DisconnectIncompatibleVersion is sent if remote uses unsupported protocol version
+	DisconnectIncompatibleVersion uint32 = 0x02
+	// DisconnectNetworkMismatch is sent if remote is node of another network
+	DisconnectNetworkMismatch uint32 = 0x03
+	// DisconnectGenesisMismatch is sent if remote has different genesis block
+	DisconnectGenesisMismatch uint32 = 0x04
+	// DisconnectInvalidNodeRecord is sent if remote's node record is invalid
+	// or doesn't match its TLS certificate
+	DisconnectInvalidNodeRecord uint32 = 0x05
+	// DisconnectSelf is sent if node connected to itself
+	DisconnectSelf uint32 = 0x06
+	// DisconnectTooManyPeers is sent if node has no free peer slots
+	DisconnectTooManyPeers uint32 = 0x07
+	// DisconnectDuplicate is sent if node is already connected to remote
+	DisconnectDuplicate uint32 = 0x08
+	// DisconnectBanned is sent if remote is banned or its reputation fell below threshold
+	DisconnectBanned uint32 = 0x09
+	// DisconnectTimeout is sent if remote didn't respond to keep-alive Ping in time
+	DisconnectTimeout uint32 = 0x0A
+)
+
+// DisconnectReasonString returns human-readable description of disconnect reason code
+func DisconnectReasonString(reason uint32) string {
+	switch reason {
+	case DisconnectRequested:
+		return "disconnect requested"
+	case DisconnectProtocolError:
+		return "protocol error"
+	case DisconnectIncompatibleVersion:
+		return "incompatible protocol version"
+	case DisconnectNetworkMismatch:
+		return "network mismatch"
+	case DisconnectGenesisMismatch:
+		return "genesis block mismatch"
+	case DisconnectInvalidNodeRecord:
+		return "invalid node record"
+	case DisconnectSelf:
+		return "connected to self"
+	case DisconnectTooManyPeers:
+		return "too many peers"
+	case DisconnectDuplicate:
+		return "already connected"
+	case DisconnectBanned:
+		return "banned"
+	case DisconnectTimeout:
+		return "keep-alive timeout"
+	}
+	return fmt.Sprintf("unknown reason 0x%02X", reason)
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Block headers in BlockHeaders message are protobuf-encoded blockchain.Block
+	messages without Transactions field. TxHashes, ProofData and Signature are
+	included, so header chain can be validated before transactions are downloaded.
+*/
+
+// EncodeBlockHeader encodes block header for BlockHeaders message
+func EncodeBlockHeader(block blockchain.Block) ([]byte, error) {
+	block.Transactions = nil
+	return proto.Marshal(&block)
+}
+
+// DecodeBlockHeader decodes block header from BlockHeaders message
+func DecodeBlockHeader(data []byte) (*blockchain.Block, error) {
+	block := new(blockchain.Block)
+	if err := proto.Unmarshal(data, block); err != nil {
+		return nil, err
+	}
+	block.Transactions = nil
+	return block, nil
+}
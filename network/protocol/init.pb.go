@@ -22,12 +22,9 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type SealedNodeRecord struct {
 	//
-	//nodeRecord contains node data in binary format specified below:
-	//SeqID - 4 bytes, sequential ID of NodeRecord
-	//NodeID - 20 bytes, address of network node
-	//Network - 1 byte, allowed values are 0x04 and 0x06, for IPv4 and IPv6 accordingly
-	//Port - 2 bytes, little-endian integer
-	//IPAddress - 4 or 16 bytes, depends on value of `net`
+	//nodeRecord contains node data in versioned binary format specified in record.go:
+	//Version - 1 byte, SeqID - 4 bytes, PublicKey - 32 bytes,
+	//followed by sorted key/value entries with addresses, ports and capabilities
 	NodeRecord           []byte   `protobuf:"bytes,1,opt,name=nodeRecord,proto3" json:"nodeRecord,omitempty"`
 	Signature            []byte   `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -77,15 +74,19 @@ func (m *SealedNodeRecord) GetSignature() []byte {
 // Both sides send own HelloMessage with network and chain initial data
 // HelloMessage is connection initial message
 type HelloMessage struct {
-	ProtoVersion         uint32            `protobuf:"varint,1,opt,name=protoVersion,proto3" json:"protoVersion,omitempty"`
-	NetworkID            uint32            `protobuf:"varint,2,opt,name=networkID,proto3" json:"networkID,omitempty"`
-	GenesisHash          []byte            `protobuf:"bytes,3,opt,name=genesisHash,proto3" json:"genesisHash,omitempty"`
-	LastBlockHash        []byte            `protobuf:"bytes,4,opt,name=lastBlockHash,proto3" json:"lastBlockHash,omitempty"`
-	LastBlockIndex       uint64            `protobuf:"varint,5,opt,name=lastBlockIndex,proto3" json:"lastBlockIndex,omitempty"`
-	SealedNodeRecord     *SealedNodeRecord `protobuf:"bytes,7,opt,name=sealedNodeRecord,proto3" json:"sealedNodeRecord,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	ProtoVersion     uint32            `protobuf:"varint,1,opt,name=protoVersion,proto3" json:"protoVersion,omitempty"`
+	NetworkID        uint32            `protobuf:"varint,2,opt,name=networkID,proto3" json:"networkID,omitempty"`
+	GenesisHash      []byte            `protobuf:"bytes,3,opt,name=genesisHash,proto3" json:"genesisHash,omitempty"`
+	LastBlockHash    []byte            `protobuf:"bytes,4,opt,name=lastBlockHash,proto3" json:"lastBlockHash,omitempty"`
+	LastBlockIndex   uint64            `protobuf:"varint,5,opt,name=lastBlockIndex,proto3" json:"lastBlockIndex,omitempty"`
+	SealedNodeRecord *SealedNodeRecord `protobuf:"bytes,7,opt,name=sealedNodeRecord,proto3" json:"sealedNodeRecord,omitempty"`
+	// Temporary connection is used for single discovery request, it isn't registered as peer
+	Temporary bool `protobuf:"varint,8,opt,name=temporary,proto3" json:"temporary,omitempty"`
+	// Compression lists names of compression algorithms supported by node in order of preference
+	Compression          []string `protobuf:"bytes,9,rep,name=compression,proto3" json:"compression,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *HelloMessage) Reset()         { *m = HelloMessage{} }
@@ -155,6 +156,61 @@ func (m *HelloMessage) GetSealedNodeRecord() *SealedNodeRecord {
 	return nil
 }
 
+func (m *HelloMessage) GetTemporary() bool {
+	if m != nil {
+		return m.Temporary
+	}
+	return false
+}
+
+func (m *HelloMessage) GetCompression() []string {
+	if m != nil {
+		return m.Compression
+	}
+	return nil
+}
+
+// Disconnect is sent before connection is closed
+type Disconnect struct {
+	// Reason is code of disconnection reason, codes are specified in disconnect.go
+	Reason               uint32   `protobuf:"varint,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Disconnect) Reset()         { *m = Disconnect{} }
+func (m *Disconnect) String() string { return proto.CompactTextString(m) }
+func (*Disconnect) ProtoMessage()    {}
+func (*Disconnect) Descriptor() ([]byte, []int) {
+	return fileDescriptor_80b66ecdaf2ec123, []int{2}
+}
+
+func (m *Disconnect) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Disconnect.Unmarshal(m, b)
+}
+func (m *Disconnect) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Disconnect.Marshal(b, m, deterministic)
+}
+func (m *Disconnect) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Disconnect.Merge(m, src)
+}
+func (m *Disconnect) XXX_Size() int {
+	return xxx_messageInfo_Disconnect.Size(m)
+}
+func (m *Disconnect) XXX_DiscardUnknown() {
+	xxx_messageInfo_Disconnect.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Disconnect proto.InternalMessageInfo
+
+func (m *Disconnect) GetReason() uint32 {
+	if m != nil {
+		return m.Reason
+	}
+	return 0
+}
+
 // Ping is request for node record data
 type Ping struct {
 	// Tag is 32 bytes length data: xor(sha3(PONG_SENDER_ID), PING_SENDER_ID)
@@ -169,7 +225,7 @@ func (m *Ping) Reset()         { *m = Ping{} }
 func (m *Ping) String() string { return proto.CompactTextString(m) }
 func (*Ping) ProtoMessage()    {}
 func (*Ping) Descriptor() ([]byte, []int) {
-	return fileDescriptor_80b66ecdaf2ec123, []int{2}
+	return fileDescriptor_80b66ecdaf2ec123, []int{3}
 }
 
 func (m *Ping) XXX_Unmarshal(b []byte) error {
@@ -208,7 +264,11 @@ type Pong struct {
 	Tag              []byte            `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
 	NodeRecord       *SealedNodeRecord `protobuf:"bytes,2,opt,name=nodeRecord,proto3" json:"nodeRecord,omitempty"`
 	RecipientAddress string            `protobuf:"bytes,3,opt,name=recipientAddress,proto3" json:"recipientAddress,omitempty"`
-	// Signature is calculated over tag||nodeRecord||recipientAddress
+	// Nonce is copied from Ping, it is used to match Pong with Ping
+	Nonce uint64 `protobuf:"varint,4,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// Signature is calculated over SHA3(len(tag)||tag||len(nodeRecord)||nodeRecord||
+	// len(recipientAddress)||recipientAddress||nonce), nodeRecord is encoded record of
+	// SealedNodeRecord. Lengths are 4-byte and nonce is 8-byte little-endian integers
 	Signature            []byte   `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -219,7 +279,7 @@ func (m *Pong) Reset()         { *m = Pong{} }
 func (m *Pong) String() string { return proto.CompactTextString(m) }
 func (*Pong) ProtoMessage()    {}
 func (*Pong) Descriptor() ([]byte, []int) {
-	return fileDescriptor_80b66ecdaf2ec123, []int{3}
+	return fileDescriptor_80b66ecdaf2ec123, []int{4}
 }
 
 func (m *Pong) XXX_Unmarshal(b []byte) error {
@@ -261,6 +321,13 @@ func (m *Pong) GetRecipientAddress() string {
 	return ""
 }
 
+func (m *Pong) GetNonce() uint64 {
+	if m != nil {
+		return m.Nonce
+	}
+	return 0
+}
+
 func (m *Pong) GetSignature() []byte {
 	if m != nil {
 		return m.Signature
@@ -271,7 +338,7 @@ func (m *Pong) GetSignature() []byte {
 // FindNode is request for Neighbours message, it
 // should contain up to 16 nodes with nearest target
 type FindNode struct {
-	// Target is 32 byte length hash of target node
+	// Target is 20 byte length ID of target node
 	Target               []byte   `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -282,7 +349,7 @@ func (m *FindNode) Reset()         { *m = FindNode{} }
 func (m *FindNode) String() string { return proto.CompactTextString(m) }
 func (*FindNode) ProtoMessage()    {}
 func (*FindNode) Descriptor() ([]byte, []int) {
-	return fileDescriptor_80b66ecdaf2ec123, []int{4}
+	return fileDescriptor_80b66ecdaf2ec123, []int{5}
 }
 
 func (m *FindNode) XXX_Unmarshal(b []byte) error {
@@ -322,7 +389,7 @@ func (m *Neighbours) Reset()         { *m = Neighbours{} }
 func (m *Neighbours) String() string { return proto.CompactTextString(m) }
 func (*Neighbours) ProtoMessage()    {}
 func (*Neighbours) Descriptor() ([]byte, []int) {
-	return fileDescriptor_80b66ecdaf2ec123, []int{5}
+	return fileDescriptor_80b66ecdaf2ec123, []int{6}
 }
 
 func (m *Neighbours) XXX_Unmarshal(b []byte) error {
@@ -360,6 +427,7 @@ func (m *Neighbours) GetNodes() []*SealedNodeRecord {
 func init() {
 	proto.RegisterType((*SealedNodeRecord)(nil), "protocol.SealedNodeRecord")
 	proto.RegisterType((*HelloMessage)(nil), "protocol.HelloMessage")
+	proto.RegisterType((*Disconnect)(nil), "protocol.Disconnect")
 	proto.RegisterType((*Ping)(nil), "protocol.Ping")
 	proto.RegisterType((*Pong)(nil), "protocol.Pong")
 	proto.RegisterType((*FindNode)(nil), "protocol.FindNode")
@@ -369,28 +437,31 @@ func init() {
 func init() { proto.RegisterFile("protocol/init.proto", fileDescriptor_80b66ecdaf2ec123) }
 
 var fileDescriptor_80b66ecdaf2ec123 = []byte{
-	// 364 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x91, 0xcf, 0x4a, 0xeb, 0x40,
-	0x14, 0xc6, 0x49, 0x9a, 0xf6, 0xb6, 0xa7, 0xed, 0x25, 0xcc, 0xbd, 0x5c, 0xc2, 0x45, 0x24, 0x04,
-	0x91, 0xe2, 0x22, 0x8a, 0xee, 0xdc, 0x29, 0x52, 0xda, 0x85, 0xa5, 0x8c, 0xe2, 0x7e, 0x9a, 0x1c,
-	0xd2, 0xa1, 0x61, 0xa6, 0xcc, 0x4c, 0xd1, 0x47, 0xf0, 0x29, 0x7c, 0x56, 0xc9, 0x24, 0xfd, 0x93,
-	0x54, 0xe8, 0x2e, 0xdf, 0x2f, 0x67, 0xce, 0x9f, 0xef, 0x83, 0x3f, 0x6b, 0x25, 0x8d, 0x4c, 0x64,
-	0x7e, 0xcd, 0x05, 0x37, 0xb1, 0x55, 0xa4, 0xbb, 0x85, 0xd1, 0x1c, 0xfc, 0x17, 0x64, 0x39, 0xa6,
-	0x33, 0x99, 0x22, 0xc5, 0x44, 0xaa, 0x94, 0x9c, 0x03, 0x88, 0x9d, 0x0a, 0x9c, 0xd0, 0x19, 0x0d,
-	0xe8, 0x01, 0x21, 0x67, 0xd0, 0xd3, 0x3c, 0x13, 0xcc, 0x6c, 0x14, 0x06, 0xae, 0xfd, 0xbd, 0x07,
-	0xd1, 0xa7, 0x0b, 0x83, 0x09, 0xe6, 0xb9, 0x7c, 0x46, 0xad, 0x59, 0x86, 0x24, 0x82, 0x81, 0x1d,
-	0xf7, 0x86, 0x4a, 0x73, 0x29, 0x6c, 0xc3, 0x21, 0xad, 0xb1, 0xa2, 0xa5, 0x40, 0xf3, 0x2e, 0xd5,
-	0x6a, 0xfa, 0x64, 0x5b, 0x0e, 0xe9, 0x1e, 0x90, 0x10, 0xfa, 0x19, 0x0a, 0xd4, 0x5c, 0x4f, 0x98,
-	0x5e, 0x06, 0x2d, 0x3b, 0xf2, 0x10, 0x91, 0x0b, 0x18, 0xe6, 0x4c, 0x9b, 0xc7, 0x5c, 0x26, 0x2b,
-	0x5b, 0xe3, 0xd9, 0x9a, 0x3a, 0x24, 0x97, 0xf0, 0x7b, 0x07, 0xa6, 0x22, 0xc5, 0x8f, 0xa0, 0x1d,
-	0x3a, 0x23, 0x8f, 0x36, 0x28, 0x19, 0x83, 0xaf, 0x1b, 0xa6, 0x04, 0xbf, 0x42, 0x67, 0xd4, 0xbf,
-	0xfd, 0x1f, 0x6f, 0x9d, 0x8b, 0x9b, 0xb6, 0xd1, 0xa3, 0x37, 0x51, 0x0c, 0xde, 0x9c, 0x8b, 0x8c,
-	0xf8, 0xd0, 0x32, 0x2c, 0xab, 0x9c, 0x2c, 0x3e, 0xc9, 0x5f, 0x68, 0x0b, 0x29, 0x92, 0xd2, 0x3e,
-	0x8f, 0x96, 0x22, 0xfa, 0x72, 0xc0, 0x9b, 0xcb, 0x1f, 0x1f, 0xdc, 0xd7, 0x32, 0x71, 0x4f, 0x2e,
-	0x73, 0x98, 0xd7, 0x15, 0xf8, 0x0a, 0x13, 0xbe, 0xe6, 0x28, 0xcc, 0x43, 0x9a, 0x2a, 0xd4, 0xda,
-	0x7a, 0xd8, 0xa3, 0x47, 0xbc, 0x9e, 0x6d, 0xbb, 0x99, 0x6d, 0x04, 0xdd, 0x31, 0x17, 0x76, 0x0e,
-	0xf9, 0x07, 0x1d, 0xc3, 0x54, 0x86, 0xa6, 0x5a, 0xb3, 0x52, 0xd1, 0x2b, 0xc0, 0x0c, 0x79, 0xb6,
-	0x5c, 0xc8, 0x8d, 0xd2, 0xc5, 0xa1, 0x46, 0x1a, 0x96, 0x57, 0xa9, 0x97, 0x82, 0xdc, 0x14, 0xe7,
-	0xa7, 0xa8, 0x03, 0x37, 0x6c, 0x9d, 0x38, 0xa4, 0x2c, 0x5c, 0x74, 0x6c, 0xc5, 0xdd, 0x77, 0x00,
-	0x00, 0x00, 0xff, 0xff, 0xf2, 0x37, 0x5f, 0x3f, 0xcf, 0x02, 0x00, 0x00,
+	// 415 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x92, 0xdd, 0x6a, 0xd4, 0x40,
+	0x14, 0xc7, 0xc9, 0x6e, 0xb6, 0xee, 0x9e, 0xee, 0x4a, 0x18, 0x45, 0x82, 0x88, 0x84, 0xa1, 0x48,
+	0xf0, 0x22, 0x8a, 0xde, 0x79, 0xa7, 0x94, 0xd2, 0x5e, 0x58, 0x96, 0x51, 0xbc, 0x9f, 0x4e, 0x0e,
+	0xe9, 0xd0, 0x74, 0x4e, 0x98, 0x99, 0xa2, 0xbe, 0x97, 0x0f, 0xe1, 0x63, 0x49, 0x26, 0xe9, 0xe6,
+	0xa3, 0x42, 0xef, 0xf2, 0xff, 0xe5, 0xcc, 0xf9, 0xf8, 0x9f, 0x03, 0xcf, 0x1a, 0x4b, 0x9e, 0x14,
+	0xd5, 0xef, 0xb4, 0xd1, 0xbe, 0x08, 0x8a, 0xad, 0xef, 0x21, 0xdf, 0x43, 0xf2, 0x0d, 0x65, 0x8d,
+	0xe5, 0x25, 0x95, 0x28, 0x50, 0x91, 0x2d, 0xd9, 0x6b, 0x00, 0x73, 0x50, 0x69, 0x94, 0x45, 0xf9,
+	0x56, 0x8c, 0x08, 0x7b, 0x05, 0x1b, 0xa7, 0x2b, 0x23, 0xfd, 0x9d, 0xc5, 0x74, 0x11, 0x7e, 0x0f,
+	0x80, 0xff, 0x5d, 0xc0, 0xf6, 0x1c, 0xeb, 0x9a, 0xbe, 0xa2, 0x73, 0xb2, 0x42, 0xc6, 0x61, 0x1b,
+	0xca, 0xfd, 0x40, 0xeb, 0x34, 0x99, 0x90, 0x70, 0x27, 0x26, 0xac, 0x4d, 0x69, 0xd0, 0xff, 0x24,
+	0x7b, 0x73, 0x71, 0x1a, 0x52, 0xee, 0xc4, 0x00, 0x58, 0x06, 0xc7, 0x15, 0x1a, 0x74, 0xda, 0x9d,
+	0x4b, 0x77, 0x9d, 0x2e, 0x43, 0xc9, 0x31, 0x62, 0x27, 0xb0, 0xab, 0xa5, 0xf3, 0x5f, 0x6a, 0x52,
+	0x37, 0x21, 0x26, 0x0e, 0x31, 0x53, 0xc8, 0xde, 0xc0, 0xd3, 0x03, 0xb8, 0x30, 0x25, 0xfe, 0x4a,
+	0x57, 0x59, 0x94, 0xc7, 0x62, 0x46, 0xd9, 0x19, 0x24, 0x6e, 0x66, 0x4a, 0xfa, 0x24, 0x8b, 0xf2,
+	0xe3, 0x0f, 0x2f, 0x8b, 0x7b, 0xe7, 0x8a, 0xb9, 0x6d, 0xe2, 0xc1, 0x9b, 0x76, 0x2a, 0x8f, 0xb7,
+	0x0d, 0x59, 0x69, 0x7f, 0xa7, 0xeb, 0x2c, 0xca, 0xd7, 0x62, 0x00, 0xed, 0x54, 0x8a, 0x6e, 0x1b,
+	0x8b, 0x2e, 0xd8, 0xb2, 0xc9, 0x96, 0xf9, 0x46, 0x8c, 0x11, 0x3f, 0x01, 0x38, 0xd5, 0x4e, 0x91,
+	0x31, 0xa8, 0x3c, 0x7b, 0x01, 0x47, 0x16, 0xa5, 0x3b, 0x38, 0xd8, 0x2b, 0x5e, 0x40, 0xbc, 0xd7,
+	0xa6, 0x62, 0x09, 0x2c, 0xbd, 0xac, 0xfa, 0x7d, 0xb5, 0x9f, 0xec, 0x39, 0xac, 0x0c, 0x19, 0xd5,
+	0x2d, 0x29, 0x16, 0x9d, 0xe0, 0x7f, 0x22, 0x88, 0xf7, 0xf4, 0xdf, 0x07, 0x9f, 0x26, 0x9b, 0x5f,
+	0x3c, 0x3a, 0xf2, 0xf8, 0x2a, 0xde, 0x42, 0x62, 0x51, 0xe9, 0x46, 0xa3, 0xf1, 0x9f, 0xcb, 0xb2,
+	0x9d, 0x21, 0x6c, 0x6a, 0x23, 0x1e, 0xf0, 0xa1, 0xb1, 0x78, 0xd4, 0xd8, 0xf4, 0xae, 0x56, 0xf3,
+	0xbb, 0xe2, 0xb0, 0x3e, 0xd3, 0x26, 0x54, 0x6f, 0xad, 0xf0, 0xd2, 0x56, 0xe8, 0xfb, 0xe6, 0x7b,
+	0xc5, 0xbf, 0x03, 0x5c, 0xa2, 0xae, 0xae, 0xaf, 0xe8, 0xce, 0x86, 0x2a, 0x9e, 0xbc, 0xac, 0x7b,
+	0xbf, 0x3a, 0xc1, 0xde, 0xb7, 0xb5, 0x4b, 0x74, 0xe9, 0x22, 0x5b, 0x3e, 0x32, 0x5e, 0x17, 0x78,
+	0x75, 0x14, 0x22, 0x3e, 0xfe, 0x1b, 0x00, 0xd7, 0x33, 0xfc, 0x40, 0x4b, 0x03, 0x00, 0x00,
 }
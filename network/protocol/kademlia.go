@@ -1,113 +1,362 @@
 package protocol
 
 import (
+	"bytes"
+	"errors"
 	"math/bits"
+	"net"
+	"sync"
 )
 
-// KademliaTable is list of nodes in Buuzcoin network
+/*
+	Routing table consists of 160 k-buckets, node is placed in bucket with index
+	of highest differing bit of XOR distance between node ID and local ID.
+	Buckets are ordered from most-recently seen node (Head) to least-recently seen (Tail):
+	1. Known node is moved to front of its bucket on contact
+	2. New node is inserted in front of bucket if it isn't full
+	3. If bucket is full, node is added to replacement cache and least-recently seen
+	   node of bucket should be pinged. It is evicted only if ping fails,
+	   then most-recently seen node from replacement cache takes its place
+	To resist eclipse attacks count of nodes from same subnet (/24 for IPv4, /64 for IPv6)
+	is limited per bucket and per table. Loopback, private and unspecified addresses aren't limited.
+*/
+
+// BucketCount is count of k-buckets in routing table
+const BucketCount = 160
+
+const (
+	// BucketSubnetLimit is maximal count of nodes from same subnet in single bucket
+	BucketSubnetLimit = 2
+	// TableSubnetLimit is maximal count of nodes from same subnet in ActiveNodes
+	TableSubnetLimit = 10
+)
+
+var (
+	// ErrSelfNode is returned on insertion of local node in routing table
+	ErrSelfNode = errors.New("protocol: node is local node")
+	// ErrInvalidNodeID is returned on insertion of node with invalid ID
+	ErrInvalidNodeID = errors.New("protocol: invalid node ID")
+	// ErrSubnetLimit is returned if there are too many nodes from same subnet
+	ErrSubnetLimit = errors.New("protocol: too many nodes from same subnet")
+)
+
+// KademliaTable is list of nodes in Buuzcoin network, it is safe for concurrent use
 type KademliaTable struct {
 	// LocalID is ID of local node
 	LocalID []byte
 
-	ActiveNodes      [160]NodeList
-	ReplacementCache [160]NodeList
+	activeNodes      [BucketCount]NodeList
+	replacementCache [BucketCount]NodeList
+	// subnets contains count of nodes from each subnet in activeNodes
+	subnets map[string]int
+	lock    *sync.RWMutex
 }
 
-// FindBucketIndex returns index of bucket corresponding to distance between LocalID and nodeID
-func (routingTable *KademliaTable) FindBucketIndex(nodeID []byte) int {
-	if len(routingTable.LocalID) != 20 {
-		panic("protocol: KademliaTable.LocalID was not set")
+// NewKademliaTable creates empty routing table of node with localID
+func NewKademliaTable(localID []byte) *KademliaTable {
+	if len(localID) != 20 {
+		panic("protocol: invalid KademliaTable.LocalID")
+	}
+	return &KademliaTable{
+		LocalID: localID,
+		subnets: make(map[string]int),
+		lock:    &sync.RWMutex{},
 	}
+}
 
-	distance := 160
+// FindBucketIndex returns index of bucket corresponding to distance between LocalID and nodeID.
+// Returns -1 if nodeID is equal to LocalID
+func (routingTable *KademliaTable) FindBucketIndex(nodeID []byte) int {
 	for i := 0; i < 20; i++ {
-		dist := bits.LeadingZeros8(routingTable.LocalID[i] ^ nodeID[i])
-		distance -= dist
-		if dist != 8 {
-			break
+		if xor := routingTable.LocalID[i] ^ nodeID[i]; xor != 0 {
+			return BucketCount - 1 - i*8 - bits.LeadingZeros8(xor)
+		}
+	}
+	return -1
+}
+
+// subnetKey returns subnet of node address. Returns false if node address isn't limited
+func subnetKey(nodeRecord *NodeRecord) (string, bool) {
+	ip := nodeRecord.IP()
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || isPrivateIP(ip) {
+		return "", false
+	}
+	switch len(ip) {
+	case net.IPv4len:
+		return string(ip.Mask(net.CIDRMask(24, 32))), true
+	case net.IPv6len:
+		return string(ip.Mask(net.CIDRMask(64, 128))), true
+	}
+	return "", false
+}
+
+var privateNetworks = []*net.IPNet{
+	{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+	{IP: net.IP{172, 16, 0, 0}, Mask: net.CIDRMask(12, 32)},
+	{IP: net.IP{192, 168, 0, 0}, Mask: net.CIDRMask(16, 32)},
+	{IP: net.IP{0xFC, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Mask: net.CIDRMask(7, 128)},
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, privateNetwork := range privateNetworks {
+		if len(ip) == len(privateNetwork.IP) && privateNetwork.Contains(ip) {
+			return true
 		}
 	}
+	return false
+}
 
-	return distance
+// countSubnet returns count of nodes from subnet in list, except node with ignoredID
+func countSubnet(list *NodeList, subnet string, ignoredID []byte) int {
+	count := 0
+	for node := list.Head; node != nil; node = node.Next {
+		if nodeSubnet, limited := subnetKey(node.Record); limited && nodeSubnet == subnet &&
+			bytes.Compare(node.Key, ignoredID) != 0 {
+			count++
+		}
+	}
+	return count
 }
 
-// Insert adds node record in routing table
-// If bucket in ActiveNodes is full, it will be inserted
-// If bucket in ReplacementCache is full, nodeRecord will be ignored
-// Returns bool, whether nodeRecord was ignored
-func (routingTable *KademliaTable) Insert(nodeRecord *NodeRecord) bool {
+// checkSubnetLimits checks whether node can be placed in list.
+// tableLimit specifies whether TableSubnetLimit should be checked
+func (routingTable *KademliaTable) checkSubnetLimits(list *NodeList, nodeRecord *NodeRecord, tableLimit bool) error {
+	subnet, limited := subnetKey(nodeRecord)
+	if !limited {
+		return nil
+	}
+	if countSubnet(list, subnet, nodeRecord.NodeID) >= BucketSubnetLimit {
+		return ErrSubnetLimit
+	}
+	if tableLimit && routingTable.subnets[subnet] >= TableSubnetLimit {
+		return ErrSubnetLimit
+	}
+	return nil
+}
+
+func (routingTable *KademliaTable) addSubnet(nodeRecord *NodeRecord, delta int) {
+	if subnet, limited := subnetKey(nodeRecord); limited {
+		routingTable.subnets[subnet] += delta
+		if routingTable.subnets[subnet] == 0 {
+			delete(routingTable.subnets, subnet)
+		}
+	}
+}
+
+// updateActiveRecord replaces record of node in activeNodes if new record has higher SeqID
+func (routingTable *KademliaTable) updateActiveRecord(bucket *NodeList, node *NodeListItem, nodeRecord *NodeRecord) error {
+	if nodeRecord.SeqID <= node.Record.SeqID {
+		return nil
+	}
+	if !nodeRecord.IP().Equal(node.Record.IP()) {
+		routingTable.addSubnet(node.Record, -1)
+		if err := routingTable.checkSubnetLimits(bucket, nodeRecord, true); err != nil {
+			routingTable.addSubnet(node.Record, 1)
+			return err
+		}
+		routingTable.addSubnet(nodeRecord, 1)
+	}
+	node.Record = nodeRecord
+	return nil
+}
+
+// Insert adds node record in routing table or moves known node to front of its bucket.
+// Record of known node is replaced only if nodeRecord has higher SeqID.
+// If bucket in ActiveNodes is full, node is added to replacement cache and least-recently
+// seen node of bucket is returned. It should be pinged and evicted if it doesn't respond
+func (routingTable *KademliaTable) Insert(nodeRecord *NodeRecord) (*NodeRecord, error) {
+	if len(nodeRecord.NodeID) != 20 {
+		return nil, ErrInvalidNodeID
+	}
 	bucketIndex := routingTable.FindBucketIndex(nodeRecord.NodeID)
+	if bucketIndex < 0 {
+		return nil, ErrSelfNode
+	}
 
-	bucket := routingTable.ActiveNodes[bucketIndex]
+	routingTable.lock.Lock()
+	defer routingTable.lock.Unlock()
+
+	bucket := &routingTable.activeNodes[bucketIndex]
+	if node := bucket.Lookup(nodeRecord.NodeID); node != nil {
+		if err := routingTable.updateActiveRecord(bucket, node, nodeRecord); err != nil {
+			return nil, err
+		}
+		bucket.BringToFront(nodeRecord.NodeID)
+		return nil, nil
+	}
+
+	replacements := &routingTable.replacementCache[bucketIndex]
 	if bucket.Size < NodeListMaxSize {
+		if err := routingTable.checkSubnetLimits(bucket, nodeRecord, true); err != nil {
+			return nil, err
+		}
+		replacements.Remove(replacements.Lookup(nodeRecord.NodeID))
 		bucket.Insert(nodeRecord.NodeID, nodeRecord)
+		routingTable.addSubnet(nodeRecord, 1)
+		return nil, nil
+	}
+
+	// Bucket in ActiveNodes is full, node is kept in replacement cache
+	if err := routingTable.checkSubnetLimits(replacements, nodeRecord, false); err != nil {
+		return nil, err
+	}
+	if node := replacements.Lookup(nodeRecord.NodeID); node != nil {
+		if nodeRecord.SeqID > node.Record.SeqID {
+			node.Record = nodeRecord
+		}
+		replacements.BringToFront(nodeRecord.NodeID)
+	} else {
+		if replacements.Size >= NodeListMaxSize {
+			replacements.Remove(replacements.Tail)
+		}
+		replacements.Insert(nodeRecord.NodeID, nodeRecord)
+	}
+	return bucket.Tail.Record, nil
+}
+
+// Update replaces record of known node if nodeRecord has higher SeqID, node isn't moved in bucket.
+// Returns true if record was replaced
+func (routingTable *KademliaTable) Update(nodeRecord *NodeRecord) (bool, error) {
+	if len(nodeRecord.NodeID) != 20 {
+		return false, ErrInvalidNodeID
+	}
+	bucketIndex := routingTable.FindBucketIndex(nodeRecord.NodeID)
+	if bucketIndex < 0 {
+		return false, ErrSelfNode
+	}
+
+	routingTable.lock.Lock()
+	defer routingTable.lock.Unlock()
+
+	bucket := &routingTable.activeNodes[bucketIndex]
+	if node := bucket.Lookup(nodeRecord.NodeID); node != nil {
+		if nodeRecord.SeqID <= node.Record.SeqID {
+			return false, nil
+		}
+		if err := routingTable.updateActiveRecord(bucket, node, nodeRecord); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	replacements := &routingTable.replacementCache[bucketIndex]
+	if node := replacements.Lookup(nodeRecord.NodeID); node != nil && nodeRecord.SeqID > node.Record.SeqID {
+		node.Record = nodeRecord
+		return true, nil
+	}
+	return false, nil
+}
+
+// Evict removes node from ActiveNodes and replaces it with most-recently seen node from
+// replacement cache. Returns false if node isn't found
+func (routingTable *KademliaTable) Evict(nodeID []byte) bool {
+	if len(nodeID) != 20 {
+		return false
+	}
+	bucketIndex := routingTable.FindBucketIndex(nodeID)
+	if bucketIndex < 0 {
 		return false
 	}
 
-	replaceBucket := routingTable.ReplacementCache[bucketIndex]
-	// Bucket in ActiveNodes is full, check least-recently communicated nodes
-	if replaceBucket.Size < NodeListMaxSize {
-		replaceBucket.Insert(nodeRecord.NodeID, nodeRecord)
+	routingTable.lock.Lock()
+	defer routingTable.lock.Unlock()
+
+	bucket := &routingTable.activeNodes[bucketIndex]
+	replacements := &routingTable.replacementCache[bucketIndex]
+	if node := replacements.Lookup(nodeID); node != nil {
+		replacements.Remove(node)
+	}
+	node := bucket.Lookup(nodeID)
+	if node == nil {
 		return false
 	}
+	bucket.Remove(node)
+	routingTable.addSubnet(node.Record, -1)
+
+	for replacement := replacements.Head; replacement != nil; replacement = replacement.Next {
+		if routingTable.checkSubnetLimits(bucket, replacement.Record, true) == nil {
+			replacements.Remove(replacement)
+			// Replacement was seen after nodes in bucket, so it is placed in front
+			bucket.Insert(replacement.Key, replacement.Record)
+			routingTable.addSubnet(replacement.Record, 1)
+			break
+		}
+	}
 	return true
 }
 
-// LookupNearestNodes looks for nodes closest to targetID
-func (routingTable *KademliaTable) LookupNearestNodes(targetID []byte) []*NodeRecord {
-	bucket := routingTable.ActiveNodes[routingTable.FindBucketIndex(targetID)]
+// Get returns record of node from ActiveNodes, nil is returned if node isn't found
+func (routingTable *KademliaTable) Get(nodeID []byte) *NodeRecord {
+	if len(nodeID) != 20 {
+		return nil
+	}
+	bucketIndex := routingTable.FindBucketIndex(nodeID)
+	if bucketIndex < 0 {
+		return nil
+	}
 
-	results := make([]*NodeRecord, 0, bucket.Size)
-	node := bucket.Head
-	for node != nil {
-		results = append(results, node.Record)
-		node = node.Next
+	routingTable.lock.RLock()
+	defer routingTable.lock.RUnlock()
+	if node := routingTable.activeNodes[bucketIndex].Lookup(nodeID); node != nil {
+		return node.Record
 	}
+	return nil
+}
 
+// Len returns count of nodes in ActiveNodes
+func (routingTable *KademliaTable) Len() int {
+	routingTable.lock.RLock()
+	defer routingTable.lock.RUnlock()
+
+	size := 0
+	for i := range routingTable.activeNodes {
+		size += routingTable.activeNodes[i].Size
+	}
+	return size
+}
+
+// LookupNearestNodes looks for up to NodeListMaxSize nodes closest to targetID across all buckets
+func (routingTable *KademliaTable) LookupNearestNodes(targetID []byte) []*NodeRecord {
+	routingTable.lock.RLock()
+	var results []*NodeRecord
+	for i := range routingTable.activeNodes {
+		for node := routingTable.activeNodes[i].Head; node != nil; node = node.Next {
+			results = append(results, node.Record)
+		}
+	}
+	routingTable.lock.RUnlock()
+
+	SortByDistance(targetID, results)
+	if len(results) > NodeListMaxSize {
+		results = results[:NodeListMaxSize]
+	}
 	return results
 }
 
 // PingFn is function sending Ping message and waiting and verifying Pong message
 type PingFn = func(nodeRecord *NodeRecord) error
 
-// FlushReplacementCache checks ActiveNodes whether they are active, if they are
-// not, these nodes are replaced with alive nodes in ReplacementCache
-// Warning: this function is blocking
-func (routingTable *KademliaTable) FlushReplacementCache(ping PingFn) error {
-	for i := 0; i < 160; i++ {
-		currentBucket := routingTable.ReplacementCache[i]
-		if currentBucket.Size == 0 {
-			continue
-		}
-
-		node := currentBucket.Head
-		for node != nil {
-			nextNode := node.Next
-			if err := ping(node.Record); err != nil {
-				currentBucket.Remove(node)
-			}
-			node = nextNode
+// FlushReplacementCache pings least-recently seen nodes of buckets with non-empty
+// replacement cache. Nodes which don't respond are replaced with nodes from replacement cache,
+// responding nodes are moved to front of their buckets.
+// Warning: this function is blocking, routing table isn't locked during pings
+func (routingTable *KademliaTable) FlushReplacementCache(ping PingFn) {
+	var candidates []*NodeRecord
+	routingTable.lock.RLock()
+	for i := range routingTable.activeNodes {
+		if routingTable.replacementCache[i].Size != 0 && routingTable.activeNodes[i].Tail != nil {
+			candidates = append(candidates, routingTable.activeNodes[i].Tail.Record)
 		}
 	}
+	routingTable.lock.RUnlock()
 
-	for i := 0; i < 160; i++ {
-		currentBucket := routingTable.ActiveNodes[i]
-		replacementBucket := routingTable.ReplacementCache[i]
-		if replacementBucket.Size == 0 {
+	for _, candidate := range candidates {
+		if err := ping(candidate); err != nil {
+			routingTable.Evict(candidate.NodeID)
 			continue
 		}
 
-		node := currentBucket.Head
-		for node != nil {
-			nextNode := node.Next
-			if err := ping(node.Record); err != nil {
-				currentBucket.Remove(node)
-				node.Key = replacementBucket.Head.Key
-				node.Record = replacementBucket.Head.Record
-				replacementBucket.Remove(replacementBucket.Head)
-			}
-			node = nextNode
-		}
+		routingTable.lock.Lock()
+		routingTable.activeNodes[routingTable.FindBucketIndex(candidate.NodeID)].BringToFront(candidate.NodeID)
+		routingTable.lock.Unlock()
 	}
-	return nil
 }
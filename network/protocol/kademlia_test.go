@@ -0,0 +1,314 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func createLocalID(t *testing.T) []byte {
+	localID := make([]byte, 20)
+	if _, err := rand.Read(localID); err != nil {
+		t.Fatalf("rand.Read failed: %+v", err)
+	}
+	return localID
+}
+
+// createBucketNode creates node record which is placed in bucket with specific index,
+// IP address is public and unique for each node
+func createBucketNode(t *testing.T, localID []byte, bucketIndex int) *NodeRecord {
+	nodeID := make([]byte, 20)
+	if _, err := rand.Read(nodeID); err != nil {
+		t.Fatalf("rand.Read failed: %+v", err)
+	}
+	// Bits above bucketIndex are equal to local ID, bit with bucketIndex differs
+	for bit := BucketCount - 1; bit >= bucketIndex; bit-- {
+		byteIndex, mask := 19-bit/8, byte(1)<<(bit%8)
+		nodeID[byteIndex] = nodeID[byteIndex]&^mask | localID[byteIndex]&mask
+		if bit == bucketIndex {
+			nodeID[byteIndex] ^= mask
+		}
+	}
+	return &NodeRecord{NodeID: nodeID, IPv4: []byte{1, nodeID[17], nodeID[18], nodeID[19]}}
+}
+
+func TestFindBucketIndex(t *testing.T) {
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+	if index := routingTable.FindBucketIndex(localID); index != -1 {
+		t.Errorf("Unexpected bucket index of local node: %d", index)
+	}
+
+	nodeID := append([]byte{}, localID...)
+	nodeID[19] ^= 0x01
+	if index := routingTable.FindBucketIndex(nodeID); index != 0 {
+		t.Errorf("Unexpected bucket index of closest node: %d", index)
+	}
+	nodeID[0] ^= 0x80
+	if index := routingTable.FindBucketIndex(nodeID); index != BucketCount-1 {
+		t.Errorf("Unexpected bucket index of farthest node: %d", index)
+	}
+
+	for _, bucketIndex := range []int{1, 7, 8, 100, 158} {
+		node := createBucketNode(t, localID, bucketIndex)
+		if index := routingTable.FindBucketIndex(node.NodeID); index != bucketIndex {
+			t.Errorf("Unexpected bucket index: %d, expected %d", index, bucketIndex)
+		}
+	}
+}
+
+func TestKademliaInsert(t *testing.T) {
+	/*
+		1. Local node and node with invalid ID shouldn't be inserted
+		2. Nodes are inserted until bucket is full
+		3. Known node is moved to front of bucket on contact
+		4. Node inserted in full bucket is kept in replacement cache, least-recently seen node is returned
+	*/
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+	if _, err := routingTable.Insert(&NodeRecord{NodeID: localID}); err != ErrSelfNode {
+		t.Errorf("Local node was inserted: %+v", err)
+	}
+	if _, err := routingTable.Insert(&NodeRecord{NodeID: []byte{0x01}}); err != ErrInvalidNodeID {
+		t.Errorf("Node with invalid ID was inserted: %+v", err)
+	}
+
+	nodes := make([]*NodeRecord, NodeListMaxSize)
+	for i := range nodes {
+		nodes[i] = createBucketNode(t, localID, 150)
+		if lruNode, err := routingTable.Insert(nodes[i]); err != nil || lruNode != nil {
+			t.Fatalf("Insert failed: %+v", err)
+		}
+	}
+	if routingTable.Len() != NodeListMaxSize {
+		t.Fatalf("Unexpected routing table size: %d", routingTable.Len())
+	}
+
+	// nodes[0] is least-recently seen node, it is moved to front
+	if lruNode, err := routingTable.Insert(nodes[0]); err != nil || lruNode != nil {
+		t.Fatalf("Insert of known node failed: %+v", err)
+	}
+
+	replacement := createBucketNode(t, localID, 150)
+	lruNode, err := routingTable.Insert(replacement)
+	if err != nil {
+		t.Fatalf("Insert in full bucket failed: %+v", err)
+	}
+	if lruNode != nodes[1] {
+		t.Errorf("Unexpected least-recently seen node: %x", lruNode.NodeID)
+	}
+	if routingTable.Get(replacement.NodeID) != nil || routingTable.Len() != NodeListMaxSize {
+		t.Error("Node was inserted in full bucket")
+	}
+}
+
+func TestKademliaUpdateRecord(t *testing.T) {
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+	node := createBucketNode(t, localID, 10)
+	node.SeqID = 2
+	routingTable.Insert(node)
+
+	oldRecord := &NodeRecord{NodeID: node.NodeID, SeqID: 1, IPv4: []byte{2, 2, 2, 2}}
+	routingTable.Insert(oldRecord)
+	if routingTable.Get(node.NodeID) != node {
+		t.Error("Record was replaced with older one")
+	}
+
+	newRecord := &NodeRecord{NodeID: node.NodeID, SeqID: 3, IPv4: []byte{2, 2, 2, 2}}
+	routingTable.Insert(newRecord)
+	if routingTable.Get(node.NodeID) != newRecord {
+		t.Error("Record wasn't updated")
+	}
+}
+
+func TestKademliaEvict(t *testing.T) {
+	/*
+		1. Fill bucket and add 2 nodes in replacement cache
+		2. Evicted node should be replaced with most-recently seen replacement
+		3. Unknown node can't be evicted
+	*/
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+	nodes := make([]*NodeRecord, NodeListMaxSize)
+	for i := range nodes {
+		nodes[i] = createBucketNode(t, localID, 100)
+		routingTable.Insert(nodes[i])
+	}
+	replacements := []*NodeRecord{createBucketNode(t, localID, 100), createBucketNode(t, localID, 100)}
+	for _, replacement := range replacements {
+		routingTable.Insert(replacement)
+	}
+
+	if !routingTable.Evict(nodes[0].NodeID) {
+		t.Fatal("Node wasn't evicted")
+	}
+	if routingTable.Get(nodes[0].NodeID) != nil {
+		t.Error("Evicted node is still in routing table")
+	}
+	if routingTable.Get(replacements[1].NodeID) == nil || routingTable.Get(replacements[0].NodeID) != nil {
+		t.Error("Node wasn't replaced with most-recently seen replacement")
+	}
+	if routingTable.Len() != NodeListMaxSize {
+		t.Errorf("Unexpected routing table size: %d", routingTable.Len())
+	}
+
+	if routingTable.Evict(createBucketNode(t, localID, 100).NodeID) {
+		t.Error("Unknown node was evicted")
+	}
+	if routingTable.Evict(localID) {
+		t.Error("Local node was evicted")
+	}
+}
+
+func TestFlushReplacementCache(t *testing.T) {
+	/*
+		1. Fill bucket, add replacement node
+		2. Least-recently seen node doesn't respond to ping, it should be replaced
+		3. Responding node should be moved to front and kept
+	*/
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+	nodes := make([]*NodeRecord, NodeListMaxSize)
+	for i := range nodes {
+		nodes[i] = createBucketNode(t, localID, 42)
+		routingTable.Insert(nodes[i])
+	}
+	replacement := createBucketNode(t, localID, 42)
+	routingTable.Insert(replacement)
+
+	var pinged []*NodeRecord
+	routingTable.FlushReplacementCache(func(node *NodeRecord) error {
+		pinged = append(pinged, node)
+		return errors.New("node doesn't respond")
+	})
+	if len(pinged) != 1 || pinged[0] != nodes[0] {
+		t.Fatalf("Unexpected pinged nodes: %d", len(pinged))
+	}
+	if routingTable.Get(nodes[0].NodeID) != nil || routingTable.Get(replacement.NodeID) == nil {
+		t.Error("Node which doesn't respond wasn't replaced")
+	}
+
+	// Replacement cache is empty, nodes aren't pinged
+	routingTable.FlushReplacementCache(func(node *NodeRecord) error {
+		t.Error("Node was pinged with empty replacement cache")
+		return nil
+	})
+
+	routingTable.Insert(createBucketNode(t, localID, 42))
+	routingTable.FlushReplacementCache(func(node *NodeRecord) error {
+		if node != nodes[1] {
+			t.Errorf("Unexpected pinged node: %x", node.NodeID)
+		}
+		return nil
+	})
+	lruNode, _ := routingTable.Insert(createBucketNode(t, localID, 42))
+	if routingTable.Get(nodes[1].NodeID) == nil || lruNode != nodes[2] {
+		t.Error("Responding node wasn't moved to front")
+	}
+}
+
+func TestKademliaSubnetLimits(t *testing.T) {
+	/*
+		1. Only BucketSubnetLimit nodes from same subnet are inserted in bucket
+		2. Only TableSubnetLimit nodes from same subnet are inserted in routing table
+		3. Loopback and private addresses aren't limited
+	*/
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+
+	for i := 0; i < BucketSubnetLimit+1; i++ {
+		node := createBucketNode(t, localID, 20)
+		node.IPv4 = []byte{8, 8, 8, byte(i)}
+		_, err := routingTable.Insert(node)
+		if i < BucketSubnetLimit && err != nil {
+			t.Fatalf("Insert failed: %+v", err)
+		}
+		if i == BucketSubnetLimit && err != ErrSubnetLimit {
+			t.Errorf("Bucket subnet limit wasn't applied: %+v", err)
+		}
+	}
+
+	inserted := BucketSubnetLimit
+	for bucketIndex := 21; bucketIndex < 40; bucketIndex++ {
+		node := createBucketNode(t, localID, bucketIndex)
+		node.IPv4 = []byte{8, 8, 8, byte(bucketIndex)}
+		_, err := routingTable.Insert(node)
+		if inserted < TableSubnetLimit {
+			if err != nil {
+				t.Fatalf("Insert failed: %+v", err)
+			}
+			inserted++
+		} else if err != ErrSubnetLimit {
+			t.Fatalf("Table subnet limit wasn't applied: %+v", err)
+		}
+	}
+
+	for i, ip := range [][]byte{{127, 0, 0, 1}, {10, 0, 0, 1}, {192, 168, 1, 1}, {0, 0, 0, 0}} {
+		for j := 0; j < BucketSubnetLimit+1; j++ {
+			node := createBucketNode(t, localID, 60+i)
+			node.IPv4 = ip
+			if _, err := routingTable.Insert(node); err != nil {
+				t.Errorf("Node with address %v was limited: %+v", ip, err)
+			}
+		}
+	}
+}
+
+func TestKademliaLookupNearestNodes(t *testing.T) {
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+	var nodes []*NodeRecord
+	for bucketIndex := 0; bucketIndex < BucketCount; bucketIndex += 4 {
+		node := createBucketNode(t, localID, bucketIndex)
+		nodes = append(nodes, node)
+		routingTable.Insert(node)
+	}
+
+	target := createLocalID(t)
+	result := routingTable.LookupNearestNodes(target)
+	expected := closestNodes(target, nodes, NodeListMaxSize)
+	if len(result) != NodeListMaxSize {
+		t.Fatalf("Unexpected result size: %d", len(result))
+	}
+	for i := range expected {
+		if bytes.Compare(result[i].NodeID, expected[i].NodeID) != 0 {
+			t.Errorf("Unexpected node at position %d", i)
+		}
+	}
+}
+
+func TestKademliaConcurrentAccess(t *testing.T) {
+	localID := createLocalID(t)
+	routingTable := NewKademliaTable(localID)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				node := createBucketNode(t, localID, (worker*100+i)%BucketCount)
+				routingTable.Insert(node)
+				routingTable.LookupNearestNodes(node.NodeID)
+				if i%3 == 0 {
+					routingTable.Evict(node.NodeID)
+				}
+			}
+		}(worker)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			routingTable.FlushReplacementCache(func(*NodeRecord) error { return nil })
+		}
+	}()
+	wg.Wait()
+
+	if routingTable.Len() == 0 {
+		t.Error("Nodes weren't inserted")
+	}
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+/*
+	Iterative node lookup:
+	1. Lookup starts with k closest nodes to target known by local node
+	2. Alpha closest not queried nodes are asked for their nodes closest to target
+	3. Received nodes are merged into result set, which keeps k closest nodes
+	4. Lookup ends when all k closest nodes were queried
+	Nodes which failed to respond are removed from result set.
+*/
+
+// LookupAlpha is count of concurrent FindNode requests during lookup
+const LookupAlpha = 3
+
+// FindNodeFn sends FindNode request with target to node and returns received node records
+type FindNodeFn = func(node *NodeRecord, target []byte) ([]*NodeRecord, error)
+
+// CompareDistance compares XOR distances from target to a and b.
+// Returns -1 if a is closer, 1 if b is closer and 0 if distances are equal
+func CompareDistance(target, a, b []byte) int {
+	for i := 0; i < len(target) && i < len(a) && i < len(b); i++ {
+		distA := a[i] ^ target[i]
+		distB := b[i] ^ target[i]
+		if distA < distB {
+			return -1
+		}
+		if distA > distB {
+			return 1
+		}
+	}
+	return 0
+}
+
+// SortByDistance sorts node records by XOR distance to target
+func SortByDistance(target []byte, nodes []*NodeRecord) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return CompareDistance(target, nodes[i].NodeID, nodes[j].NodeID) < 0
+	})
+}
+
+type lookupEntry struct {
+	record  *NodeRecord
+	queried bool
+}
+
+// IterativeLookup finds up to NodeListMaxSize nodes closest to target, starting from seeds.
+// Node with localID is never queried or returned.
+func IterativeLookup(target, localID []byte, seeds []*NodeRecord, findNode FindNodeFn) []*NodeRecord {
+	var (
+		entries []*lookupEntry
+		seen    = make(map[string]bool)
+	)
+	addNodes := func(nodes []*NodeRecord) {
+		for _, node := range nodes {
+			if node == nil || seen[string(node.NodeID)] || bytes.Compare(node.NodeID, localID) == 0 {
+				continue
+			}
+			seen[string(node.NodeID)] = true
+			entries = append(entries, &lookupEntry{record: node})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return CompareDistance(target, entries[i].record.NodeID, entries[j].record.NodeID) < 0
+		})
+		if len(entries) > NodeListMaxSize {
+			entries = entries[:NodeListMaxSize]
+		}
+	}
+	addNodes(seeds)
+
+	for {
+		var queried []*lookupEntry
+		for _, entry := range entries {
+			if !entry.queried {
+				entry.queried = true
+				queried = append(queried, entry)
+				if len(queried) == LookupAlpha {
+					break
+				}
+			}
+		}
+		if len(queried) == 0 {
+			break
+		}
+
+		type response struct {
+			entry *lookupEntry
+			nodes []*NodeRecord
+			err   error
+		}
+		responses := make([]response, len(queried))
+		var wg sync.WaitGroup
+		wg.Add(len(queried))
+		for i, entry := range queried {
+			go func(i int, entry *lookupEntry) {
+				defer wg.Done()
+				nodes, err := findNode(entry.record, target)
+				responses[i] = response{entry, nodes, err}
+			}(i, entry)
+		}
+		wg.Wait()
+
+		for _, response := range responses {
+			if response.err != nil {
+				for i, entry := range entries {
+					if entry == response.entry {
+						entries = append(entries[:i], entries[i+1:]...)
+						break
+					}
+				}
+				continue
+			}
+			addNodes(response.nodes)
+		}
+	}
+
+	result := make([]*NodeRecord, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.record
+	}
+	return result
+}
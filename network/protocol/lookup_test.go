@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// createTestNodes creates records with random 20 byte node IDs
+func createTestNodes(t *testing.T, count int) []*NodeRecord {
+	nodes := make([]*NodeRecord, count)
+	for i := range nodes {
+		nodeID := make([]byte, 20)
+		if _, err := rand.Read(nodeID); err != nil {
+			t.Fatalf("rand.Read failed: %+v", err)
+		}
+		nodes[i] = &NodeRecord{NodeID: nodeID}
+	}
+	return nodes
+}
+
+func closestNodes(target []byte, nodes []*NodeRecord, count int) []*NodeRecord {
+	sorted := append([]*NodeRecord{}, nodes...)
+	SortByDistance(target, sorted)
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	return sorted
+}
+
+func TestCompareDistance(t *testing.T) {
+	target := []byte{0x0F, 0x00}
+	if CompareDistance(target, []byte{0x0E, 0xFF}, []byte{0x1F, 0x00}) != -1 {
+		t.Error("Closer node wasn't detected")
+	}
+	if CompareDistance(target, []byte{0x0F, 0x02}, []byte{0x0F, 0x01}) != 1 {
+		t.Error("Farther node wasn't detected")
+	}
+	if CompareDistance(target, []byte{0x0F, 0x01}, []byte{0x0F, 0x01}) != 0 {
+		t.Error("Equal distances weren't detected")
+	}
+}
+
+func TestIterativeLookup(t *testing.T) {
+	/*
+		1. Simulated network of 300 nodes, every node knows 16 closest nodes to any target
+		2. Lookup starts from 2 random nodes and should find 16 closest nodes to target
+		3. Local node is one of 16 closest nodes, it shouldn't be queried or returned
+	*/
+	nodes := createTestNodes(t, 300)
+	target := nodes[150].NodeID
+	// Local node is 6th closest node to target, responses contain one extra node to fill result
+	SortByDistance(target, nodes)
+	nodes[0], nodes[5] = nodes[5], nodes[0]
+	localNode := nodes[0]
+
+	var (
+		queried = make(map[string]int)
+		lock    = &sync.Mutex{}
+	)
+	findNode := func(node *NodeRecord, target []byte) ([]*NodeRecord, error) {
+		lock.Lock()
+		queried[string(node.NodeID)]++
+		lock.Unlock()
+		return closestNodes(target, nodes, NodeListMaxSize+1), nil
+	}
+
+	result := IterativeLookup(target, localNode.NodeID, []*NodeRecord{localNode, nodes[100], nodes[200]}, findNode)
+	expected := closestNodes(target, nodes[1:], NodeListMaxSize)
+	if len(result) != len(expected) {
+		t.Fatalf("Unexpected result size: %d", len(result))
+	}
+	for i := range expected {
+		if bytes.Compare(result[i].NodeID, expected[i].NodeID) != 0 {
+			t.Fatalf("Unexpected node at position %d", i)
+		}
+	}
+
+	if queried[string(localNode.NodeID)] != 0 {
+		t.Error("Local node was queried")
+	}
+	for nodeID, count := range queried {
+		if count != 1 {
+			t.Errorf("Node %x was queried %d times", nodeID, count)
+		}
+	}
+}
+
+func TestIterativeLookupFailedNodes(t *testing.T) {
+	/*
+		1. Half of nodes don't respond
+		2. Lookup should return only responding nodes
+	*/
+	nodes := createTestNodes(t, 100)
+	failed := make(map[string]bool)
+	for i := 0; i < len(nodes); i += 2 {
+		failed[string(nodes[i].NodeID)] = true
+	}
+	findNode := func(node *NodeRecord, target []byte) ([]*NodeRecord, error) {
+		if failed[string(node.NodeID)] {
+			return nil, errors.New("node doesn't respond")
+		}
+		return closestNodes(target, nodes, NodeListMaxSize), nil
+	}
+
+	target := make([]byte, 20)
+	result := IterativeLookup(target, nil, nodes[:4], findNode)
+	if len(result) == 0 {
+		t.Fatal("Lookup didn't find any nodes")
+	}
+	for _, node := range result {
+		if failed[string(node.NodeID)] {
+			t.Errorf("Failed node %x was returned", node.NodeID)
+		}
+	}
+}
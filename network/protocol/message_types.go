@@ -1,5 +1,10 @@
 package protocol
 
+import (
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/golang/protobuf/proto"
+)
+
 const (
 	// MessageGetBlockHeaders is ID for GetBlockHeaders message
 	MessageGetBlockHeaders byte = 0x01
@@ -17,6 +22,10 @@ const (
 	MessageGetNodeData byte = 0x07
 	// MessageNodeData is ID for NodeData message
 	MessageNodeData byte = 0x08
+	// MessageVote is ID for finality Vote message
+	MessageVote byte = 0x09
+	// MessageFinalityCertificate is ID for blockchain.FinalityCertificate message
+	MessageFinalityCertificate byte = 0x0A
 
 	// MessagePing is ID for Ping message
 	MessagePing byte = 0xF0
@@ -26,4 +35,109 @@ const (
 	MessageFindNode byte = 0xF2
 	// MessageNeighbours is ID for Neighbours message
 	MessageNeighbours byte = 0xF3
+	// MessageHello is ID for HelloMessage message
+	MessageHello byte = 0xF4
+	// MessageDisconnect is ID for Disconnect message
+	MessageDisconnect byte = 0xF5
+	// MessageNodeRecord is ID for SealedNodeRecord message sent when node record is updated
+	MessageNodeRecord byte = 0xF6
 )
+
+// MessageTypes maps message IDs to constructors of protobuf messages they carry
+var MessageTypes = map[byte]func() proto.Message{
+	MessageGetBlockHeaders:     func() proto.Message { return new(GetBlockHeaders) },
+	MessageBlockHeaders:        func() proto.Message { return new(BlockHeaders) },
+	MessageNewBlockHashes:      func() proto.Message { return new(NewBlockHashes) },
+	MessageGetTransactions:     func() proto.Message { return new(GetTransactions) },
+	MessageTransactions:        func() proto.Message { return new(Transactions) },
+	MessageNewBlock:            func() proto.Message { return new(NewBlock) },
+	MessageGetNodeData:         func() proto.Message { return new(GetNodeData) },
+	MessageNodeData:            func() proto.Message { return new(NodeData) },
+	MessageVote:                func() proto.Message { return new(Vote) },
+	MessageFinalityCertificate: func() proto.Message { return new(blockchain.FinalityCertificate) },
+
+	MessagePing:       func() proto.Message { return new(Ping) },
+	MessagePong:       func() proto.Message { return new(Pong) },
+	MessageFindNode:   func() proto.Message { return new(FindNode) },
+	MessageNeighbours: func() proto.Message { return new(Neighbours) },
+	MessageHello:      func() proto.Message { return new(HelloMessage) },
+	MessageDisconnect: func() proto.Message { return new(Disconnect) },
+	MessageNodeRecord: func() proto.Message { return new(SealedNodeRecord) },
+}
+
+// ResponseTypes maps IDs of request messages to IDs of their responses
+var ResponseTypes = map[byte]byte{
+	MessageGetBlockHeaders: MessageBlockHeaders,
+	MessageGetTransactions: MessageTransactions,
+	MessageGetNodeData:     MessageNodeData,
+	MessagePing:            MessagePong,
+	MessageFindNode:        MessageNeighbours,
+}
+
+// SetRequestID sets ID of request, which is copied to response
+func (m *GetBlockHeaders) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+// SetRequestID sets ID of request response belongs to
+func (m *BlockHeaders) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+// SetRequestID sets ID of request, which is copied to response
+func (m *GetTransactions) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+// SetRequestID sets ID of request response belongs to
+func (m *Transactions) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+// SetRequestID sets ID of request, which is copied to response
+func (m *GetNodeData) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+// SetRequestID sets ID of request response belongs to
+func (m *NodeData) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+// IDs of streams messages of sub-protocols are sent over
+const (
+	// StreamControl is stream of handshake, liveness and node record messages
+	StreamControl byte = 0x00
+	// StreamDiscovery is stream of node discovery messages
+	StreamDiscovery byte = 0x01
+	// StreamSync is stream of chain data requests and responses
+	StreamSync byte = 0x02
+	// StreamGossip is stream of block, transaction and vote propagation
+	StreamGossip byte = 0x03
+)
+
+// MessageStream returns ID of stream message with specific ID is sent over.
+// Unknown messages are sent over StreamControl
+func MessageStream(messageID byte) byte {
+	switch messageID {
+	case MessageGetBlockHeaders, MessageBlockHeaders, MessageGetNodeData, MessageNodeData:
+		return StreamSync
+	case MessageNewBlockHashes, MessageNewBlock, MessageGetTransactions, MessageTransactions,
+		MessageVote, MessageFinalityCertificate:
+		return StreamGossip
+	case MessageFindNode, MessageNeighbours:
+		return StreamDiscovery
+	}
+	return StreamControl
+}
+
+// MessageSizeLimits are maximal sizes of messages including message ID, other messages
+// are limited by maximal message size of transport
+var MessageSizeLimits = map[byte]int{
+	MessagePing:       16 * 1024,
+	MessagePong:       16 * 1024,
+	MessageHello:      16 * 1024,
+	MessageDisconnect: 1024,
+	MessageNodeRecord: 16 * 1024,
+	MessageFindNode:   1024,
+	MessageNeighbours: 256 * 1024,
+
+	MessageGetBlockHeaders:     1024,
+	MessageNewBlockHashes:      64 * 1024,
+	MessageGetTransactions:     256 * 1024,
+	MessageGetNodeData:         256 * 1024,
+	MessageVote:                1024,
+	MessageFinalityCertificate: 256 * 1024,
+	MessageNewBlock:            4 * 1024 * 1024,
+	MessageBlockHeaders:        8 * 1024 * 1024,
+	MessageTransactions:        8 * 1024 * 1024,
+	MessageNodeData:            8 * 1024 * 1024,
+}
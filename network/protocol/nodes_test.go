@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func checkNodeList(t *testing.T, list *NodeList, expectedKeys ...byte) {
+	if list.Size != len(expectedKeys) {
+		t.Fatalf("Unexpected list size: %d", list.Size)
+	}
+	node := list.Head
+	for i, key := range expectedKeys {
+		if node == nil || node.Key[0] != key {
+			t.Fatalf("Unexpected node at position %d", i)
+		}
+		if (i == 0) != (node.Prev == nil) {
+			t.Fatalf("Invalid Prev link at position %d", i)
+		}
+		if i == len(expectedKeys)-1 && (node != list.Tail || node.Next != nil) {
+			t.Fatal("Invalid list tail")
+		}
+		node = node.Next
+	}
+	if len(expectedKeys) == 0 && (list.Head != nil || list.Tail != nil) {
+		t.Fatal("Empty list has nodes")
+	}
+}
+
+func TestNodeList(t *testing.T) {
+	/*
+		1. Insert adds nodes in front of list, first inserted node is tail
+		2. BringToFront moves nodes from middle and tail
+		3. Remove unlinks head, tail and middle nodes
+	*/
+	list := new(NodeList)
+	for key := byte(1); key <= 4; key++ {
+		list.Insert([]byte{key}, &NodeRecord{})
+	}
+	checkNodeList(t, list, 4, 3, 2, 1)
+
+	if !list.BringToFront([]byte{2}) {
+		t.Fatal("Node wasn't found")
+	}
+	checkNodeList(t, list, 2, 4, 3, 1)
+	list.BringToFront([]byte{1})
+	checkNodeList(t, list, 1, 2, 4, 3)
+	list.BringToFront([]byte{1})
+	checkNodeList(t, list, 1, 2, 4, 3)
+	if list.BringToFront([]byte{5}) {
+		t.Error("Unknown node was found")
+	}
+
+	list.Remove(list.Lookup([]byte{4}))
+	checkNodeList(t, list, 1, 2, 3)
+	list.Remove(list.Tail)
+	checkNodeList(t, list, 1, 2)
+	list.Remove(list.Head)
+	checkNodeList(t, list, 2)
+	list.Remove(list.Head)
+	checkNodeList(t, list)
+	list.Remove(nil)
+	checkNodeList(t, list)
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+/*
+	Ping/Pong exchange:
+	Ping sender sends Ping with tag xor(sha3(pongSenderID), pingSenderID) and random nonce.
+	Pong sender verifies tag and responds with same tag and nonce, its sealed node record and
+	address of Ping sender as seen by Pong sender, Pong is signed with Pong sender's key.
+	Signed hash covers all fields of Pong, each variable-length field is prefixed with its length.
+	Ping sender verifies that tag and nonce match sent Ping, node record belongs to remote
+	and signature is valid.
+*/
+
+var (
+	// ErrInvalidPingTag is returned if tag of Ping or Pong doesn't match IDs of nodes
+	ErrInvalidPingTag = errors.New("protocol: invalid ping tag")
+	// ErrPongMismatch is returned if Pong nonce doesn't match nonce of sent Ping
+	ErrPongMismatch = errors.New("protocol: pong doesn't match ping")
+	// ErrInvalidPongSignature is returned if Pong has invalid signature or node record of other node
+	ErrInvalidPongSignature = errors.New("protocol: invalid pong signature")
+)
+
+// PingTag calculates tag of Ping and Pong messages: xor(sha3(pongSenderID), pingSenderID)
+func PingTag(pongSenderID, pingSenderID []byte) []byte {
+	tag := sha3.Sum256(pongSenderID)
+	for i := 0; i < len(pingSenderID) && i < len(tag); i++ {
+		tag[i] ^= pingSenderID[i]
+	}
+	return tag[:]
+}
+
+// PongHash calculates hash signed in Pong message:
+// SHA3(len(tag)||tag||len(nodeRecord)||nodeRecord||len(recipientAddress)||recipientAddress||nonce).
+// Lengths are 4-byte and nonce is 8-byte little-endian integers, so fields can't be shifted into each other
+func PongHash(pong *Pong) []byte {
+	hash := sha3.New256()
+	writeField := func(field []byte) {
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(field)))
+		hash.Write(length[:])
+		hash.Write(field)
+	}
+	writeField(pong.Tag)
+	writeField(pong.GetNodeRecord().GetNodeRecord())
+	writeField([]byte(pong.RecipientAddress))
+
+	var nonce [8]byte
+	binary.LittleEndian.PutUint64(nonce[:], pong.Nonce)
+	hash.Write(nonce[:])
+	return hash.Sum(nil)
+}
+
+// NewPing creates Ping message with random nonce sent from local node to remote node
+func NewPing(localID, remoteID []byte) (*Ping, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Ping{
+		Tag:   PingTag(remoteID, localID),
+		Nonce: binary.LittleEndian.Uint64(nonce),
+	}, nil
+}
+
+// VerifyPing checks whether Ping was sent by remote node to local node
+func VerifyPing(ping *Ping, localID, remoteID []byte) error {
+	if bytes.Compare(ping.Tag, PingTag(localID, remoteID)) != 0 {
+		return ErrInvalidPingTag
+	}
+	return nil
+}
+
+// NewPong creates Pong message responding to ping and signs it with private key of local node.
+// recipientAddress is address of Ping sender as seen by local node
+func NewPong(ping *Ping, sealedNodeRecord *SealedNodeRecord, recipientAddress string, privKey ed25519.PrivateKey) *Pong {
+	pong := &Pong{
+		Tag:              ping.Tag,
+		NodeRecord:       sealedNodeRecord,
+		RecipientAddress: recipientAddress,
+		Nonce:            ping.Nonce,
+	}
+	pong.Signature = ed25519.Sign(privKey, PongHash(pong))
+	return pong
+}
+
+// VerifyPong checks whether Pong responds to ping and is signed by node with publicKey,
+// returns node record of Pong sender
+func VerifyPong(pong *Pong, ping *Ping, publicKey ed25519.PublicKey) (*NodeRecord, error) {
+	if bytes.Compare(pong.Tag, ping.Tag) != 0 {
+		return nil, ErrInvalidPingTag
+	}
+	if pong.Nonce != ping.Nonce {
+		return nil, ErrPongMismatch
+	}
+	if pong.NodeRecord == nil || len(publicKey) != ed25519.PublicKeySize ||
+		!ed25519.Verify(publicKey, PongHash(pong), pong.Signature) {
+		return nil, ErrInvalidPongSignature
+	}
+
+	nodeRecord, err := pong.NodeRecord.Open()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Compare(nodeRecord.PublicKey, publicKey) != 0 {
+		return nil, ErrInvalidPongSignature
+	}
+	return nodeRecord, nil
+}
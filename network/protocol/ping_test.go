@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/network"
+)
+
+func TestPingPong(t *testing.T) {
+	/*
+		1. Ping created by node A is verified by node B, but not by other node
+		2. Pong of node B is verified by node A and contains record of node B
+		3. Pong with modified recipient address, other nonce or key of other node is rejected
+		4. Pong with nonce or field boundaries changed after signing is rejected
+	*/
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	otherPubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	idA, idB := createLocalID(t), network.DeriveAddress(pubKey)
+	record := &NodeRecord{PublicKey: pubKey, SeqID: 1, NodeID: idB, IPv4: []byte{8, 8, 8, 8}, QUICPort: 7000}
+	sealedRecord, err := record.Seal(privKey)
+	if err != nil {
+		t.Fatalf("Seal failed: %+v", err)
+	}
+
+	ping, err := NewPing(idA, idB)
+	if err != nil {
+		t.Fatalf("NewPing failed: %+v", err)
+	}
+	if err = VerifyPing(ping, idB, idA); err != nil {
+		t.Fatalf("VerifyPing failed: %+v", err)
+	}
+	if err = VerifyPing(ping, idA, idB); err != ErrInvalidPingTag {
+		t.Errorf("Ping was verified by sender: %+v", err)
+	}
+
+	pong := NewPong(ping, sealedRecord, "1.1.1.1:7000", privKey)
+	remoteRecord, err := VerifyPong(pong, ping, pubKey)
+	if err != nil {
+		t.Fatalf("VerifyPong failed: %+v", err)
+	}
+	if remoteRecord.SeqID != 1 || remoteRecord.Address() != "8.8.8.8:7000" {
+		t.Errorf("Unexpected node record: %+v", remoteRecord)
+	}
+
+	if _, err = VerifyPong(pong, ping, otherPubKey); err != ErrInvalidPongSignature {
+		t.Errorf("Pong was verified with key of other node: %+v", err)
+	}
+	otherPing, _ := NewPing(idA, idB)
+	if _, err = VerifyPong(pong, otherPing, pubKey); err != ErrPongMismatch {
+		t.Errorf("Pong was matched with other ping: %+v", err)
+	}
+	pong.RecipientAddress = "2.2.2.2:7000"
+	if _, err = VerifyPong(pong, ping, pubKey); err != ErrInvalidPongSignature {
+		t.Errorf("Modified pong was verified: %+v", err)
+	}
+
+	pong = NewPong(ping, sealedRecord, "1.1.1.1:7000", privKey)
+	ping.Nonce++
+	pong.Nonce++
+	if _, err = VerifyPong(pong, ping, pubKey); err != ErrInvalidPongSignature {
+		t.Errorf("Pong with modified nonce was verified: %+v", err)
+	}
+	ping.Nonce--
+	pong.Nonce--
+
+	// First byte of recipient address is moved to the end of node record, concatenation of fields isn't changed
+	pong.NodeRecord = &SealedNodeRecord{
+		NodeRecord: append(append([]byte{}, sealedRecord.NodeRecord...), pong.RecipientAddress[0]),
+		Signature:  sealedRecord.Signature,
+	}
+	pong.RecipientAddress = pong.RecipientAddress[1:]
+	if _, err = VerifyPong(pong, ping, pubKey); err != ErrInvalidPongSignature {
+		t.Errorf("Pong with shifted fields was verified: %+v", err)
+	}
+}
@@ -0,0 +1,332 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"golang.org/x/crypto/sha3"
+)
+
+/*
+	NodeRecord binary format, version 1:
+	Version   - 1 byte, NodeRecordVersion
+	SeqID     - 4 bytes, little-endian sequential ID of record
+	PublicKey - 32 bytes, ed25519 public key of node, NodeID is derived from it
+	Entries   - key/value pairs sorted by key, keys are unique:
+	            KeyLength (1 byte) | Key | ValueLength (2 bytes, little-endian) | Value
+	Known entries:
+	"caps" - capabilities, each is encoded as Length (1 byte) | Name
+	"ip4"  - 4 bytes, IPv4 address
+	"ip6"  - 16 bytes, IPv6 address, IPv4-mapped addresses aren't allowed
+	"quic" - 2 bytes, little-endian QUIC port
+	"udp"  - 2 bytes, little-endian UDP discovery port
+	Entries with unknown keys are kept in NodeRecord.Extra, so record can be re-encoded.
+	Record size is limited with MaxNodeRecordSize.
+*/
+
+// NodeRecordVersion is version of NodeRecord binary format
+const NodeRecordVersion = 1
+
+// MaxNodeRecordSize is maximal size of binary encoded NodeRecord
+const MaxNodeRecordSize = 300
+
+const (
+	// NetworkIPv4 is network of node with IPv4 address
+	NetworkIPv4 = 0x04
+	// NetworkIPv6 is network of node with IPv6 address
+	NetworkIPv6 = 0x06
+)
+
+const (
+	nodeRecordHeaderSize = 1 + 4 + ed25519.PublicKeySize
+	maxEntryKeySize      = 32
+
+	entryCapabilities = "caps"
+	entryIPv4         = "ip4"
+	entryIPv6         = "ip6"
+	entryQUICPort     = "quic"
+	entryUDPPort      = "udp"
+)
+
+var (
+	// ErrNodeRecordVersion is returned if record has unsupported version
+	ErrNodeRecordVersion = errors.New("protocol: unsupported node record version")
+	// ErrNodeRecordTooLarge is returned if record exceeds MaxNodeRecordSize
+	ErrNodeRecordTooLarge = errors.New("protocol: node record is too large")
+	// ErrMalformedNodeRecord is returned if record cannot be decoded
+	ErrMalformedNodeRecord = errors.New("protocol: malformed node record")
+	// ErrInvalidEntry is returned if record entry has invalid key or value
+	ErrInvalidEntry = errors.New("protocol: invalid node record entry")
+	// ErrInvalidSignature is returned if signature of sealed record is invalid
+	ErrInvalidSignature = errors.New("protocol: invalid node record signature")
+)
+
+// NodeRecord is structure containing node data
+type NodeRecord struct {
+	PublicKey []byte
+	SeqID     uint32
+	// NodeID is derived from PublicKey, it isn't encoded
+	NodeID []byte
+
+	IPv4         []byte
+	IPv6         []byte
+	QUICPort     uint16
+	UDPPort      uint16
+	Capabilities []string
+	// Extra contains entries with unknown keys
+	Extra map[string][]byte
+
+	// Sealed is signed record this NodeRecord was unsealed from
+	Sealed *SealedNodeRecord
+}
+
+// IP returns IPv4 address of node if it is set, IPv6 address otherwise
+func (nodeRecord NodeRecord) IP() net.IP {
+	if len(nodeRecord.IPv4) != 0 {
+		return net.IP(nodeRecord.IPv4)
+	}
+	return net.IP(nodeRecord.IPv6)
+}
+
+// Address returns QUIC address of node in host:port format
+func (nodeRecord NodeRecord) Address() string {
+	host := ""
+	if ip := nodeRecord.IP(); ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(nodeRecord.QUICPort)))
+}
+
+// HasCapability checks whether node has capability with specific name
+func (nodeRecord NodeRecord) HasCapability(name string) bool {
+	for _, capability := range nodeRecord.Capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
+
+type recordEntry struct {
+	key   string
+	value []byte
+}
+
+func encodePort(port uint16) []byte {
+	value := make([]byte, 2)
+	binary.LittleEndian.PutUint16(value, port)
+	return value
+}
+
+// entries returns record entries sorted by key
+func (nodeRecord NodeRecord) entries() ([]recordEntry, error) {
+	var entries []recordEntry
+	if len(nodeRecord.Capabilities) != 0 {
+		var value []byte
+		for _, capability := range nodeRecord.Capabilities {
+			if len(capability) == 0 || len(capability) > 255 {
+				return nil, ErrInvalidEntry
+			}
+			value = append(append(value, byte(len(capability))), capability...)
+		}
+		entries = append(entries, recordEntry{entryCapabilities, value})
+	}
+	if len(nodeRecord.IPv4) != 0 {
+		if len(nodeRecord.IPv4) != net.IPv4len {
+			return nil, ErrInvalidEntry
+		}
+		entries = append(entries, recordEntry{entryIPv4, nodeRecord.IPv4})
+	}
+	if len(nodeRecord.IPv6) != 0 {
+		if len(nodeRecord.IPv6) != net.IPv6len || net.IP(nodeRecord.IPv6).To4() != nil {
+			return nil, ErrInvalidEntry
+		}
+		entries = append(entries, recordEntry{entryIPv6, nodeRecord.IPv6})
+	}
+	if nodeRecord.QUICPort != 0 {
+		entries = append(entries, recordEntry{entryQUICPort, encodePort(nodeRecord.QUICPort)})
+	}
+	if nodeRecord.UDPPort != 0 {
+		entries = append(entries, recordEntry{entryUDPPort, encodePort(nodeRecord.UDPPort)})
+	}
+
+	for key, value := range nodeRecord.Extra {
+		switch key {
+		case entryCapabilities, entryIPv4, entryIPv6, entryQUICPort, entryUDPPort:
+			return nil, ErrInvalidEntry
+		}
+		if len(key) == 0 || len(key) > maxEntryKeySize {
+			return nil, ErrInvalidEntry
+		}
+		entries = append(entries, recordEntry{key, value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries, nil
+}
+
+// ToBytes encodes NodeRecord to binary format
+func (nodeRecord NodeRecord) ToBytes() ([]byte, error) {
+	if len(nodeRecord.PublicKey) != ed25519.PublicKeySize {
+		return nil, ErrMalformedNodeRecord
+	}
+	entries, err := nodeRecord.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, nodeRecordHeaderSize, MaxNodeRecordSize)
+	data[0] = NodeRecordVersion
+	binary.LittleEndian.PutUint32(data[1:5], nodeRecord.SeqID)
+	copy(data[5:], nodeRecord.PublicKey)
+	for _, entry := range entries {
+		if len(data)+1+len(entry.key)+2+len(entry.value) > MaxNodeRecordSize {
+			return nil, ErrNodeRecordTooLarge
+		}
+		data = append(append(data, byte(len(entry.key))), entry.key...)
+		data = append(append(data, encodePort(uint16(len(entry.value)))...), entry.value...)
+	}
+	return data, nil
+}
+
+// DecodeNodeRecord parses binary encoded NodeRecord.
+// Decoding is strict: entries should be sorted and known entries should have valid values
+func DecodeNodeRecord(data []byte) (*NodeRecord, error) {
+	if len(data) > MaxNodeRecordSize {
+		return nil, ErrNodeRecordTooLarge
+	}
+	if len(data) < nodeRecordHeaderSize {
+		return nil, ErrMalformedNodeRecord
+	}
+	if data[0] != NodeRecordVersion {
+		return nil, ErrNodeRecordVersion
+	}
+
+	nodeRecord := &NodeRecord{
+		SeqID:     binary.LittleEndian.Uint32(data[1:5]),
+		PublicKey: append([]byte{}, data[5:nodeRecordHeaderSize]...),
+	}
+	nodeRecord.NodeID = network.DeriveAddress(nodeRecord.PublicKey)
+
+	previousKey := ""
+	for offset := nodeRecordHeaderSize; offset < len(data); {
+		keySize := int(data[offset])
+		offset++
+		if keySize == 0 || keySize > maxEntryKeySize || offset+keySize+2 > len(data) {
+			return nil, ErrMalformedNodeRecord
+		}
+		key := string(data[offset : offset+keySize])
+		offset += keySize
+
+		valueSize := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+		offset += 2
+		if offset+valueSize > len(data) {
+			return nil, ErrMalformedNodeRecord
+		}
+		value := append([]byte{}, data[offset:offset+valueSize]...)
+		offset += valueSize
+
+		if previousKey != "" && key <= previousKey {
+			return nil, ErrMalformedNodeRecord
+		}
+		previousKey = key
+		if err := nodeRecord.setEntry(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return nodeRecord, nil
+}
+
+// setEntry sets field of NodeRecord corresponding to entry key
+func (nodeRecord *NodeRecord) setEntry(key string, value []byte) error {
+	switch key {
+	case entryCapabilities:
+		if len(value) == 0 {
+			return ErrInvalidEntry
+		}
+		for offset := 0; offset < len(value); {
+			size := int(value[offset])
+			offset++
+			if size == 0 || offset+size > len(value) {
+				return ErrInvalidEntry
+			}
+			nodeRecord.Capabilities = append(nodeRecord.Capabilities, string(value[offset:offset+size]))
+			offset += size
+		}
+	case entryIPv4:
+		if len(value) != net.IPv4len {
+			return ErrInvalidEntry
+		}
+		nodeRecord.IPv4 = value
+	case entryIPv6:
+		if len(value) != net.IPv6len || net.IP(value).To4() != nil {
+			return ErrInvalidEntry
+		}
+		nodeRecord.IPv6 = value
+	case entryQUICPort, entryUDPPort:
+		if len(value) != 2 || binary.LittleEndian.Uint16(value) == 0 {
+			return ErrInvalidEntry
+		}
+		if key == entryQUICPort {
+			nodeRecord.QUICPort = binary.LittleEndian.Uint16(value)
+		} else {
+			nodeRecord.UDPPort = binary.LittleEndian.Uint16(value)
+		}
+	default:
+		if nodeRecord.Extra == nil {
+			nodeRecord.Extra = make(map[string][]byte)
+		}
+		nodeRecord.Extra[key] = value
+	}
+	return nil
+}
+
+// Seal encodes and signs node record with private key of node
+func (nodeRecord NodeRecord) Seal(privKey ed25519.PrivateKey) (*SealedNodeRecord, error) {
+	if bytes.Compare(privKey.Public().(ed25519.PublicKey), nodeRecord.PublicKey) != 0 {
+		return nil, ErrInvalidSignature
+	}
+	data, err := nodeRecord.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha3.Sum256(data)
+	return &SealedNodeRecord{NodeRecord: data, Signature: ed25519.Sign(privKey, hash[:])}, nil
+}
+
+// Open verifies signature of sealed record and decodes it
+func (sealedNodeRecord SealedNodeRecord) Open() (*NodeRecord, error) {
+	if len(sealedNodeRecord.Signature) != ed25519.SignatureSize {
+		return nil, ErrInvalidSignature
+	}
+	nodeRecord, err := DecodeNodeRecord(sealedNodeRecord.NodeRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha3.Sum256(sealedNodeRecord.NodeRecord)
+	if !ed25519.Verify(nodeRecord.PublicKey, hash[:], sealedNodeRecord.Signature) {
+		return nil, ErrInvalidSignature
+	}
+	nodeRecord.Sealed = &SealedNodeRecord{
+		NodeRecord: sealedNodeRecord.NodeRecord,
+		Signature:  sealedNodeRecord.Signature,
+	}
+	return nodeRecord, nil
+}
+
+// Unseal verifies signature of sealed record and decodes it. Returns nil if record is invalid
+func (sealedNodeRecord SealedNodeRecord) Unseal() *NodeRecord {
+	nodeRecord, err := sealedNodeRecord.Open()
+	if err != nil {
+		return nil
+	}
+	return nodeRecord
+}
@@ -0,0 +1,201 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	mathrand "math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/network"
+)
+
+// randomNodeRecord generates record with random set of entries
+func randomNodeRecord(t testing.TB, random *mathrand.Rand) (*NodeRecord, ed25519.PrivateKey) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	record := &NodeRecord{PublicKey: pubKey, SeqID: random.Uint32(), NodeID: network.DeriveAddress(pubKey)}
+
+	if random.Intn(2) == 0 {
+		record.IPv4 = make([]byte, 4)
+		random.Read(record.IPv4)
+	}
+	if random.Intn(2) == 0 {
+		record.IPv6 = make([]byte, 16)
+		random.Read(record.IPv6)
+		record.IPv6[0] = 0x20
+	}
+	if random.Intn(2) == 0 {
+		record.QUICPort = uint16(random.Intn(65535) + 1)
+	}
+	if random.Intn(2) == 0 {
+		record.UDPPort = uint16(random.Intn(65535) + 1)
+	}
+	for i := random.Intn(3); i > 0; i-- {
+		capability := make([]byte, random.Intn(10)+1)
+		random.Read(capability)
+		record.Capabilities = append(record.Capabilities, string(capability))
+	}
+	for i := random.Intn(3); i > 0; i-- {
+		if record.Extra == nil {
+			record.Extra = make(map[string][]byte)
+		}
+		value := make([]byte, random.Intn(20))
+		random.Read(value)
+		record.Extra[string([]byte{'x', byte('a' + random.Intn(26))})] = value
+	}
+	return record, privKey
+}
+
+func TestNodeRecordEncoding(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	record := &NodeRecord{
+		PublicKey:    pubKey,
+		SeqID:        7,
+		NodeID:       network.DeriveAddress(pubKey),
+		IPv4:         []byte{8, 8, 4, 4},
+		QUICPort:     7000,
+		Capabilities: []string{"bzc/1"},
+	}
+	sealed, err := record.Seal(privKey)
+	if err != nil {
+		t.Fatalf("Seal failed: %+v", err)
+	}
+	decoded, err := sealed.Open()
+	if err != nil {
+		t.Fatalf("Open failed: %+v", err)
+	}
+	if decoded.Address() != "8.8.4.4:7000" || !decoded.HasCapability("bzc/1") || decoded.SeqID != 7 ||
+		bytes.Compare(decoded.NodeID, record.NodeID) != 0 {
+		t.Errorf("Unexpected decoded record: %+v", decoded)
+	}
+
+	// Signature should cover whole record
+	sealed.NodeRecord[len(sealed.NodeRecord)-1] ^= 0x01
+	if _, err = sealed.Open(); err != ErrInvalidSignature {
+		t.Errorf("Modified record was accepted: %+v", err)
+	}
+
+	// Record can't be sealed with key of other node
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err = record.Seal(otherKey); err == nil {
+		t.Error("Record was sealed with key of other node")
+	}
+}
+
+func TestDecodeNodeRecordStrict(t *testing.T) {
+	pubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+	header := append([]byte{NodeRecordVersion, 1, 0, 0, 0}, pubKey...)
+	entry := func(key string, value ...byte) []byte {
+		return append(append(append([]byte{byte(len(key))}, key...), byte(len(value)), 0), value...)
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, parts...), nil)
+	}
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"valid", join(entry("ip4", 1, 2, 3, 4), entry("quic", 0x58, 0x1B)), nil},
+		{"unsupported version", append([]byte{0x02}, header[1:]...), ErrNodeRecordVersion},
+		{"short header", header[:20], ErrMalformedNodeRecord},
+		{"unsorted entries", join(entry("quic", 0x58, 0x1B), entry("ip4", 1, 2, 3, 4)), ErrMalformedNodeRecord},
+		{"duplicate entries", join(entry("ip4", 1, 2, 3, 4), entry("ip4", 1, 2, 3, 4)), ErrMalformedNodeRecord},
+		{"truncated entry", join(entry("ip4", 1, 2, 3, 4))[:len(header)+5], ErrMalformedNodeRecord},
+		{"empty key", join(entry("", 1)), ErrMalformedNodeRecord},
+		{"invalid IPv4", join(entry("ip4", 1, 2, 3)), ErrInvalidEntry},
+		{"IPv4-mapped IPv6", join(entry("ip6", 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 1, 2, 3, 4)), ErrInvalidEntry},
+		{"zero port", join(entry("udp", 0, 0)), ErrInvalidEntry},
+		{"empty capability", join(entry("caps", 0)), ErrInvalidEntry},
+		{"too large", join(entry("xx", make([]byte, MaxNodeRecordSize)...)), ErrNodeRecordTooLarge},
+	}
+	for _, testCase := range testCases {
+		if _, err := DecodeNodeRecord(testCase.data); err != testCase.expected {
+			t.Errorf("%s: unexpected error: %v", testCase.name, err)
+		}
+	}
+}
+
+func FuzzNodeRecordRoundTrip(f *testing.F) {
+	/*
+		Records which can be encoded are sealed, opened and compared with original ones
+	*/
+	f.Add(uint32(1), []byte{8, 8, 8, 8}, []byte{}, uint16(7000), uint16(7000), "bzc/1", "xa", []byte{1})
+	f.Add(uint32(0), []byte{}, bytes.Repeat([]byte{0x20}, 16), uint16(0), uint16(1), "", "", []byte{})
+	f.Add(uint32(0xFFFFFFFF), []byte{127, 0, 0, 1}, bytes.Repeat([]byte{0x20}, 16), uint16(65535), uint16(0), "a", "xz", bytes.Repeat([]byte{0xFF}, 19))
+
+	privKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x01}, ed25519.SeedSize))
+	pubKey := privKey.Public().(ed25519.PublicKey)
+	f.Fuzz(func(t *testing.T, seqID uint32, ipv4, ipv6 []byte, quicPort, udpPort uint16, capability, extraKey string, extraValue []byte) {
+		record := &NodeRecord{PublicKey: pubKey, SeqID: seqID, NodeID: network.DeriveAddress(pubKey), QUICPort: quicPort, UDPPort: udpPort}
+		if len(ipv4) > 0 {
+			record.IPv4 = ipv4
+		}
+		if len(ipv6) > 0 {
+			record.IPv6 = ipv6
+		}
+		if len(capability) > 0 {
+			record.Capabilities = []string{capability}
+		}
+		if len(extraKey) > 0 {
+			record.Extra = map[string][]byte{extraKey: extraValue}
+		}
+
+		sealed, err := record.Seal(privKey)
+		if err != nil {
+			return
+		}
+		decoded, err := sealed.Open()
+		if err != nil {
+			t.Fatalf("Open failed: %+v", err)
+		}
+		decoded.Sealed = nil
+		if !reflect.DeepEqual(record, decoded) {
+			t.Fatalf("Decoded record differs:\n%+v\n%+v", record, decoded)
+		}
+	})
+}
+
+func FuzzDecodeNodeRecord(f *testing.F) {
+	/*
+		Arbitrary data shouldn't cause panic.
+		If data is decoded, its encoding should be identical, so encoding is canonical.
+		Seed corpus contains encodings of random records and their truncations
+	*/
+	random := mathrand.New(mathrand.NewSource(1))
+	for i := 0; i < 16; i++ {
+		record, _ := randomNodeRecord(f, random)
+		data, err := record.ToBytes()
+		if err != nil {
+			f.Fatalf("ToBytes failed: %+v", err)
+		}
+		f.Add(data)
+		f.Add(data[:random.Intn(len(data))])
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded, err := DecodeNodeRecord(data)
+		if err != nil {
+			return
+		}
+		encoded, err := decoded.ToBytes()
+		if err != nil {
+			t.Fatalf("Decoded record can't be encoded: %+v, data: %x", err, data)
+		}
+		if bytes.Compare(encoded, data) != 0 {
+			t.Fatalf("Encoding isn't canonical:\n%x\n%x", data, encoded)
+		}
+	})
+}
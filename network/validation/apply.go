@@ -1,14 +1,18 @@
 package validation
 
 import (
+	"encoding/hex"
+
 	"github.com/buuzcoin/go-buuzcoin/blockchain"
 	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
 	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
 )
 
-// applyTxInMemory evaluates transaction in memory and validates transaction's nonce
+// applyTxInMemory evaluates transaction in memory and validates transaction's nonce.
+// Transaction signature is expected to be checked before by PreverifyTransactions
 func applyTxInMemory(tx blockchain.TX, stateRoot *trie.MerkleTrieNode, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, uint64, bool, error) {
-	if valid, err := CheckTx(tx, func(address []byte) (*blockchain.AccountState, error) {
+	if valid, err := CheckTxState(tx, func(address []byte) (*blockchain.AccountState, error) {
 		return blockchain.GetAccountState(address, stateRoot, retrieve)
 	}); !valid || err != nil {
 		return nil, 0, valid, err
@@ -40,24 +44,48 @@ func applyTxInMemory(tx blockchain.TX, stateRoot *trie.MerkleTrieNode, retrieve
 	if err != nil {
 		return nil, 0, false, err
 	}
-	updatedChildren = append(updatedChildren, updatedChild)
+	updatedChildren = append(updatedChildren, updatedChild...)
 
 	updatedChild, err = recipientAccountState.Save(tx.To, stateRoot, retrieve)
 	if err != nil {
 		return nil, 0, false, err
 	}
-	updatedChildren = append(updatedChildren, updatedChild)
+	updatedChildren = append(updatedChildren, updatedChild...)
 
 	return updatedChildren, beneficiaryAmount, true, nil
 }
 
+func pendingLookup(pendingNodes map[string][]byte, retrieve trie.LookupFn) trie.LookupFn {
+	return func(key []byte) ([]byte, error) {
+		if data, exists := pendingNodes[hex.EncodeToString(key)]; exists {
+			return data, nil
+		}
+		return retrieve(key)
+	}
+}
+
+func addPendingNodes(pendingNodes map[string][]byte, updatedNodes []*trie.MerkleTrieNode) {
+	for _, node := range updatedNodes {
+		for ; node != nil; node = node.Parent {
+			pendingNodes[hex.EncodeToString(node.CalculateHash())] = node.ToBytes()
+		}
+	}
+}
+
 // ApplyBlockInMemory tries to evaluate block's transactions in memory
 // Returns changed children on success, whether if block is valid or error.
-// This function assumes that blocks were previously validated.
-func ApplyBlockInMemory(prevStateRoot []byte, block blockchain.Block, transactions []blockchain.TX, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, bool, error) {
-	var beneficiaryAmount uint64 = network.BlockReward(block.Index)
+// Consensus rewards are applied by proofAlgo after transactions evaluation.
+// This function assumes that blocks were previously validated and transactions
+// were checked by PreverifyTransactions.
+func ApplyBlockInMemory(prevStateRoot []byte, block blockchain.Block, transactions []blockchain.TX,
+	proofAlgo consensus.ProofAlgorithm, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, bool, error) {
+	var fees uint64
 	updatedChildren := make([]*trie.MerkleTrieNode, 0, len(transactions)*2+1)
 
+	// Updated nodes aren't saved to data source, so they are looked up in memory first
+	pendingNodes := make(map[string][]byte)
+	retrieve = pendingLookup(pendingNodes, retrieve)
+
 	stateRootBytes, err := retrieve(prevStateRoot)
 	if err != nil {
 		return nil, false, err
@@ -79,20 +107,15 @@ func ApplyBlockInMemory(prevStateRoot []byte, block blockchain.Block, transactio
 			return nil, false, nil
 		}
 		updatedChildren = append(updatedChildren, txUpdatedChildren...)
-		beneficiaryAmount += txBeneficiaryAmount
-	}
-
-	beneficiaryAccountState, err := blockchain.GetAccountState(block.Beneficiary, stateRoot, retrieve)
-	if err != nil {
-		return nil, false, err
+		addPendingNodes(pendingNodes, txUpdatedChildren)
+		fees += txBeneficiaryAmount
 	}
 
-	beneficiaryAccountState.Balance += beneficiaryAmount
-	updatedChild, err := beneficiaryAccountState.Save(block.Beneficiary, stateRoot, retrieve)
+	updatedChild, err := proofAlgo.Finalize(block, fees, stateRoot, retrieve)
 	if err != nil {
 		return nil, false, err
 	}
 
-	updatedChildren = append(updatedChildren, updatedChild)
+	updatedChildren = append(updatedChildren, updatedChild...)
 	return updatedChildren, true, nil
 }
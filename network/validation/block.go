@@ -64,6 +64,9 @@ func CheckGenesisBlock(block blockchain.Block) (bool, error) {
 		return false, ErrInvalidMerkleRoot
 	}
 
+	if len(block.Signature) != ed25519.SignatureSize+ed25519.PublicKeySize {
+		return false, ErrMalformedBlock
+	}
 	blockHash := block.CalculateHash()
 	pubKey := block.Signature[ed25519.SignatureSize:]
 	if bytes.Compare(network.DeriveAddress(pubKey), block.Beneficiary) != 0 {
@@ -119,6 +122,9 @@ func CheckBlock(block, prevBlock blockchain.Block) (bool, error) {
 		return false, ErrInvalidMerkleRoot
 	}
 
+	if len(block.Signature) != ed25519.SignatureSize+ed25519.PublicKeySize {
+		return false, ErrMalformedBlock
+	}
 	blockHash := block.CalculateHash()
 	pubKey := block.Signature[ed25519.SignatureSize:]
 	if bytes.Compare(network.DeriveAddress(pubKey), block.Beneficiary) != 0 {
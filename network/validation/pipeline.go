@@ -0,0 +1,166 @@
+package validation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/blockchain/trie"
+	"github.com/buuzcoin/go-buuzcoin/network/consensus"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Full block validation is performed in following stages:
+	1. Structure - block fields are checked by CheckBlock or CheckGenesisBlock
+	2. Consensus - block header is verified by proof algorithm
+	3. Transactions - block's transactions are decoded, matched with TxHashes
+	   and their signatures are pre-verified concurrently
+	4. Execution - transactions are evaluated on parent's state
+	5. State root - resulting state root is compared to block's StateMerkleRoot
+	Failed stage is reported with StageError.
+*/
+
+// Stage is stage of block validation pipeline
+type Stage int
+
+// Stages of block validation pipeline
+const (
+	StageStructure Stage = iota + 1
+	StageConsensus
+	StageTransactions
+	StageExecution
+	StageStateRoot
+)
+
+func (stage Stage) String() string {
+	switch stage {
+	case StageStructure:
+		return "structure"
+	case StageConsensus:
+		return "consensus"
+	case StageTransactions:
+		return "transactions"
+	case StageExecution:
+		return "execution"
+	case StageStateRoot:
+		return "state root"
+	}
+	return fmt.Sprintf("unknown(%d)", int(stage))
+}
+
+var (
+	// ErrTxMismatch is returned if block's transactions don't match TxHashes
+	ErrTxMismatch = errors.New("validation: transactions don't match block's tx hashes")
+	// ErrInvalidStateRoot is returned if state root after execution doesn't match block's StateMerkleRoot
+	ErrInvalidStateRoot = errors.New("validation: invalid state root")
+)
+
+// StageError is returned by ValidateAndExecute, it specifies which stage of validation failed
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (err *StageError) Error() string {
+	return fmt.Sprintf("validation: %s stage failed: %v", err.Stage, err.Err)
+}
+
+// Unwrap returns error which caused stage failure
+func (err *StageError) Unwrap() error {
+	return err.Err
+}
+
+func checkStructure(block blockchain.Block, parent *blockchain.Block) error {
+	var (
+		valid bool
+		err   error
+	)
+	if parent == nil {
+		valid, err = CheckGenesisBlock(block)
+	} else {
+		valid, err = CheckBlock(block, *parent)
+	}
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrMalformedBlock
+	}
+	return nil
+}
+
+func checkConsensus(block blockchain.Block, parent *blockchain.Block, proofAlgo consensus.ProofAlgorithm) error {
+	if parent != nil {
+		return proofAlgo.VerifyHeader(block, *parent)
+	}
+
+	valid, err := proofAlgo.IsValidBlock(block)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return consensus.ErrInvalidProof
+	}
+	return nil
+}
+
+// DecodeTransactions decodes block's transactions and checks whether if they match block's TxHashes
+func DecodeTransactions(block blockchain.Block) ([]blockchain.TX, error) {
+	if len(block.Transactions) != len(block.TxHashes) {
+		return nil, ErrTxMismatch
+	}
+
+	transactions := make([]blockchain.TX, len(block.Transactions))
+	for i, txData := range block.Transactions {
+		if err := proto.Unmarshal(txData, &transactions[i]); err != nil {
+			return nil, ErrMalformedTx
+		}
+		if bytes.Compare(transactions[i].Hash, transactions[i].CalculateHash()) != 0 {
+			return nil, ErrInvalidTxHash
+		}
+		if bytes.Compare(transactions[i].Hash, block.TxHashes[i]) != 0 {
+			return nil, ErrTxMismatch
+		}
+	}
+	return transactions, nil
+}
+
+// ValidateAndExecute fully validates block and evaluates its transactions on parent's state.
+// parent is nil for genesis block, parentStateRoot is state root after parent block.
+// Returns updated trie nodes on success or StageError.
+func ValidateAndExecute(block blockchain.Block, parent *blockchain.Block, parentStateRoot []byte,
+	proofAlgo consensus.ProofAlgorithm, retrieve trie.LookupFn) ([]*trie.MerkleTrieNode, error) {
+	if err := checkStructure(block, parent); err != nil {
+		return nil, &StageError{Stage: StageStructure, Err: err}
+	}
+	if err := checkConsensus(block, parent, proofAlgo); err != nil {
+		return nil, &StageError{Stage: StageConsensus, Err: err}
+	}
+
+	transactions, err := DecodeTransactions(block)
+	if err != nil {
+		return nil, &StageError{Stage: StageTransactions, Err: err}
+	}
+	if err = PreverifyTransactions(transactions, VerifiedTxs); err != nil {
+		return nil, &StageError{Stage: StageTransactions, Err: err}
+	}
+
+	updatedChildren, valid, err := ApplyBlockInMemory(parentStateRoot, block, transactions, proofAlgo, retrieve)
+	if err != nil {
+		return nil, &StageError{Stage: StageExecution, Err: err}
+	}
+	if !valid {
+		return nil, &StageError{Stage: StageExecution, Err: ErrRejectedTx}
+	}
+
+	stateRoot := parentStateRoot
+	if len(updatedChildren) > 0 {
+		stateRoot = updatedChildren[0].Root().CalculateHash()
+	}
+	if bytes.Compare(stateRoot, block.StateMerkleRoot) != 0 {
+		return nil, &StageError{Stage: StageStateRoot, Err: ErrInvalidStateRoot}
+	}
+	return updatedChildren, nil
+}
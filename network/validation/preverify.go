@@ -0,0 +1,159 @@
+package validation
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+)
+
+/*
+	Signature verification doesn't depend on state, so transactions of block
+	are pre-verified concurrently before state-dependent checks are performed.
+	Verified transactions are cached, so transactions verified on mempool
+	insertion aren't checked again on block import.
+
+	Signature isn't covered by transaction hash, so cache key is
+	transaction hash concatenated with signature. Hash is recomputed from
+	transaction fields on lookup, so transaction with forged fields and
+	hash of cached transaction isn't found in cache.
+*/
+
+// DefaultVerifiedTxCacheSize is capacity of VerifiedTxs cache
+const DefaultVerifiedTxCacheSize = 1 << 16
+
+// VerifiedTxCache stores keys of transactions which passed CheckTxSignature.
+// When cache is full, oldest entries are evicted
+type VerifiedTxCache struct {
+	entries  map[string]struct{}
+	order    []string
+	next     int
+	capacity int
+	lock     *sync.RWMutex
+}
+
+// VerifiedTxs is cache of verified transactions shared by mempool and block validation
+var VerifiedTxs = NewVerifiedTxCache(DefaultVerifiedTxCacheSize)
+
+// NewVerifiedTxCache creates empty cache holding up to capacity transactions
+func NewVerifiedTxCache(capacity int) *VerifiedTxCache {
+	return &VerifiedTxCache{
+		entries:  make(map[string]struct{}, capacity),
+		order:    make([]string, 0, capacity),
+		capacity: capacity,
+		lock:     &sync.RWMutex{},
+	}
+}
+
+// verifiedTxKey returns cache key of transaction, false is returned if transaction hash doesn't match its fields
+func verifiedTxKey(tx blockchain.TX) (string, bool) {
+	txHash := tx.CalculateHash()
+	return string(txHash) + string(tx.Signature), bytes.Compare(txHash, tx.Hash) == 0
+}
+
+// Contains checks whether if transaction was verified before
+func (cache *VerifiedTxCache) Contains(tx blockchain.TX) bool {
+	key, validHash := verifiedTxKey(tx)
+	if !validHash {
+		return false
+	}
+
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	_, exists := cache.entries[key]
+	return exists
+}
+
+// Add marks transaction as verified
+func (cache *VerifiedTxCache) Add(tx blockchain.TX) {
+	key, validHash := verifiedTxKey(tx)
+	if !validHash {
+		return
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if _, exists := cache.entries[key]; exists || cache.capacity == 0 {
+		return
+	}
+
+	if len(cache.order) < cache.capacity {
+		cache.order = append(cache.order, key)
+	} else {
+		delete(cache.entries, cache.order[cache.next])
+		cache.order[cache.next] = key
+		cache.next = (cache.next + 1) % cache.capacity
+	}
+	cache.entries[key] = struct{}{}
+}
+
+// Size returns count of cached transactions
+func (cache *VerifiedTxCache) Size() int {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	return len(cache.entries)
+}
+
+// PreverifyTransactions checks transactions with CheckTxSignature concurrently across CPU cores.
+// Transactions found in cache are skipped, verified ones are added to cache. Cache may be nil.
+// Returns first found error, ErrMalformedTx is returned for invalid transaction without error.
+func PreverifyTransactions(transactions []blockchain.TX, cache *VerifiedTxCache) error {
+	pending := make([]int, 0, len(transactions))
+	for i := range transactions {
+		if cache == nil || !cache.Contains(transactions[i]) {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		indexes  = make(chan int, len(pending))
+		failed   = make(chan struct{})
+	)
+	for _, i := range pending {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				select {
+				case <-failed:
+					return
+				default:
+				}
+
+				valid, err := CheckTxSignature(transactions[i])
+				if err == nil && !valid {
+					err = ErrMalformedTx
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+					return
+				}
+				if cache != nil {
+					cache.Add(transactions[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,71 @@
+package validation
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/buuzcoin/go-buuzcoin/blockchain"
+	"github.com/buuzcoin/go-buuzcoin/network"
+)
+
+func createSignedTx(t *testing.T, nonce uint64) blockchain.TX {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %+v", err)
+	}
+
+	tx := blockchain.TX{
+		Version:  network.CurrentTxVersion,
+		From:     network.DeriveAddress(pubKey),
+		To:       make([]byte, network.AddressSize),
+		Nonce:    nonce,
+		GasPrice: network.MinimalGasFee,
+	}
+	tx.Hash = tx.CalculateHash()
+	tx.Signature = append(ed25519.Sign(privKey, tx.Hash), pubKey...)
+	return tx
+}
+
+func TestPreverifyTransactions(t *testing.T) {
+	/*
+		1. Verify batch of valid transactions, all of them should be cached
+		2. Transaction with forged signature or forged fields should be rejected even if it has cached hash
+		3. Cache should evict oldest entries when it is full
+	*/
+	transactions := make([]blockchain.TX, 32)
+	for i := range transactions {
+		transactions[i] = createSignedTx(t, uint64(i+1))
+	}
+
+	cache := NewVerifiedTxCache(len(transactions))
+	if err := PreverifyTransactions(transactions, cache); err != nil {
+		t.Fatalf("PreverifyTransactions failed: %+v", err)
+	}
+	if cache.Size() != len(transactions) {
+		t.Fatalf("Unexpected cache size: %d", cache.Size())
+	}
+
+	forgedTx := transactions[5]
+	forgedTx.Signature = append([]byte{}, forgedTx.Signature...)
+	forgedTx.Signature[0] ^= 0xFF
+	if err := PreverifyTransactions(append(transactions, forgedTx), cache); err != ErrMalformedTx {
+		t.Errorf("Forged transaction passed verification: %+v", err)
+	}
+	forgedTx = transactions[6]
+	forgedTx.Amount++
+	if cache.Contains(forgedTx) {
+		t.Error("Transaction with forged fields was found in cache")
+	}
+	if err := PreverifyTransactions([]blockchain.TX{forgedTx}, cache); err != ErrInvalidTxHash {
+		t.Errorf("Transaction with forged fields passed verification: %+v", err)
+	}
+
+	newTx := createSignedTx(t, 1)
+	if err := PreverifyTransactions([]blockchain.TX{newTx}, cache); err != nil {
+		t.Fatalf("PreverifyTransactions failed: %+v", err)
+	}
+	if !cache.Contains(newTx) || cache.Contains(transactions[0]) || cache.Size() != len(transactions) {
+		t.Error("Oldest transaction wasn't evicted from cache")
+	}
+}
@@ -41,6 +41,15 @@ type GetAccountDataFn = func(address []byte) (*blockchain.AccountState, error)
 
 // CheckTx checks whether if transaction data is valid
 func CheckTx(tx blockchain.TX, getAccountData GetAccountDataFn) (bool, error) {
+	if valid, err := CheckTxSignature(tx); !valid || err != nil {
+		return valid, err
+	}
+	return CheckTxState(tx, getAccountData)
+}
+
+// CheckTxSignature performs state-independent checks of transaction:
+// transaction structure, hash, sender's address and signature
+func CheckTxSignature(tx blockchain.TX) (bool, error) {
 	if tx.Version > network.CurrentTxVersion {
 		return false, ErrTxUnsupported
 	}
@@ -58,14 +67,29 @@ func CheckTx(tx blockchain.TX, getAccountData GetAccountDataFn) (bool, error) {
 		return false, ErrMalformedTx
 	}
 
+	if bytes.Compare(tx.Hash, tx.CalculateHash()) != 0 {
+		return false, ErrInvalidTxHash
+	}
+
+	pubKey := tx.Signature[ed25519.SignatureSize:]
+	if bytes.Compare(network.DeriveAddress(pubKey), tx.From) != 0 {
+		return false, ErrMalformedTx
+	}
+	if !ed25519.Verify(pubKey, tx.Hash, tx.Signature[:ed25519.SignatureSize]) {
+		return false, ErrMalformedTx
+	}
+
+	return true, nil
+}
+
+// CheckTxState checks transaction against sender's account state.
+// Transaction must be checked with CheckTxSignature before
+func CheckTxState(tx blockchain.TX, getAccountData GetAccountDataFn) (bool, error) {
 	account, err := getAccountData(tx.From)
 	if err != nil {
 		return false, err
 	}
 
-	if bytes.Compare(tx.Hash, tx.CalculateHash()) != 0 {
-		return false, ErrInvalidTxHash
-	}
 	if tx.Nonce <= account.OutTxCounter {
 		return false, ErrRejectedTx
 	}
@@ -80,13 +104,5 @@ func CheckTx(tx blockchain.TX, getAccountData GetAccountDataFn) (bool, error) {
 		return false, ErrInsufficientFunds
 	}
 
-	pubKey := tx.Signature[ed25519.SignatureSize:]
-	if bytes.Compare(network.DeriveAddress(pubKey), tx.From) != 0 {
-		return false, ErrMalformedTx
-	}
-	if !ed25519.Verify(pubKey, tx.Hash, tx.Signature[:ed25519.SignatureSize]) {
-		return false, ErrMalformedTx
-	}
-
 	return true, nil
 }
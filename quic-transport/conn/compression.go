@@ -0,0 +1,90 @@
+package conn
+
+import (
+	"errors"
+
+	"github.com/golang/snappy"
+)
+
+/*
+	Compression:
+	Algorithm is negotiated by upper protocol, e.g. advertised in handshake, and enabled by
+	Peer.EnableCompression. Then messages with payload of at least CompressionThreshold bytes
+	are compressed by Send if compressed payload is smaller. Most significant bit of length
+	prefix of compressed message is set, message ID isn't compressed. Peer accepts compressed
+	messages of supported algorithm regardless of whether compression of sent messages is
+	enabled, so remote may compress messages as soon as it received list of supported algorithms.
+	Decompressed length is read from compressed payload and checked with message size limits
+	before payload is decompressed, so compressed messages can't exceed limits.
+*/
+
+// CompressionSnappy is name of Snappy compression algorithm
+const CompressionSnappy = "snappy"
+
+// SupportedCompression are names of supported compression algorithms in order of preference
+var SupportedCompression = []string{CompressionSnappy}
+
+// compressedFlag is set in length prefix of compressed message
+const compressedFlag uint32 = 1 << 31
+
+var (
+	// ErrUnsupportedCompression is returned by EnableCompression if algorithm isn't supported
+	ErrUnsupportedCompression = errors.New("peer: unsupported compression algorithm")
+	// ErrInvalidCompressedMessage is returned if remote sent message which can't be decompressed
+	ErrInvalidCompressedMessage = errors.New("peer: invalid compressed message")
+)
+
+// NegotiateCompression returns first algorithm of local list supported by remote,
+// empty string is returned if there is no such algorithm
+func NegotiateCompression(local, remote []string) string {
+	for _, algorithm := range local {
+		for _, remoteAlgorithm := range remote {
+			if algorithm == remoteAlgorithm {
+				return algorithm
+			}
+		}
+	}
+	return ""
+}
+
+// EnableCompression enables compression of sent messages with algorithm supported by remote
+func (peer *Peer) EnableCompression(algorithm string) error {
+	if algorithm != CompressionSnappy {
+		return ErrUnsupportedCompression
+	}
+	peer.compressionLock.Lock()
+	defer peer.compressionLock.Unlock()
+	peer.compression = algorithm
+	return nil
+}
+
+// compress returns compressed payload, false is returned if payload shouldn't be compressed
+func (peer *Peer) compress(payload []byte) ([]byte, bool) {
+	peer.compressionLock.RLock()
+	enabled := len(peer.compression) > 0
+	peer.compressionLock.RUnlock()
+	if !enabled || len(payload) < peer.config().CompressionThreshold {
+		return nil, false
+	}
+	compressed := snappy.Encode(nil, payload)
+	if len(compressed) >= len(payload) {
+		return nil, false
+	}
+	return compressed, true
+}
+
+// decompress decompresses payload of message with specific ID, decompressed size is checked before allocation
+func (peer *Peer) decompress(messageID byte, payload []byte) ([]byte, error) {
+	length, err := snappy.DecodedLen(payload)
+	if err != nil {
+		return nil, ErrInvalidCompressedMessage
+	}
+	if length+1 > peer.config().maxSize(messageID) {
+		return nil, ErrMessageTooLarge
+	}
+	decompressed, err := snappy.Decode(make([]byte, length), payload)
+	if err != nil {
+		return nil, ErrInvalidCompressedMessage
+	}
+	return decompressed, nil
+}
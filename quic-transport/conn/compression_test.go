@@ -0,0 +1,94 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/golang/snappy"
+)
+
+func TestNegotiateCompression(t *testing.T) {
+	if algorithm := NegotiateCompression([]string{"zstd", CompressionSnappy}, []string{CompressionSnappy, "zstd"}); algorithm != "zstd" {
+		t.Fatalf("Unexpected algorithm: %s", algorithm)
+	}
+	if algorithm := NegotiateCompression(SupportedCompression, []string{"zstd"}); algorithm != "" {
+		t.Fatalf("Unexpected algorithm: %s", algorithm)
+	}
+	if err := (&Peer{}).EnableCompression("zstd"); err != ErrUnsupportedCompression {
+		t.Fatalf("Unsupported algorithm was enabled: %+v", err)
+	}
+}
+
+func TestPeerCompression(t *testing.T) {
+	/*
+		1. Large messages are compressed, small messages aren't compressed
+		2. Compressed messages are decompressed by receiver
+		3. Connection is closed if decompressed size exceeds limit
+	*/
+	memNet := NewMemoryNetwork(1)
+	first, second := newMemoryTransport(t, memNet, "first"), newMemoryTransport(t, memNet, "second")
+	defer first.Close()
+	defer second.Close()
+	sender, receiver := memoryConnect(t, first, second)
+	receiver.Config = &PeerConfig{MaxMessageSize: 64 * 1024, MessageSizeLimits: map[byte]int{0x02: 4096}}
+	if err := sender.EnableCompression(CompressionSnappy); err != nil {
+		t.Fatalf("EnableCompression failed: %+v", err)
+	}
+
+	large := &wrappers.BytesValue{Value: bytes.Repeat([]byte("block"), 4096)}
+	if _, ok := sender.compress([]byte("small")); ok {
+		t.Fatal("Small message was compressed")
+	}
+	if _, ok := sender.compress(large.Value); !ok {
+		t.Fatal("Large message wasn't compressed")
+	}
+
+	if err := sender.Send(0x01, large); err != nil {
+		t.Fatalf("Send failed: %+v", err)
+	}
+	if err := sender.Send(0x01, &wrappers.BytesValue{Value: []byte("small")}); err != nil {
+		t.Fatalf("Send failed: %+v", err)
+	}
+	messageID, payload := receiver.ReadMessage()
+	received := new(wrappers.BytesValue)
+	if messageID != 0x01 || payload == nil {
+		t.Fatal("Compressed message wasn't received")
+	}
+	if err := proto.Unmarshal(payload, received); err != nil || !bytes.Equal(received.Value, large.Value) {
+		t.Fatal("Compressed message was corrupted")
+	}
+	if messageID, payload = receiver.ReadMessage(); messageID != 0x01 || payload == nil {
+		t.Fatal("Uncompressed message wasn't received")
+	}
+
+	// Compressed message fits limit of message type, but decompressed message doesn't
+	compressed := append([]byte{0x02}, snappy.Encode(nil, make([]byte, 8192))...)
+	frame := make([]byte, 4, 4+len(compressed))
+	binary.LittleEndian.PutUint32(frame, uint32(len(compressed))|compressedFlag)
+	stream, err := sender.sendStream(DefaultStream)
+	if err != nil {
+		t.Fatalf("sendStream failed: %+v", err)
+	}
+	stream.stream.Write(append(frame, compressed...))
+	select {
+	case <-readDone(receiver):
+	case <-time.After(time.Second):
+		t.Fatal("Connection wasn't closed after decompression bomb")
+	}
+	if receiver.Err() != ErrMessageTooLarge {
+		t.Fatalf("Unexpected cause of closing: %+v", receiver.Err())
+	}
+}
+
+// readDone reads messages from peer until connection is closed
+func readDone(peer *Peer) <-chan interface{} {
+	go func() {
+		for peer.Read() != nil {
+		}
+	}()
+	return peer.Done()
+}
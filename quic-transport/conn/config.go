@@ -0,0 +1,76 @@
+package conn
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+/*
+	Limits and timeouts:
+	Length prefix of received message is checked with MaxMessageSize before message is allocated,
+	then message ID is read and length is checked with limit of message type. Empty messages are
+	rejected. Rest of message should be received in ReadTimeout after its length prefix, message
+	should be sent in WriteTimeout. QUIC session is closed if nothing was received for IdleTimeout,
+	keep-alive packets are sent if KeepAlive is set. Size limits of compressed messages are
+	checked with both compressed and decompressed size. Connection is closed if limits are
+	violated, cause is returned by Peer.Err.
+*/
+
+var (
+	// ErrMessageTooLarge is returned if remote sent message exceeding size limit
+	ErrMessageTooLarge = errors.New("peer: message too large")
+	// ErrEmptyMessage is returned if remote sent message without message ID
+	ErrEmptyMessage = errors.New("peer: empty message")
+	// ErrReadTimeout is returned if message wasn't received in ReadTimeout
+	ErrReadTimeout = errors.New("peer: read timed out")
+	// ErrWriteTimeout is returned if message wasn't sent in WriteTimeout
+	ErrWriteTimeout = errors.New("peer: write timed out")
+)
+
+// PeerConfig specifies limits and timeouts of peer connection
+type PeerConfig struct {
+	// MaxMessageSize is maximal size of message including message ID
+	MaxMessageSize int
+	// MessageSizeLimits are maximal sizes of messages by message ID, they can't exceed MaxMessageSize
+	MessageSizeLimits map[byte]int
+	// ReadTimeout is maximal time of receiving message after its length prefix was received,
+	// reading isn't limited if it is zero
+	ReadTimeout time.Duration
+	// WriteTimeout is maximal time of sending message, writing isn't limited if it is zero
+	WriteTimeout time.Duration
+	// IdleTimeout is maximal time without incoming network activity
+	IdleTimeout time.Duration
+	// KeepAlive specifies whether keep-alive packets are sent, so session isn't closed by IdleTimeout
+	KeepAlive bool
+	// CompressionThreshold is minimal size of message payload compressed if compression is enabled
+	CompressionThreshold int
+}
+
+// DefaultPeerConfig is configuration used by peers without Config
+var DefaultPeerConfig = PeerConfig{
+	MaxMessageSize: 16 * 1024 * 1024,
+	ReadTimeout:    30 * time.Second,
+	WriteTimeout:   30 * time.Second,
+	IdleTimeout:    60 * time.Second,
+	KeepAlive:      true,
+
+	CompressionThreshold: 1024,
+}
+
+// QUICConfig returns QUIC configuration with idle timeout and keep-alive of peer configuration
+func (config *PeerConfig) QUICConfig() *quic.Config {
+	return &quic.Config{
+		IdleTimeout: config.IdleTimeout,
+		KeepAlive:   config.KeepAlive,
+	}
+}
+
+// maxSize returns maximal size of message with specific ID
+func (config *PeerConfig) maxSize(messageID byte) int {
+	if limit, exists := config.MessageSizeLimits[messageID]; exists && limit < config.MaxMessageSize {
+		return limit
+	}
+	return config.MaxMessageSize
+}
@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
@@ -10,35 +11,110 @@ import (
 	"github.com/pkg/errors"
 )
 
-// ErrInvalidCertificate is returned if server didn't send or sent invalid certificate
-var ErrInvalidCertificate = errors.New("dial: Invalid certificate")
+/*
+	Authentication:
+	Nodes are identified by self-signed Ed25519 certificates, node ID is derived from certificate key
+	by network.DeriveAddress. Possession of private key is proven by TLS handshake, so certificates
+	aren't verified by certificate authorities.
+	1. If expected node ID is passed to DialWithOptions, handshake is aborted when remote presented
+	   certificate of other node, so remote found in routing table can't be impersonated
+	2. If Certificate is passed to DialWithOptions, it is presented to remote. Listeners with mutual
+	   authentication require and verify client certificates, RemoteID of incoming peers is set
+*/
+
+var (
+	// ErrInvalidCertificate is returned if server didn't send or sent invalid certificate
+	ErrInvalidCertificate = errors.New("dial: Invalid certificate")
+	// ErrUnexpectedNode is returned if remote presented certificate of node other than expected
+	ErrUnexpectedNode = errors.New("dial: unexpected node ID")
+)
+
+// DialOptions specify authentication of outgoing connection
+type DialOptions struct {
+	// NodeID is expected ID of remote node, any node is accepted if it is nil
+	NodeID []byte
+	// Certificate is presented to remote, it is required by listeners with mutual authentication
+	Certificate *tls.Certificate
+	// Config specifies QUIC timeouts and limits of peer, DefaultPeerConfig is used if it is nil
+	Config *PeerConfig
+}
+
+// certificateKey returns Ed25519 key of single certificate presented by remote
+func certificateKey(rawCerts [][]byte) (ed25519.PublicKey, error) {
+	if len(rawCerts) != 1 {
+		return nil, ErrInvalidCertificate
+	}
+	certificate, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil || certificate.PublicKeyAlgorithm != x509.Ed25519 {
+		return nil, ErrInvalidCertificate
+	}
+	publicKey, ok := certificate.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrInvalidCertificate
+	}
+	return publicKey, nil
+}
+
+// VerifyCertificate checks that remote presented single Ed25519 certificate,
+// it is used as tls.Config.VerifyPeerCertificate by listeners with mutual authentication
+func VerifyCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	_, err := certificateKey(rawCerts)
+	return err
+}
 
 // Dial connects to UDP address provided.
 // If connection succeeded, RemotePublicKey and RemoteAddress are set
 func Dial(protoName, address string) (*Peer, error) {
-	session, err := quic.DialAddr(address, &tls.Config{
+	return DialWithOptions(protoName, address, &DialOptions{})
+}
+
+// DialNode connects to node with specific ID on UDP address provided.
+// ErrUnexpectedNode is returned if node on address has other ID
+func DialNode(protoName, address string, nodeID []byte) (*Peer, error) {
+	return DialWithOptions(protoName, address, &DialOptions{NodeID: nodeID})
+}
+
+// DialWithOptions connects to UDP address provided, remote is authenticated as specified by options.
+// If connection succeeded, RemotePublicKey and RemoteAddress are set, Config of peer is set to options.Config
+func DialWithOptions(protoName, address string, options *DialOptions) (*Peer, error) {
+	var remotePublicKey ed25519.PublicKey
+	var verifyErr error
+	tlsConfig := &tls.Config{
 		NextProtos:         []string{protoName},
 		InsecureSkipVerify: true,
-	}, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "quic.DialAddr failed")
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			remotePublicKey, verifyErr = certificateKey(rawCerts)
+			if verifyErr == nil && options.NodeID != nil && !bytes.Equal(network.DeriveAddress(remotePublicKey), options.NodeID) {
+				verifyErr = ErrUnexpectedNode
+			}
+			return verifyErr
+		},
+	}
+	if options.Certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*options.Certificate}
 	}
 
-	if len(session.ConnectionState().PeerCertificates) != 1 {
-		return nil, ErrInvalidCertificate
+	config := options.Config
+	if config == nil {
+		config = &DefaultPeerConfig
 	}
-	remoteCert := session.ConnectionState().PeerCertificates[0]
-	if remoteCert.PublicKeyAlgorithm != x509.Ed25519 {
-		return nil, ErrInvalidCertificate
+	session, err := quic.DialAddr(address, tlsConfig, config.QUICConfig())
+	if err != nil {
+		// Error returned by VerifyPeerCertificate is wrapped by QUIC handshake error
+		if verifyErr != nil {
+			return nil, verifyErr
+		}
+		return nil, errors.Wrap(err, "quic.DialAddr failed")
 	}
-	remotePublicKey, ok := remoteCert.PublicKey.(ed25519.PublicKey)
-	if !ok {
+	if remotePublicKey == nil {
+		session.Close()
 		return nil, ErrInvalidCertificate
 	}
 
 	peer := &Peer{
 		RemotePublicKey: remotePublicKey,
 		RemoteID:        network.DeriveAddress(remotePublicKey),
+		Config:          options.Config,
 
 		session: session,
 		done:    make(chan interface{}),
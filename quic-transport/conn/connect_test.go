@@ -1,10 +1,12 @@
 package conn
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"testing"
 
+	"github.com/buuzcoin/go-buuzcoin/network"
 	"github.com/lucas-clemente/quic-go"
 )
 
@@ -64,3 +66,67 @@ func TestConnect(t *testing.T) {
 		}
 	})
 }
+
+func certificateID(t *testing.T, cert *tls.Certificate) []byte {
+	publicKey, err := certificateKey(cert.Certificate)
+	if err != nil {
+		t.Fatalf("certificateKey failed: %+v\n", err)
+	}
+	return network.DeriveAddress(publicKey)
+}
+
+func TestDialNode(t *testing.T) {
+	/*
+		1. Dial to expected node succeeds, incoming peer of listener with mutual authentication has RemoteID
+		2. Dial is aborted if remote has unexpected node ID
+		3. Listener with mutual authentication rejects remote without client certificate
+	*/
+	transports := make([]*QUICTransport, 2)
+	for i := range transports {
+		cert, err := generateCertificate()
+		if err != nil {
+			t.Fatalf("generateCertificate failed: %+v\n", err)
+		}
+		transports[i] = &QUICTransport{
+			ProtocolName:   "quic-transport-auth-test",
+			TLSCertificate: *cert,
+		}
+		if err = transports[i].InitWithOptions(&TransportOptions{
+			ListenAddress:            "127.0.0.1:0",
+			RequireClientCertificate: true,
+		}); err != nil {
+			t.Fatalf("InitWithOptions failed: %+v\n", err)
+		}
+		defer transports[i].Close()
+	}
+	client, server := transports[0], transports[1]
+	serverID := certificateID(t, &server.TLSCertificate)
+
+	peer, err := client.Dial(server.Address.String(), serverID)
+	if err != nil {
+		t.Fatalf("Dial failed: %+v\n", err)
+	}
+	defer peer.Close()
+	if !bytes.Equal(peer.RemoteID, serverID) {
+		t.Fatal("Unexpected RemoteID of outgoing peer")
+	}
+	if !peer.Write([]byte("Hello!")) {
+		t.Fatal("peer.Write failed")
+	}
+	incoming := <-server.IncomingConnections
+	defer incoming.Close()
+	if !bytes.Equal(incoming.RemoteID, certificateID(t, &client.TLSCertificate)) {
+		t.Fatal("Unexpected RemoteID of incoming peer")
+	}
+
+	if _, err = client.Dial(server.Address.String(), certificateID(t, &client.TLSCertificate)); err != ErrUnexpectedNode {
+		t.Fatalf("Dial to unexpected node didn't fail: %+v\n", err)
+	}
+
+	if peer, err := DialNode("quic-transport-auth-test", server.Address.String(), serverID); err == nil {
+		defer peer.Close()
+		if peer.Read() != nil {
+			t.Fatal("Remote without client certificate was accepted")
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	Dispatcher decodes messages received from peers using registry of message types
+	and routes them to handlers registered by message ID.
+	1. Messages of each peer are read by Serve and handled by separate goroutine for each
+	   stream selected by StreamOf, so handlers of same stream are called in order of received
+	   messages, while messages of other streams and other peers are handled concurrently.
+	   Slow handler delays messages of other streams only when queue of its stream is full
+	2. Request sends CorrelatedMessage with unique request ID and waits for response
+	   with same request ID. Matched responses aren't passed to handlers. Request ID of
+	   received request is copied to response returned by handler, so handlers don't deal
+	   with request IDs. Messages with zero request ID and responses to timed out requests
+	   are passed to handlers
+	3. Raw handlers receive encoded payload, such messages are decoded by dispatcher
+	   only if their type is CorrelatedMessage
+	4. Peer is closed if it sent message of unknown type, message cannot be decoded
+	   or handler returned error. After handler error, queued messages of peer are dropped
+*/
+
+// DefaultRequestTimeout is time of waiting for response used if timeout passed to Request is zero
+const DefaultRequestTimeout = 10 * time.Second
+
+// DispatchQueueSize is count of messages of peer's stream waiting for handler,
+// reading from peer is paused when queue is full
+const DispatchQueueSize = 64
+
+var (
+	// ErrUnknownMessage is returned if message ID isn't registered in dispatcher
+	ErrUnknownMessage = errors.New("dispatcher: unknown message type")
+	// ErrRequestTimeout is returned if response wasn't received in time
+	ErrRequestTimeout = errors.New("dispatcher: request timed out")
+	// ErrPeerClosed is returned if connection was closed while waiting for response
+	ErrPeerClosed = errors.New("dispatcher: connection closed")
+	// ErrUncorrelatedRequest is returned by Request if request doesn't carry request ID
+	ErrUncorrelatedRequest = errors.New("dispatcher: request doesn't implement CorrelatedMessage")
+)
+
+// MessageConn is connection messages are dispatched from, it is implemented by Peer
+type MessageConn interface {
+	ReadMessage() (byte, []byte)
+	Send(messageID byte, message proto.Message) error
+	Close()
+	Done() <-chan interface{}
+}
+
+// CorrelatedMessage is request or response message carrying ID of request
+type CorrelatedMessage interface {
+	proto.Message
+	GetRequestID() uint64
+	SetRequestID(requestID uint64)
+}
+
+// MessageHandlerFn handles decoded message received from peer.
+// If response is not nil, it is sent to peer with responseID
+type MessageHandlerFn = func(peer MessageConn, message proto.Message) (responseID byte, response proto.Message, err error)
+
+// RawHandlerFn handles encoded payload of message received from peer.
+// If response is not nil, it is sent to peer with responseID
+type RawHandlerFn = func(peer MessageConn, payload []byte) (responseID byte, response proto.Message, err error)
+
+// pendingRequest is request waiting for response with specific message ID
+type pendingRequest struct {
+	responseID byte
+	response   chan proto.Message
+}
+
+// dispatchedPeer keeps requests of peer waiting for responses
+type dispatchedPeer struct {
+	// pending are requests waiting for response by request ID
+	pending map[uint64]pendingRequest
+	lock    sync.Mutex
+}
+
+// Dispatcher routes decoded messages of peers to registered handlers
+type Dispatcher struct {
+	// StreamOf selects stream message is handled in, all messages of peer are handled in order
+	// if it is nil. It should be set before Serve is called
+	StreamOf StreamFn
+
+	types       map[byte]func() proto.Message
+	correlated  map[byte]bool
+	handlers    map[byte]MessageHandlerFn
+	rawHandlers map[byte]RawHandlerFn
+	peers       map[MessageConn]*dispatchedPeer
+	// lastRequestID is ID of last sent request, it is accessed atomically
+	lastRequestID uint64
+	lock          *sync.RWMutex
+}
+
+// NewDispatcher creates dispatcher decoding messages with constructors of types by message ID
+func NewDispatcher(types map[byte]func() proto.Message) *Dispatcher {
+	dispatcher := &Dispatcher{
+		types:       make(map[byte]func() proto.Message, len(types)),
+		correlated:  make(map[byte]bool),
+		handlers:    make(map[byte]MessageHandlerFn),
+		rawHandlers: make(map[byte]RawHandlerFn),
+		peers:       make(map[MessageConn]*dispatchedPeer),
+		lock:        &sync.RWMutex{},
+	}
+	for messageID, newMessage := range types {
+		dispatcher.registerType(messageID, newMessage)
+	}
+	return dispatcher
+}
+
+// RegisterType registers constructor of protobuf message with specific ID
+func (dispatcher *Dispatcher) RegisterType(messageID byte, newMessage func() proto.Message) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	dispatcher.registerType(messageID, newMessage)
+}
+
+func (dispatcher *Dispatcher) registerType(messageID byte, newMessage func() proto.Message) {
+	dispatcher.types[messageID] = newMessage
+	_, correlated := newMessage().(CorrelatedMessage)
+	dispatcher.correlated[messageID] = correlated
+}
+
+// Handle registers handler of messages with specific ID, type of message should be registered
+func (dispatcher *Dispatcher) Handle(messageID byte, handler MessageHandlerFn) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	delete(dispatcher.rawHandlers, messageID)
+	dispatcher.handlers[messageID] = handler
+}
+
+// HandleRaw registers handler of encoded messages with specific ID
+func (dispatcher *Dispatcher) HandleRaw(messageID byte, handler RawHandlerFn) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	delete(dispatcher.handlers, messageID)
+	dispatcher.rawHandlers[messageID] = handler
+}
+
+// Decode decodes payload of message with specific ID
+func (dispatcher *Dispatcher) Decode(messageID byte, payload []byte) (proto.Message, error) {
+	dispatcher.lock.RLock()
+	newMessage := dispatcher.types[messageID]
+	dispatcher.lock.RUnlock()
+	if newMessage == nil {
+		return nil, ErrUnknownMessage
+	}
+
+	message := newMessage()
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, fmt.Errorf("dispatcher: failed to decode message 0x%02X: %v", messageID, err)
+	}
+	return message, nil
+}
+
+func (dispatcher *Dispatcher) handler(messageID byte) (MessageHandlerFn, RawHandlerFn) {
+	dispatcher.lock.RLock()
+	defer dispatcher.lock.RUnlock()
+	return dispatcher.handlers[messageID], dispatcher.rawHandlers[messageID]
+}
+
+// needsDecoding checks whether if message with specific ID is decoded before it is passed to handler
+func (dispatcher *Dispatcher) needsDecoding(messageID byte) bool {
+	dispatcher.lock.RLock()
+	defer dispatcher.lock.RUnlock()
+	_, raw := dispatcher.rawHandlers[messageID]
+	return !raw || dispatcher.correlated[messageID]
+}
+
+// peer returns state of peer, it is created if it doesn't exist
+func (dispatcher *Dispatcher) peer(peer MessageConn) *dispatchedPeer {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	state := dispatcher.peers[peer]
+	if state == nil {
+		state = &dispatchedPeer{pending: make(map[uint64]pendingRequest)}
+		dispatcher.peers[peer] = state
+	}
+	return state
+}
+
+// removePeer removes state of peer, pending requests receive ErrPeerClosed
+func (dispatcher *Dispatcher) removePeer(peer MessageConn) {
+	dispatcher.lock.Lock()
+	state := dispatcher.peers[peer]
+	delete(dispatcher.peers, peer)
+	dispatcher.lock.Unlock()
+	if state == nil {
+		return
+	}
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	for _, request := range state.pending {
+		close(request.response)
+	}
+	state.pending = make(map[uint64]pendingRequest)
+}
+
+// resolve passes response to request with same request ID, returns false if there is no such request
+func (state *dispatchedPeer) resolve(messageID byte, message proto.Message) bool {
+	correlated, ok := message.(CorrelatedMessage)
+	if !ok || correlated.GetRequestID() == 0 {
+		return false
+	}
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	request, exists := state.pending[correlated.GetRequestID()]
+	if !exists || request.responseID != messageID {
+		return false
+	}
+	request.response <- message
+	delete(state.pending, correlated.GetRequestID())
+	return true
+}
+
+// cancel removes request which didn't receive response
+func (state *dispatchedPeer) cancel(requestID uint64) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	delete(state.pending, requestID)
+}
+
+// Request sets unique request ID of request, sends it to peer and waits for message
+// with responseID and same request ID. If timeout is zero, DefaultRequestTimeout is used
+func (dispatcher *Dispatcher) Request(peer MessageConn, requestID byte, request proto.Message, responseID byte, timeout time.Duration) (proto.Message, error) {
+	correlated, ok := request.(CorrelatedMessage)
+	if !ok {
+		return nil, ErrUncorrelatedRequest
+	}
+	if timeout == 0 {
+		timeout = DefaultRequestTimeout
+	}
+	id := atomic.AddUint64(&dispatcher.lastRequestID, 1)
+	correlated.SetRequestID(id)
+
+	state := dispatcher.peer(peer)
+	response := make(chan proto.Message, 1)
+	state.lock.Lock()
+	state.pending[id] = pendingRequest{responseID: responseID, response: response}
+	state.lock.Unlock()
+
+	if err := peer.Send(requestID, request); err != nil {
+		state.cancel(id)
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case message, ok := <-response:
+		if !ok {
+			return nil, ErrPeerClosed
+		}
+		return message, nil
+	case <-timer.C:
+		state.cancel(id)
+		return nil, ErrRequestTimeout
+	case <-peer.Done():
+		state.cancel(id)
+		return nil, ErrPeerClosed
+	}
+}
+
+// dispatchedMessage is received message waiting for handler, message is nil if it wasn't decoded
+type dispatchedMessage struct {
+	id      byte
+	payload []byte
+	message proto.Message
+}
+
+// call passes message to handler registered for its ID
+func (dispatcher *Dispatcher) call(peer MessageConn, received dispatchedMessage) (byte, proto.Message, error) {
+	handler, rawHandler := dispatcher.handler(received.id)
+	if rawHandler != nil {
+		return rawHandler(peer, received.payload)
+	}
+	if handler == nil || received.message == nil {
+		return 0, nil, ErrUnknownMessage
+	}
+	return handler(peer, received.message)
+}
+
+// handlerFailure is closed when handler of any stream of peer returned error
+type handlerFailure struct {
+	failed chan struct{}
+	once   sync.Once
+}
+
+func (failure *handlerFailure) fail() {
+	failure.once.Do(func() { close(failure.failed) })
+}
+
+// handle calls handlers of messages from queue in order until queue is closed.
+// Peer is closed if handler returned error, after that messages of all streams
+// of peer are dropped without dispatching
+func (dispatcher *Dispatcher) handle(peer MessageConn, queue <-chan dispatchedMessage, failure *handlerFailure, done *sync.WaitGroup) {
+	defer done.Done()
+	for received := range queue {
+		select {
+		case <-failure.failed:
+			continue
+		default:
+		}
+
+		responseID, response, err := dispatcher.call(peer, received)
+		if err != nil {
+			log.Printf("Failed to handle message 0x%02X, closing connection: %+v", received.id, err)
+			failure.fail()
+			peer.Close()
+			continue
+		}
+		if response == nil {
+			continue
+		}
+		if request, ok := received.message.(CorrelatedMessage); ok {
+			if correlated, ok := response.(CorrelatedMessage); ok {
+				correlated.SetRequestID(request.GetRequestID())
+			}
+		}
+		peer.Send(responseID, response)
+	}
+}
+
+// Serve reads messages from peer and dispatches them until connection is closed.
+// Returns after all received messages are handled
+func (dispatcher *Dispatcher) Serve(peer MessageConn) {
+	state := dispatcher.peer(peer)
+	queues := make(map[byte]chan dispatchedMessage)
+	failure := &handlerFailure{failed: make(chan struct{})}
+	var handled sync.WaitGroup
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		handled.Wait()
+		dispatcher.removePeer(peer)
+	}()
+
+	for {
+		messageID, payload := peer.ReadMessage()
+		if payload == nil {
+			return
+		}
+
+		received := dispatchedMessage{id: messageID, payload: payload}
+		if dispatcher.needsDecoding(messageID) {
+			message, err := dispatcher.Decode(messageID, payload)
+			if err != nil {
+				log.Printf("Received invalid message 0x%02X, closing connection: %v", messageID, err)
+				peer.Close()
+				return
+			}
+			if state.resolve(messageID, message) {
+				continue
+			}
+			received.message = message
+		}
+
+		streamID := DefaultStream
+		if dispatcher.StreamOf != nil {
+			streamID = dispatcher.StreamOf(messageID)
+		}
+		queue := queues[streamID]
+		if queue == nil {
+			queue = make(chan dispatchedMessage, DispatchQueueSize)
+			queues[streamID] = queue
+			handled.Add(1)
+			go dispatcher.handle(peer, queue, failure, &handled)
+		}
+
+		select {
+		case queue <- received:
+		case <-peer.Done():
+			return
+		}
+	}
+}
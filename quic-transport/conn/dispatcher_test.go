@@ -0,0 +1,274 @@
+package conn
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// testConn is in-memory MessageConn connected to other testConn
+type testConn struct {
+	incoming  chan []byte
+	remote    *testConn
+	done      chan interface{}
+	closeOnce sync.Once
+}
+
+func newTestConnPair() (*testConn, *testConn) {
+	first := &testConn{incoming: make(chan []byte, 16), done: make(chan interface{})}
+	second := &testConn{incoming: make(chan []byte, 16), done: make(chan interface{}), remote: first}
+	first.remote = second
+	return first, second
+}
+
+func (conn *testConn) ReadMessage() (byte, []byte) {
+	select {
+	case data := <-conn.incoming:
+		return data[0], data[1:]
+	case <-conn.done:
+		return 0, nil
+	}
+}
+
+func (conn *testConn) Send(messageID byte, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return ErrMessageEncodingFailed
+	}
+	select {
+	case conn.remote.incoming <- append([]byte{messageID}, data...):
+		return nil
+	case <-conn.done:
+		return ErrPeerClosed
+	}
+}
+
+func (conn *testConn) Close() {
+	conn.closeOnce.Do(func() { close(conn.done) })
+}
+
+func (conn *testConn) Done() <-chan interface{} {
+	return conn.done
+}
+
+// testRequest is request and response message carrying request ID
+type testRequest struct {
+	Value     uint64 `protobuf:"varint,1,opt,name=value,proto3"`
+	RequestID uint64 `protobuf:"varint,2,opt,name=requestID,proto3"`
+}
+
+func (m *testRequest) Reset()                        { *m = testRequest{} }
+func (m *testRequest) String() string                { return proto.CompactTextString(m) }
+func (*testRequest) ProtoMessage()                   {}
+func (m *testRequest) GetRequestID() uint64          { return m.RequestID }
+func (m *testRequest) SetRequestID(requestID uint64) { m.RequestID = requestID }
+
+var testMessageTypes = map[byte]func() proto.Message{
+	0x01: func() proto.Message { return new(testRequest) },
+	0x02: func() proto.Message { return new(testRequest) },
+	0x03: func() proto.Message { return new(wrappers.StringValue) },
+}
+
+func TestDispatcherOrdering(t *testing.T) {
+	/*
+		1. Messages of peer are handled in order they were sent
+		2. Message of unknown type closes connection
+	*/
+	local, remote := newTestConnPair()
+	dispatcher := NewDispatcher(testMessageTypes)
+
+	var received []uint64
+	dispatcher.Handle(0x01, func(peer MessageConn, message proto.Message) (byte, proto.Message, error) {
+		received = append(received, message.(*testRequest).Value)
+		return 0, nil, nil
+	})
+
+	served := make(chan struct{})
+	go func() {
+		dispatcher.Serve(local)
+		close(served)
+	}()
+	for i := uint64(0); i < 100; i++ {
+		if err := remote.Send(0x01, &testRequest{Value: i}); err != nil {
+			t.Fatalf("Send failed: %+v", err)
+		}
+	}
+	remote.Send(0xFF, &testRequest{})
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("Connection wasn't closed after unknown message")
+	}
+	if len(received) != 100 {
+		t.Fatalf("Unexpected count of handled messages: %d", len(received))
+	}
+	for i, value := range received {
+		if value != uint64(i) {
+			t.Fatalf("Message %d was handled out of order", i)
+		}
+	}
+}
+
+func TestDispatcherRequest(t *testing.T) {
+	/*
+		1. Remote responds to concurrent requests, responses are matched with requests by request ID
+		2. Response isn't passed to handler, request ID is copied to response of raw handler
+		3. Request without request ID fails, request without response times out
+		4. Response without request ID is passed to handler
+		5. Pending request fails when connection is closed
+	*/
+	local, remote := newTestConnPair()
+	localDispatcher, remoteDispatcher := NewDispatcher(testMessageTypes), NewDispatcher(testMessageTypes)
+	remoteDispatcher.HandleRaw(0x01, func(peer MessageConn, payload []byte) (byte, proto.Message, error) {
+		request := new(testRequest)
+		if err := proto.Unmarshal(payload, request); err != nil {
+			return 0, nil, err
+		}
+		if request.Value == 0 {
+			return 0, nil, nil
+		}
+		return 0x02, &testRequest{Value: request.Value * 2}, nil
+	})
+	unsolicited := make(chan uint64, 1)
+	localDispatcher.Handle(0x02, func(peer MessageConn, message proto.Message) (byte, proto.Message, error) {
+		unsolicited <- message.(*testRequest).Value
+		return 0, nil, nil
+	})
+	go localDispatcher.Serve(local)
+	go remoteDispatcher.Serve(remote)
+
+	var wg sync.WaitGroup
+	for i := uint64(1); i <= 10; i++ {
+		wg.Add(1)
+		go func(value uint64) {
+			defer wg.Done()
+			response, err := localDispatcher.Request(local, 0x01, &testRequest{Value: value}, 0x02, time.Second)
+			if err != nil {
+				t.Errorf("Request failed: %+v", err)
+				return
+			}
+			if response.(*testRequest).Value != value*2 {
+				t.Errorf("Response %d was matched with request %d", response.(*testRequest).Value, value)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := localDispatcher.Request(local, 0x01, &wrappers.UInt64Value{Value: 1}, 0x02, time.Second); err != ErrUncorrelatedRequest {
+		t.Errorf("Request without request ID didn't fail: %+v", err)
+	}
+	if _, err := localDispatcher.Request(local, 0x01, &testRequest{}, 0x02, 50*time.Millisecond); err != ErrRequestTimeout {
+		t.Errorf("Request without response didn't time out: %+v", err)
+	}
+
+	remote.Send(0x02, &testRequest{Value: 42})
+	select {
+	case value := <-unsolicited:
+		if value != 42 {
+			t.Errorf("Unexpected message passed to handler: %d", value)
+		}
+	case <-time.After(time.Second):
+		t.Error("Message without request ID wasn't passed to handler")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		local.Close()
+	}()
+	if _, err := localDispatcher.Request(local, 0x01, &testRequest{}, 0x02, time.Second); err != ErrPeerClosed {
+		t.Errorf("Pending request didn't fail after connection was closed: %+v", err)
+	}
+}
+
+func TestDispatcherStreams(t *testing.T) {
+	/*
+		1. Handler of first stream blocks until message of second stream is handled
+		2. Messages of first stream are handled in order after handler is unblocked
+	*/
+	local, remote := newTestConnPair()
+	dispatcher := NewDispatcher(testMessageTypes)
+	dispatcher.StreamOf = func(messageID byte) byte {
+		if messageID == 0x03 {
+			return 1
+		}
+		return DefaultStream
+	}
+
+	unblock := make(chan struct{})
+	var received []uint64
+	dispatcher.Handle(0x01, func(peer MessageConn, message proto.Message) (byte, proto.Message, error) {
+		<-unblock
+		received = append(received, message.(*testRequest).Value)
+		return 0, nil, nil
+	})
+	dispatcher.Handle(0x03, func(peer MessageConn, message proto.Message) (byte, proto.Message, error) {
+		close(unblock)
+		return 0, nil, nil
+	})
+
+	served := make(chan struct{})
+	go func() {
+		dispatcher.Serve(local)
+		close(served)
+	}()
+	remote.Send(0x01, &testRequest{Value: 1})
+	remote.Send(0x01, &testRequest{Value: 2})
+	remote.Send(0x03, &wrappers.StringValue{Value: "unblock"})
+
+	select {
+	case <-unblock:
+	case <-time.After(time.Second):
+		t.Fatal("Message of second stream was delayed by handler of first stream")
+	}
+	local.Close()
+	<-served
+	if len(received) != 2 || received[0] != 1 || received[1] != 2 {
+		t.Fatalf("Messages of first stream weren't handled in order: %v", received)
+	}
+}
+
+func TestDispatcherHandlerError(t *testing.T) {
+	/*
+		1. Handler of first message blocks until following messages are queued, then returns error
+		2. Connection is closed, queued messages aren't passed to handler
+	*/
+	local, remote := newTestConnPair()
+	dispatcher := NewDispatcher(testMessageTypes)
+
+	release := make(chan struct{})
+	var received []uint64
+	dispatcher.Handle(0x01, func(peer MessageConn, message proto.Message) (byte, proto.Message, error) {
+		value := message.(*testRequest).Value
+		received = append(received, value)
+		if value == 0 {
+			<-release
+			return 0, nil, ErrUnknownMessage
+		}
+		return 0, nil, nil
+	})
+
+	served := make(chan struct{})
+	go func() {
+		dispatcher.Serve(local)
+		close(served)
+	}()
+	for i := uint64(0); i < 3; i++ {
+		remote.Send(0x01, &testRequest{Value: i})
+	}
+	// Messages are read by Serve and queued while handler is blocked
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("Connection wasn't closed after handler error")
+	}
+	if len(received) != 1 {
+		t.Errorf("Messages queued after handler error were handled: %v", received)
+	}
+}
@@ -35,10 +35,8 @@ func (transport *QUICTransport) listen(listener quic.Listener) {
 			if err != nil {
 				continue
 			}
-			peer = &Peer{
-				session: session,
-				done:    make(chan interface{}),
-			}
+			peer = NewPeer(session)
+			peer.Config = transport.config
 		}
 	}
 }
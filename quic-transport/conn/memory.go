@@ -0,0 +1,548 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buuzcoin/go-buuzcoin/network"
+	"github.com/lucas-clemente/quic-go"
+)
+
+/*
+	In-memory transport:
+	MemoryNetwork connects MemoryTransports of simulated nodes without sockets, so discovery,
+	sync and gossip can be tested deterministically. Connections implement quic.Session,
+	so they are used by Peer like QUIC sessions.
+	1. Connection is established after round trip, both sides present certificates,
+	   so RemoteID of incoming and outgoing peers is set
+	2. Every write to stream is delivered after one-way latency of network, writes to
+	   same stream are delivered in order
+	3. Write is dropped with probability of loss rate. Random numbers are generated from
+	   seed of network, so same writes are dropped if they are made in same order
+	4. Nodes in different partitions can't connect, writes between them are dropped
+	Peer writes every message at once, so loss drops whole messages. Unlike QUIC, lost
+	messages aren't retransmitted, loss simulates unreliable links and unresponsive peers.
+	Bidirectional streams aren't supported.
+*/
+
+// acceptQueueSize is count of accepted connections waiting to be read from MemoryTransport.Incoming,
+// Dial blocks when queue is full
+const acceptQueueSize = 16
+
+var (
+	// ErrUnreachable is returned if there is no transport on address or it is in other partition
+	ErrUnreachable = errors.New("memory transport: address unreachable")
+	// ErrAddressInUse is returned if transport on address already exists
+	ErrAddressInUse = errors.New("memory transport: address in use")
+	// ErrTransportClosed is returned by Dial of closed transport
+	ErrTransportClosed = errors.New("memory transport: transport closed")
+
+	errSessionClosed  = errors.New("memory transport: session closed")
+	errStreamClosed   = errors.New("memory transport: stream closed")
+	errBidirectional  = errors.New("memory transport: bidirectional streams aren't supported")
+	errTooManyStreams = errors.New("memory transport: too many streams")
+)
+
+// MemoryNetwork is simulated network connecting MemoryTransports by address
+type MemoryNetwork struct {
+	transports map[string]*MemoryTransport
+	latency    time.Duration
+	lossRate   float64
+	random     *rand.Rand
+	// partitions are groups of addresses by address, addresses which aren't listed are in group 0
+	partitions map[string]int
+	lock       sync.Mutex
+}
+
+// NewMemoryNetwork creates network without latency, loss and partitions.
+// Lost writes are selected by random numbers generated from seed
+func NewMemoryNetwork(seed int64) *MemoryNetwork {
+	return &MemoryNetwork{
+		transports: make(map[string]*MemoryTransport),
+		random:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetLatency sets one-way delay of writes made after call
+func (memNet *MemoryNetwork) SetLatency(latency time.Duration) {
+	memNet.lock.Lock()
+	defer memNet.lock.Unlock()
+	memNet.latency = latency
+}
+
+// SetLossRate sets probability of dropping write, it is between 0 and 1
+func (memNet *MemoryNetwork) SetLossRate(lossRate float64) {
+	memNet.lock.Lock()
+	defer memNet.lock.Unlock()
+	memNet.lossRate = lossRate
+}
+
+// Partition splits network into groups of addresses, nodes of different groups can't communicate.
+// Addresses which aren't listed form separate group. Previous partitions are replaced
+func (memNet *MemoryNetwork) Partition(groups ...[]string) {
+	memNet.lock.Lock()
+	defer memNet.lock.Unlock()
+	memNet.partitions = make(map[string]int)
+	for i, group := range groups {
+		for _, address := range group {
+			memNet.partitions[address] = i + 1
+		}
+	}
+}
+
+// Heal removes partitions
+func (memNet *MemoryNetwork) Heal() {
+	memNet.lock.Lock()
+	defer memNet.lock.Unlock()
+	memNet.partitions = nil
+}
+
+// deliver returns time write from one address to another is delivered, false is returned if write is lost
+func (memNet *MemoryNetwork) deliver(from, to string) (time.Time, bool) {
+	memNet.lock.Lock()
+	defer memNet.lock.Unlock()
+	if memNet.partitions[from] != memNet.partitions[to] {
+		return time.Time{}, false
+	}
+	if memNet.lossRate > 0 && memNet.random.Float64() < memNet.lossRate {
+		return time.Time{}, false
+	}
+	return time.Now().Add(memNet.latency), true
+}
+
+// NewTransport creates transport on address of network, certificate is presented to remote nodes
+func (memNet *MemoryNetwork) NewTransport(address string, certificate tls.Certificate) (*MemoryTransport, error) {
+	if len(certificate.Certificate) == 0 {
+		return nil, ErrInvalidCertificate
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	memNet.lock.Lock()
+	defer memNet.lock.Unlock()
+	if memNet.transports[address] != nil {
+		return nil, ErrAddressInUse
+	}
+	transport := &MemoryTransport{
+		network:     memNet,
+		address:     memoryAddr(address),
+		certificate: leaf,
+		incoming:    make(chan *Peer, acceptQueueSize),
+		sessions:    make(map[*memorySession]struct{}),
+		done:        make(chan interface{}),
+	}
+	memNet.transports[address] = transport
+	return transport, nil
+}
+
+// memoryAddr is address of MemoryTransport
+type memoryAddr string
+
+func (addr memoryAddr) Network() string {
+	return "memory"
+}
+
+func (addr memoryAddr) String() string {
+	return string(addr)
+}
+
+// MemoryTransport is Transport of simulated node connected to MemoryNetwork
+type MemoryTransport struct {
+	network     *MemoryNetwork
+	address     memoryAddr
+	certificate *x509.Certificate
+	incoming    chan *Peer
+
+	// sessions are open sessions of transport, they are closed by Close
+	sessions  map[*memorySession]struct{}
+	lock      sync.RWMutex
+	done      chan interface{}
+	closeOnce sync.Once
+}
+
+// Incoming returns channel of accepted connections
+func (transport *MemoryTransport) Incoming() <-chan *Peer {
+	return transport.incoming
+}
+
+// LocalAddress returns address of transport in network
+func (transport *MemoryTransport) LocalAddress() net.Addr {
+	return transport.address
+}
+
+// Close removes transport from network and closes its connections
+func (transport *MemoryTransport) Close() {
+	transport.closeOnce.Do(func() {
+		close(transport.done)
+		memNet := transport.network
+		memNet.lock.Lock()
+		if memNet.transports[transport.address.String()] == transport {
+			delete(memNet.transports, transport.address.String())
+		}
+		memNet.lock.Unlock()
+
+		// Dial releases read lock when done is closed
+		transport.lock.Lock()
+		close(transport.incoming)
+		sessions := make([]*memorySession, 0, len(transport.sessions))
+		for session := range transport.sessions {
+			sessions = append(sessions, session)
+		}
+		transport.lock.Unlock()
+		for _, session := range sessions {
+			session.Close()
+		}
+	})
+}
+
+// Dial connects to transport on address, connection is aborted if nodeID isn't nil and remote has other ID
+func (transport *MemoryTransport) Dial(address string, nodeID []byte) (*Peer, error) {
+	select {
+	case <-transport.done:
+		return nil, ErrTransportClosed
+	default:
+	}
+
+	memNet := transport.network
+	memNet.lock.Lock()
+	remote := memNet.transports[address]
+	reachable := remote != nil && memNet.partitions[transport.address.String()] == memNet.partitions[address]
+	latency := memNet.latency
+	memNet.lock.Unlock()
+	if !reachable {
+		return nil, ErrUnreachable
+	}
+	time.Sleep(2 * latency)
+
+	if nodeID != nil {
+		remotePublicKey, err := certificateKey([][]byte{remote.certificate.Raw})
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(network.DeriveAddress(remotePublicKey), nodeID) {
+			return nil, ErrUnexpectedNode
+		}
+	}
+
+	local, accepted := newMemorySessionPair(transport, remote)
+	if err := remote.accept(NewPeer(accepted), transport.done); err != nil {
+		local.Close()
+		return nil, err
+	}
+	return NewPeer(local), nil
+}
+
+// accept passes connection to Incoming channel
+func (transport *MemoryTransport) accept(peer *Peer, cancel <-chan interface{}) error {
+	transport.lock.RLock()
+	defer transport.lock.RUnlock()
+	select {
+	case transport.incoming <- peer:
+		return nil
+	case <-transport.done:
+		return ErrUnreachable
+	case <-cancel:
+		return ErrTransportClosed
+	}
+}
+
+func (transport *MemoryTransport) addSession(session *memorySession) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	transport.sessions[session] = struct{}{}
+}
+
+func (transport *MemoryTransport) removeSession(session *memorySession) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	delete(transport.sessions, session)
+}
+
+// memorySession is side of connection between MemoryTransports, it implements quic.Session
+type memorySession struct {
+	transport         *MemoryTransport
+	remote            *memorySession
+	localAddr         memoryAddr
+	remoteAddr        memoryAddr
+	remoteCertificate *x509.Certificate
+	// uniStreams are streams opened by remote which weren't accepted
+	uniStreams chan *memoryStream
+	streamID   int64
+
+	// ctx is shared by both sides, connection is closed when it is cancelled
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newMemorySessionPair creates connected sessions of dialing and accepting transports
+func newMemorySessionPair(dialer, acceptor *MemoryTransport) (*memorySession, *memorySession) {
+	ctx, cancel := context.WithCancel(context.Background())
+	local := &memorySession{
+		transport:         dialer,
+		localAddr:         dialer.address,
+		remoteAddr:        acceptor.address,
+		remoteCertificate: acceptor.certificate,
+		uniStreams:        make(chan *memoryStream, incomingQueueSize),
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+	remote := &memorySession{
+		transport:         acceptor,
+		remote:            local,
+		localAddr:         acceptor.address,
+		remoteAddr:        dialer.address,
+		remoteCertificate: dialer.certificate,
+		uniStreams:        make(chan *memoryStream, incomingQueueSize),
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+	local.remote = remote
+	dialer.addSession(local)
+	acceptor.addSession(remote)
+	return local, remote
+}
+
+func (session *memorySession) AcceptStream(context.Context) (quic.Stream, error) {
+	return nil, errBidirectional
+}
+
+func (session *memorySession) OpenStream() (quic.Stream, error) {
+	return nil, errBidirectional
+}
+
+func (session *memorySession) OpenStreamSync(context.Context) (quic.Stream, error) {
+	return nil, errBidirectional
+}
+
+func (session *memorySession) AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error) {
+	select {
+	case stream := <-session.uniStreams:
+		return stream, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-session.ctx.Done():
+		return nil, errSessionClosed
+	}
+}
+
+func (session *memorySession) newStream() *memoryStream {
+	return &memoryStream{
+		id:      quic.StreamID(atomic.AddInt64(&session.streamID, 1)),
+		session: session,
+		changed: make(chan struct{}),
+	}
+}
+
+func (session *memorySession) OpenUniStream() (quic.SendStream, error) {
+	stream := session.newStream()
+	select {
+	case <-session.ctx.Done():
+		return nil, errSessionClosed
+	case session.remote.uniStreams <- stream:
+		return stream, nil
+	default:
+		return nil, errTooManyStreams
+	}
+}
+
+func (session *memorySession) OpenUniStreamSync(ctx context.Context) (quic.SendStream, error) {
+	stream := session.newStream()
+	select {
+	case <-session.ctx.Done():
+		return nil, errSessionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case session.remote.uniStreams <- stream:
+		return stream, nil
+	}
+}
+
+func (session *memorySession) LocalAddr() net.Addr {
+	return session.localAddr
+}
+
+func (session *memorySession) RemoteAddr() net.Addr {
+	return session.remoteAddr
+}
+
+// Close closes both sides of connection
+func (session *memorySession) Close() error {
+	return session.CloseWithError(0, "")
+}
+
+func (session *memorySession) CloseWithError(quic.ErrorCode, string) error {
+	session.cancel()
+	session.transport.removeSession(session)
+	session.remote.transport.removeSession(session.remote)
+	return nil
+}
+
+func (session *memorySession) Context() context.Context {
+	return session.ctx
+}
+
+func (session *memorySession) ConnectionState() tls.ConnectionState {
+	return tls.ConnectionState{
+		HandshakeComplete: true,
+		PeerCertificates:  []*x509.Certificate{session.remoteCertificate},
+	}
+}
+
+// memoryTimeoutError is returned by Read if read deadline is exceeded, it implements net.Error
+type memoryTimeoutError struct{}
+
+func (memoryTimeoutError) Error() string   { return "memory transport: deadline exceeded" }
+func (memoryTimeoutError) Timeout() bool   { return true }
+func (memoryTimeoutError) Temporary() bool { return true }
+
+// memoryChunk is data of write delivered at specific time
+type memoryChunk struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// memoryStream is unidirectional stream, it implements quic.SendStream for opening side
+// and quic.ReceiveStream for accepting side
+type memoryStream struct {
+	id      quic.StreamID
+	session *memorySession
+
+	chunks       []memoryChunk
+	closed       bool
+	readDeadline time.Time
+	// changed is closed when chunks, closed flag or deadline are changed
+	changed chan struct{}
+	lock    sync.Mutex
+}
+
+// notify wakes up Read, it is called with lock held
+func (stream *memoryStream) notify() {
+	close(stream.changed)
+	stream.changed = make(chan struct{})
+}
+
+func (stream *memoryStream) StreamID() quic.StreamID {
+	return stream.id
+}
+
+func (stream *memoryStream) Context() context.Context {
+	return stream.session.ctx
+}
+
+func (stream *memoryStream) Write(data []byte) (int, error) {
+	select {
+	case <-stream.session.ctx.Done():
+		return 0, errSessionClosed
+	default:
+	}
+
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.closed {
+		return 0, errStreamClosed
+	}
+	memNet := stream.session.transport.network
+	if deliverAt, delivered := memNet.deliver(stream.session.localAddr.String(), stream.session.remoteAddr.String()); delivered {
+		stream.chunks = append(stream.chunks, memoryChunk{append([]byte(nil), data...), deliverAt})
+		stream.notify()
+	}
+	return len(data), nil
+}
+
+// Read returns data which is delivered, io.EOF is returned after all data of closed stream is read
+func (stream *memoryStream) Read(buffer []byte) (int, error) {
+	for {
+		stream.lock.Lock()
+		now := time.Now()
+		if !stream.readDeadline.IsZero() && !now.Before(stream.readDeadline) {
+			stream.lock.Unlock()
+			return 0, memoryTimeoutError{}
+		}
+
+		wait := time.Duration(-1)
+		if len(stream.chunks) > 0 {
+			chunk := &stream.chunks[0]
+			if !now.Before(chunk.deliverAt) {
+				read := copy(buffer, chunk.data)
+				if chunk.data = chunk.data[read:]; len(chunk.data) == 0 {
+					stream.chunks = stream.chunks[1:]
+				}
+				stream.lock.Unlock()
+				return read, nil
+			}
+			wait = chunk.deliverAt.Sub(now)
+		} else if stream.closed {
+			stream.lock.Unlock()
+			return 0, io.EOF
+		}
+		if !stream.readDeadline.IsZero() && (wait < 0 || stream.readDeadline.Sub(now) < wait) {
+			wait = stream.readDeadline.Sub(now)
+		}
+		changed := stream.changed
+		stream.lock.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-stream.session.ctx.Done():
+			return 0, errSessionClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Close closes sending side, remote reads io.EOF after delivered data
+func (stream *memoryStream) Close() error {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if !stream.closed {
+		stream.closed = true
+		stream.notify()
+	}
+	return nil
+}
+
+func (stream *memoryStream) CancelWrite(quic.ErrorCode) {
+	stream.Close()
+}
+
+// CancelRead discards data which wasn't read
+func (stream *memoryStream) CancelRead(quic.ErrorCode) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	stream.chunks = nil
+	stream.closed = true
+	stream.notify()
+}
+
+func (stream *memoryStream) SetReadDeadline(deadline time.Time) error {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	stream.readDeadline = deadline
+	stream.notify()
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes don't block
+func (stream *memoryStream) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package conn
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func newMemoryTransport(t *testing.T, memNet *MemoryNetwork, address string) *MemoryTransport {
+	cert, err := generateCertificate()
+	if err != nil {
+		t.Fatalf("generateCertificate failed: %+v\n", err)
+	}
+	transport, err := memNet.NewTransport(address, *cert)
+	if err != nil {
+		t.Fatalf("NewTransport failed: %+v\n", err)
+	}
+	return transport
+}
+
+// memoryConnect dials transport on address and returns both sides of connection
+func memoryConnect(t *testing.T, from, to *MemoryTransport) (*Peer, *Peer) {
+	peer, err := from.Dial(to.LocalAddress().String(), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %+v\n", err)
+	}
+	return peer, <-to.Incoming()
+}
+
+func TestMemoryTransport(t *testing.T) {
+	/*
+		1. Nodes exchange messages over connection, RemoteID of both sides is set
+		2. Dial to unexpected node ID and to unknown address fails
+		3. Connections are closed when transport is closed
+	*/
+	memNet := NewMemoryNetwork(1)
+	first, second := newMemoryTransport(t, memNet, "10.0.0.1:7000"), newMemoryTransport(t, memNet, "10.0.0.2:7000")
+	defer first.Close()
+	secondID := certificateID(t, &tls.Certificate{Certificate: [][]byte{second.certificate.Raw}})
+
+	outgoing, err := first.Dial("10.0.0.2:7000", secondID)
+	if err != nil {
+		t.Fatalf("Dial failed: %+v\n", err)
+	}
+	incoming := <-second.Incoming()
+	if !bytes.Equal(outgoing.RemoteID, secondID) || incoming.RemoteID == nil {
+		t.Fatal("RemoteID isn't set")
+	}
+	if incoming.RemoteAddr() != "10.0.0.1:7000" {
+		t.Fatalf("Unexpected remote address %s", incoming.RemoteAddr())
+	}
+	if !outgoing.Write([]byte("Hello!")) {
+		t.Fatal("peer.Write failed")
+	}
+	if message := incoming.Read(); string(message) != "Hello!" {
+		t.Fatal("peer.Read failed")
+	}
+	if !incoming.WriteTo(1, []byte("test")) {
+		t.Fatal("peer.WriteTo failed")
+	}
+	if message := outgoing.Read(); string(message) != "test" {
+		t.Fatal("peer.Read failed")
+	}
+
+	if _, err = first.Dial("10.0.0.2:7000", incoming.RemoteID); err != ErrUnexpectedNode {
+		t.Fatalf("Dial to unexpected node didn't fail: %+v\n", err)
+	}
+	if _, err = first.Dial("10.0.0.3:7000", nil); err != ErrUnreachable {
+		t.Fatalf("Dial to unknown address didn't fail: %+v\n", err)
+	}
+
+	second.Close()
+	select {
+	case <-outgoing.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Connection wasn't closed with transport")
+	}
+}
+
+func TestMemoryNetworkConditions(t *testing.T) {
+	/*
+		1. Messages are delivered after latency in order
+		2. Nodes in different partitions can't connect, messages between them are dropped
+		3. Lost messages are same for same seed
+	*/
+	memNet := NewMemoryNetwork(1)
+	first, second := newMemoryTransport(t, memNet, "first"), newMemoryTransport(t, memNet, "second")
+	defer first.Close()
+	defer second.Close()
+	outgoing, incoming := memoryConnect(t, first, second)
+
+	memNet.SetLatency(50 * time.Millisecond)
+	sent := time.Now()
+	for i := 0; i < 3; i++ {
+		outgoing.Write([]byte{byte(i)})
+	}
+	for i := 0; i < 3; i++ {
+		if message := incoming.Read(); len(message) != 1 || message[0] != byte(i) {
+			t.Fatalf("Message %d wasn't received in order", i)
+		}
+	}
+	if time.Since(sent) < 50*time.Millisecond {
+		t.Fatal("Messages were delivered before latency")
+	}
+	memNet.SetLatency(0)
+
+	memNet.Partition([]string{"first"})
+	outgoing.Write([]byte("lost"))
+	third := newMemoryTransport(t, memNet, "third")
+	defer third.Close()
+	if _, err := first.Dial("third", nil); err != ErrUnreachable {
+		t.Fatalf("Dial between partitions didn't fail: %+v", err)
+	}
+	memNet.Heal()
+	outgoing.Write([]byte("delivered"))
+	if message := incoming.Read(); string(message) != "delivered" {
+		t.Fatalf("Unexpected message after partition was healed: %s", message)
+	}
+
+	receivedWithSeed := func(seed int64) []byte {
+		memNet := NewMemoryNetwork(seed)
+		first, second := newMemoryTransport(t, memNet, "first"), newMemoryTransport(t, memNet, "second")
+		defer first.Close()
+		defer second.Close()
+		outgoing, incoming := memoryConnect(t, first, second)
+
+		memNet.SetLossRate(0.5)
+		for i := 0; i < 100; i++ {
+			outgoing.Write([]byte{byte(i)})
+		}
+		memNet.SetLossRate(0)
+		outgoing.Write([]byte("end"))
+
+		var received []byte
+		for {
+			message := incoming.Read()
+			if message == nil {
+				t.Fatal("Connection was closed")
+			}
+			if string(message) == "end" {
+				return received
+			}
+			received = append(received, message...)
+		}
+	}
+	firstRun, secondRun := receivedWithSeed(42), receivedWithSeed(42)
+	if len(firstRun) == 0 || len(firstRun) == 100 {
+		t.Fatalf("Unexpected count of delivered messages: %d", len(firstRun))
+	}
+	if !bytes.Equal(firstRun, secondRun) {
+		t.Fatalf("Lost messages differ for same seed: %x, %x", firstRun, secondRun)
+	}
+}
@@ -3,12 +3,17 @@ package conn
 import (
 	"crypto/tls"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/pixelbender/go-stun/stun"
 	"github.com/pkg/errors"
 )
 
+// DefaultSTUNServer is STUN server used by Init if server isn't specified
+const DefaultSTUNServer = "stun.l.google.com:19302"
+
 // QUICTransport maintains incoming and outgoing connections
 type QUICTransport struct {
 	Address             net.Addr
@@ -17,39 +22,138 @@ type QUICTransport struct {
 	IncomingConnections chan *Peer
 
 	packetConn net.PacketConn
+	config     *PeerConfig
 	done       chan interface{}
 }
 
+// TransportOptions specify how QUIC listener is bound and how its external address is determined:
+//  1. If ExternalAddress is set, listener is bound to ListenAddress and ExternalAddress is advertised
+//  2. If STUNServers are set, external address is discovered by first responding server,
+//     listener is bound to port used for STUN request, ListenAddress is ignored
+//  3. Otherwise listener is bound to ListenAddress and bound address is advertised,
+//     it is used in private networks and tests
+type TransportOptions struct {
+	// ListenAddress is local UDP address, e.g. "127.0.0.1:0". All interfaces and random port are used if it is empty
+	ListenAddress string
+	// ExternalAddress is address advertised to remote nodes. If it doesn't contain port, bound port is used
+	ExternalAddress string
+	// STUNServers are addresses of STUN servers, they are tried in order until external address is discovered
+	STUNServers []string
+	// RequireClientCertificate enables mutual authentication, remotes without Ed25519 certificate are rejected
+	RequireClientCertificate bool
+	// Config specifies QUIC timeouts and limits of incoming and outgoing peers, DefaultPeerConfig is used if it is nil
+	Config *PeerConfig
+}
+
 // Close closes all channels and terminates QUIC listener
 func (transport *QUICTransport) Close() {
 	close(transport.IncomingConnections)
 	// This implicitcly closes QUIC listener
 	close(transport.done)
+	if transport.packetConn != nil {
+		transport.packetConn.Close()
+	}
 }
 
-// Init discovers own IP address using STUN protocol and creates QUIC listener
+// Init discovers own IP address using STUN protocol and creates QUIC listener.
+// DefaultSTUNServer is used if stunServer is empty
 func (transport *QUICTransport) Init(stunServer string) error {
-	transport.done = make(chan interface{})
-
 	if len(stunServer) == 0 {
-		stunServer = "stun.l.google.com:19302"
+		stunServer = DefaultSTUNServer
+	}
+	return transport.InitWithOptions(&TransportOptions{STUNServers: []string{stunServer}})
+}
+
+// InitWithOptions binds UDP socket, determines external address as specified by options and creates QUIC listener
+func (transport *QUICTransport) InitWithOptions(options *TransportOptions) error {
+	transport.done = make(chan interface{})
+	transport.config = options.Config
+	if transport.IncomingConnections == nil {
+		transport.IncomingConnections = make(chan *Peer)
 	}
 
 	var err error
-	transport.packetConn, transport.Address, err = stun.Discover("stun:" + stunServer)
+	if len(options.STUNServers) > 0 && len(options.ExternalAddress) == 0 {
+		transport.packetConn, transport.Address, err = discoverAddress(options.STUNServers)
+	} else {
+		transport.packetConn, transport.Address, err = bindAddress(options.ListenAddress, options.ExternalAddress)
+	}
 	if err != nil {
-		return errors.Wrap(err, "NetworkNode.Init: STUN discovery failed")
+		return err
 	}
 
-	listener, err := quic.Listen(transport.packetConn, &tls.Config{
+	tlsConfig := &tls.Config{
 		Certificates:       []tls.Certificate{transport.TLSCertificate},
 		NextProtos:         []string{transport.ProtocolName},
 		InsecureSkipVerify: true,
-	}, nil)
+	}
+	if options.RequireClientCertificate {
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		tlsConfig.VerifyPeerCertificate = VerifyCertificate
+	}
+	config := transport.config
+	if config == nil {
+		config = &DefaultPeerConfig
+	}
+	listener, err := quic.Listen(transport.packetConn, tlsConfig, config.QUICConfig())
 	if err != nil {
+		transport.packetConn.Close()
 		return errors.Wrap(err, "NetworkNode.Init: creating QUIC listener failed")
 	}
 
 	go transport.listen(listener)
 	return nil
 }
+
+// Dial connects to node with specific ID on address presenting certificate of transport.
+// Any node is accepted if nodeID is nil
+func (transport *QUICTransport) Dial(address string, nodeID []byte) (*Peer, error) {
+	return DialWithOptions(transport.ProtocolName, address, &DialOptions{
+		NodeID:      nodeID,
+		Certificate: &transport.TLSCertificate,
+		Config:      transport.config,
+	})
+}
+
+// discoverAddress discovers external address using STUN servers in order, first successful result is returned
+func discoverAddress(stunServers []string) (net.PacketConn, net.Addr, error) {
+	var failures []string
+	for _, stunServer := range stunServers {
+		packetConn, address, err := stun.Discover("stun:" + stunServer)
+		if err == nil {
+			return packetConn, address, nil
+		}
+		failures = append(failures, stunServer+": "+err.Error())
+	}
+	return nil, nil, errors.Errorf("NetworkNode.Init: STUN discovery failed: %s", strings.Join(failures, "; "))
+}
+
+// bindAddress binds UDP socket to listenAddress. Returns externalAddress if it is set,
+// otherwise bound address is returned
+func bindAddress(listenAddress, externalAddress string) (net.PacketConn, net.Addr, error) {
+	if len(listenAddress) == 0 {
+		listenAddress = "0.0.0.0:0"
+	}
+	localAddress, err := net.ResolveUDPAddr("udp", listenAddress)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "NetworkNode.Init: invalid listen address")
+	}
+	packetConn, err := net.ListenUDP("udp", localAddress)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "NetworkNode.Init: binding UDP socket failed")
+	}
+	if len(externalAddress) == 0 {
+		return packetConn, packetConn.LocalAddr(), nil
+	}
+
+	if _, _, err = net.SplitHostPort(externalAddress); err != nil {
+		port := packetConn.LocalAddr().(*net.UDPAddr).Port
+		externalAddress = net.JoinHostPort(externalAddress, strconv.Itoa(port))
+	}
+	address, err := net.ResolveUDPAddr("udp", externalAddress)
+	if err != nil {
+		packetConn.Close()
+		return nil, nil, errors.Wrap(err, "NetworkNode.Init: invalid external address")
+	}
+	return packetConn, address, nil
+}
@@ -2,6 +2,8 @@ package conn
 
 import (
 	"crypto/tls"
+	"fmt"
+	"net"
 	"testing"
 
 	"github.com/lucas-clemente/quic-go"
@@ -70,3 +72,56 @@ func TestQUICTransport(t *testing.T) {
 		}
 	})
 }
+
+func TestQUICTransportLocal(t *testing.T) {
+	/*
+		1. Multiple transports are bound to localhost without STUN, bound addresses are advertised
+		2. Transports connect to each other by advertised addresses
+		3. External address without port is advertised with bound port
+	*/
+	transports := make([]*QUICTransport, 3)
+	for i := range transports {
+		cert, err := generateCertificate()
+		if err != nil {
+			t.Fatalf("generateCertificate failed: %+v\n", err)
+		}
+		transports[i] = &QUICTransport{
+			ProtocolName:   "QUICTransport-local-test",
+			TLSCertificate: *cert,
+		}
+		if err = transports[i].InitWithOptions(&TransportOptions{ListenAddress: "127.0.0.1:0"}); err != nil {
+			t.Fatalf("InitWithOptions failed: %+v\n", err)
+		}
+		defer transports[i].Close()
+		if transports[i].Address.(*net.UDPAddr).Port == 0 {
+			t.Fatal("Bound port isn't advertised")
+		}
+	}
+
+	for i := 1; i < len(transports); i++ {
+		peer, err := Dial("QUICTransport-local-test", transports[i].Address.String())
+		if err != nil {
+			t.Fatalf("Dial failed: %+v\n", err)
+		}
+		defer peer.Close()
+		if !peer.Write([]byte("Hello!")) {
+			t.Fatal("peer.Write failed")
+		}
+
+		remote := <-transports[i].IncomingConnections
+		defer remote.Close()
+		if message := remote.Read(); message == nil || string(message) != "Hello!" {
+			t.Fatal("peer.Read failed")
+		}
+	}
+
+	transport := &QUICTransport{ProtocolName: "QUICTransport-local-test"}
+	if err := transport.InitWithOptions(&TransportOptions{ListenAddress: "127.0.0.1:0", ExternalAddress: "192.0.2.1"}); err != nil {
+		t.Fatalf("InitWithOptions failed: %+v\n", err)
+	}
+	defer transport.Close()
+	localPort := transport.packetConn.LocalAddr().(*net.UDPAddr).Port
+	if expected := fmt.Sprintf("192.0.2.1:%d", localPort); transport.Address.String() != expected {
+		t.Fatalf("Unexpected external address %s, expected %s", transport.Address, expected)
+	}
+}
@@ -3,57 +3,205 @@ package conn
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
 
+	"github.com/buuzcoin/go-buuzcoin/network"
 	"github.com/golang/protobuf/proto"
 	"github.com/lucas-clemente/quic-go"
 )
 
+/*
+	Streams:
+	Messages are sent over unidirectional QUIC streams, each side opens its own streams.
+	Stream is selected by Peer.StreamOf, so messages of different sub-protocols are sent
+	over separate streams with independent flow control: large block download doesn't block
+	pings or gossip. Stream is opened when first message is sent over it.
+	Every message is prepended by 4-byte little-endian length. Messages of same stream are
+	received in order they were sent, messages of different streams may be reordered.
+	Incoming streams are read concurrently, received messages of all streams are returned
+	by Read and ReadMessage. Limits and timeouts are described in config.go, compression
+	is described in compression.go.
+*/
+
+// DefaultStream is ID of stream used for raw messages and if Peer.StreamOf is nil
+const DefaultStream byte = 0
+
+// incomingQueueSize is count of received messages waiting to be read, stream readers
+// are paused when queue is full
+const incomingQueueSize = 16
+
+// StreamFn returns ID of stream message with specific ID is sent over
+type StreamFn = func(messageID byte) byte
+
+// sendStream is outgoing stream, writeLock serializes writes of messages sent from different goroutines
+type sendStream struct {
+	stream    quic.SendStream
+	writeLock sync.Mutex
+}
+
 // Peer represents connection with remote peer
 type Peer struct {
 	RemotePublicKey ed25519.PublicKey
 	RemoteID        []byte
+	// StreamOf selects stream messages are sent over, all messages are sent over DefaultStream if it is nil.
+	// It should be set before first message is sent
+	StreamOf StreamFn
+	// Config specifies limits and timeouts, DefaultPeerConfig is used if it is nil.
+	// It should be set before connection is used
+	Config *PeerConfig
+
+	session   quic.Session
+	done      chan interface{}
+	closeOnce sync.Once
+	err       error
+	errLock   sync.Mutex
+
+	streams     map[byte]*sendStream
+	streamsLock sync.Mutex
+
+	readOnce sync.Once
+	incoming chan []byte
+
+	// compression is algorithm of sent messages, they aren't compressed if it is empty
+	compression     string
+	compressionLock sync.RWMutex
+}
+
+// NewPeer creates peer using established QUIC session.
+// RemotePublicKey and RemoteID are set if remote sent Ed25519 certificate
+func NewPeer(session quic.Session) *Peer {
+	peer := &Peer{
+		session: session,
+		done:    make(chan interface{}),
+	}
 
-	session quic.Session
-	stream  quic.Stream
-	done    chan interface{}
+	peerCertificates := session.ConnectionState().PeerCertificates
+	if len(peerCertificates) == 1 && peerCertificates[0].PublicKeyAlgorithm == x509.Ed25519 {
+		if remotePublicKey, ok := peerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+			peer.RemotePublicKey = remotePublicKey
+			peer.RemoteID = network.DeriveAddress(remotePublicKey)
+		}
+	}
+	return peer
 }
 
-// Close closes connection
+// Close closes connection, it may be called multiple times
 func (peer *Peer) Close() {
-	peer.session.Close()
-	if peer.stream != nil {
-		peer.stream.Close()
+	peer.closeWithError(nil)
+}
+
+// closeWithError closes connection, err is saved as cause of closing if connection wasn't closed before
+func (peer *Peer) closeWithError(err error) {
+	peer.closeOnce.Do(func() {
+		peer.errLock.Lock()
+		peer.err = err
+		peer.errLock.Unlock()
+
+		peer.session.Close()
+		peer.streamsLock.Lock()
+		for _, stream := range peer.streams {
+			stream.stream.Close()
+		}
+		peer.streamsLock.Unlock()
+		close(peer.done)
+	})
+}
+
+// Err returns error which caused connection to be closed.
+// nil is returned if connection is open or it was closed by Close
+func (peer *Peer) Err() error {
+	peer.errLock.Lock()
+	defer peer.errLock.Unlock()
+	return peer.err
+}
+
+func (peer *Peer) config() *PeerConfig {
+	if peer.Config == nil {
+		return &DefaultPeerConfig
+	}
+	return peer.Config
+}
+
+// streamError converts error of stream operation to error saved as cause of closing connection
+func streamError(err error, timeoutErr error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return timeoutErr
+	}
+	return fmt.Errorf("peer: stream failed: %v", err)
+}
+
+// RemoteAddr returns network address of remote peer
+func (peer *Peer) RemoteAddr() string {
+	return peer.session.RemoteAddr().String()
+}
+
+// Done returns channel which is closed when connection is closed
+func (peer *Peer) Done() <-chan interface{} {
+	return peer.done
+}
+
+// sendStream returns outgoing stream with specific ID, it is opened if it doesn't exist
+func (peer *Peer) sendStream(streamID byte) (*sendStream, error) {
+	peer.streamsLock.Lock()
+	defer peer.streamsLock.Unlock()
+
+	if stream := peer.streams[streamID]; stream != nil {
+		return stream, nil
+	}
+	stream, err := peer.session.OpenUniStreamSync(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if peer.streams == nil {
+		peer.streams = make(map[byte]*sendStream)
 	}
-	close(peer.done)
+	peer.streams[streamID] = &sendStream{stream: stream}
+	return peer.streams[streamID], nil
 }
 
-// Write sends raw data to remote peer.
+// WriteTo sends raw data over stream with specific ID, it is safe to call from multiple goroutines.
 // Returns false if error occured and connection was closed
-func (peer *Peer) Write(data []byte) bool {
-	if peer.stream == nil {
-		var err error
-		peer.stream, err = peer.session.OpenStreamSync(context.Background())
-		if err != nil {
-			peer.Close()
-			return false
-		}
+func (peer *Peer) WriteTo(streamID byte, data []byte) bool {
+	return peer.writeFrame(streamID, 0, data)
+}
+
+// writeFrame sends data prepended by length with flags over stream with specific ID
+func (peer *Peer) writeFrame(streamID byte, flags uint32, data []byte) bool {
+	stream, err := peer.sendStream(streamID)
+	if err != nil {
+		peer.closeWithError(fmt.Errorf("peer: opening stream failed: %v", err))
+		return false
 	}
 
 	buffer := make([]byte, 4, 4+len(data))
-	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(data))|flags)
 	buffer = append(buffer, data...)
 
+	stream.writeLock.Lock()
+	defer stream.writeLock.Unlock()
+	if writeTimeout := peer.config().WriteTimeout; writeTimeout > 0 {
+		if err = stream.stream.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			peer.closeWithError(streamError(err, ErrWriteTimeout))
+			return false
+		}
+	}
+
 	offset := 0
 	for offset < len(buffer) {
 		select {
 		case <-peer.done:
 			return false
 		default:
-			written, err := peer.stream.Write(buffer[offset:])
+			written, err := stream.stream.Write(buffer[offset:])
 			if err != nil {
-				peer.Close()
+				peer.closeWithError(streamError(err, ErrWriteTimeout))
 				return false
 			}
 			offset += written
@@ -63,72 +211,136 @@ func (peer *Peer) Write(data []byte) bool {
 	return true
 }
 
-func (peer *Peer) read(expectedLength int) []byte {
-	if peer.stream == nil {
-		var err error
-		peer.stream, err = peer.session.AcceptStream(context.Background())
+// Write sends raw data over DefaultStream, it is safe to call from multiple goroutines.
+// Returns false if error occured and connection was closed
+func (peer *Peer) Write(data []byte) bool {
+	return peer.WriteTo(DefaultStream, data)
+}
+
+// acceptStreams accepts streams opened by remote and starts their readers until connection is closed
+func (peer *Peer) acceptStreams() {
+	for {
+		stream, err := peer.session.AcceptUniStream(context.Background())
 		if err != nil {
-			peer.Close()
-			return nil
+			peer.closeWithError(fmt.Errorf("peer: session closed: %v", err))
+			return
 		}
+		go peer.readStream(stream)
 	}
+}
 
-	result := make([]byte, expectedLength)
+// readMessage reads message from incoming stream, size of message is checked before it is allocated.
+// Compressed message is decompressed
+func (peer *Peer) readMessage(stream quic.ReceiveStream) ([]byte, error) {
+	config := peer.config()
+	lengthBuffer := make([]byte, 4)
+	if _, err := io.ReadFull(stream, lengthBuffer); err != nil {
+		return nil, streamError(err, ErrReadTimeout)
+	}
+	prefix := binary.LittleEndian.Uint32(lengthBuffer)
+	compressed := prefix&compressedFlag != 0
+	length := uint64(prefix &^ compressedFlag)
+	if length == 0 {
+		return nil, ErrEmptyMessage
+	}
+	if length > uint64(config.MaxMessageSize) {
+		return nil, ErrMessageTooLarge
+	}
+
+	if config.ReadTimeout > 0 {
+		if err := stream.SetReadDeadline(time.Now().Add(config.ReadTimeout)); err != nil {
+			return nil, streamError(err, ErrReadTimeout)
+		}
+		defer stream.SetReadDeadline(time.Time{})
+	}
+	messageID := make([]byte, 1)
+	if _, err := io.ReadFull(stream, messageID); err != nil {
+		return nil, streamError(err, ErrReadTimeout)
+	}
+	if length > uint64(config.maxSize(messageID[0])) {
+		return nil, ErrMessageTooLarge
+	}
+	message := make([]byte, length)
+	message[0] = messageID[0]
+	if _, err := io.ReadFull(stream, message[1:]); err != nil {
+		return nil, streamError(err, ErrReadTimeout)
+	}
+	if compressed {
+		payload, err := peer.decompress(message[0], message[1:])
+		if err != nil {
+			return nil, err
+		}
+		message = append(message[:1], payload...)
+	}
+	return message, nil
+}
+
+// readStream reads messages from incoming stream and puts them in incoming queue
+func (peer *Peer) readStream(stream quic.ReceiveStream) {
+	for {
+		message, err := peer.readMessage(stream)
+		if err != nil {
+			peer.closeWithError(err)
+			return
+		}
 
-	offset := 0
-	for offset < expectedLength {
 		select {
+		case peer.incoming <- message:
 		case <-peer.done:
-			return nil
-		default:
-			read, err := peer.stream.Read(result[offset:expectedLength])
-			if err != nil {
-				peer.Close()
-				return nil
-			}
-			offset += read
+			return
 		}
 	}
-
-	return result
 }
 
-// Read reads raw message from remote.
+// Read reads raw message received over any stream from remote.
 // nil is returned if connection was closed.
 func (peer *Peer) Read() []byte {
-	msgLenBuffer := peer.read(4)
-	if msgLenBuffer == nil {
+	peer.readOnce.Do(func() {
+		peer.incoming = make(chan []byte, incomingQueueSize)
+		go peer.acceptStreams()
+	})
+
+	select {
+	case message := <-peer.incoming:
+		return message
+	case <-peer.done:
 		return nil
 	}
-
-	messageLength := int(binary.LittleEndian.Uint32(msgLenBuffer))
-	return peer.read(messageLength)
 }
 
 // ReadMessage reads raw message and message ID from remote.
 // nil is returned if connection was closed.
 func (peer *Peer) ReadMessage() (byte, []byte) {
-	msgLenBuffer := peer.read(4)
-	if msgLenBuffer == nil {
+	rawMessage := peer.Read()
+	if rawMessage == nil {
 		return 0, nil
 	}
-
-	messageLength := int(binary.LittleEndian.Uint32(msgLenBuffer))
-	rawMessage := peer.read(messageLength)
 	return rawMessage[0], rawMessage[1:]
 }
 
 // ErrMessageEncodingFailed is returned by peer.Send function if message marshal has failed
 var ErrMessageEncodingFailed = errors.New("peer: Protobuf message encoding failed")
 
-// Send writes protobuf message with specific ID to remote peer
-// If error returned is not ErrMessageEncodingFailed, connection was closed
+// Send writes protobuf message with specific ID to remote peer over stream selected by StreamOf,
+// message is compressed if compression is enabled.
+// If error returned is not ErrMessageEncodingFailed or ErrMessageTooLarge, connection was closed
 func (peer *Peer) Send(messageID byte, message proto.Message) error {
 	messageBytes, err := proto.Marshal(message)
 	if err != nil {
 		return ErrMessageEncodingFailed
 	}
-	success := peer.Write(append([]byte{messageID}, messageBytes...))
+	if len(messageBytes)+1 > peer.config().maxSize(messageID) {
+		return ErrMessageTooLarge
+	}
+	streamID := DefaultStream
+	if peer.StreamOf != nil {
+		streamID = peer.StreamOf(messageID)
+	}
+	var flags uint32
+	if compressed, ok := peer.compress(messageBytes); ok {
+		messageBytes, flags = compressed, compressedFlag
+	}
+	success := peer.writeFrame(streamID, flags, append([]byte{messageID}, messageBytes...))
 	if !success {
 		return errors.New("peer.Send: write failed")
 	}
@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -12,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/lucas-clemente/quic-go"
 	"github.com/pkg/errors"
 )
@@ -123,3 +125,135 @@ func TestNodeReadWrite(t *testing.T) {
 		}
 	})
 }
+
+func TestPeerStreams(t *testing.T) {
+	/*
+		1. Client sends large message over separate stream and small messages over default stream
+		2. Server receives all messages, messages of default stream are received in order
+	*/
+	cert, err := generateCertificate()
+	if err != nil {
+		t.Fatalf("Creating certificate failed: %+v\n", err)
+	}
+	listener, err := quic.ListenAddr("localhost:14053", &tls.Config{
+		Certificates:       []tls.Certificate{*cert},
+		NextProtos:         []string{"quic-transport-streams-test"},
+		InsecureSkipVerify: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("quic.ListenAddr failed: %+v\n", err)
+	}
+	defer listener.Close()
+
+	largeMessage := make([]byte, 4<<20)
+	for i := range largeMessage {
+		largeMessage[i] = byte(i)
+	}
+	go func() {
+		session, err := quic.DialAddr("localhost:14053", &tls.Config{
+			NextProtos:         []string{"quic-transport-streams-test"},
+			InsecureSkipVerify: true,
+		}, nil)
+		if err != nil {
+			t.Errorf("quic.DialAddr failed: %+v\n", err)
+			return
+		}
+		peer := NewPeer(session)
+
+		go peer.WriteTo(1, largeMessage)
+		for i := byte(0); i < 10; i++ {
+			if !peer.Write([]byte{i}) {
+				t.Error("peer.Write failed")
+				return
+			}
+		}
+		<-peer.Done()
+	}()
+
+	session, err := listener.Accept(context.Background())
+	if err != nil {
+		t.Fatalf("listener.Accept failed: %+v\n", err)
+	}
+	peer := NewPeer(session)
+	defer peer.Close()
+
+	var received []byte
+	next := byte(0)
+	for next < 10 || received == nil {
+		message := peer.Read()
+		if message == nil {
+			t.Fatal("peer.Read failed")
+		}
+		if len(message) == len(largeMessage) {
+			received = message
+			continue
+		}
+		if len(message) != 1 || message[0] != next {
+			t.Fatalf("Unexpected message of default stream: %v", message)
+		}
+		next++
+	}
+	if !bytes.Equal(received, largeMessage) {
+		t.Error("Large message is corrupted")
+	}
+}
+
+func TestPeerMessageLimits(t *testing.T) {
+	/*
+		1. Message exceeding limit of its type is rejected by Send
+		2. Receiver closes connection if message exceeds limit or is empty
+	*/
+	cert, err := generateCertificate()
+	if err != nil {
+		t.Fatalf("Creating certificate failed: %+v\n", err)
+	}
+	listener, err := quic.ListenAddr("localhost:14054", &tls.Config{
+		Certificates:       []tls.Certificate{*cert},
+		NextProtos:         []string{"quic-transport-limits-test"},
+		InsecureSkipVerify: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("quic.ListenAddr failed: %+v\n", err)
+	}
+	defer listener.Close()
+
+	config := &PeerConfig{MaxMessageSize: 1024, MessageSizeLimits: map[byte]int{0x01: 16}, ReadTimeout: time.Second}
+	for _, message := range [][]byte{append([]byte{0x01}, make([]byte, 16)...), {}} {
+		go func(message []byte) {
+			session, err := quic.DialAddr("localhost:14054", &tls.Config{
+				NextProtos:         []string{"quic-transport-limits-test"},
+				InsecureSkipVerify: true,
+			}, nil)
+			if err != nil {
+				t.Errorf("quic.DialAddr failed: %+v\n", err)
+				return
+			}
+			peer := NewPeer(session)
+			peer.Config = config
+			if len(message) > 0 && peer.Send(0x01, &wrappers.BytesValue{Value: message[1:]}) != ErrMessageTooLarge {
+				t.Error("Message exceeding limit was sent")
+			}
+			peer.Write(message)
+			<-time.After(time.Second)
+			peer.Close()
+		}(message)
+
+		session, err := listener.Accept(context.Background())
+		if err != nil {
+			t.Fatalf("listener.Accept failed: %+v\n", err)
+		}
+		peer := NewPeer(session)
+		peer.Config = config
+		if peer.Read() != nil {
+			t.Fatal("Invalid message was received")
+		}
+		expected := ErrMessageTooLarge
+		if len(message) == 0 {
+			expected = ErrEmptyMessage
+		}
+		if peer.Err() != expected {
+			t.Errorf("Unexpected error: %+v", peer.Err())
+		}
+		peer.Close()
+	}
+}
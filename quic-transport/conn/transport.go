@@ -0,0 +1,31 @@
+package conn
+
+import "net"
+
+// Transport establishes connections between nodes, it is implemented by QUICTransport
+// and by MemoryTransport used in tests
+type Transport interface {
+	// Dial connects to node on address, connection is aborted if nodeID isn't nil and remote has other ID
+	Dial(address string, nodeID []byte) (*Peer, error)
+	// Incoming returns channel of connections accepted from remote nodes, it is closed by Close
+	Incoming() <-chan *Peer
+	// LocalAddress returns address remote nodes connect to
+	LocalAddress() net.Addr
+	// Close stops accepting connections
+	Close()
+}
+
+var (
+	_ Transport = (*QUICTransport)(nil)
+	_ Transport = (*MemoryTransport)(nil)
+)
+
+// Incoming returns channel of accepted connections
+func (transport *QUICTransport) Incoming() <-chan *Peer {
+	return transport.IncomingConnections
+}
+
+// LocalAddress returns external address of transport
+func (transport *QUICTransport) LocalAddress() net.Addr {
+	return transport.Address
+}